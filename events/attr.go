@@ -0,0 +1,226 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// AttrName returns a perf-style name for the event selected by the Type,
+// Config, Ext1 (config1), and Ext2 (config2) fields of attr, plus Bp_type for
+// breakpoints. It ignores all other fields.
+//
+// This is a best-effort reverse of [ParseEvent]. It uses perf's canonical
+// name for builtin events (for example, "cpu-cycles" or
+// "L1-dcache-load-misses"), "subsys:event" for tracepoints, and
+// "mem:addr/len:access" for breakpoints. For other
+// PMUs, it uses an event name from sysfs if one matches exactly, or
+// otherwise decodes the config fields using the PMU's formats, as in
+// "cpu/event=0x3c,umask=0x1/". If all else fails, it returns a name in the
+// form "pmu4/config=0x1234/".
+func AttrName(attr *unix.PerfEventAttr) string {
+	if attr.Type == unix.PERF_TYPE_BREAKPOINT {
+		return fmt.Sprintf("mem:%#x/%d:%s", attr.Ext1, attr.Ext2, BreakpointType(attr.Bp_type))
+	}
+	if attr.Ext1 == 0 && attr.Ext2 == 0 {
+		if name, ok := builtinAttrName(attr.Type, attr.Config); ok {
+			return name
+		}
+		if name, ok := hybridAttrName(attr.Type, attr.Config); ok {
+			return name
+		}
+		if attr.Type == unix.PERF_TYPE_TRACEPOINT {
+			if name, ok := tracepointName(attr.Config); ok {
+				return name
+			}
+		}
+	}
+	if name, ok := pmuAttrName(attr); ok {
+		return name
+	}
+	ev := rawEvent{config: attr.Config, config1: attr.Ext1, config2: attr.Ext2}
+	return fmt.Sprintf("pmu%d/%s/", attr.Type, ev.configString(nil))
+}
+
+// builtinAttrName returns the canonical name of the builtin event with the
+// given type and config.
+func builtinAttrName(typ uint32, config uint64) (string, bool) {
+	initBuiltinEvents()
+	switch typ {
+	case unix.PERF_TYPE_HARDWARE:
+		if names, ok := builtinEvents.cpuNames[config]; ok {
+			return names[0], true
+		}
+	case unix.PERF_TYPE_SOFTWARE:
+		if names, ok := builtinEvents.softwareNames[config]; ok {
+			return names[0], true
+		}
+	case unix.PERF_TYPE_HW_CACHE:
+		// See evsel.c:__evsel__hw_cache_type_op_res_name.
+		cache, op, result := config&0xff, (config>>8)&0xff, config>>16
+		cacheNames, ok1 := builtinEvents.cacheNames[cache]
+		opNames, ok2 := builtinEvents.cacheOpNames[op]
+		resultNames, ok3 := builtinEvents.cacheResultNames[result]
+		if !ok1 || !ok2 || !ok3 || builtinEvents.cacheAllowed[cache]&(1<<op) == 0 {
+			return "", false
+		}
+		if result == unix.PERF_COUNT_HW_CACHE_RESULT_ACCESS {
+			return cacheNames[0] + "-" + opNames[1], true
+		}
+		return cacheNames[0] + "-" + opNames[0] + "-" + resultNames[0], true
+	}
+	return "", false
+}
+
+// hybridAttrName returns the name of a builtin event on a hybrid core PMU,
+// such as "cpu_core/cpu-cycles/".
+func hybridAttrName(typ uint32, config uint64) (string, bool) {
+	if typ != unix.PERF_TYPE_HARDWARE && typ != unix.PERF_TYPE_HW_CACHE {
+		return "", false
+	}
+	pmu, ok := pmuOfType(uint32(config >> perfPMUTypeShift))
+	if !ok || !isHybridPMU(pmu) {
+		return "", false
+	}
+	name, ok := builtinAttrName(typ, config&(1<<perfPMUTypeShift-1))
+	if !ok {
+		return "", false
+	}
+	return pmu + "/" + name + "/", true
+}
+
+// tracepointName returns the "subsys:event" name of the tracepoint with the
+// given ID.
+func tracepointName(id uint64) (string, bool) {
+	tfs := tracefsFS()
+	if tfs == nil {
+		return "", false
+	}
+	paths, _ := fs.Glob(tfs, "events/*/*/id")
+	want := strconv.FormatUint(id, 10)
+	for _, p := range paths {
+		data, err := fs.ReadFile(tfs, p)
+		if err != nil || strings.TrimSpace(string(data)) != want {
+			continue
+		}
+		dir, event := path.Split(path.Dir(p))
+		return path.Base(dir) + ":" + event, true
+	}
+	return "", false
+}
+
+// pmuAttrName returns the name of attr's event using the PMU in sysfs with
+// attr's type.
+func pmuAttrName(attr *unix.PerfEventAttr) (string, bool) {
+	ents, err := fs.ReadDir(pmuFS, ".")
+	if err != nil {
+		return "", false
+	}
+	names := make([]string, 0, len(ents))
+	for _, ent := range ents {
+		names = append(names, ent.Name())
+	}
+	// Several PMUs can have the same type (for example, the core PMU's type
+	// is also PERF_TYPE_RAW), so prefer "cpu".
+	slices.SortFunc(names, func(a, b string) int {
+		if (a == "cpu") != (b == "cpu") {
+			if a == "cpu" {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	for _, name := range names {
+		desc, err := pmus.get(name)
+		if err != nil || desc.pmu != attr.Type {
+			continue
+		}
+		want := rawEvent{config: attr.Config, config1: attr.Ext1, config2: attr.Ext2}
+
+		// Look for a named event of this PMU.
+		evNames := make([]string, 0, len(desc.events))
+		for evName := range desc.events {
+			evNames = append(evNames, evName)
+		}
+		slices.Sort(evNames)
+		for _, evName := range evNames {
+			var ev rawEvent
+			if _, ok := resolveBuiltinEvent(name, evName); ok {
+				// This name refers to the builtin event.
+				continue
+			}
+			if resolvePMUEvent(desc, evName, &ev) != nil {
+				continue
+			}
+			if ev.config == want.config && ev.config1 == want.config1 && ev.config2 == want.config2 {
+				return name + "/" + evName + "/", true
+			}
+		}
+
+		return name + "/" + want.configString(desc) + "/", true
+	}
+	return "", false
+}
+
+// configString returns the config fields of e as a list of parameters, using
+// the formats of PMU d if d is non-nil. Parsing the result as an event of PMU
+// d recreates e's config fields.
+func (e *rawEvent) configString(d *pmuDesc) string {
+	var params []string
+	var formats []pmuFormat
+	if d != nil {
+		for _, f := range d.format {
+			formats = append(formats, f)
+		}
+		slices.SortFunc(formats, func(a, b pmuFormat) int { return strings.Compare(a.name, b.name) })
+	}
+	for _, field := range []struct {
+		name string
+		val  uint64
+	}{{"config", e.config}, {"config1", e.config1}, {"config2", e.config2}} {
+		// Decode the field using the formats that don't overlap.
+		var fparams []string
+		rest, covered := field.val, uint64(0)
+		for _, f := range formats {
+			mask := f.mask()
+			if f.fieldName != field.name || mask&covered != 0 || rest&mask == 0 {
+				continue
+			}
+			covered |= mask
+			fparams = append(fparams, fmt.Sprintf("%s=%#x", f.name, f.get(rest)))
+			rest &^= mask
+		}
+		// Setting the whole field clears the formats, so it must come
+		// first.
+		if rest != 0 || (field.name == "config" && len(fparams) == 0) {
+			params = append(params, fmt.Sprintf("%s=%#x", field.name, rest))
+		}
+		params = append(params, fparams...)
+	}
+	return strings.Join(params, ",")
+}
+
+// get extracts the value of format f from the raw field value x. This is the
+// inverse of set.
+func (f pmuFormat) get(x uint64) uint64 {
+	var val uint64
+	shift := 0
+	for _, bits := range f.bits {
+		max := (uint64(1) << bits.nBits) - 1
+		val |= ((x >> bits.shift) & max) << shift
+		shift += bits.nBits
+	}
+	return val
+}
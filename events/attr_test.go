@@ -0,0 +1,76 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestAttrName(t *testing.T) {
+	for _, tc := range []struct {
+		event string
+		want  string
+	}{
+		{"cycles", "cpu-cycles"},
+		{"cpu/instructions/", "instructions"},
+		{"faults", "page-faults"},
+		{"l1d-loads", "L1-dcache-loads"},
+		{"L1-dcache-load-misses", "L1-dcache-load-misses"},
+		{"d-tlb-store-miss", "dTLB-store-misses"},
+		{"sched:sched_switch", "sched:sched_switch"},
+		// The cpu-cycles event in sysfs would parse as the builtin event.
+		{"cpu/event=0x3c/", "cpu/event=0x3c/"},
+		{"cpu/event=0xd0,umask=0x82/", "cpu/mem-stores/"},
+		{"cpu/event=0xd0,umask=0x1,edge/", "cpu/edge=0x1,event=0xd0,umask=0x1/"},
+		{"cpu/event=0x1,config1=0x3/", "cpu/event=0x1,frontend=0x3/"},
+		{"cpu/config=0/", "cpu/config=0x0/"},
+	} {
+		ev, err := ParseEvent(tc.event)
+		if err != nil {
+			t.Errorf("%s: %v", tc.event, err)
+			continue
+		}
+		var attr unix.PerfEventAttr
+		if err := ev.SetAttrs(&attr); err != nil {
+			t.Fatal(err)
+		}
+		got := AttrName(&attr)
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.event, got, tc.want)
+			continue
+		}
+
+		// The name should parse back to the same event.
+		ev2, err := ParseEvent(got)
+		if err != nil {
+			t.Errorf("%s: parsing %q: %v", tc.event, got, err)
+			continue
+		}
+		if evString(ev2) != evString(ev) {
+			t.Errorf("%s: %q parses as %s, want %s", tc.event, got, evString(ev2), evString(ev))
+		}
+	}
+
+	// Bits not covered by a format are set directly, and unknown events use
+	// the PMU's type number.
+	for _, tc := range []struct {
+		attr unix.PerfEventAttr
+		want string
+	}{
+		{unix.PerfEventAttr{Type: 4, Config: 0x100000001}, "cpu/config=0x100000000,event=0x1/"},
+		{unix.PerfEventAttr{Type: 99, Config: 0x12}, "pmu99/config=0x12/"},
+		{unix.PerfEventAttr{Type: 99, Config: 0x12, Ext2: 1}, "pmu99/config=0x12,config2=0x1/"},
+		{unix.PerfEventAttr{Type: unix.PERF_TYPE_HARDWARE, Config: 1000}, "pmu0/config=0x3e8/"},
+		{unix.PerfEventAttr{Type: unix.PERF_TYPE_TRACEPOINT, Config: 1}, "pmu2/config=0x1/"},
+	} {
+		if got := AttrName(&tc.attr); got != tc.want {
+			t.Errorf("%+v: got %q, want %q", tc.attr, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// BreakpointType is the kind of memory access that triggers a hardware
+// breakpoint.
+type BreakpointType uint32
+
+// These are the HW_BREAKPOINT_* values of linux/hw_breakpoint.h.
+const (
+	BreakpointRead      BreakpointType = 1
+	BreakpointWrite     BreakpointType = 2
+	BreakpointReadWrite BreakpointType = BreakpointRead | BreakpointWrite
+	BreakpointExec      BreakpointType = 4
+)
+
+// String returns typ in perf's syntax, such as "rw" or "x".
+func (typ BreakpointType) String() string {
+	var s string
+	if typ&BreakpointRead != 0 {
+		s += "r"
+	}
+	if typ&BreakpointWrite != 0 {
+		s += "w"
+	}
+	if typ&BreakpointExec != 0 {
+		s += "x"
+	}
+	if typ&^(BreakpointReadWrite|BreakpointExec) != 0 || s == "" {
+		return fmt.Sprintf("BreakpointType(%d)", uint32(typ))
+	}
+	return s
+}
+
+// breakpointEvent is a hardware breakpoint (PERF_TYPE_BREAKPOINT).
+type breakpointEvent struct {
+	name   string
+	addr   uint64
+	length uint64
+	typ    BreakpointType
+}
+
+func (e *breakpointEvent) isEvent() {}
+
+func (e *breakpointEvent) String() string {
+	return e.name
+}
+
+func (e *breakpointEvent) SetAttrs(attr *unix.PerfEventAttr) error {
+	attr.Type = unix.PERF_TYPE_BREAKPOINT
+	attr.Config = 0
+	attr.Bp_type = uint32(e.typ)
+	// bp_addr and bp_len are unions with config1 and config2.
+	attr.Ext1 = e.addr
+	attr.Ext2 = e.length
+	// Like perf, sample every hit by default.
+	attr.Sample = 1
+	attr.Bits &^= unix.PerfBitFreq
+	return nil
+}
+
+// SampleRate implements [EventSampleRate]. Breakpoints are rare, so, like
+// perf, a Sampler samples every hit by default.
+func (e *breakpointEvent) SampleRate() (period, freq uint64) {
+	return 1, 0
+}
+
+// Breakpoint returns an Event that occurs each time a thread accesses the
+// length bytes at addr in a way that matches typ, using the CPU's debug
+// registers. length must be 1, 2, 4, or 8, and for [BreakpointExec], it must
+// be 8. Most CPUs also require addr to be aligned to length.
+//
+// Counting a breakpoint counts matching accesses, and sampling it, which
+// samples every access by default, records where they happened. For
+// example, sampling a write breakpoint on a variable with [SampleCallchain]
+// finds the code that modified it. CPUs have few debug registers, typically
+// four, so only a few breakpoints can be active at once.
+//
+// The equivalent [ParseEvent] syntax is "mem:addr[/length][:access]", such as
+// "mem:0xc000012345/8:w".
+func Breakpoint(addr uint64, length int, typ BreakpointType) (Event, error) {
+	name := fmt.Sprintf("mem:%#x/%d:%s", addr, length, typ)
+	return newBreakpoint(name, addr, length, typ)
+}
+
+func newBreakpoint(name string, addr uint64, length int, typ BreakpointType) (Event, error) {
+	switch typ {
+	case BreakpointRead, BreakpointWrite, BreakpointReadWrite:
+		if length != 1 && length != 2 && length != 4 && length != 8 {
+			return nil, fmt.Errorf("event %q: breakpoint length must be 1, 2, 4, or 8, got %d", name, length)
+		}
+	case BreakpointExec:
+		if length != 8 {
+			return nil, fmt.Errorf("event %q: execute breakpoint length must be 8, got %d", name, length)
+		}
+	default:
+		return nil, fmt.Errorf("event %q: bad breakpoint type %s", name, typ)
+	}
+	return &breakpointEvent{name: name, addr: addr, length: uint64(length), typ: typ}, nil
+}
+
+var errNotBreakpoint = errors.New("not a breakpoint event")
+
+// parseBreakpoint parses a breakpoint event in perf's "mem:addr[/len][:access]"
+// syntax, where access is a combination of "r", "w", and "x". Like perf, the
+// access defaults to "rw" and the length defaults to 4, or 8 for "x". It
+// returns errNotBreakpoint if name isn't in this form.
+func parseBreakpoint(name string) (Event, error) {
+	spec, ok := strings.CutPrefix(name, "mem:")
+	if !ok {
+		return nil, errNotBreakpoint
+	}
+	spec, access, hasAccess := strings.Cut(spec, ":")
+	addrStr, lenStr, hasLen := strings.Cut(spec, "/")
+
+	addr, err := strconv.ParseUint(addrStr, 0, 64)
+	if err != nil {
+		return nil, fmt.Errorf("event %q: bad breakpoint address %q", name, addrStr)
+	}
+	typ := BreakpointReadWrite
+	if hasAccess {
+		typ = 0
+		for _, c := range access {
+			var bit BreakpointType
+			switch c {
+			case 'r':
+				bit = BreakpointRead
+			case 'w':
+				bit = BreakpointWrite
+			case 'x':
+				bit = BreakpointExec
+			default:
+				return nil, fmt.Errorf("event %q: bad breakpoint access %q", name, access)
+			}
+			if typ&bit != 0 {
+				return nil, fmt.Errorf("event %q: bad breakpoint access %q", name, access)
+			}
+			typ |= bit
+		}
+	}
+	length := 4
+	if typ == BreakpointExec {
+		length = 8
+	}
+	if hasLen {
+		length, err = strconv.Atoi(lenStr)
+		if err != nil {
+			return nil, fmt.Errorf("event %q: bad breakpoint length %q", name, lenStr)
+		}
+	}
+	return newBreakpoint(name, addr, length, typ)
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseBreakpoint(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		addr   uint64
+		length uint64
+		typ    BreakpointType
+		err    bool
+	}{
+		{name: "mem:0x1000", addr: 0x1000, length: 4, typ: BreakpointReadWrite},
+		{name: "mem:0x1000:w", addr: 0x1000, length: 4, typ: BreakpointWrite},
+		{name: "mem:0x1000/8:rw", addr: 0x1000, length: 8, typ: BreakpointReadWrite},
+		{name: "mem:4096/1:r", addr: 4096, length: 1, typ: BreakpointRead},
+		{name: "mem:0x1000:x", addr: 0x1000, length: 8, typ: BreakpointExec},
+		{name: "mem:0x1000/3:w", err: true},
+		{name: "mem:0x1000/4:x", err: true},
+		{name: "mem:0x1000:rx", err: true},
+		{name: "mem:0x1000:ww", err: true},
+		{name: "mem:0x1000:q", err: true},
+		{name: "mem:nope", err: true},
+		{name: "mem:0x1000/x:w", err: true},
+	} {
+		ev, err := ParseEvent(tc.name)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: want error, got %s", tc.name, evString(ev))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var attr unix.PerfEventAttr
+		if err := ev.SetAttrs(&attr); err != nil {
+			t.Fatal(err)
+		}
+		if attr.Type != unix.PERF_TYPE_BREAKPOINT || attr.Ext1 != tc.addr || attr.Ext2 != tc.length || BreakpointType(attr.Bp_type) != tc.typ {
+			t.Errorf("%s: got type %d, addr %#x, len %d, bp type %s; want breakpoint at %#x, len %d, bp type %s", tc.name, attr.Type, attr.Ext1, attr.Ext2, BreakpointType(attr.Bp_type), tc.addr, tc.length, tc.typ)
+		}
+		if attr.Sample != 1 || attr.Bits&unix.PerfBitFreq != 0 {
+			t.Errorf("%s: got sample period %d, want 1", tc.name, attr.Sample)
+		}
+		if got := ev.String(); got != tc.name {
+			t.Errorf("%s: got name %q", tc.name, got)
+		}
+	}
+}
+
+func TestBreakpoint(t *testing.T) {
+	ev, err := Breakpoint(0xc000012340, 8, BreakpointWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "mem:0xc000012340/8:w"
+	if got := ev.String(); got != want {
+		t.Errorf("got name %q, want %q", got, want)
+	}
+	var attr unix.PerfEventAttr
+	if err := ev.SetAttrs(&attr); err != nil {
+		t.Fatal(err)
+	}
+	if got := AttrName(&attr); got != want {
+		t.Errorf("AttrName: got %q, want %q", got, want)
+	}
+	// The name round-trips through ParseEvent.
+	ev2, err := ParseEvent(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var attr2 unix.PerfEventAttr
+	if err := ev2.SetAttrs(&attr2); err != nil {
+		t.Fatal(err)
+	}
+	if attr != attr2 {
+		t.Errorf("parsed %s: got attr %+v, want %+v", want, attr2, attr)
+	}
+
+	if _, err := Breakpoint(0x1000, 4, 0); err == nil {
+		t.Errorf("breakpoint with no type: want error")
+	}
+	if got := BreakpointType(16).String(); got != "BreakpointType(16)" {
+		t.Errorf("got %q for invalid type", got)
+	}
+}
@@ -55,18 +55,25 @@ var builtinEvents struct {
 	cacheResult  []cacheEventName
 	cacheAllowed map[uint64]uint8 // Cache level -> bitmap of cache op
 
+	// Names of each config, in perf's order, for mapping configs back to
+	// names. The first name is perf's canonical name.
+	cpuNames, softwareNames                    map[uint64][]string
+	cacheNames, cacheOpNames, cacheResultNames map[uint64][]string
+
 	once sync.Once
 }
 
-func resolveBuiltinEvent(pmu, eventName string) (builtinEvent, bool) {
+func initBuiltinEvents() {
 	builtinEvents.once.Do(func() {
 		// See parse-events.c:event_symbols_hw
 		builtinEvents.cpu = make(map[string]builtinEvent)
+		builtinEvents.cpuNames = make(map[uint64][]string)
 		hw := func(config uint64, names ...string) {
 			ev := builtinEvent{"", unix.PERF_TYPE_HARDWARE, config}
 			for _, name := range names {
 				builtinEvents.cpu[name] = ev
 			}
+			builtinEvents.cpuNames[config] = names
 		}
 		hw(unix.PERF_COUNT_HW_CPU_CYCLES, "cpu-cycles", "cycles")
 		hw(unix.PERF_COUNT_HW_INSTRUCTIONS, "instructions")
@@ -81,11 +88,13 @@ func resolveBuiltinEvent(pmu, eventName string) (builtinEvent, bool) {
 
 		// See parse-events.c:event_symbols_sw
 		builtinEvents.software = make(map[string]builtinEvent)
+		builtinEvents.softwareNames = make(map[uint64][]string)
 		sw := func(config uint64, names ...string) {
 			ev := builtinEvent{"", unix.PERF_TYPE_SOFTWARE, config}
 			for _, name := range names {
 				builtinEvents.software[name] = ev
 			}
+			builtinEvents.softwareNames[config] = names
 		}
 		sw(unix.PERF_COUNT_SW_CPU_CLOCK, "cpu-clock")
 		sw(unix.PERF_COUNT_SW_TASK_CLOCK, "task-clock")
@@ -102,10 +111,12 @@ func resolveBuiltinEvent(pmu, eventName string) (builtinEvent, bool) {
 		//sw(unix.PERF_COUNT_SW_CGROUP_SWITCHES, "cgroup-switches")
 
 		var m *[]cacheEventName
+		var n map[uint64][]string
 		c := func(config uint64, names ...string) {
 			for _, name := range names {
 				(*m) = append(*m, cacheEventName{name, config})
 			}
+			n[config] = names
 		}
 		cSort := func() {
 			// Put longer names earlier for matching
@@ -114,7 +125,8 @@ func resolveBuiltinEvent(pmu, eventName string) (builtinEvent, bool) {
 			})
 		}
 		// See evsel.c:evsel__hw_cache
-		m = &builtinEvents.cache
+		m, n = &builtinEvents.cache, make(map[uint64][]string)
+		builtinEvents.cacheNames = n
 		c(unix.PERF_COUNT_HW_CACHE_L1D, "L1-dcache", "l1-d", "l1d", "L1-data")
 		c(unix.PERF_COUNT_HW_CACHE_L1I, "L1-icache", "l1-i", "l1i", "L1-instruction")
 		c(unix.PERF_COUNT_HW_CACHE_LL, "LLC", "L2")
@@ -124,13 +136,15 @@ func resolveBuiltinEvent(pmu, eventName string) (builtinEvent, bool) {
 		c(unix.PERF_COUNT_HW_CACHE_NODE, "node")
 		cSort()
 		// See evsel.c:evsel__hw_cache_op
-		m = &builtinEvents.cacheOp
+		m, n = &builtinEvents.cacheOp, make(map[uint64][]string)
+		builtinEvents.cacheOpNames = n
 		c(unix.PERF_COUNT_HW_CACHE_OP_READ, "load", "loads", "read")
 		c(unix.PERF_COUNT_HW_CACHE_OP_WRITE, "store", "stores", "write")
 		c(unix.PERF_COUNT_HW_CACHE_OP_PREFETCH, "prefetch", "prefetches", "speculative-read", "speculative-load")
 		cSort()
 		// evsel.c:evsel__hw_cache_result
-		m = &builtinEvents.cacheResult
+		m, n = &builtinEvents.cacheResult, make(map[uint64][]string)
+		builtinEvents.cacheResultNames = n
 		c(unix.PERF_COUNT_HW_CACHE_RESULT_ACCESS, "refs", "Reference", "ops", "access")
 		c(unix.PERF_COUNT_HW_CACHE_RESULT_MISS, "misses", "miss")
 		cSort()
@@ -148,15 +162,33 @@ func resolveBuiltinEvent(pmu, eventName string) (builtinEvent, bool) {
 			unix.PERF_COUNT_HW_CACHE_NODE: r | w | p,
 		}
 	})
+}
 
-	// All builtin events are either under no PMU or under cpu/.
-	if !(pmu == "" || pmu == "cpu") {
+func resolveBuiltinEvent(pmu, eventName string) (builtinEvent, bool) {
+	initBuiltinEvents()
+
+	// All builtin events are either under no PMU or under cpu/. On hybrid
+	// CPUs, CPU events can also be under a core PMU, such as cpu_core/,
+	// which is encoded in the upper bits of the config.
+	var extType uint64
+	name := eventName
+	switch {
+	case pmu == "" || pmu == "cpu":
+	case isHybridPMU(pmu):
+		desc, err := pmus.get(pmu)
+		if err != nil {
+			return builtinEvent{}, false
+		}
+		extType = uint64(desc.pmu) << perfPMUTypeShift
+		name = pmu + "/" + eventName + "/"
+	default:
 		return builtinEvent{}, false
 	}
 
 	// CPU events can be used with or without a PMU name.
 	if e, ok := builtinEvents.cpu[eventName]; ok {
-		e.name = eventName
+		e.name = name
+		e.config |= extType
 		return e, true
 	}
 
@@ -210,7 +242,7 @@ func resolveBuiltinEvent(pmu, eventName string) (builtinEvent, bool) {
 			// Parsed the whole event. Check if it's an allowed combination.
 			if builtinEvents.cacheAllowed[config]&(1<<op) != 0 {
 				config |= (op << 8) | (result << 16)
-				return builtinEvent{eventName, unix.PERF_TYPE_HW_CACHE, config}, true
+				return builtinEvent{name, unix.PERF_TYPE_HW_CACHE, config | extType}, true
 			}
 		}
 	}
@@ -0,0 +1,135 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// An EventDescription describes what an event counts.
+type EventDescription struct {
+	// Type is the kind of event, using the names from "perf list": "Hardware
+	// event", "Software event", "Hardware cache event", "Kernel PMU event",
+	// "Tracepoint event", or "Hardware breakpoint".
+	Type string
+
+	// Topic is the category of the event, such as "cache" or "pipeline",
+	// or "" if unknown.
+	Topic string
+
+	// BriefDescription is a one-line description of the event, or "" if
+	// unknown.
+	BriefDescription string
+
+	// PublicDescription is a longer description of the event, or "" if
+	// there's none beyond BriefDescription.
+	PublicDescription string
+}
+
+// Describe returns a description of the named event, which may be any name
+// accepted by [ParseEvent]. It returns an error if [ParseEvent] does.
+//
+// The descriptions of CPU-specific events come from the pmu-events database,
+// if it has been generated and has this CPU, or, failing that, "perf list -j",
+// if perf is installed. Sysfs doesn't describe PMU events, so unless perf also
+// lists an event, its description only has a Type.
+func Describe(name string) (*EventDescription, error) {
+	ev, err := ParseEvent(name)
+	if err != nil {
+		return nil, err
+	}
+	// These are optional. Without them, we just have less to say.
+	table, _ := getPMUEvents()
+	list, _ := getPerfList()
+	return describeEvent(ev, name, table, list), nil
+}
+
+// describeEvent returns the description of ev, which was parsed from name,
+// using the descriptions in the pmu-events table and perf list, either of
+// which may be nil.
+func describeEvent(ev Event, name string, table map[string]*pmuEventsEntry, list map[string]perfJson) *EventDescription {
+	var desc EventDescription
+	var attr unix.PerfEventAttr
+	if err := ev.SetAttrs(&attr); err != nil {
+		return &desc
+	}
+	switch attr.Type {
+	case unix.PERF_TYPE_HARDWARE:
+		// Ignore the core PMU of a hybrid CPU.
+		desc.Type = "Hardware event"
+		desc.BriefDescription = builtinDescriptions.hardware[attr.Config&(1<<perfPMUTypeShift-1)]
+	case unix.PERF_TYPE_SOFTWARE:
+		desc.Type = "Software event"
+		desc.BriefDescription = builtinDescriptions.software[attr.Config]
+	case unix.PERF_TYPE_HW_CACHE:
+		desc.Type = "Hardware cache event"
+	case unix.PERF_TYPE_TRACEPOINT:
+		desc.Type = "Tracepoint event"
+	case unix.PERF_TYPE_BREAKPOINT:
+		desc.Type = "Hardware breakpoint"
+	default:
+		desc.Type = "Kernel PMU event"
+	}
+
+	if base, _, ok := splitModifiers(name); ok {
+		name = base
+	}
+	if entry, ok := table[strings.ToLower(name)]; ok {
+		desc.Topic = entry.Topic
+		desc.BriefDescription = entry.BriefDescription
+		if entry.PublicDescription != entry.BriefDescription {
+			desc.PublicDescription = entry.PublicDescription
+		}
+		return &desc
+	}
+	// Look up the event in perf list, which has both bare names like
+	// "l1d.replacement" and PMU names like "cpu/mem-loads/".
+	if evJSON, ok := list[name]; ok {
+		desc.Topic = evJSON.Topic
+		if evJSON.BriefDescription != "" {
+			desc.BriefDescription = evJSON.BriefDescription
+		}
+		if evJSON.PublicDescription != evJSON.BriefDescription {
+			desc.PublicDescription = evJSON.PublicDescription
+		}
+	}
+	return &desc
+}
+
+// builtinDescriptions describe the builtin hardware and software events,
+// following the comments in the kernel's include/uapi/linux/perf_event.h.
+var builtinDescriptions = struct {
+	hardware, software map[uint64]string
+}{
+	hardware: map[uint64]string{
+		unix.PERF_COUNT_HW_CPU_CYCLES:              "Total CPU cycles",
+		unix.PERF_COUNT_HW_INSTRUCTIONS:            "Retired instructions",
+		unix.PERF_COUNT_HW_CACHE_REFERENCES:        "Cache accesses, usually of the last level cache",
+		unix.PERF_COUNT_HW_CACHE_MISSES:            "Cache misses, usually of the last level cache",
+		unix.PERF_COUNT_HW_BRANCH_INSTRUCTIONS:     "Retired branch instructions",
+		unix.PERF_COUNT_HW_BRANCH_MISSES:           "Mispredicted branch instructions",
+		unix.PERF_COUNT_HW_BUS_CYCLES:              "Bus cycles, which can differ from total cycles",
+		unix.PERF_COUNT_HW_STALLED_CYCLES_FRONTEND: "Stalled cycles during issue",
+		unix.PERF_COUNT_HW_STALLED_CYCLES_BACKEND:  "Stalled cycles during retirement",
+		unix.PERF_COUNT_HW_REF_CPU_CYCLES:          "Total cycles, not affected by CPU frequency scaling",
+	},
+	software: map[uint64]string{
+		unix.PERF_COUNT_SW_CPU_CLOCK:        "CPU clock, a high-resolution per-CPU timer, in nanoseconds",
+		unix.PERF_COUNT_SW_TASK_CLOCK:       "Clock count specific to the task that is running, in nanoseconds",
+		unix.PERF_COUNT_SW_PAGE_FAULTS:      "Page faults",
+		unix.PERF_COUNT_SW_CONTEXT_SWITCHES: "Context switches",
+		unix.PERF_COUNT_SW_CPU_MIGRATIONS:   "Migrations of the process to a new CPU",
+		unix.PERF_COUNT_SW_PAGE_FAULTS_MIN:  "Minor page faults, which didn't require disk I/O",
+		unix.PERF_COUNT_SW_PAGE_FAULTS_MAJ:  "Major page faults, which required disk I/O",
+		unix.PERF_COUNT_SW_ALIGNMENT_FAULTS: "Alignment faults, which occur when an unaligned memory access is fixed up by the kernel",
+		unix.PERF_COUNT_SW_EMULATION_FAULTS: "Unimplemented instructions emulated by the kernel",
+		unix.PERF_COUNT_SW_DUMMY:            "Placeholder event that counts nothing",
+		unix.PERF_COUNT_SW_BPF_OUTPUT:       "Output from BPF programs",
+	},
+}
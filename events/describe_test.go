@@ -0,0 +1,70 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"testing"
+)
+
+func TestDescribe(t *testing.T) {
+	list, err := parsePerfList([]byte(`[
+{
+	"Unit": "cpu",
+	"Topic": "cache",
+	"EventName": "l1d.replacement",
+	"EventType": "Kernel PMU event",
+	"BriefDescription": "Counts the number of cache lines replaced in L1 data cache",
+	"PublicDescription": "Counts L1D data line replacements including opportunistic replacements, and replacements that require stall-for-replace or block-for-replace.",
+	"Encoding": "cpu/event=0x51,umask=0x1/"
+},
+{
+	"Unit": "cpu",
+	"EventName": "mem-stores",
+	"EventAlias": "cpu/mem-stores/",
+	"EventType": "Kernel PMU event",
+	"BriefDescription": "Retired store instructions",
+	"PublicDescription": "Retired store instructions",
+	"Encoding": "cpu/event=0xd0,umask=0x82/"
+}
+]`), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		want EventDescription
+	}{
+		{"cycles", EventDescription{Type: "Hardware event", BriefDescription: "Total CPU cycles"}},
+		{"cpu-clock:u", EventDescription{Type: "Software event", BriefDescription: "CPU clock, a high-resolution per-CPU timer, in nanoseconds"}},
+		{"L1-dcache-loads", EventDescription{Type: "Hardware cache event"}},
+		{"cpu/event=0x3c/", EventDescription{Type: "Kernel PMU event"}},
+		{"l1d.replacement", EventDescription{
+			Type:              "Kernel PMU event",
+			Topic:             "cache",
+			BriefDescription:  "Counts the number of cache lines replaced in L1 data cache",
+			PublicDescription: "Counts L1D data line replacements including opportunistic replacements, and replacements that require stall-for-replace or block-for-replace.",
+		}},
+		{"cpu/mem-stores/u", EventDescription{Type: "Kernel PMU event", BriefDescription: "Retired store instructions"}},
+		{"mem:0x1000", EventDescription{Type: "Hardware breakpoint"}},
+	} {
+		ev, err := ParseEvent(tc.name)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := describeEvent(ev, tc.name, nil, list); *got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, *got, tc.want)
+		}
+	}
+
+	if _, err := Describe("bogus"); err == nil {
+		t.Errorf("Describe of unknown event: want error")
+	}
+	if got, err := Describe("instructions"); err != nil || got.BriefDescription != "Retired instructions" {
+		t.Errorf("Describe(instructions) = %+v, %v; want Retired instructions", got, err)
+	}
+}
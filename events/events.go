@@ -25,3 +25,142 @@ type EventScale interface {
 	// should return 1.0, "".
 	ScaleUnit() (scale float64, unit string)
 }
+
+// An EventSampleRate is an Event that specifies how often it should be
+// sampled, either as a period (one sample every N events) or as a frequency
+// (N samples per second, with the kernel adjusting the period dynamically).
+//
+// This only matters when sampling an event. Counting ignores it.
+type EventSampleRate interface {
+	Event
+
+	// SampleRate returns the sampling period or frequency of this event. At
+	// most one of period and freq is non-zero. If both are zero, the event
+	// doesn't specify a sample rate.
+	SampleRate() (period, freq uint64)
+}
+
+// An EventWarnings is an Event that may have non-fatal problems, such as a
+// parameter that overrides part of the encoding of a named event. For example,
+// "cpu/mem-stores,umask=0x1/" replaces the umask bits of the mem-stores event.
+type EventWarnings interface {
+	Event
+
+	// Warnings returns a list of human-readable warnings about this event, or
+	// nil if there are none.
+	Warnings() []string
+}
+
+// An EventFilter is an Event with a filter expression, such as a tracepoint
+// filter like "prev_pid == 0". The kernel only counts or samples occurrences
+// of the event that match the filter. See the kernel's
+// Documentation/trace/events.rst for the syntax.
+type EventFilter interface {
+	Event
+
+	// Filter returns the filter expression of this event, or "" if it
+	// has none.
+	Filter() string
+}
+
+// FilterOf returns the filter expression of ev, or "" if ev doesn't have one.
+func FilterOf(ev Event) string {
+	if ef, ok := ev.(EventFilter); ok {
+		return ef.Filter()
+	}
+	return ""
+}
+
+// An EventPerCore is an Event that should be aggregated per physical core
+// rather than per CPU, like perf's "percore" term. On CPUs with simultaneous
+// multithreading, some events count the activity of the whole core on each of
+// its hardware threads, so summing the counts of sibling threads overcounts.
+// The kernel doesn't act on this; it's up to consumers that count the event
+// on each CPU to combine the counts of sibling threads.
+type EventPerCore interface {
+	Event
+
+	// PerCore reports whether this event should be aggregated per core.
+	PerCore() bool
+}
+
+// PerCoreOf reports whether ev should be aggregated per core. It returns false
+// if ev doesn't say.
+func PerCoreOf(ev Event) bool {
+	if ep, ok := ev.(EventPerCore); ok {
+		return ep.PerCore()
+	}
+	return false
+}
+
+// An EventMetricID is an Event with a name to refer to it by in metric
+// expressions, like perf's "metric-id" term.
+type EventMetricID interface {
+	Event
+
+	// MetricID returns the metric ID of this event.
+	MetricID() string
+}
+
+// MetricIDOf returns the metric ID of ev. If ev doesn't have one, this returns
+// ev.String().
+func MetricIDOf(ev Event) string {
+	if em, ok := ev.(EventMetricID); ok {
+		return em.MetricID()
+	}
+	return ev.String()
+}
+
+// An EventCPUs is an Event that can only be counted on certain CPUs, such as
+// an event of an uncore PMU, which counts for a whole socket or device. Such
+// an event must be opened on one of its CPUs, and can't be opened for a
+// thread. This comes from the PMU's cpumask file.
+type EventCPUs interface {
+	Event
+
+	// CPUs returns the CPUs this event must be opened on, or nil if it can
+	// be opened on any CPU or thread.
+	CPUs() []int
+}
+
+// CPUsOf returns the CPUs ev must be opened on, or nil if ev can be opened on
+// any CPU or thread.
+func CPUsOf(ev Event) []int {
+	if ec, ok := ev.(EventCPUs); ok {
+		return ec.CPUs()
+	}
+	return nil
+}
+
+// Priority is how important it is to keep an event on the PMU when there are
+// more events than hardware counters. By default, the kernel multiplexes
+// events, giving each a share of the time; see [WithPriority].
+type Priority uint8
+
+const (
+	// PriorityNormal events are multiplexed with other events as needed.
+	PriorityNormal Priority = iota
+
+	// PriorityPinned events are always counted while they're enabled. The
+	// kernel schedules them onto the PMU before any other events. If it
+	// can't, the event goes into an error state rather than being
+	// multiplexed, and reads fail. This is like perf's "D" modifier.
+	PriorityPinned
+)
+
+// An EventPriority is an Event that specifies its [Priority].
+type EventPriority interface {
+	Event
+
+	// Priority returns the priority of this event.
+	Priority() Priority
+}
+
+// PriorityOf returns the priority of ev, or PriorityNormal if ev doesn't
+// specify one.
+func PriorityOf(ev Event) Priority {
+	if ep, ok := ev.(EventPriority); ok {
+		return ep.Priority()
+	}
+	return PriorityNormal
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestEventBasic(t *testing.T) {
+	for _, tc := range []struct {
+		ev     Event
+		name   string
+		typ    uint32
+		config uint64
+	}{
+		{EventCPUCycles, "cpu-cycles", unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_CPU_CYCLES},
+		{EventInstructions, "instructions", unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_INSTRUCTIONS},
+		{EventCacheReferences, "cache-references", unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_CACHE_REFERENCES},
+		{EventCacheMisses, "cache-misses", unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_CACHE_MISSES},
+		{EventBranches, "branches", unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_BRANCH_INSTRUCTIONS},
+		{EventBranchesMisses, "branch-misses", unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_BRANCH_MISSES},
+		{EventBusCycles, "bus-cycles", unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_BUS_CYCLES},
+		{EventCPUClock, "cpu-clock", unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_CPU_CLOCK},
+		{EventTaskClock, "task-clock", unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_TASK_CLOCK},
+		{EventPageFaults, "page-faults", unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_PAGE_FAULTS},
+		{EventContextSwitches, "context-switches", unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_CONTEXT_SWITCHES},
+		{EventCPUMigrations, "cpu-migrations", unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_CPU_MIGRATIONS},
+		{EventMinorFaults, "minor-faults", unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_PAGE_FAULTS_MIN},
+		{EventMajorFaults, "major-faults", unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_PAGE_FAULTS_MAJ},
+		{EventAlignmentFaults, "alignment-faults", unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_ALIGNMENT_FAULTS},
+		{EventEmulationFaults, "emulation-faults", unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_EMULATION_FAULTS},
+		{EventDummy, "dummy", unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_DUMMY},
+		{EventBPFOutput, "bpf-output", unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_BPF_OUTPUT},
+	} {
+		var attr unix.PerfEventAttr
+		if err := tc.ev.SetAttrs(&attr); err != nil {
+			t.Fatal(err)
+		}
+		if attr.Type != tc.typ || attr.Config != tc.config {
+			t.Errorf("%s: got type %d config %d, want type %d config %d", tc.name, attr.Type, attr.Config, tc.typ, tc.config)
+		}
+		if got := tc.ev.String(); got != tc.name {
+			t.Errorf("%s: got name %q", tc.name, got)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseGroup parses a perf-style event group, such as
+// "{cycles,instructions}:u". Modifiers after the closing brace apply to every
+// event in the group, replacing any privilege levels of the individual
+// events. A spec that isn't in braces is parsed as a group of one event.
+//
+// The result can be passed directly to perf.OpenCounter, which opens the
+// events as a group so they're always counted at the same time.
+func ParseGroup(spec string) ([]Event, error) {
+	if !strings.HasPrefix(spec, "{") {
+		ev, err := ParseEvent(spec)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{ev}, nil
+	}
+
+	end := strings.LastIndexByte(spec, '}')
+	if end < 0 {
+		return nil, fmt.Errorf("event group %q: missing '}'", spec)
+	}
+	body, mods := spec[1:end], spec[end+1:]
+	if mods != "" {
+		var ok bool
+		mods, ok = strings.CutPrefix(mods, ":")
+		if !ok || mods == "" {
+			return nil, fmt.Errorf("event group %q: unexpected %q after '}'", spec, spec[end+1:])
+		}
+	}
+	names, err := splitEventList(body)
+	if err != nil {
+		return nil, fmt.Errorf("event group %q: %w", spec, err)
+	}
+	var evs []Event
+	for _, name := range names {
+		if strings.HasPrefix(name, "{") {
+			return nil, fmt.Errorf("event group %q: groups cannot be nested", spec)
+		}
+		ev, err := ParseEvent(name)
+		if err != nil {
+			return nil, err
+		}
+		if mods != "" {
+			ev, err = applyModifiers(ev, mods)
+			if err != nil {
+				return nil, fmt.Errorf("event group %q: %w", spec, err)
+			}
+		}
+		evs = append(evs, ev)
+	}
+	return evs, nil
+}
+
+// ParseEventList parses a comma-separated list of events, as in
+// "cycles,instructions,cpu/event=0x3c,umask=0x1/". Commas within a PMU event
+// or a braced group (see [ParseGroup]) don't separate events. The events of
+// each group are included in the result in order, and events with PMU
+// wildcards are expanded (see [ExpandEvent]).
+func ParseEventList(list string) ([]Event, error) {
+	names, err := splitEventList(list)
+	if err != nil {
+		return nil, fmt.Errorf("event list %q: %w", list, err)
+	}
+	var evs []Event
+	for _, name := range names {
+		var group []Event
+		if strings.HasPrefix(name, "{") {
+			group, err = ParseGroup(name)
+		} else {
+			group, err = ExpandEvent(name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		evs = append(evs, group...)
+	}
+	return evs, nil
+}
+
+// ExpandEvent is like [ParseEvent], but the PMU of a PMU event may be a
+// wildcard pattern using the syntax of [path.Match], as in
+// "uncore_cha_*/event=0x35/". This returns an event for each matching PMU,
+// such as "uncore_cha_0/event=0x35/". This is useful for uncore PMUs, which
+// typically have one instance per box or memory controller.
+//
+// On hybrid CPUs, which have a core PMU for each type of core, such as
+// cpu_core and cpu_atom, this expands a CPU event without a PMU, such as
+// "cpu-cycles", into an event for each core PMU that supports it, such as
+// "cpu_core/cpu-cycles/" and "cpu_atom/cpu-cycles/". Otherwise, the event
+// counts on only one type of core.
+//
+// For any other event, this returns just the parsed event.
+func ExpandEvent(name string) ([]Event, error) {
+	base, mods, ok := splitModifiers(name)
+	if !ok {
+		base, mods = name, ""
+	}
+	pmu, rest, ok := strings.Cut(base, "/")
+	if !ok || !isPMUWildcard(pmu) {
+		if evs := expandHybridEvent(name); len(evs) > 0 {
+			return evs, nil
+		}
+		ev, err := ParseEvent(name)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{ev}, nil
+	}
+	pmuNames, err := matchPMUs(pmu)
+	if err != nil {
+		return nil, fmt.Errorf("event %q: %w", name, err)
+	}
+	if len(pmuNames) == 0 {
+		return nil, fmt.Errorf("event %q: no PMUs match %q", name, pmu)
+	}
+	var evs []Event
+	for _, pmuName := range pmuNames {
+		ev, err := ParseEvent(pmuName + "/" + rest + mods)
+		if err != nil {
+			return nil, err
+		}
+		evs = append(evs, ev)
+	}
+	return evs, nil
+}
+
+// isPMUWildcard reports whether pmu is a wildcard pattern.
+func isPMUWildcard(pmu string) bool {
+	return strings.ContainsAny(pmu, "*?[")
+}
+
+// splitEventList splits a comma-separated list of events, ignoring commas
+// within PMU events, as in "cpu/event=0x3c,umask=0x1/", and within braced
+// groups.
+func splitEventList(list string) ([]string, error) {
+	var names []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(list); i++ {
+		switch list[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unexpected '}'")
+			}
+		case ',':
+			if depth > 0 || inPMUEvent(list[start:i]) {
+				continue
+			}
+			names = append(names, list[start:i])
+			start = i + 1
+		}
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("missing '}'")
+	}
+	names = append(names, list[start:])
+	for _, name := range names {
+		if name == "" {
+			return nil, fmt.Errorf("empty event name")
+		}
+	}
+	return names, nil
+}
+
+// inPMUEvent reports whether prefix is the unterminated beginning of a PMU
+// event, such as "cpu/event=0x3c". Breakpoints like "mem:0x1000/8" also
+// contain a slash, but PMU names can't contain a colon.
+func inPMUEvent(prefix string) bool {
+	i := strings.IndexByte(prefix, '/')
+	return i > 0 && !strings.Contains(prefix[:i], ":") && strings.Count(prefix, "/")%2 == 1
+}
@@ -0,0 +1,210 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseGroup(t *testing.T) {
+	for spec, want := range map[string][]string{
+		"cpu-cycles":                             {"cpu-cycles"},
+		"{cpu-cycles,instructions}":              {"cpu-cycles", "instructions"},
+		"{cpu-cycles,instructions}:u":            {"cpu-cycles:u", "instructions:u"},
+		"{cpu-cycles:k,instructions}:uD":         {"cpu-cycles:u:D", "instructions:u:D"},
+		"{cpu/event=0x3c,umask=1/,mem-stores:k}": {"cpu/event=0x3c,umask=1/", "mem-stores:k"},
+	} {
+		evs, err := ParseGroup(spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		var got []string
+		for _, ev := range evs {
+			got = append(got, ev.String())
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", spec, got, want)
+		}
+	}
+
+	for spec, want := range map[string]string{
+		"{cpu-cycles,instructions":    `event group "{cpu-cycles,instructions": missing '}'`,
+		"{cpu-cycles}u":               `event group "{cpu-cycles}u": unexpected "u" after '}'`,
+		"{cpu-cycles,,instructions}":  `event group "{cpu-cycles,,instructions}": empty event name`,
+		"{cpu-cycles,{instructions}}": `event group "{cpu-cycles,{instructions}}": groups cannot be nested`,
+		"{cpu-cycles}:pp":             `event group "{cpu-cycles}:pp": modifier 'p' is not supported`,
+		"{bogus}":                     `unknown event "bogus"`,
+	} {
+		_, err := ParseGroup(spec)
+		if err == nil || err.Error() != want {
+			t.Errorf("%s: got error %v, want %s", spec, err, want)
+		}
+	}
+}
+
+func TestParseEventList(t *testing.T) {
+	evs, err := ParseEventList("cpu-cycles,{instructions,mem-stores}:u,cpu/event=0x3c,umask=1/k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ev := range evs {
+		got = append(got, ev.String())
+	}
+	want := []string{"cpu-cycles", "instructions:u", "mem-stores:u", "cpu/event=0x3c,umask=1/k"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for list, want := range map[string]string{
+		"cpu-cycles,":              `event list "cpu-cycles,": empty event name`,
+		"cpu-cycles,instructions}": `event list "cpu-cycles,instructions}": unexpected '}'`,
+		"cpu-cycles,bogus":         `unknown event "bogus"`,
+	} {
+		_, err := ParseEventList(list)
+		if err == nil || err.Error() != want {
+			t.Errorf("%s: got error %v, want %s", list, err, want)
+		}
+	}
+}
+
+func TestSplitEventList(t *testing.T) {
+	for list, want := range map[string][]string{
+		"a":                          {"a"},
+		"a,b":                        {"a", "b"},
+		"cpu/event=0x3c,umask=1/,b":  {"cpu/event=0x3c,umask=1/", "b"},
+		"cpu/event=0x3c,umask=1/u,b": {"cpu/event=0x3c,umask=1/u", "b"},
+		"{a,b}:u,c":                  {"{a,b}:u", "c"},
+		"mem:0x1000/8:w,b":           {"mem:0x1000/8:w", "b"},
+	} {
+		got, err := splitEventList(list)
+		if err != nil {
+			t.Errorf("%s: %v", list, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", list, got, want)
+		}
+	}
+}
+
+func TestExpandEvent(t *testing.T) {
+	evs, err := ExpandEvent("uncore_cha_*/event=0x35,umask=1/u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ev := range evs {
+		var attr unix.PerfEventAttr
+		if err := ev.SetAttrs(&attr); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s type=%d config=%#x", ev, attr.Type, attr.Config))
+	}
+	want := []string{
+		"uncore_cha_0/event=0x35,umask=1/u type=20 config=0x135",
+		"uncore_cha_1/event=0x35,umask=1/u type=21 config=0x135",
+		"uncore_cha_10/event=0x35,umask=1/u type=30 config=0x135",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Events without wildcards expand to themselves.
+	evs, err = ExpandEvent("cpu-cycles:k")
+	if err != nil || len(evs) != 1 || evs[0].String() != "cpu-cycles:k" {
+		t.Errorf("got %v, %v, want [cpu-cycles:k]", evs, err)
+	}
+
+	evs, err = ParseEventList("cpu-cycles,uncore_cha_?/clockticks/")
+	if err != nil || len(evs) != 3 {
+		t.Errorf("got %v, %v, want cpu-cycles and 2 uncore events", evs, err)
+	}
+
+	for name, want := range map[string]string{
+		"bogus_*/event=1/":      `event "bogus_*/event=1/": no PMUs match "bogus_*"`,
+		"uncore_[/event=1/":     `event "uncore_[/event=1/": bad PMU pattern "uncore_[": syntax error in pattern`,
+		"uncore_cha_*/bogus=1/": `event "uncore_cha_0/bogus=1/": unknown event or parameter "bogus"`,
+	} {
+		_, err := ExpandEvent(name)
+		if err == nil || err.Error() != want {
+			t.Errorf("%s: got error %v, want %s", name, err, want)
+		}
+	}
+	if _, err := ParseEvent("uncore_cha_*/event=1/"); err == nil {
+		t.Errorf("ParseEvent with PMU wildcard: want error")
+	}
+}
+
+func TestPMUAlias(t *testing.T) {
+	// uncore_type_13_0 has the alias uncore_imc_0.
+	for _, name := range []string{"uncore_imc_0/cas_count_read/", "uncore_type_13_0/cas_count_read/"} {
+		ev, err := ParseEvent(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		var attr unix.PerfEventAttr
+		if err := ev.SetAttrs(&attr); err != nil {
+			t.Fatal(err)
+		}
+		if attr.Type != 40 || attr.Config != 0x304 {
+			t.Errorf("%s: got type=%d config=%#x, want type=40 config=0x304", name, attr.Type, attr.Config)
+		}
+	}
+
+	// Wildcards match aliases, but don't match a PMU twice.
+	for pattern, want := range map[string][]string{
+		"uncore_imc_*": {"uncore_imc_0"},
+		"uncore_*_0":   {"uncore_cha_0", "uncore_type_13_0"},
+	} {
+		got, err := matchPMUs(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", pattern, got, want)
+		}
+	}
+}
+
+func TestPMUCPUMask(t *testing.T) {
+	for _, tc := range []struct {
+		event string
+		want  []int
+	}{
+		{"cpu-cycles", nil},
+		{"cpu/event=0x3c/", nil},
+		{"uncore_cha_0/clockticks/", []int{0}},
+		{"uncore_imc_0/cas_count_read/", []int{0, 18}},
+		{"uncore_imc_0/cas_count_read/D", []int{0, 18}},
+	} {
+		ev, err := ParseEvent(tc.event)
+		if err != nil {
+			t.Errorf("%s: %v", tc.event, err)
+			continue
+		}
+		if got := CPUsOf(ev); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got CPUs %v, want %v", tc.event, got, tc.want)
+		}
+	}
+}
+
+func TestComparePMUNames(t *testing.T) {
+	names := []string{"uncore_imc_0", "uncore_cha_10", "uncore_cha", "uncore_cha_2", "uncore_cha_1"}
+	slices.SortFunc(names, comparePMUNames)
+	want := []string{"uncore_cha", "uncore_cha_1", "uncore_cha_2", "uncore_cha_10", "uncore_imc_0"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %q, want %q", names, want)
+	}
+}
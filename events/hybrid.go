@@ -0,0 +1,111 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// perfPMUTypeShift is PERF_PMU_TYPE_SHIFT. On hybrid CPUs, the upper bits of
+// the config of a hardware or hardware cache event select the core PMU to
+// count it on.
+const perfPMUTypeShift = 32
+
+// hybridPMUs returns the names of the core PMUs of a hybrid CPU, such as
+// "cpu_atom" and "cpu_core" on Intel CPUs with both E-cores and P-cores, or
+// nil if the CPU isn't hybrid. Hybrid CPUs have no "cpu" PMU. Instead, each
+// core type has its own PMU, which lists the CPUs of that type in its "cpus"
+// file.
+func hybridPMUs() []string {
+	if _, err := fs.Stat(pmuFS, "cpu"); err == nil {
+		return nil
+	}
+	names, err := matchPMUs("cpu_*")
+	if err != nil {
+		return nil
+	}
+	return slices.DeleteFunc(names, func(name string) bool {
+		_, err := fs.Stat(pmuFS, name+"/cpus")
+		return err != nil
+	})
+}
+
+// isHybridPMU reports whether pmu is a core PMU of a hybrid CPU.
+func isHybridPMU(pmu string) bool {
+	return strings.HasPrefix(pmu, "cpu_") && slices.Contains(hybridPMUs(), pmu)
+}
+
+// resolveHybridEvent resolves a symbolic event on a hybrid CPU. The event must
+// exist on exactly one of the core PMUs. Otherwise, the caller must choose
+// which core PMU to count it on, or count it on all of them with
+// [ExpandEvent].
+func resolveHybridEvent(enc string, terms eventTerms, params []eventParam, hybrid []string) (Event, error) {
+	var found []Event
+	var foundPMUs []string
+	var firstErr error
+	for _, pmu := range hybrid {
+		ev, err := resolveEvent(enc, pmu, params)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		found = append(found, terms.apply(ev))
+		foundPMUs = append(foundPMUs, pmu)
+	}
+	switch len(found) {
+	case 0:
+		return nil, firstErr
+	case 1:
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("event %q exists on hybrid core PMUs %s; choose one, as in %q, or use ExpandEvent", enc, strings.Join(foundPMUs, ", "), foundPMUs[0]+"/"+enc+"/")
+}
+
+// expandHybridEvent returns an event for each core PMU of a hybrid CPU that
+// name exists on. It returns nil if the CPU isn't hybrid or name isn't a
+// symbolic CPU event.
+func expandHybridEvent(name string) []Event {
+	hybrid := hybridPMUs()
+	if len(hybrid) == 0 || strings.ContainsAny(name, "/@") {
+		return nil
+	}
+	base, mods, ok := splitModifiers(name)
+	if !ok {
+		base, mods = name, ""
+	}
+	if strings.Contains(base, ":") {
+		// A tracepoint or other special event.
+		return nil
+	}
+	var evs []Event
+	for _, pmu := range hybrid {
+		ev, err := ParseEvent(pmu + "/" + base + "/" + mods)
+		if err == nil {
+			evs = append(evs, ev)
+		}
+	}
+	return evs
+}
+
+// pmuOfType returns the name of the PMU with the given type.
+func pmuOfType(typ uint32) (string, bool) {
+	ents, err := fs.ReadDir(pmuFS, ".")
+	if err != nil {
+		return "", false
+	}
+	for _, ent := range ents {
+		if desc, err := pmus.get(ent.Name()); err == nil && desc.pmu == typ {
+			return ent.Name(), true
+		}
+	}
+	return "", false
+}
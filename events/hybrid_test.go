@@ -0,0 +1,92 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+//go:embed testdata/hybridfs
+var testHybridFS embed.FS
+
+// useHybridPMUs switches to a fake PMU file system of a hybrid CPU for the
+// duration of the test.
+func useHybridPMUs(t *testing.T) {
+	oldDir, oldFS := pmuDir, pmuFS
+	t.Cleanup(func() { pmuDir, pmuFS = oldDir, oldFS; pmus = newOnceMap(pmus.new) })
+	pmuDir = "testdata/hybridfs"
+	pmuFS, _ = fs.Sub(testHybridFS, pmuDir)
+	pmus = newOnceMap(pmus.new)
+}
+
+func TestHybrid(t *testing.T) {
+	useHybridPMUs(t)
+
+	if got, want := hybridPMUs(), []string{"cpu_atom", "cpu_core"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("hybridPMUs() = %q, want %q", got, want)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		str    string
+		typ    uint32
+		config uint64
+	}{
+		// Builtin events without a PMU use the legacy encoding.
+		{"cpu-cycles", "cpu-cycles", unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_CPU_CYCLES},
+		{"cpu_core/cpu-cycles/", "cpu_core/cpu-cycles/", unix.PERF_TYPE_HARDWARE, 4<<32 | unix.PERF_COUNT_HW_CPU_CYCLES},
+		{"cpu_atom/instructions/", "cpu_atom/instructions/", unix.PERF_TYPE_HARDWARE, 10<<32 | unix.PERF_COUNT_HW_INSTRUCTIONS},
+		{"cpu_atom/L1-dcache-loads/", "cpu_atom/L1-dcache-loads/", unix.PERF_TYPE_HW_CACHE, 10 << 32},
+		// Symbolic events resolve against the core PMU that has them.
+		{"slots", "slots", 4, 0x2c2},
+		{"cpu_atom/mem-stores/", "cpu_atom/mem-stores/", 10, 0x82d0},
+	} {
+		ev, err := ParseEvent(tc.name)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var attr unix.PerfEventAttr
+		if err := ev.SetAttrs(&attr); err != nil {
+			t.Fatal(err)
+		}
+		if ev.String() != tc.str || attr.Type != tc.typ || attr.Config != tc.config {
+			t.Errorf("%s: got %s type %d config %#x, want %s type %d config %#x", tc.name, ev, attr.Type, attr.Config, tc.str, tc.typ, tc.config)
+		}
+		if got := AttrName(&attr); tc.str != "slots" && got != tc.str {
+			t.Errorf("%s: AttrName = %q, want %q", tc.name, got, tc.str)
+		}
+	}
+
+	want := `event "mem-stores" exists on hybrid core PMUs cpu_atom, cpu_core; choose one, as in "cpu_atom/mem-stores/", or use ExpandEvent`
+	if _, err := ParseEvent("mem-stores"); err == nil || err.Error() != want {
+		t.Errorf("ambiguous event: got error %v, want %s", err, want)
+	}
+
+	for name, want := range map[string][]string{
+		"cpu-cycles:u": {"cpu_atom/cpu-cycles/u", "cpu_core/cpu-cycles/u"},
+		"mem-stores":   {"cpu_atom/mem-stores/", "cpu_core/mem-stores/"},
+		"slots":        {"cpu_core/slots/"},
+		// Software events aren't per core type.
+		"task-clock": {"task-clock"},
+	} {
+		evs, err := ExpandEvent(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got := fmt.Sprint(evs); got != fmt.Sprint(want) {
+			t.Errorf("ExpandEvent(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
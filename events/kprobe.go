@@ -0,0 +1,88 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// probeEvent is a dynamic probe created through the kprobe or uprobe PMU.
+type probeEvent struct {
+	name   string
+	pmu    uint32
+	config uint64 // Includes the retprobe bit for return probes
+	target []byte // NUL-terminated kernel function name or binary path
+	offset uint64
+}
+
+func (e *probeEvent) isEvent() {}
+
+func (e *probeEvent) String() string {
+	return e.name
+}
+
+func (e *probeEvent) SetAttrs(attr *unix.PerfEventAttr) error {
+	attr.Type = e.pmu
+	attr.Config = e.config
+	// kprobe_func/uprobe_path and probe_offset are unions with config1
+	// and config2. The kernel copies the string when the event is opened.
+	// The event holds the string, so it stays live as long as the attr is
+	// used.
+	attr.Ext1 = uint64(uintptr(unsafe.Pointer(&e.target[0])))
+	attr.Ext2 = e.offset
+	return nil
+}
+
+// Kprobe returns an Event that occurs each time the kernel executes the
+// instruction offset bytes into kernel function fn. An offset of 0 probes
+// entries to fn.
+//
+// Kprobe uses the kernel's kprobe PMU, which creates the probe when a
+// Counter or Sampler opens the event and removes it when it's closed, so it
+// doesn't leave probes behind in tracefs like "perf probe". The kprobe PMU
+// requires Linux 4.17 or later, and opening a kprobe typically requires root.
+func Kprobe(fn string, offset uint64) (Event, error) {
+	name := "kprobe:" + fn
+	if offset != 0 {
+		name += fmt.Sprintf("+%#x", offset)
+	}
+	return newProbe("kprobe", name, fn, offset, false)
+}
+
+// Kretprobe is like [Kprobe], but returns an Event that occurs each time
+// kernel function fn returns.
+func Kretprobe(fn string) (Event, error) {
+	return newProbe("kprobe", "kretprobe:"+fn, fn, 0, true)
+}
+
+// newProbe returns a probe event of PMU pmu ("kprobe" or "uprobe") on target.
+func newProbe(pmu, name, target string, offset uint64, retprobe bool) (Event, error) {
+	if target == "" || strings.ContainsRune(target, 0) {
+		return nil, fmt.Errorf("%s: bad probe target %q", name, target)
+	}
+	desc, err := pmus.get(pmu)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	ev := &probeEvent{name: name, pmu: desc.pmu, target: append([]byte(target), 0), offset: offset}
+	if retprobe {
+		f, ok := desc.getFormat("retprobe")
+		if !ok {
+			return nil, fmt.Errorf("%s: %s PMU doesn't support return probes", name, pmu)
+		}
+		var raw rawEvent
+		if err := f.set(&raw, 1); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		ev.config = raw.config
+	}
+	return ev, nil
+}
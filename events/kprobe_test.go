@@ -0,0 +1,60 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"io/fs"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestKprobe(t *testing.T) {
+	for _, tc := range []struct {
+		ev     func() (Event, error)
+		name   string
+		config uint64
+		offset uint64
+	}{
+		{func() (Event, error) { return Kprobe("do_sys_openat2", 0) }, "kprobe:do_sys_openat2", 0, 0},
+		{func() (Event, error) { return Kprobe("do_sys_openat2", 0x10) }, "kprobe:do_sys_openat2+0x10", 0, 0x10},
+		{func() (Event, error) { return Kretprobe("do_sys_openat2") }, "kretprobe:do_sys_openat2", 1, 0},
+	} {
+		ev, err := tc.ev()
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := ev.String(); got != tc.name {
+			t.Errorf("got name %q, want %q", got, tc.name)
+		}
+		var attr unix.PerfEventAttr
+		if err := ev.SetAttrs(&attr); err != nil {
+			t.Fatal(err)
+		}
+		if attr.Type != 6 || attr.Config != tc.config || attr.Ext2 != tc.offset {
+			t.Errorf("%s: got type %d, config %#x, offset %#x; want type 6, config %#x, offset %#x", tc.name, attr.Type, attr.Config, attr.Ext2, tc.config, tc.offset)
+		}
+		pev := ev.(*probeEvent)
+		if string(pev.target) != "do_sys_openat2\x00" || attr.Ext1 != uint64(uintptr(unsafe.Pointer(&pev.target[0]))) {
+			t.Errorf("%s: kprobe_func doesn't point to NUL-terminated do_sys_openat2", tc.name)
+		}
+	}
+
+	if _, err := Kprobe("", 0); err == nil {
+		t.Errorf("Kprobe with empty function: want error")
+	}
+
+	old := pmuFS
+	defer func() { pmuFS = old; pmus = newOnceMap(pmus.new) }()
+	pmuFS, _ = fs.Sub(testPMUFS, "testdata/pmufs/cpu")
+	pmus = newOnceMap(pmus.new)
+	if _, err := Kprobe("do_sys_openat2", 0); err == nil {
+		t.Errorf("Kprobe without kprobe PMU: want error")
+	}
+}
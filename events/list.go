@@ -0,0 +1,260 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// A PMU describes a performance monitoring unit in sysfs.
+type PMU struct {
+	// Name is the name of the PMU, such as "cpu" or "uncore_imc_0".
+	Name string
+
+	// Alias is another name for the PMU from its alias file, or "" if it
+	// has none. [ParseEvent] accepts either name.
+	Alias string
+
+	// Type is the PMU's perf_event_attr type.
+	Type uint32
+
+	// CPUs is the CPUs that events of this PMU must be opened on, or nil if
+	// they can be opened on any CPU or thread. See [EventCPUs].
+	CPUs []int
+}
+
+// ListPMUs returns the PMUs in sysfs. They're sorted by their non-numeric
+// prefix and then by their numeric suffix, so "uncore_cha_2" comes before
+// "uncore_cha_10".
+func ListPMUs() ([]PMU, error) {
+	ents, err := fs.ReadDir(pmuFS, ".")
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", pmuDir, err)
+	}
+	names := make([]string, 0, len(ents))
+	for _, ent := range ents {
+		names = append(names, ent.Name())
+	}
+	slices.SortFunc(names, comparePMUNames)
+
+	aliases := make(map[string]string)
+	for alias, name := range pmuAliases() {
+		aliases[name] = alias
+	}
+	var out []PMU
+	for _, name := range names {
+		desc, err := pmus.get(name)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, PMU{
+			Name:  name,
+			Alias: aliases[name],
+			Type:  desc.pmu,
+			CPUs:  desc.cpumask,
+		})
+	}
+	return out, nil
+}
+
+// An EventInfo describes a named event.
+type EventInfo struct {
+	// Name is the name of the event, which can be passed to [ParseEvent].
+	Name string
+
+	// Aliases are other names for the event, if any.
+	Aliases []string
+
+	// PMU is the name of the event's PMU, or "" for events built in to
+	// perf, such as "cpu-cycles" and "L1-dcache-loads".
+	PMU string
+
+	// Encoding is the PMU and config fields of the event, as in
+	// "cpu/event=0xd0,umask=0x82/". Built-in events are encoded using the
+	// PMU type number, as in "pmu0/config=0x0/". See [AttrName].
+	Encoding string
+
+	// Scale and Unit convert raw counts of the event into meaningful values.
+	// See [EventScale].
+	Scale float64
+	Unit  string
+}
+
+// ListEvents returns the named events of PMU pmu, or of all PMUs if pmu is "".
+// This includes perf's built-in events (only if pmu is ""), the events of each
+// PMU in sysfs, and the events of the core PMU in the pmu-events database (if
+// it has been generated) or listed by "perf list -j", if perf is installed.
+// The events are sorted by PMU (as in [ListPMUs]) and then by name.
+//
+// This lists only individual events. Cache events can also be written in
+// other forms, such as "l1d-loads" for "L1-dcache-loads", and PMU events can
+// be encoded directly, as in "cpu/event=0x3c/".
+func ListEvents(pmu string) ([]EventInfo, error) {
+	var out []EventInfo
+	if pmu == "" {
+		out = builtinEventInfos()
+	}
+
+	pmuList, err := ListPMUs()
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, p := range pmuList {
+		if pmu != "" && pmu != p.Name && pmu != p.Alias {
+			continue
+		}
+		found = true
+		desc, err := pmus.get(p.Name)
+		if err != nil {
+			return nil, err
+		}
+		var infos []EventInfo
+		for name := range desc.events {
+			if _, ok := resolveBuiltinEvent(p.Name, name); ok {
+				// This name refers to the builtin event.
+				continue
+			}
+			ev, err := ParseEvent(p.Name + "/" + name + "/")
+			if err != nil {
+				// Skip events we can't use.
+				continue
+			}
+			infos = append(infos, newEventInfo(ev, p.Name, desc))
+		}
+		if p.Name == "cpu" {
+			infos = append(infos, pmuEventsEventInfos(desc)...)
+			infos = append(infos, perfListEventInfos(desc)...)
+		}
+		slices.SortFunc(infos, func(a, b EventInfo) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		out = append(out, infos...)
+	}
+	if pmu != "" && !found {
+		return nil, fmt.Errorf("unknown PMU %q", pmu)
+	}
+	return out, nil
+}
+
+// newEventInfo returns the EventInfo of ev, an event of PMU pmuName with
+// description desc.
+func newEventInfo(ev Event, pmuName string, desc *pmuDesc) EventInfo {
+	info := EventInfo{Name: ev.String(), PMU: pmuName}
+	info.Scale, info.Unit = ScaleUnitOf(ev)
+	var attr unix.PerfEventAttr
+	if err := ev.SetAttrs(&attr); err == nil {
+		raw := rawEvent{config: attr.Config, config1: attr.Ext1, config2: attr.Ext2}
+		if desc != nil && attr.Type == desc.pmu {
+			info.Encoding = pmuName + "/" + raw.configString(desc) + "/"
+		} else {
+			info.Encoding = fmt.Sprintf("pmu%d/%s/", attr.Type, raw.configString(nil))
+		}
+	}
+	return info
+}
+
+// builtinEventInfos returns the EventInfos of the built-in hardware, software,
+// and cache events, sorted by name.
+func builtinEventInfos() []EventInfo {
+	initBuiltinEvents()
+	var infos []EventInfo
+	add := func(typ uint32, config uint64, names []string) {
+		info := newEventInfo(builtinEvent{names[0], typ, config}, "", nil)
+		if len(names) > 1 {
+			info.Aliases = names[1:]
+		}
+		infos = append(infos, info)
+	}
+	for config, names := range builtinEvents.cpuNames {
+		add(unix.PERF_TYPE_HARDWARE, config, names)
+	}
+	for config, names := range builtinEvents.softwareNames {
+		add(unix.PERF_TYPE_SOFTWARE, config, names)
+	}
+	for cache := range builtinEvents.cacheNames {
+		for op := range builtinEvents.cacheOpNames {
+			for result := range builtinEvents.cacheResultNames {
+				config := cache | op<<8 | result<<16
+				if name, ok := builtinAttrName(unix.PERF_TYPE_HW_CACHE, config); ok {
+					add(unix.PERF_TYPE_HW_CACHE, config, []string{name})
+				}
+			}
+		}
+	}
+	slices.SortFunc(infos, func(a, b EventInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return infos
+}
+
+// pmuEventsEventInfos returns the EventInfos of the core PMU events in the
+// pmu-events database that aren't built-in or in sysfs. cpu is the core PMU.
+func pmuEventsEventInfos(cpu *pmuDesc) []EventInfo {
+	table, err := getPMUEvents()
+	if err != nil {
+		return nil
+	}
+	var infos []EventInfo
+	for name := range table {
+		if _, ok := resolveBuiltinEvent("", name); ok {
+			continue
+		}
+		if _, ok := cpu.events[name]; ok {
+			continue
+		}
+		ev := &rawEvent{name: name, pmu: cpu.pmu, scale: 1.0}
+		if err := resolvePMUEventsEvent(cpu, name, ev); err != nil {
+			continue
+		}
+		infos = append(infos, newEventInfo(ev, "cpu", cpu))
+	}
+	return infos
+}
+
+// perfListEventInfos returns the EventInfos of the core PMU events listed by
+// "perf list -j" that aren't built-in, in sysfs, or in the pmu-events
+// database. cpu is the core PMU. If perf isn't available, it returns nil.
+func perfListEventInfos(cpu *pmuDesc) []EventInfo {
+	list, err := getPerfList()
+	if err != nil {
+		return nil
+	}
+	table, _ := getPMUEvents()
+	var infos []EventInfo
+	for key, evJSON := range list {
+		name := evJSON.EventName
+		if key != name || evJSON.Encoding == "" {
+			// An alias, or not a core PMU event.
+			continue
+		}
+		if _, ok := resolveBuiltinEvent("", name); ok {
+			continue
+		}
+		if _, ok := cpu.events[name]; ok {
+			continue
+		}
+		if _, ok := table[name]; ok {
+			continue
+		}
+		ev := &rawEvent{name: name, pmu: cpu.pmu, scale: 1.0}
+		if err := evJSON.toRawEvent(cpu, ev); err != nil {
+			continue
+		}
+		info := newEventInfo(ev, "cpu", cpu)
+		if alias := evJSON.EventAlias; alias != "" && !strings.Contains(alias, "/") {
+			info.Aliases = []string{alias}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestListPMUs(t *testing.T) {
+	pmuList, err := ListPMUs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range pmuList {
+		names = append(names, p.Name)
+	}
+	want := []string{"cpu", "fake", "kprobe", "uncore_cha_0", "uncore_cha_1", "uncore_cha_10", "uncore_type_13_0", "uprobe"}
+	if !slices.Equal(names, want) {
+		t.Errorf("got PMUs %q, want %q", names, want)
+	}
+	imc := pmuList[slices.Index(names, "uncore_type_13_0")]
+	if want := (PMU{"uncore_type_13_0", "uncore_imc_0", 40, []int{0, 18}}); !reflect.DeepEqual(imc, want) {
+		t.Errorf("got %+v, want %+v", imc, want)
+	}
+}
+
+func TestListEvents(t *testing.T) {
+	all, err := ListEvents("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]EventInfo)
+	for _, info := range all {
+		if _, ok := byName[info.Name]; ok {
+			t.Errorf("duplicate event %q", info.Name)
+		}
+		byName[info.Name] = info
+
+		// Every listed event should parse.
+		if _, err := ParseEvent(info.Name); err != nil {
+			t.Errorf("%s: %v", info.Name, err)
+		}
+	}
+	for _, want := range []EventInfo{
+		{"cpu-cycles", []string{"cycles"}, "", "pmu0/config=0x0/", 1, ""},
+		{"task-clock", nil, "", "pmu1/config=0x1/", 1, ""},
+		{"L1-dcache-load-misses", nil, "", "pmu3/config=0x10000/", 1, ""},
+		{"cpu/mem-stores/", nil, "cpu", "cpu/event=0xd0,umask=0x82/", 1, ""},
+		{"br_inst_retired.cond_taken", nil, "cpu", "cpu/event=0xc4,umask=0x1/", 1, ""},
+		{"fakescaled", nil, "cpu", "cpu/config=0x0/", 100, "%"},
+		{"fake/scaled/", nil, "fake", "fake/config=0x0/", 2.5e-10, "Joules"},
+		{"uncore_type_13_0/cas_count_read/", nil, "uncore_type_13_0", "uncore_type_13_0/event=0x4,umask=0x3/", 1, ""},
+	} {
+		got, ok := byName[want.Name]
+		if !ok {
+			t.Errorf("event %q not listed", want.Name)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+	// Events in perf list that are in sysfs are listed only under their
+	// sysfs name.
+	if _, ok := byName["mem-stores"]; ok {
+		t.Errorf("event %q listed under perf list name", "mem-stores")
+	}
+
+	// Listing one PMU, by name or alias.
+	for _, pmu := range []string{"uncore_type_13_0", "uncore_imc_0"} {
+		evs, err := ListEvents(pmu)
+		if err != nil || len(evs) != 1 || evs[0].Name != "uncore_type_13_0/cas_count_read/" {
+			t.Errorf("ListEvents(%q) = %+v, %v; want just cas_count_read", pmu, evs, err)
+		}
+	}
+	if _, err := ListEvents("bogus"); err == nil {
+		t.Errorf("ListEvents of unknown PMU: want error")
+	}
+}
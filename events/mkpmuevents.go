@@ -0,0 +1,174 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build ignore
+
+// mkpmuevents generates the pmu-events database in pmu-events/ from the
+// tables in a Linux source tree. It keeps only the core PMU events of each
+// CPU model and only the fields used by this package, and compresses each
+// model's table. The tables are part of Linux, so it also copies the Linux
+// license notice.
+//
+// Usage:
+//
+//	go run mkpmuevents.go -linux /path/to/linux
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// fields are the fields of each event to keep. All values are converted to
+// strings.
+var fields = []string{
+	"EventName",
+	"EventCode",
+	"UMask",
+	"CounterMask",
+	"Invert",
+	"EdgeDetect",
+	"AnyThread",
+	"MSRIndex",
+	"MSRValue",
+	"SampleAfterValue",
+	"BriefDescription",
+	"PublicDescription",
+}
+
+func main() {
+	linux := flag.String("linux", "", "path to a Linux source tree")
+	out := flag.String("o", "pmu-events", "output directory")
+	flag.Parse()
+	if *linux == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := os.MkdirAll(*out, 0777); err != nil {
+		log.Fatal(err)
+	}
+	license, err := os.ReadFile(filepath.Join(*linux, "COPYING"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	notice := "The files in this directory are generated by mkpmuevents.go from\n" +
+		"tools/perf/pmu-events in the Linux source tree, which is distributed\n" +
+		"under the following license.\n\n"
+	if err := os.WriteFile(filepath.Join(*out, "LICENSE"), append([]byte(notice), license...), 0666); err != nil {
+		log.Fatal(err)
+	}
+
+	// TODO: Support arm64, which identifies CPUs by MIDR.
+	for _, arch := range []string{"x86"} {
+		src := filepath.Join(*linux, "tools/perf/pmu-events/arch", arch)
+		dst := filepath.Join(*out, arch)
+		if err := genArch(src, dst); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func genArch(src, dst string) error {
+	if err := os.MkdirAll(dst, 0777); err != nil {
+		return err
+	}
+
+	// Copy the core rows of the mapfile.
+	f, err := os.Open(filepath.Join(src, "mapfile.csv"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var mapfile strings.Builder
+	var models []string
+	scanner := bufio.NewScanner(f)
+	for first := true; scanner.Scan(); first = false {
+		line := scanner.Text()
+		cols := strings.Split(line, ",")
+		if len(cols) < 4 || !first && cols[3] != "core" {
+			continue
+		}
+		mapfile.WriteString(line + "\n")
+		if !first && !slices.Contains(models, cols[2]) {
+			models = append(models, cols[2])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dst, "mapfile.csv"), []byte(mapfile.String()), 0666); err != nil {
+		return err
+	}
+
+	for _, model := range models {
+		if err := genModel(filepath.Join(src, model), filepath.Join(dst, model+".json.gz")); err != nil {
+			return fmt.Errorf("model %s: %w", model, err)
+		}
+	}
+	return nil
+}
+
+func genModel(src, dst string) error {
+	paths, err := filepath.Glob(filepath.Join(src, "*.json"))
+	if err != nil {
+		return err
+	}
+	var events []map[string]string
+	for _, path := range paths {
+		name := filepath.Base(path)
+		if strings.HasSuffix(name, "metrics.json") || strings.HasPrefix(name, "uncore-") {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// Keep numbers as they're written.
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var raw []map[string]any
+		if err := dec.Decode(&raw); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		topic := strings.TrimSuffix(name, ".json")
+		for _, ev := range raw {
+			if ev["EventName"] == nil || ev["Unit"] != nil {
+				// A metric or an uncore event.
+				continue
+			}
+			out := map[string]string{"Topic": topic}
+			for _, field := range fields {
+				if v, ok := ev[field]; ok {
+					out[field] = fmt.Sprint(v)
+				}
+			}
+			events = append(events, out)
+		}
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	if err := json.NewEncoder(zw).Encode(events); err != nil {
+		f.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"fmt"
+	"strings"
+)
+
+// modifierChars are the perf event modifiers recognized by splitModifiers.
+// Not all of these are supported by applyModifiers.
+const modifierChars = "ukhGHDp"
+
+// splitModifiers splits perf-style modifiers from the end of an event name,
+// as in "cycles:u" or "cpu/event=0x3c/uk". If name doesn't end in modifiers,
+// it returns ok == false.
+func splitModifiers(name string) (base, mods string, ok bool) {
+	if i := strings.LastIndexByte(name, '/'); i > 0 && strings.Count(name, "/") == 2 {
+		// PMU events put modifiers directly after the closing slash.
+		base, mods = name[:i+1], name[i+1:]
+	} else if i := strings.LastIndexByte(name, ':'); i > 0 {
+		base, mods = name[:i], name[i+1:]
+	} else {
+		return "", "", false
+	}
+	if mods == "" || strings.Trim(mods, modifierChars) != "" {
+		return "", "", false
+	}
+	return base, mods, true
+}
+
+// applyModifiers applies perf-style modifiers to ev. It supports the
+// privilege modifiers u, k, h, G, and H (see [WithPrivilege]) and the D
+// modifier (see [WithPriority]).
+func applyModifiers(ev Event, mods string) (Event, error) {
+	var priv Privilege
+	pinned := false
+	for _, c := range mods {
+		switch c {
+		case 'u':
+			priv |= PrivUser
+		case 'k':
+			priv |= PrivKernel
+		case 'h':
+			priv |= PrivHypervisor
+		case 'G':
+			priv |= PrivGuest
+		case 'H':
+			priv |= PrivHost
+		case 'D':
+			pinned = true
+		default:
+			// Precise sampling is an option of the Sampler, not the
+			// event.
+			return nil, fmt.Errorf("modifier %q is not supported", c)
+		}
+	}
+	if priv != 0 {
+		const levels = PrivUser | PrivKernel | PrivHypervisor
+		if priv&levels == 0 {
+			// Just G or H counts at all levels, like perf.
+			priv |= levels
+		}
+		ev = WithPrivilege(ev, priv)
+	}
+	if pinned {
+		ev = WithPriority(ev, PriorityPinned)
+	}
+	return ev, nil
+}
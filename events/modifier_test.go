@@ -0,0 +1,60 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseModifiers(t *testing.T) {
+	const (
+		exU = unix.PerfBitExcludeUser
+		exK = unix.PerfBitExcludeKernel
+		exH = unix.PerfBitExcludeHv
+		exG = unix.PerfBitExcludeGuest
+		exX = unix.PerfBitExcludeHost
+		pin = unix.PerfBitPinned
+	)
+	for _, tc := range []struct {
+		name   string
+		typ    uint32
+		config uint64
+		bits   uint64
+	}{
+		{"cpu-cycles:u", unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_CPU_CYCLES, exK | exH},
+		{"cpu-cycles:kD", unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_CPU_CYCLES, exU | exH | pin},
+		{"cpu-cycles:G", unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_CPU_CYCLES, exX},
+		{"cpu-cycles:uH", unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_CPU_CYCLES, exK | exH | exG},
+		{"cpu/mem-stores/u", unix.PERF_TYPE_RAW, 0xd0 | 0x82<<8, exK | exH},
+		{"cpu/event=0x3c,umask=1/k", unix.PERF_TYPE_RAW, 0x13c, exU | exH},
+	} {
+		ev, err := ParseEvent(tc.name)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var attr unix.PerfEventAttr
+		if err := ev.SetAttrs(&attr); err != nil {
+			t.Fatal(err)
+		}
+		if attr.Type != tc.typ || attr.Config != tc.config || attr.Bits != tc.bits {
+			t.Errorf("%s: got type %d, config %#x, bits %#x, want %d, %#x, %#x", tc.name, attr.Type, attr.Config, attr.Bits, tc.typ, tc.config, tc.bits)
+		}
+	}
+
+	for name, want := range map[string]string{
+		"cpu-cycles:pp": `event "cpu-cycles:pp": modifier 'p' is not supported`,
+		"cpu-cycles:x":  `unknown event "cpu-cycles:x"`,
+	} {
+		_, err := ParseEvent(name)
+		if err == nil || err.Error() != want {
+			t.Errorf("%s: got error %v, want %s", name, err, want)
+		}
+	}
+}
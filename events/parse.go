@@ -21,10 +21,19 @@ type rawEvent struct {
 	config  uint64
 	config1 uint64
 	config2 uint64
-	period  uint64
+	period  uint64 // Sample period; mutually exclusive with freq
+	freq    uint64 // Sample frequency; mutually exclusive with period
 
 	scale float64
 	unit  string
+
+	warnings []string
+
+	filter string // Tracepoint filter
+
+	percore  bool   // See EventPerCore
+	metricID string // See EventMetricID
+	cpus     []int  // See EventCPUs
 }
 
 // *rawEvent implements Event
@@ -41,7 +50,14 @@ func (e *rawEvent) SetAttrs(attr *unix.PerfEventAttr) error {
 	attr.Config = e.config
 	attr.Ext1 = e.config1
 	attr.Ext2 = e.config2
-	attr.Sample = e.period // Union of sample_period and sample_freq
+	// attr.Sample is a union of sample_period and sample_freq.
+	if e.freq != 0 {
+		attr.Sample = e.freq
+		attr.Bits |= unix.PerfBitFreq
+	} else {
+		attr.Sample = e.period
+		attr.Bits &^= unix.PerfBitFreq
+	}
 	return nil
 }
 
@@ -49,12 +65,58 @@ func (e *rawEvent) ScaleUnit() (float64, string) {
 	return e.scale, e.unit
 }
 
+func (e *rawEvent) SampleRate() (period, freq uint64) {
+	return e.period, e.freq
+}
+
+func (e *rawEvent) Warnings() []string {
+	return e.warnings
+}
+
+func (e *rawEvent) Filter() string {
+	return e.filter
+}
+
+func (e *rawEvent) PerCore() bool {
+	return e.percore
+}
+
+func (e *rawEvent) CPUs() []int {
+	return e.cpus
+}
+
+func (e *rawEvent) MetricID() string {
+	if e.metricID == "" {
+		return e.name
+	}
+	return e.metricID
+}
+
 func ParseEvent(name string) (Event, error) {
 	// TODO: Support raw events
-	// TODO: Support modifiers
-	// TODO: Support hardware breakpoint events
+
+	if ev, err := parseBreakpoint(name); err != errNotBreakpoint {
+		return ev, err
+	}
+	if ev, err := parseTracepoint(name); err != errNotTracepoint {
+		return ev, err
+	}
+	if base, mods, ok := splitModifiers(name); ok {
+		ev, err := ParseEvent(base)
+		if err != nil {
+			return nil, err
+		}
+		ev, err = applyModifiers(ev, mods)
+		if err != nil {
+			return nil, fmt.Errorf("event %q: %w", name, err)
+		}
+		return ev, nil
+	}
 
 	pmu, params, err := parsePMUEvent(name)
+	if err == nil && isPMUWildcard(pmu) {
+		return nil, fmt.Errorf("event %q: PMU wildcards can match several PMUs; use ExpandEvent", name)
+	}
 	if err == errNotPMUEvent {
 		// Try as a symbolic event.
 		pmu = ""
@@ -86,7 +148,8 @@ func parsePMUEvent(name string) (pmu string, params []eventParam, err error) {
 type eventParam struct {
 	k     string
 	v     uint64
-	kOnly bool // Param may be an event name or k=1
+	kOnly bool   // Param may be an event name or k=1
+	str   string // Value of a string parameter: name or metric-id
 }
 
 // parseParamList parses a comma-separated list of k strings and k=v pairs. Lone
@@ -107,7 +170,19 @@ func parseParamList(list string) ([]eventParam, error) {
 			return nil, errf("missing parameter name in %q", s)
 		}
 		if !ok {
-			params = append(params, eventParam{k, 1, true})
+			params = append(params, eventParam{k, 1, true, ""})
+			continue
+		}
+		if k == "name" || k == "metric-id" {
+			// name and metric-id are the only parameters with a string
+			// value. name gives the event a name to use in place of its
+			// encoding, for example to distinguish the same event
+			// counted twice. metric-id names the event in metric
+			// expressions.
+			if vs == "" {
+				return nil, errf("empty %s in %q", k, s)
+			}
+			params = append(params, eventParam{k, 0, false, vs})
 			continue
 		}
 		// The value can be decimal, hex, or octal.
@@ -115,12 +190,67 @@ func parseParamList(list string) ([]eventParam, error) {
 		if err != nil {
 			return nil, errf("parameter %q not a number", s)
 		}
-		params = append(params, eventParam{k, v, false})
+		params = append(params, eventParam{k, v, false, ""})
 	}
 
 	return params, nil
 }
 
+// eventTerms are the terms of a PMU event that don't affect its encoding.
+type eventTerms struct {
+	name     string // From name=, or "" for the default name
+	percore  bool   // From percore
+	metricID string // From metric-id=
+}
+
+// cutEventTerms removes the terms that don't affect the encoding of an event
+// from params and returns them.
+func cutEventTerms(enc string, params []eventParam) (eventTerms, []eventParam, error) {
+	var terms eventTerms
+	rest := make([]eventParam, 0, len(params))
+	for _, param := range params {
+		switch {
+		case param.k == "name" && !param.kOnly:
+			if terms.name != "" {
+				return terms, nil, fmt.Errorf("event %q: multiple names %q and %q", enc, terms.name, param.str)
+			}
+			terms.name = param.str
+		case param.k == "metric-id" && !param.kOnly:
+			if terms.metricID != "" {
+				return terms, nil, fmt.Errorf("event %q: multiple metric IDs %q and %q", enc, terms.metricID, param.str)
+			}
+			terms.metricID = param.str
+		case param.k == "percore":
+			terms.percore = param.v != 0
+		default:
+			rest = append(rest, param)
+		}
+	}
+	return terms, rest, nil
+}
+
+// apply applies the terms to ev, which must be a builtinEvent or *rawEvent.
+// Only rawEvent can carry percore and metric-id, so this converts a
+// builtinEvent with either of those terms to a rawEvent.
+func (t eventTerms) apply(ev Event) Event {
+	if be, ok := ev.(builtinEvent); ok {
+		if t.name != "" {
+			be.name = t.name
+		}
+		if !t.percore && t.metricID == "" {
+			return be
+		}
+		ev = &rawEvent{name: be.name, pmu: be.pmu, config: be.config, scale: 1.0}
+	}
+	re := ev.(*rawEvent)
+	if t.name != "" {
+		re.name = t.name
+	}
+	re.percore = t.percore
+	re.metricID = t.metricID
+	return re
+}
+
 type eventResolver func(pmu *pmuDesc, eventName string, out *rawEvent) error
 
 // errUnknownEvent is an internal error returned by eventResolver.
@@ -128,13 +258,30 @@ var errUnknownEvent = errors.New("unknown event")
 
 var eventResolvers = []eventResolver{
 	resolvePMUEvent,
+	resolvePMUEventsEvent,
 	resolvePerfJsonEvent,
 }
 
 // resolveEvent resolves an event in the form pmu/param1=N,.../ or a symbolic
 // event. Symbolic events will have pmu == "" and a single kOnly param.
 func resolveEvent(enc string, pmu string, params []eventParam) (Event, error) {
-	event := rawEvent{name: enc, scale: 1.0, unit: ""}
+	// Some terms don't affect the encoding, so pull them out first.
+	terms, params, err := cutEventTerms(enc, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(params) == 0 {
+		// Perf also accepts terms following a symbolic event, as in
+		// "cycles/name=foo/", in which case what we parsed as the PMU is
+		// really the event.
+		pmu, params = "", []eventParam{{k: pmu, kOnly: true}}
+	}
+	name := enc
+	if terms.name != "" {
+		name = terms.name
+	}
+
+	event := rawEvent{name: name, scale: 1.0, unit: "", percore: terms.percore, metricID: terms.metricID}
 
 	// Events with perf constants are baked in and don't necessarily appear in
 	// /sys. (Though sometimes they do!) Perf will prefer this over the
@@ -144,13 +291,16 @@ func resolveEvent(enc string, pmu string, params []eventParam) (Event, error) {
 	// this inevitably produces malformed events.
 	if len(params) == 1 && params[0].kOnly {
 		if ev, ok := resolveBuiltinEvent(pmu, params[0].k); ok {
-			return ev, nil
+			return terms.apply(ev), nil
 		}
 	}
 
 	// If we get to here for a symbolic event, then the CPU PMU is implied.
 	symEvent := pmu == ""
 	if pmu == "" {
+		if hybrid := hybridPMUs(); len(hybrid) > 0 {
+			return resolveHybridEvent(enc, terms, params, hybrid)
+		}
 		pmu = "cpu"
 	}
 
@@ -160,6 +310,7 @@ func resolveEvent(enc string, pmu string, params []eventParam) (Event, error) {
 		return nil, err
 	}
 	event.pmu = desc.pmu
+	event.cpus = desc.cpumask
 
 	// Resolve each parameter to either an event name or a PMU format.
 	eventNameIndex := -1
@@ -197,15 +348,54 @@ Params:
 	}
 
 	// Finally, resolve the parameters into an event.
+	named := event // The encoding of just the named event
+	type written struct {
+		param string
+		f     pmuFormat
+	}
+	var explicit []written
+	var explicitRate string
 	for i, param := range params {
 		if i == eventNameIndex {
 			// Already resolved above.
 			continue
 		}
+		switch param.k {
+		case "period", "freq":
+			// An explicit period or frequency overrides any sample rate from
+			// the named event, but it's an error to specify both.
+			if explicitRate != "" && explicitRate != param.k {
+				return nil, fmt.Errorf("event %q: cannot specify both period and freq", enc)
+			}
+			explicitRate = param.k
+			event.period, event.freq = 0, 0
+		}
 		f, _ := desc.getFormat(param.k)
+		if err := desc.checkReserved(f, param.v); err != nil {
+			return nil, fmt.Errorf("event %q: %w", enc, err)
+		}
+		// Explicit parameters that set the same bits are almost certainly a
+		// mistake, since the result depends on their order.
+		for _, prev := range explicit {
+			if prev.f.fieldName == f.fieldName && prev.f.mask()&f.mask() != 0 {
+				return nil, fmt.Errorf("event %q: parameters %q and %q overlap", enc, prev.param, param.k)
+			}
+		}
+		explicit = append(explicit, written{param.k, f})
 		if err := f.set(&event, param.v); err != nil {
 			return nil, fmt.Errorf("event %q: %w", enc, err)
 		}
+		// Explicit parameters override the encoding of a named event. This is
+		// sometimes intentional (for example, to select a different umask),
+		// but can easily clobber part of an event's encoding by accident, so
+		// we record a warning.
+		if eventNameIndex != -1 {
+			mask := f.mask()
+			before, after := *f.field(&named)&mask, *f.field(&event)&mask
+			if before != 0 && before != after {
+				event.warnings = append(event.warnings, fmt.Sprintf("parameter %s=%#x overrides %s bits %#x of event %q", param.k, param.v, f.fieldName, before, params[eventNameIndex].k))
+			}
+		}
 	}
 
 	return &event, nil
@@ -13,6 +13,7 @@ import (
 	"io"
 	"io/fs"
 	"os/exec"
+	"slices"
 	"strings"
 	"testing"
 
@@ -30,6 +31,9 @@ func init() {
 	pmuDir = "testdata/pmufs"
 	pmuFS, _ = fs.Sub(testPMUFS, pmuDir)
 
+	// Likewise for tracefs.
+	tracefsFS = func() fs.FS { return testTracefs }
+
 	// Stub the perf command with real data (albeit minimized).
 	perfListHook = func(outBuf io.Writer) {
 		outBuf.Write(testPerfListJ)
@@ -59,7 +63,9 @@ func evString(ev Event) string {
 	if attrs.Ext2 != 0 {
 		fmt.Fprintf(&s, ",config2=%#x", attrs.Ext2)
 	}
-	if attrs.Sample != 0 {
+	if attrs.Bits&unix.PerfBitFreq != 0 {
+		fmt.Fprintf(&s, ",freq=%d", attrs.Sample)
+	} else if attrs.Sample != 0 {
 		fmt.Fprintf(&s, ",period=%#x", attrs.Sample)
 	}
 	s.WriteByte('/')
@@ -181,6 +187,10 @@ func (ev *rawEvent) p(val uint64) *rawEvent {
 	ev.period = val
 	return ev
 }
+func (ev *rawEvent) f(val uint64) *rawEvent {
+	ev.freq = val
+	return ev
+}
 func (ev *rawEvent) setScale(scale float64, unit string) *rawEvent {
 	ev.scale = scale
 	ev.unit = unit
@@ -264,6 +274,12 @@ func TestParse(t *testing.T) {
 	test("l1d.replacement", raw(0x51|0x1<<8).p(0x186a3)) // cpu/event=0x51,period=0x186a3,umask=0x1/
 	test("cpu/l1d.replacement/", raw(0x51|0x1<<8).p(0x186a3))
 
+	// Test sample period and frequency.
+	test("cpu/event=0x3c,period=1000/", raw(0x3c).p(1000))
+	test("cpu/event=0x3c,freq=1000/", raw(0x3c).f(1000))
+	// An explicit frequency overrides the period of a named event.
+	test("cpu/l1d.replacement,freq=1000/", raw(0x51|0x1<<8).f(1000))
+
 	// Test scaled events from /sys.
 	test("fake/scaled/", raw(0).setScale(2.5e-10, "Joules"))
 	test("fake/united/", raw(0).setScale(1, "Joules"))
@@ -281,6 +297,8 @@ func TestParse(t *testing.T) {
 	testErr("fake/splitevent=0x10/", `event "fake/splitevent=0x10/": parameter splitevent=16 not in range 0-15`)
 	// Test unknown parameter
 	testErr("cpu/bad=25/", `event "cpu/bad=25/": unknown event or parameter "bad"`)
+	// Test period and frequency together
+	testErr("cpu/event=0x3c,period=1,freq=2/", `event "cpu/event=0x3c,period=1,freq=2/": cannot specify both period and freq`)
 	// Test multiple events
 	testErr("cpu/cpu-cycles,mem-stores/", `event "cpu/cpu-cycles,mem-stores/": multiple events "cpu-cycles" and "mem-stores"`)
 	// Test mixing built-in events (that aren't in /sys) with parameters from
@@ -294,6 +312,30 @@ func TestParse(t *testing.T) {
 	testErr("cpu/event=abc/", `event "cpu/event=abc/": error parsing event param list "event=abc": parameter "event=abc" not a number`)
 	testErr("cpu/one,two/", `event "cpu/one,two/": unknown event or parameter "one"`)
 	testErr("cpu/=1/", `event "cpu/=1/": error parsing event param list "=1": missing parameter name in "=1"`)
+	// Test parameters that set reserved bits. Bit 16 of config isn't in any
+	// cpu format.
+	testErr("cpu/config=0x10000/", `event "cpu/config=0x10000/": parameter config=0x10000 sets reserved bits 0x10000`)
+	testErr("cpu/event=0x3c,config=0x1003c/", `event "cpu/event=0x3c,config=0x1003c/": parameter config=0x1003c sets reserved bits 0x10000`)
+	// Test explicit parameters that set the same bits.
+	testErr("cpu/event=0x3c,config=0x3c/", `event "cpu/event=0x3c,config=0x3c/": parameters "event" and "config" overlap`)
+	testErr("cpu/umask=1,umask=2/", `event "cpu/umask=1,umask=2/": parameters "umask" and "umask" overlap`)
+
+	// Names don't affect the encoding.
+	test("cpu/event=0x3c,name=foo/", raw(0x3c))
+	test("cpu/cpu-cycles,name=foo/", hw(unix.PERF_COUNT_HW_CPU_CYCLES))
+	test("cpu-cycles/name=foo/", hw(unix.PERF_COUNT_HW_CPU_CYCLES))
+	test("mem-stores/name=foo/", raw(0xd0|0x82<<8))
+	testErr("cpu/event=0x3c,name=a,name=b/", `event "cpu/event=0x3c,name=a,name=b/": multiple names "a" and "b"`)
+	testErr("cpu/event=0x3c,name=/", `event "cpu/event=0x3c,name=/": error parsing event param list "event=0x3c,name=": empty name in "name="`)
+	testErr("bad/name=foo/", `unknown event "bad/name=foo/"`)
+
+	// Neither do percore or metric-id.
+	test("cpu/event=0x3c,percore/", raw(0x3c))
+	test("cpu/cpu-cycles,percore/", hw(unix.PERF_COUNT_HW_CPU_CYCLES))
+	test("cpu/event=0x3c,metric-id=c/", raw(0x3c))
+	test("cpu-cycles/metric-id=c,percore=1/", hw(unix.PERF_COUNT_HW_CPU_CYCLES))
+	testErr("cpu/event=0x3c,metric-id=a,metric-id=b/", `event "cpu/event=0x3c,metric-id=a,metric-id=b/": multiple metric IDs "a" and "b"`)
+	testErr("cpu/event=0x3c,metric-id=/", `event "cpu/event=0x3c,metric-id=/": error parsing event param list "event=0x3c,metric-id=": empty metric-id in "metric-id="`)
 }
 
 func TestParsePerfList(t *testing.T) {
@@ -342,3 +384,111 @@ func testParsePerfList(t *testing.T, data, errOut []byte, err error) {
 		}
 	}
 }
+
+func TestSampleRate(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		period, freq uint64
+	}{
+		{"cpu/event=0x3c/", 0, 0},
+		{"cpu/event=0x3c,period=1000/", 1000, 0},
+		{"cpu/event=0x3c,freq=4000/", 0, 4000},
+		{"l1d.replacement", 0x186a3, 0},
+	} {
+		ev, err := ParseEvent(tc.name)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		sr, ok := ev.(EventSampleRate)
+		if !ok {
+			t.Errorf("%s: not an EventSampleRate", tc.name)
+			continue
+		}
+		period, freq := sr.SampleRate()
+		if period != tc.period || freq != tc.freq {
+			t.Errorf("%s: got period %d, freq %d; want period %d, freq %d", tc.name, period, freq, tc.period, tc.freq)
+		}
+	}
+}
+
+func TestEventName(t *testing.T) {
+	for _, tc := range []struct {
+		event, want string
+	}{
+		{"cpu-cycles", "cpu-cycles"},
+		{"cpu/event=0x3c/", "cpu/event=0x3c/"},
+		{"cpu/event=0x3c,name=foo/", "foo"},
+		{"cpu/name=foo,event=0x3c/", "foo"},
+		{"cpu/cpu-cycles,name=foo/", "foo"},
+		{"cycles/name=foo/", "foo"},
+		{"cpu/mem-stores,umask=1,name=stores1/", "stores1"},
+	} {
+		ev, err := ParseEvent(tc.event)
+		if err != nil {
+			t.Errorf("%s: %s", tc.event, err)
+			continue
+		}
+		if got := ev.String(); got != tc.want {
+			t.Errorf("%s: got name %q, want %q", tc.event, got, tc.want)
+		}
+	}
+}
+
+func TestEventTerms(t *testing.T) {
+	for _, tc := range []struct {
+		event    string
+		percore  bool
+		metricID string
+	}{
+		{"cpu-cycles", false, "cpu-cycles"},
+		{"cpu/event=0x3c/", false, "cpu/event=0x3c/"},
+		{"cpu/event=0x3c,percore/", true, "cpu/event=0x3c,percore/"},
+		{"cpu/event=0x3c,percore=0/", false, "cpu/event=0x3c,percore=0/"},
+		{"cpu/event=0x3c,name=foo/", false, "foo"},
+		{"cpu/event=0x3c,name=foo,metric-id=bar/", false, "bar"},
+		{"cpu/cpu-cycles,percore,metric-id=c/", true, "c"},
+		{"cycles/percore/", true, "cycles"},
+		{"cpu/event=0x3c,percore/u", true, "cpu/event=0x3c,percore/"},
+		{"cpu/event=0x3c,metric-id=c/D", false, "c"},
+	} {
+		ev, err := ParseEvent(tc.event)
+		if err != nil {
+			t.Errorf("%s: %s", tc.event, err)
+			continue
+		}
+		if got := PerCoreOf(ev); got != tc.percore {
+			t.Errorf("%s: got percore %v, want %v", tc.event, got, tc.percore)
+		}
+		if got := MetricIDOf(ev); got != tc.metricID {
+			t.Errorf("%s: got metric ID %q, want %q", tc.event, got, tc.metricID)
+		}
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want []string
+	}{
+		{"cpu/mem-stores/", nil},
+		{"cpu/mem-stores,edge/", nil},
+		{"cpu/mem-stores,umask=0x82/", nil},
+		{"cpu/mem-stores,umask=42/", []string{`parameter umask=0x2a overrides config bits 0x8200 of event "mem-stores"`}},
+		{"cpu/umask=42,mem-stores/", []string{`parameter umask=0x2a overrides config bits 0x8200 of event "mem-stores"`}},
+	} {
+		ev, err := ParseEvent(tc.name)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		ew, ok := ev.(EventWarnings)
+		if !ok {
+			t.Errorf("%s: not an EventWarnings", tc.name)
+			continue
+		}
+		if got := ew.Warnings(); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got warnings %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
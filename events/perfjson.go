@@ -20,15 +20,9 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-// TODO: It might just be better to use the perfmon database. See
-// event_download.py in github.com/andikleen/pmu-tools for downloading it all as
-// JSON and
-// https://github.com/torvalds/linux/blob/master/tools/perf/pmu-events/jevents.py
-// for the tool that converts the JSON into perf C definitions. Alternatively,
-// https://github.com/andikleen/pmu-tools/blob/master/jevents/jevents.c is a C
-// implementation that translates from the JSON definitions directly to
-// perf_event_attrs. Perhaps use perfmon if we have the database for the current
-// chip and otherwise fall back to perf.
+// perf list -j is the fallback for CPU-specific events that aren't in the
+// embedded pmu-events database (see pmuevents.go), for example because the
+// database hasn't been generated or predates the CPU.
 
 func resolvePerfJsonEvent(pmu *pmuDesc, eventName string, ev *rawEvent) error {
 	if pmu.pmu != unix.PERF_TYPE_RAW {
@@ -71,13 +65,26 @@ var getPerfList = sync.OnceValues(func() (map[string]perfJson, error) {
 	var err error
 	if perfListHook != nil {
 		perfListHook(&outBuf)
-	} else {
-		cmd := exec.Command("perf", "list", "-j")
-		cmd.Stdout = &outBuf
-		cmd.Stderr = &errBuf
-		err = cmd.Run()
+		return parsePerfList(outBuf.Bytes(), errBuf.Bytes(), err)
 	}
-	return parsePerfList(outBuf.Bytes(), errBuf.Bytes(), err)
+
+	// Running perf list is slow, so check the cache first.
+	key, keyErr := perfListCacheKey()
+	if keyErr == nil {
+		if m, ok := readPerfListCache(key); ok {
+			return m, nil
+		}
+	}
+
+	cmd := exec.Command("perf", "list", "-j")
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	err = cmd.Run()
+	m, err := parsePerfList(outBuf.Bytes(), errBuf.Bytes(), err)
+	if err == nil && keyErr == nil {
+		writePerfListCache(key, m)
+	}
+	return m, err
 })
 
 func parsePerfList(data, errOut []byte, err error) (map[string]perfJson, error) {
@@ -0,0 +1,121 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// The parsed output of "perf list -j" is cached on disk, since running it
+// takes hundreds of milliseconds and short-lived processes would otherwise pay
+// that on every run. The output depends on the perf version, the CPU, and the
+// kernel's PMUs, so the cache is keyed by the perf version, the CPU ID, and
+// the kernel release. A cache entry with any other key is ignored and
+// overwritten.
+
+// perfListCacheDir returns the directory of the perf list cache. It's a
+// variable so it can be stubbed by tests.
+var perfListCacheDir = func() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "go-perfevent"), nil
+}
+
+// perfVersion returns the output of "perf version". It's a variable so it can
+// be stubbed by tests.
+var perfVersion = func() (string, error) {
+	out, err := exec.Command("perf", "version").Output()
+	return string(bytes.TrimSpace(out)), err
+}
+
+// perfListCache is the format of the perf list cache file.
+type perfListCache struct {
+	Key    string
+	Events map[string]perfJson
+}
+
+// perfListCacheKey returns the key of the current perf list output.
+func perfListCacheKey() (string, error) {
+	version, err := perfVersion()
+	if err != nil {
+		return "", err
+	}
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return "", err
+	}
+	release := unix.ByteSliceToString(uname.Release[:])
+	return fmt.Sprintf("%s\n%s\n%s", version, cpuID(), release), nil
+}
+
+// perfListCachePath returns the path of the perf list cache file.
+func perfListCachePath() (string, error) {
+	dir, err := perfListCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "perf-list.json"), nil
+}
+
+// readPerfListCache returns the cached perf list output if it has the given
+// key.
+func readPerfListCache(key string) (map[string]perfJson, bool) {
+	path, err := perfListCachePath()
+	if err != nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var cache perfListCache
+	if err := json.Unmarshal(data, &cache); err != nil || cache.Key != key {
+		return nil, false
+	}
+	return cache.Events, true
+}
+
+// writePerfListCache caches perf list output m with the given key. The cache
+// is best-effort, so this ignores errors.
+func writePerfListCache(key string, m map[string]perfJson) {
+	path, err := perfListCachePath()
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(perfListCache{Key: key, Events: m})
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return
+	}
+	// Write to a temporary file and rename it so concurrent processes never
+	// read a partial cache.
+	f, err := os.CreateTemp(filepath.Dir(path), "perf-list-*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPerfListCache(t *testing.T) {
+	dir := t.TempDir()
+	oldDir := perfListCacheDir
+	perfListCacheDir = func() (string, error) { return filepath.Join(dir, "go-perfevent"), nil }
+	defer func() { perfListCacheDir = oldDir }()
+
+	if _, ok := readPerfListCache("key1"); ok {
+		t.Fatalf("read from empty cache succeeded")
+	}
+
+	m := map[string]perfJson{
+		"l1d.replacement": {EventName: "l1d.replacement", Topic: "cache", Encoding: "cpu/event=0x51,umask=0x1/"},
+	}
+	writePerfListCache("key1", m)
+	got, ok := readPerfListCache("key1")
+	if !ok {
+		t.Fatalf("read from cache failed")
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("got %v, want %v", got, m)
+	}
+
+	// A different key invalidates the cache.
+	if _, ok := readPerfListCache("key2"); ok {
+		t.Errorf("read with different key succeeded")
+	}
+
+	// A corrupt cache is a miss.
+	path, err := perfListCachePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := readPerfListCache("key1"); ok {
+		t.Errorf("read from corrupt cache succeeded")
+	}
+
+	// Check that writing didn't leave temporary files behind.
+	ents, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 1 {
+		t.Errorf("cache directory has %d files, want 1", len(ents))
+	}
+}
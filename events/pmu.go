@@ -8,11 +8,14 @@ package events
 
 import (
 	"bytes"
+	"cmp"
 	"errors"
 	"fmt"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -47,12 +50,24 @@ type pmuDesc struct {
 	pmu    uint32
 	format map[string]pmuFormat // Keyed by symbolic field name
 	events map[string]pmuEvent  // Keyed by event name
+
+	// validBits is the union of the bits of all formats, keyed by raw field
+	// name (e.g., "config"). If a PMU defines any formats for a field, we
+	// assume all other bits of that field are reserved.
+	validBits map[string]uint64
+
+	// cpumask is the CPUs that events on this PMU must be opened on, or nil
+	// if they can be opened on any CPU or thread. Uncore PMUs count events
+	// for a whole socket or device, so they can only be opened on a
+	// designated CPU of each, and never for a thread.
+	cpumask []int
 }
 
 type pmuFormat struct {
-	name  string
-	field func(*rawEvent) *uint64
-	bits  []formatBitRange
+	name      string
+	field     func(*rawEvent) *uint64
+	fieldName string // Name of the raw field, e.g., "config"
+	bits      []formatBitRange
 }
 
 type formatBitRange struct {
@@ -73,26 +88,56 @@ func fieldConfig(e *rawEvent) *uint64  { return &e.config }
 func fieldConfig1(e *rawEvent) *uint64 { return &e.config1 }
 func fieldConfig2(e *rawEvent) *uint64 { return &e.config2 }
 func fieldPeriod(e *rawEvent) *uint64  { return &e.period }
+func fieldFreq(e *rawEvent) *uint64    { return &e.freq }
 
 // getFormat returns the pmuFormat for the given parameter in a PMU event
 // description. E.g., in "cpu/config=42,edge/", "config" and "edge" would be
 // mapped to formats using this method on the "cpu" PMU.
 func (d *pmuDesc) getFormat(param string) (pmuFormat, bool) {
-	// TODO: Perf also supports config3,name,percore,metric-id
+	// TODO: Perf also supports config3, but x/sys/unix doesn't. name,
+	// percore, and metric-id are handled by cutEventTerms.
 	switch param {
 	case "config":
-		return pmuFormat{param, fieldConfig, formatAllBits}, true
+		return pmuFormat{param, fieldConfig, param, formatAllBits}, true
 	case "config1":
-		return pmuFormat{param, fieldConfig1, formatAllBits}, true
+		return pmuFormat{param, fieldConfig1, param, formatAllBits}, true
 	case "config2":
-		return pmuFormat{param, fieldConfig2, formatAllBits}, true
+		return pmuFormat{param, fieldConfig2, param, formatAllBits}, true
 	case "period":
-		return pmuFormat{param, fieldPeriod, formatAllBits}, true
+		return pmuFormat{param, fieldPeriod, param, formatAllBits}, true
+	case "freq":
+		return pmuFormat{param, fieldFreq, param, formatAllBits}, true
 	}
 	f, ok := d.format[param]
 	return f, ok
 }
 
+// mask returns the bits of the raw field that f can set.
+func (f pmuFormat) mask() uint64 {
+	var m uint64
+	for _, bits := range f.bits {
+		m |= (^uint64(0) >> (64 - bits.nBits)) << bits.shift
+	}
+	return m
+}
+
+// checkReserved returns an error if val sets bits in the raw field of f that
+// are reserved by this PMU.
+func (d *pmuDesc) checkReserved(f pmuFormat, val uint64) error {
+	valid, ok := d.validBits[f.fieldName]
+	if !ok {
+		// No formats for this field, so we don't know which bits are valid.
+		return nil
+	}
+	// Compute which bits of the raw field val would set.
+	var e rawEvent
+	f.set(&e, val)
+	if bad := *f.field(&e) &^ valid; bad != 0 {
+		return fmt.Errorf("parameter %s=%#x sets reserved bits %#x", f.name, val, bad)
+	}
+	return nil
+}
+
 // set sets the appropriate field of e to val.
 func (f pmuFormat) set(e *rawEvent, val uint64) error {
 	field := f.field(e)
@@ -113,14 +158,105 @@ func (f pmuFormat) set(e *rawEvent, val uint64) error {
 	return nil
 }
 
-// TODO: Look for a <pmu>/alias file.
+// matchPMUs returns the names of the PMUs that match pattern, which uses the
+// syntax of [path.Match]. If only the alias of a PMU matches, this returns its
+// alias. The names are sorted by their non-numeric prefix and then by their
+// numeric suffix, so "uncore_cha_2" comes before "uncore_cha_10".
+func matchPMUs(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("bad PMU pattern %q: %w", pattern, err)
+	}
+	ents, err := fs.ReadDir(pmuFS, ".")
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", pmuDir, err)
+	}
+	var names []string
+	matched := make(map[string]bool)
+	for _, ent := range ents {
+		if ok, _ := path.Match(pattern, ent.Name()); ok {
+			names = append(names, ent.Name())
+			matched[ent.Name()] = true
+		}
+	}
+	for alias, name := range pmuAliases() {
+		if ok, _ := path.Match(pattern, alias); ok && !matched[name] {
+			names = append(names, alias)
+		}
+	}
+	slices.SortFunc(names, comparePMUNames)
+	return names, nil
+}
+
+// comparePMUNames compares PMU names by their non-numeric prefix and then by
+// their numeric suffix.
+func comparePMUNames(a, b string) int {
+	pa, na := splitNumSuffix(a)
+	pb, nb := splitNumSuffix(b)
+	if c := strings.Compare(pa, pb); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(na, nb); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// splitNumSuffix splits s into a prefix and the value of its trailing
+// decimal digits, or -1 if it has none.
+func splitNumSuffix(s string) (string, int) {
+	i := len(s)
+	for i > 0 && '0' <= s[i-1] && s[i-1] <= '9' {
+		i--
+	}
+	n, err := strconv.Atoi(s[i:])
+	if err != nil {
+		return s, -1
+	}
+	return s[:i], n
+}
+
+// pmuAliases returns a map from PMU aliases to PMU names. Some PMUs have an
+// "alias" file giving another name that perf accepts for them. For example,
+// Intel uncore PMUs found through the uncore discovery table are named by
+// their type, like "uncore_type_0_0", and the alias gives a name like
+// "uncore_cha_0".
+func pmuAliases() map[string]string {
+	ents, err := fs.ReadDir(pmuFS, ".")
+	if err != nil {
+		return nil
+	}
+	aliases := make(map[string]string)
+	for _, ent := range ents {
+		alias, err := fs.ReadFile(pmuFS, path.Join(ent.Name(), "alias"))
+		if err != nil {
+			continue
+		}
+		if alias := strings.TrimSpace(string(alias)); alias != "" {
+			aliases[alias] = ent.Name()
+		}
+	}
+	return aliases
+}
 
-// pmus is a onceMap containing descriptions for each PMU type.
+// pmuDirName returns the name of the directory of PMU pmu, which may be an
+// alias.
+func pmuDirName(pmu string) string {
+	if _, err := fs.Stat(pmuFS, pmu); errors.Is(err, fs.ErrNotExist) {
+		if name, ok := pmuAliases()[pmu]; ok {
+			return name
+		}
+	}
+	return pmu
+}
+
+// pmus is a onceMap containing descriptions for each PMU type. PMUs may be
+// named by their alias.
 var pmus = newOnceMap(func(pmu string) (*pmuDesc, error) {
 	var desc pmuDesc
+	dir := pmuDirName(pmu)
 
 	// Parse the PMU type.
-	path := filepath.Join(pmu, "type")
+	path := filepath.Join(dir, "type")
 	typStr, err := fs.ReadFile(pmuFS, path)
 	if errors.Is(err, fs.ErrNotExist) {
 		return nil, fmt.Errorf("unknown PMU %q", pmu)
@@ -134,9 +270,20 @@ var pmus = newOnceMap(func(pmu string) (*pmuDesc, error) {
 	}
 	desc.pmu = uint32(num)
 
+	// Parse the cpumask.
+	path = filepath.Join(dir, "cpumask")
+	if mask, err := fs.ReadFile(pmuFS, path); err == nil {
+		desc.cpumask, err = parseCPUList(strings.TrimSpace(string(mask)))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", filepath.Join(pmuDir, path), err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error reading %s: %w", filepath.Join(pmuDir, path), err)
+	}
+
 	// Parse format.
 	desc.format = make(map[string]pmuFormat)
-	err = pmuForEachFile(filepath.Join(pmu, "format"), func(name string, data string) error {
+	err = pmuForEachFile(filepath.Join(dir, "format"), func(name string, data string) error {
 		format, err := pmuParseFormat(data)
 		if err != nil {
 			return err
@@ -148,10 +295,14 @@ var pmus = newOnceMap(func(pmu string) (*pmuDesc, error) {
 	if err != nil {
 		return nil, err
 	}
+	desc.validBits = make(map[string]uint64)
+	for _, format := range desc.format {
+		desc.validBits[format.fieldName] |= format.mask()
+	}
 
 	// Parse events. See https://www.kernel.org/doc/Documentation/ABI/testing/sysfs-bus-event_source-devices-events
 	desc.events = make(map[string]pmuEvent)
-	err = pmuForEachFile(filepath.Join(pmu, "events"), func(name string, data string) error {
+	err = pmuForEachFile(filepath.Join(dir, "events"), func(name string, data string) error {
 		data = strings.TrimRight(data, "\n")
 
 		switch {
@@ -192,6 +343,29 @@ var pmus = newOnceMap(func(pmu string) (*pmuDesc, error) {
 	return &desc, nil
 })
 
+// parseCPUList parses a kernel CPU list, such as "0-3,8".
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for _, r := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(r, "-")
+		l, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("bad CPU list %q", s)
+		}
+		h := l
+		if isRange {
+			h, err = strconv.Atoi(hi)
+			if err != nil || h < l {
+				return nil, fmt.Errorf("bad CPU list %q", s)
+			}
+		}
+		for cpu := l; cpu <= h; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
 // pmuForEachFile calls f for each file under path in the pmuFS.
 func pmuForEachFile(path string, f func(name string, data string) error) error {
 	ents, err := fs.ReadDir(pmuFS, path)
@@ -227,8 +401,8 @@ func pmuParseFormat(s string) (pmuFormat, error) {
 	if !ok {
 		return pmuFormat{}, fmt.Errorf("error parsing format %q", s)
 	}
-	var format pmuFormat
-	switch string(field) {
+	format := pmuFormat{fieldName: field}
+	switch field {
 	case "config":
 		format.field = fieldConfig
 	case "config1":
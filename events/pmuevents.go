@@ -0,0 +1,255 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+//go:generate go run mkpmuevents.go -linux $LINUX
+
+// The pmu-events database is the tables of CPU-specific events from the Linux
+// source tree (tools/perf/pmu-events), which perf compiles into its binary.
+// mkpmuevents.go extracts the core PMU events of each CPU model into
+// pmu-events/<arch>/<model>.json.gz, with a mapfile.csv that maps CPU IDs to
+// models.
+//
+// The checked-in database has no models, since generating it requires a Linux
+// source tree. Until it's generated, CPU-specific events like
+// "l1d.replacement" resolve only through sysfs or "perf list -j", as if the
+// database didn't have this CPU.
+//
+//go:embed pmu-events
+var embeddedPMUEvents embed.FS
+
+// pmuEventsFS and cpuID are the pmu-events database and the function to get
+// the CPU ID. These are variables so they can be stubbed by tests.
+var (
+	pmuEventsFS, _ = fs.Sub(embeddedPMUEvents, "pmu-events")
+	cpuID          = readCPUID
+)
+
+// A pmuEventsEntry is an event in the pmu-events database. The fields are as
+// in the kernel's JSON tables, plus Topic, which is the name of the file the
+// event came from, such as "cache".
+type pmuEventsEntry struct {
+	EventName         string
+	EventCode         string
+	UMask             string
+	CounterMask       string
+	Invert            string
+	EdgeDetect        string
+	AnyThread         string
+	MSRIndex          string
+	MSRValue          string
+	SampleAfterValue  string
+	BriefDescription  string
+	PublicDescription string
+	Topic             string
+}
+
+// getPMUEvents returns the events in the pmu-events database for this CPU,
+// keyed by lower-case event name, as perf names them. If the database doesn't
+// have this CPU, it returns a nil map.
+var getPMUEvents = sync.OnceValues(func() (map[string]*pmuEventsEntry, error) {
+	id := cpuID()
+	if id == "" {
+		return nil, nil
+	}
+	arch := runtime.GOARCH
+	if arch == "amd64" || arch == "386" {
+		arch = "x86"
+	}
+	model, err := findPMUEventsModel(arch, id)
+	if err != nil || model == "" {
+		return nil, err
+	}
+	return loadPMUEvents(path.Join(arch, model+".json.gz"))
+})
+
+// readCPUID returns the ID of this CPU in the form used by the pmu-events
+// mapfile, or "" if unknown. On x86, this is "vendor-family-model-stepping",
+// as in "GenuineIntel-6-7E-5", with the model and stepping in hex.
+func readCPUID() string {
+	if runtime.GOARCH != "amd64" && runtime.GOARCH != "386" {
+		// TODO: Support arm64, which identifies CPUs by MIDR.
+		return ""
+	}
+	data, err := os.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	return parseCPUInfo(data)
+}
+
+// parseCPUInfo returns the x86 CPU ID of the first CPU in the contents of
+// /proc/cpuinfo.
+func parseCPUInfo(data []byte) string {
+	var vendor string
+	family, model, stepping := -1, -1, -1
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			if vendor != "" {
+				// End of the first CPU.
+				break
+			}
+			continue
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		switch k {
+		case "vendor_id":
+			vendor = v
+		case "cpu family":
+			family, _ = strconv.Atoi(v)
+		case "model":
+			model, _ = strconv.Atoi(v)
+		case "stepping":
+			stepping, _ = strconv.Atoi(v)
+		}
+	}
+	if vendor == "" || family < 0 || model < 0 || stepping < 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s-%d-%X-%X", vendor, family, model, stepping)
+}
+
+// findPMUEventsModel returns the model in the pmu-events database of the CPU
+// with the given ID, or "" if the database doesn't have it.
+func findPMUEventsModel(arch, id string) (string, error) {
+	mapPath := path.Join(arch, "mapfile.csv")
+	data, err := fs.ReadFile(pmuEventsFS, mapPath)
+	if err != nil {
+		return "", nil
+	}
+	// The mapfile IDs may omit the stepping, in which case they match any
+	// stepping.
+	noStepping := id
+	if i := strings.LastIndexByte(id, '-'); i >= 0 && strings.Count(id, "-") == 3 {
+		noStepping = id[:i]
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		cols := strings.Split(line, ",")
+		if i == 0 || len(cols) < 4 || cols[3] != "core" {
+			// Header or not a core PMU table.
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + cols[0] + ")$")
+		if err != nil {
+			return "", fmt.Errorf("bad CPU ID %q in pmu-events %s", cols[0], mapPath)
+		}
+		if re.MatchString(id) || re.MatchString(noStepping) {
+			return cols[2], nil
+		}
+	}
+	return "", nil
+}
+
+// loadPMUEvents loads the pmu-events table at path.
+func loadPMUEvents(path string) (map[string]*pmuEventsEntry, error) {
+	f, err := pmuEventsFS.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading pmu-events: %w", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("error loading pmu-events %s: %w", path, err)
+	}
+	var list []*pmuEventsEntry
+	if err := json.NewDecoder(zr).Decode(&list); err != nil {
+		return nil, fmt.Errorf("error loading pmu-events %s: %w", path, err)
+	}
+	m := make(map[string]*pmuEventsEntry)
+	for _, ev := range list {
+		m[strings.ToLower(ev.EventName)] = ev
+	}
+	return m, nil
+}
+
+// pmuEventsMSRs maps the MSRIndex of an event to the PMU format that sets the
+// MSR. See jevents.py.
+var pmuEventsMSRs = map[string]string{
+	"0x3f6": "ldlat",
+	"0x1a6": "offcore_rsp",
+	"0x1a7": "offcore_rsp",
+	"0x3f7": "frontend",
+}
+
+// encoding returns the encoding of ev as a PMU parameter list, as in
+// "event=0x51,umask=0x1,period=100003".
+func (ev *pmuEventsEntry) encoding() string {
+	var params []string
+	add := func(k, v string) {
+		if v != "" {
+			params = append(params, k+"="+v)
+		}
+	}
+	// Some events list several event codes. Perf uses the first.
+	code, _, _ := strings.Cut(ev.EventCode, ",")
+	add("event", code)
+	add("umask", ev.UMask)
+	for _, f := range []struct{ k, v string }{
+		{"cmask", ev.CounterMask}, {"inv", ev.Invert}, {"edge", ev.EdgeDetect}, {"any", ev.AnyThread},
+	} {
+		if f.v != "" && f.v != "0" {
+			add(f.k, f.v)
+		}
+	}
+	msr, _, _ := strings.Cut(ev.MSRIndex, ",")
+	if k, ok := pmuEventsMSRs[strings.ToLower(strings.TrimSpace(msr))]; ok && ev.MSRValue != "" {
+		add(k, ev.MSRValue)
+	}
+	add("period", ev.SampleAfterValue)
+	return strings.Join(params, ",")
+}
+
+func resolvePMUEventsEvent(pmu *pmuDesc, eventName string, ev *rawEvent) error {
+	if pmu.pmu != unix.PERF_TYPE_RAW {
+		return errUnknownEvent
+	}
+	table, err := getPMUEvents()
+	if err != nil {
+		return err
+	}
+	entry, ok := table[strings.ToLower(eventName)]
+	if !ok {
+		return errUnknownEvent
+	}
+
+	enc := entry.encoding()
+	params, err := parseParamList(enc)
+	if err != nil {
+		return fmt.Errorf("bad encoding %q from pmu-events: %w", enc, err)
+	}
+	for _, param := range params {
+		f, ok := pmu.getFormat(param.k)
+		if !ok {
+			return fmt.Errorf("unknown parameter %q in encoding %q from pmu-events", param.k, enc)
+		}
+		if err := f.set(ev, param.v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
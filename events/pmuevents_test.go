@@ -0,0 +1,243 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+//go:embed testdata/pmu-events
+var testPMUEvents embed.FS
+
+func init() {
+	// Use a fake pmu-events database and CPU.
+	pmuEventsFS, _ = fs.Sub(testPMUEvents, "testdata/pmu-events")
+	cpuID = func() string { return "GenuineIntel-6-7E-5" }
+}
+
+func skipIfNotX86(t *testing.T) {
+	if runtime.GOARCH != "amd64" && runtime.GOARCH != "386" {
+		t.Skip("pmu-events test data is for x86")
+	}
+}
+
+func TestParseCPUInfo(t *testing.T) {
+	const cpuinfo = `processor	: 0
+vendor_id	: GenuineIntel
+cpu family	: 6
+model		: 126
+model name	: Intel(R) Core(TM) i7-1065G7 CPU @ 1.30GHz
+stepping	: 5
+
+processor	: 1
+vendor_id	: GenuineIntel
+cpu family	: 6
+model		: 127
+stepping	: 6
+`
+	if got, want := parseCPUInfo([]byte(cpuinfo)), "GenuineIntel-6-7E-5"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := parseCPUInfo([]byte("processor : 0\n")); got != "" {
+		t.Errorf("got %q for cpuinfo without vendor, want \"\"", got)
+	}
+}
+
+func TestFindPMUEventsModel(t *testing.T) {
+	for id, want := range map[string]string{
+		"GenuineIntel-6-7E-5": "testlake",
+		"GenuineIntel-6-7E":   "testlake",
+		"GenuineIntel-6-47-1": "otherlake",
+		"GenuineIntel-6-7F-5": "",
+		"GenuineIntel-6-7E0":  "",
+		"AuthenticAMD-25-1-0": "",
+	} {
+		got, err := findPMUEventsModel("x86", id)
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, %v; want %q", id, got, err, want)
+		}
+	}
+}
+
+func TestPMUEventsEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		ev   pmuEventsEntry
+		want string
+	}{
+		{pmuEventsEntry{EventCode: "0x51", UMask: "0x1", SampleAfterValue: "100003"}, "event=0x51,umask=0x1,period=100003"},
+		{pmuEventsEntry{EventCode: "0xB7,0xBB", UMask: "0x1", MSRIndex: "0x1a6,0x1a7", MSRValue: "0x10001"}, "event=0xB7,umask=0x1,offcore_rsp=0x10001"},
+		{pmuEventsEntry{EventCode: "0xcd", UMask: "0x1", MSRIndex: "0x3F6", MSRValue: "0x20"}, "event=0xcd,umask=0x1,ldlat=0x20"},
+		{pmuEventsEntry{EventCode: "0xa3", CounterMask: "4", Invert: "0", EdgeDetect: "1"}, "event=0xa3,cmask=4,edge=1"},
+		{pmuEventsEntry{EventCode: "0x00", UMask: "0x01", MSRIndex: "0x0", MSRValue: "0"}, "event=0x00,umask=0x01"},
+	} {
+		if got := tc.ev.encoding(); got != tc.want {
+			t.Errorf("%+v: got %q, want %q", tc.ev, got, tc.want)
+		}
+	}
+}
+
+func TestPMUEvents(t *testing.T) {
+	skipIfNotX86(t)
+	for _, tc := range []struct {
+		name            string
+		config, config1 uint64
+		period          uint64
+	}{
+		{"test.loads", 0x12 | 0x34<<8, 0, 2000003},
+		{"TEST.LOADS", 0x12 | 0x34<<8, 0, 2000003},
+		{"cpu/test.loads/", 0x12 | 0x34<<8, 0, 2000003},
+		{"test.offcore", 0xb7 | 0x1<<8, 0x10001, 100003},
+		{"test.stalls", 0xa3 | 0x4<<8 | 1<<18 | 4<<24, 0, 1000003},
+	} {
+		ev, err := ParseEvent(tc.name)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var attr unix.PerfEventAttr
+		if err := ev.SetAttrs(&attr); err != nil {
+			t.Fatal(err)
+		}
+		if attr.Type != unix.PERF_TYPE_RAW || attr.Config != tc.config || attr.Ext1 != tc.config1 {
+			t.Errorf("%s: got type=%d config=%#x config1=%#x, want type=%d config=%#x config1=%#x", tc.name, attr.Type, attr.Config, attr.Ext1, unix.PERF_TYPE_RAW, tc.config, tc.config1)
+		}
+		if period, _ := ev.(EventSampleRate).SampleRate(); period != tc.period {
+			t.Errorf("%s: got period %d, want %d", tc.name, period, tc.period)
+		}
+	}
+
+	got, err := Describe("test.loads:u")
+	want := EventDescription{
+		Type:              "Kernel PMU event",
+		Topic:             "cache",
+		BriefDescription:  "Test loads",
+		PublicDescription: "Counts test loads, including the ones that don't exist.",
+	}
+	if err != nil {
+		t.Errorf("Describe(test.loads:u): %v", err)
+	} else if *got != want {
+		t.Errorf("Describe(test.loads:u) = %+v, want %+v", *got, want)
+	}
+
+	evs, err := ListEvents("cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, info := range evs {
+		if info.Name == "test.loads" {
+			found = true
+			if want := "cpu/event=0x12,umask=0x34/"; info.Encoding != want {
+				t.Errorf("test.loads: got encoding %q, want %q", info.Encoding, want)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("ListEvents(cpu) doesn't include test.loads")
+	}
+}
+
+func TestMkPMUEvents(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping go run in short mode")
+	}
+	goTool := filepath.Join(runtime.GOROOT(), "bin", "go")
+	if _, err := os.Stat(goTool); err != nil {
+		t.Skipf("go tool not found: %v", err)
+	}
+	out := t.TempDir()
+	cmd := exec.Command(goTool, "run", "mkpmuevents.go", "-linux", "testdata/linux", "-o", out)
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("mkpmuevents failed: %v\n%s", err, msg)
+	}
+
+	license, err := os.ReadFile(filepath.Join(out, "LICENSE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(license), "Test Linux license notice.\n") {
+		t.Errorf("LICENSE doesn't include the Linux license notice:\n%s", license)
+	}
+
+	defer func(old fs.FS) { pmuEventsFS = old }(pmuEventsFS)
+	pmuEventsFS = os.DirFS(out)
+	model, err := findPMUEventsModel("x86", "GenuineIntel-6-7E-5")
+	if err != nil || model != "testlake" {
+		t.Fatalf("findPMUEventsModel: got %q, %v; want testlake", model, err)
+	}
+	evs, err := loadPMUEvents("x86/testlake.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Uncore events and metrics are dropped.
+	var names []string
+	for name := range evs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	if want := []string{"test.loads", "test.stalls"}; !slices.Equal(names, want) {
+		t.Errorf("got events %v, want %v", names, want)
+	}
+	if ev := evs["test.stalls"]; ev != nil {
+		if got, want := ev.encoding(), "event=0xa3,umask=0x4,cmask=4,edge=1,period=1000003"; got != want {
+			t.Errorf("test.stalls: got encoding %q, want %q", got, want)
+		}
+		if ev.Topic != "pipeline" {
+			t.Errorf("test.stalls: got topic %q, want pipeline", ev.Topic)
+		}
+	}
+}
+
+// TestEmbeddedPMUEvents checks that every table in the embedded pmu-events
+// mapfile loads and can be found by a CPU ID.
+func TestEmbeddedPMUEvents(t *testing.T) {
+	defer func(old fs.FS) { pmuEventsFS = old }(pmuEventsFS)
+	pmuEventsFS, _ = fs.Sub(embeddedPMUEvents, "pmu-events")
+
+	data, err := fs.ReadFile(pmuEventsFS, "x86/mapfile.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for i, line := range strings.Split(string(data), "\n") {
+		cols := strings.Split(line, ",")
+		if i == 0 || len(cols) < 4 || cols[3] != "core" {
+			continue
+		}
+		n++
+		evs, err := loadPMUEvents(path.Join("x86", cols[2]+".json.gz"))
+		if err != nil {
+			t.Errorf("%s: %v", cols[2], err)
+			continue
+		}
+		if len(evs) == 0 {
+			t.Errorf("%s: no events", cols[2])
+		}
+		// Simple IDs, without alternatives or wildcards, are exact CPU IDs
+		// without a stepping.
+		if id := cols[0]; regexp.QuoteMeta(id) == id {
+			if model, err := findPMUEventsModel("x86", id); err != nil || model != cols[2] {
+				t.Errorf("findPMUEventsModel(%s) = %q, %v; want %q", id, model, err, cols[2])
+			}
+		}
+	}
+	if n == 0 {
+		t.Skip("embedded pmu-events database has no models; run go generate with LINUX set to a Linux source tree")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// prioEvent is an Event with a Priority.
+type prioEvent struct {
+	Event
+	prio Priority
+}
+
+// WithPriority returns an Event that counts ev with priority p. For example,
+// WithPriority(EventCPUCycles, PriorityPinned) is equivalent to perf's
+// "cpu-cycles:D".
+//
+// Only a group leader can be pinned, so opening a group that contains a
+// pinned event pins the whole group.
+func WithPriority(ev Event, p Priority) Event {
+	if pe, ok := ev.(prioEvent); ok {
+		// Replace the existing priority.
+		ev = pe.Event
+	}
+	if p == PriorityNormal {
+		return ev
+	}
+	return prioEvent{ev, p}
+}
+
+func (e prioEvent) String() string {
+	if e.prio == PriorityPinned {
+		name := e.Event.String()
+		if strings.HasSuffix(name, "/") {
+			// PMU events put modifiers directly after the
+			// closing slash.
+			return name + "D"
+		}
+		return name + ":D"
+	}
+	return e.Event.String()
+}
+
+func (e prioEvent) SetAttrs(attr *unix.PerfEventAttr) error {
+	if err := e.Event.SetAttrs(attr); err != nil {
+		return err
+	}
+	if e.prio == PriorityPinned {
+		attr.Bits |= unix.PerfBitPinned
+	} else {
+		attr.Bits &^= unix.PerfBitPinned
+	}
+	return nil
+}
+
+func (e prioEvent) Priority() Priority {
+	return e.prio
+}
+
+func (e prioEvent) ScaleUnit() (float64, string) {
+	if es, ok := e.Event.(EventScale); ok {
+		return es.ScaleUnit()
+	}
+	return 1.0, ""
+}
+
+func (e prioEvent) Filter() string {
+	return FilterOf(e.Event)
+}
+
+func (e prioEvent) PerCore() bool {
+	return PerCoreOf(e.Event)
+}
+
+func (e prioEvent) MetricID() string {
+	return MetricIDOf(e.Event)
+}
+
+func (e prioEvent) CPUs() []int {
+	return CPUsOf(e.Event)
+}
+
+func (e prioEvent) SampleRate() (period, freq uint64) {
+	if sr, ok := e.Event.(EventSampleRate); ok {
+		return sr.SampleRate()
+	}
+	return 0, 0
+}
+
+func (e prioEvent) Warnings() []string {
+	if ew, ok := e.Event.(EventWarnings); ok {
+		return ew.Warnings()
+	}
+	return nil
+}
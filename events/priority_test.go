@@ -0,0 +1,39 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestWithPriority(t *testing.T) {
+	ev := WithPriority(EventCPUCycles, PriorityPinned)
+	if got, want := ev.String(), "cpu-cycles:D"; got != want {
+		t.Errorf("got name %q, want %q", got, want)
+	}
+	var attr unix.PerfEventAttr
+	if err := ev.SetAttrs(&attr); err != nil {
+		t.Fatal(err)
+	}
+	if attr.Bits&unix.PerfBitPinned == 0 {
+		t.Errorf("pinned bit not set")
+	}
+
+	// The priority survives other wrappers.
+	if got := PriorityOf(WithPrivilege(ev, PrivUser)); got != PriorityPinned {
+		t.Errorf("PriorityOf(WithPrivilege(pinned event)) = %d, want %d", got, PriorityPinned)
+	}
+	// Normal priority unwraps the event.
+	if got := WithPriority(ev, PriorityNormal); got != Event(EventCPUCycles) {
+		t.Errorf("WithPriority(pinned, normal) = %v, want %v", got, EventCPUCycles)
+	}
+	if got := PriorityOf(EventCPUCycles); got != PriorityNormal {
+		t.Errorf("PriorityOf(EventCPUCycles) = %d, want %d", got, PriorityNormal)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Privilege is a set of privilege levels at which an event is counted.
+//
+// On a KVM host, PrivGuest and PrivHost further restrict an event to count
+// only while running a guest or only while running the host. If neither is
+// set, the event counts in both, like perf's events without a "G" or "H"
+// modifier.
+type Privilege uint8
+
+const (
+	PrivUser       Privilege = 1 << iota // User space, like perf's ":u" modifier
+	PrivKernel                           // Kernel, like perf's ":k" modifier
+	PrivHypervisor                       // Hypervisor, like perf's ":h" modifier
+	PrivGuest                            // KVM guest, like perf's ":G" modifier
+	PrivHost                             // KVM host, like perf's ":H" modifier
+)
+
+// privEvent is an Event restricted to a set of privilege levels.
+type privEvent struct {
+	Event
+	priv Privilege
+}
+
+// WithPrivilege returns an Event that counts ev only at the privilege levels
+// in p. For example, WithPrivilege(EventCPUCycles, PrivUser) is equivalent to
+// perf's "cpu-cycles:u".
+func WithPrivilege(ev Event, p Privilege) Event {
+	if pe, ok := ev.(privEvent); ok {
+		// Replace the existing restriction.
+		ev = pe.Event
+	}
+	return privEvent{ev, p}
+}
+
+func (e privEvent) String() string {
+	var sb strings.Builder
+	name := e.Event.String()
+	sb.WriteString(name)
+	if !strings.HasSuffix(name, "/") {
+		// PMU events put modifiers directly after the closing slash.
+		sb.WriteByte(':')
+	}
+	for i, c := range "ukhGH" {
+		if e.priv&(1<<i) != 0 {
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
+
+func (e privEvent) SetAttrs(attr *unix.PerfEventAttr) error {
+	if err := e.Event.SetAttrs(attr); err != nil {
+		return err
+	}
+	attr.Bits &^= unix.PerfBitExcludeUser | unix.PerfBitExcludeKernel | unix.PerfBitExcludeHv
+	if e.priv&PrivUser == 0 {
+		attr.Bits |= unix.PerfBitExcludeUser
+	}
+	if e.priv&PrivKernel == 0 {
+		attr.Bits |= unix.PerfBitExcludeKernel
+	}
+	if e.priv&PrivHypervisor == 0 {
+		attr.Bits |= unix.PerfBitExcludeHv
+	}
+	attr.Bits &^= unix.PerfBitExcludeGuest | unix.PerfBitExcludeHost
+	if e.priv&(PrivGuest|PrivHost) != 0 {
+		if e.priv&PrivGuest == 0 {
+			attr.Bits |= unix.PerfBitExcludeGuest
+		}
+		if e.priv&PrivHost == 0 {
+			attr.Bits |= unix.PerfBitExcludeHost
+		}
+	}
+	return nil
+}
+
+func (e privEvent) ScaleUnit() (float64, string) {
+	if es, ok := e.Event.(EventScale); ok {
+		return es.ScaleUnit()
+	}
+	return 1.0, ""
+}
+
+func (e privEvent) Filter() string {
+	return FilterOf(e.Event)
+}
+
+func (e privEvent) PerCore() bool {
+	return PerCoreOf(e.Event)
+}
+
+func (e privEvent) MetricID() string {
+	return MetricIDOf(e.Event)
+}
+
+func (e privEvent) CPUs() []int {
+	return CPUsOf(e.Event)
+}
+
+func (e privEvent) SampleRate() (period, freq uint64) {
+	if sr, ok := e.Event.(EventSampleRate); ok {
+		return sr.SampleRate()
+	}
+	return 0, 0
+}
+
+func (e privEvent) Warnings() []string {
+	if ew, ok := e.Event.(EventWarnings); ok {
+		return ew.Warnings()
+	}
+	return nil
+}
+
+func (e privEvent) Priority() Priority {
+	return PriorityOf(e.Event)
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestWithPrivilege(t *testing.T) {
+	const all = unix.PerfBitExcludeUser | unix.PerfBitExcludeKernel | unix.PerfBitExcludeHv |
+		unix.PerfBitExcludeGuest | unix.PerfBitExcludeHost
+	for _, tc := range []struct {
+		priv    Privilege
+		name    string
+		exclude uint64
+	}{
+		{PrivUser, "cpu-cycles:u", unix.PerfBitExcludeKernel | unix.PerfBitExcludeHv},
+		{PrivKernel, "cpu-cycles:k", unix.PerfBitExcludeUser | unix.PerfBitExcludeHv},
+		{PrivUser | PrivKernel, "cpu-cycles:uk", unix.PerfBitExcludeHv},
+		{PrivUser | PrivKernel | PrivHypervisor, "cpu-cycles:ukh", 0},
+		{PrivUser | PrivKernel | PrivHypervisor | PrivGuest, "cpu-cycles:ukhG", unix.PerfBitExcludeHost},
+		{PrivUser | PrivHost, "cpu-cycles:uH", unix.PerfBitExcludeKernel | unix.PerfBitExcludeHv | unix.PerfBitExcludeGuest},
+		{PrivUser | PrivKernel | PrivGuest | PrivHost, "cpu-cycles:ukGH", unix.PerfBitExcludeHv},
+	} {
+		ev := WithPrivilege(EventCPUCycles, tc.priv)
+		if got := ev.String(); got != tc.name {
+			t.Errorf("got name %q, want %q", got, tc.name)
+		}
+		var attr unix.PerfEventAttr
+		if err := ev.SetAttrs(&attr); err != nil {
+			t.Fatal(err)
+		}
+		if attr.Type != unix.PERF_TYPE_HARDWARE || attr.Config != unix.PERF_COUNT_HW_CPU_CYCLES {
+			t.Errorf("%s: wrong event type %d, config %#x", tc.name, attr.Type, attr.Config)
+		}
+		if got := attr.Bits & all; got != tc.exclude {
+			t.Errorf("%s: got exclude bits %#x, want %#x", tc.name, got, tc.exclude)
+		}
+	}
+
+	// Applying a privilege again replaces it.
+	ev := WithPrivilege(WithPrivilege(EventCPUCycles, PrivUser), PrivKernel)
+	if got, want := ev.String(), "cpu-cycles:k"; got != want {
+		t.Errorf("got name %q, want %q", got, want)
+	}
+
+	// Other properties of the event pass through.
+	ev, err := ParseEvent("l1d.replacement")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pev := WithPrivilege(ev, PrivUser)
+	if period, _ := pev.(EventSampleRate).SampleRate(); period != 0x186a3 {
+		t.Errorf("got period %#x, want %#x", period, 0x186a3)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// A TracepointFormat describes the layout of the raw data a tracepoint
+// records in each sample, such as perf's Sample.Raw. It is read from the
+// tracepoint's format file in tracefs.
+type TracepointFormat struct {
+	Name   string // Tracepoint name, such as "sched_switch"
+	ID     uint64 // Tracepoint ID, which is the perf_event_attr config
+	Fields []TracepointField
+}
+
+// A TracepointField is one field of a tracepoint's raw data.
+type TracepointField struct {
+	// Name is the name of the field, such as "prev_pid". Fields shared
+	// by all tracepoints start with "common_".
+	Name string
+
+	// Type is the C type of the field, such as "pid_t" or "char[16]".
+	// Variable-length fields have a type starting with "__data_loc" or
+	// "__rel_loc", such as "__data_loc char[]".
+	Type string
+
+	// Offset and Size are the byte offset and size of the field in the
+	// raw data. For variable-length fields, this is the location of the
+	// 32-bit descriptor of the data.
+	Offset, Size int
+
+	// Signed indicates the field is a signed integer, or an array of them.
+	Signed bool
+}
+
+// ReadTracepointFormat reads the format of the tracepoint named name, in the
+// form "subsys:event", from tracefs.
+func ReadTracepointFormat(name string) (*TracepointFormat, error) {
+	subsys, event, ok := strings.Cut(name, ":")
+	if !ok {
+		return nil, fmt.Errorf("tracepoint %q: want subsys:event", name)
+	}
+	tfs := tracefsFS()
+	if tfs == nil {
+		return nil, fmt.Errorf("tracepoint %q: tracefs is not mounted", name)
+	}
+	data, err := fs.ReadFile(tfs, "events/"+subsys+"/"+event+"/format")
+	if err != nil {
+		return nil, fmt.Errorf("tracepoint %q: %w", name, err)
+	}
+	f, err := parseTracepointFormat(data)
+	if err != nil {
+		return nil, fmt.Errorf("tracepoint %q: %w", name, err)
+	}
+	return f, nil
+}
+
+// parseTracepointFormat parses a tracefs format file, such as
+//
+//	name: sched_switch
+//	ID: 316
+//	format:
+//		field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+//		...
+//		field:char prev_comm[16];	offset:8;	size:16;	signed:0;
+//
+//	print fmt: ...
+func parseTracepointFormat(data []byte) (*TracepointFormat, error) {
+	f := new(TracepointFormat)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "name:"):
+			f.Name = strings.TrimSpace(strings.TrimPrefix(line, "name:"))
+		case strings.HasPrefix(line, "ID:"):
+			id, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "ID:")), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad ID line %q", line)
+			}
+			f.ID = id
+		case strings.HasPrefix(line, "field:"):
+			field, err := parseTracepointField(line)
+			if err != nil {
+				return nil, err
+			}
+			f.Fields = append(f.Fields, field)
+		}
+	}
+	if len(f.Fields) == 0 {
+		return nil, fmt.Errorf("format has no fields")
+	}
+	return f, nil
+}
+
+// parseTracepointField parses a field line of a format file.
+func parseTracepointField(line string) (TracepointField, error) {
+	var field TracepointField
+	errf := func() (TracepointField, error) {
+		return TracepointField{}, fmt.Errorf("bad field line %q", line)
+	}
+	for _, part := range strings.Split(line, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			continue
+		}
+		var err error
+		switch k {
+		case "field":
+			field.Name, field.Type, ok = splitFieldDecl(v)
+			if !ok {
+				return errf()
+			}
+		case "offset":
+			field.Offset, err = strconv.Atoi(v)
+		case "size":
+			field.Size, err = strconv.Atoi(v)
+		case "signed":
+			field.Signed = v == "1"
+		}
+		if err != nil {
+			return errf()
+		}
+	}
+	if field.Name == "" || field.Size <= 0 || field.Offset < 0 {
+		return errf()
+	}
+	return field, nil
+}
+
+// splitFieldDecl splits a C declaration like "char prev_comm[16]" into the
+// name "prev_comm" and the type "char[16]".
+func splitFieldDecl(decl string) (name, typ string, ok bool) {
+	decl = strings.TrimSpace(decl)
+	var array string
+	if i := strings.IndexByte(decl, '['); i >= 0 && strings.HasSuffix(decl, "]") {
+		decl, array = strings.TrimSpace(decl[:i]), decl[i:]
+	}
+	i := strings.LastIndexAny(decl, " *")
+	if i < 0 || i == len(decl)-1 {
+		return "", "", false
+	}
+	name, typ = decl[i+1:], strings.TrimSpace(decl[:i+1])
+	return name, typ + array, true
+}
+
+// Decode decodes the raw data of a sample of the tracepoint into a map from
+// field name to value. Integer fields are int64 or uint64, depending on
+// whether they're signed. Pointers are uint64. Arrays of char, including
+// variable-length ones, are strings, up to the first NUL. Other fixed-size
+// arrays are []int64 or []uint64, and other variable-length arrays are
+// []byte.
+func (f *TracepointFormat) Decode(raw []byte) (map[string]any, error) {
+	vals := make(map[string]any, len(f.Fields))
+	for _, field := range f.Fields {
+		if field.Offset+field.Size > len(raw) {
+			return nil, fmt.Errorf("field %s at [%d, %d) past end of %d byte record", field.Name, field.Offset, field.Offset+field.Size, len(raw))
+		}
+		data := raw[field.Offset : field.Offset+field.Size]
+		typ := field.Type
+
+		// Variable-length fields are a 32-bit descriptor with the
+		// offset of the data in the low 16 bits and its length in the
+		// high 16 bits. __rel_loc offsets are relative to the end of
+		// the descriptor.
+		dataLoc := strings.HasPrefix(typ, "__data_loc ")
+		relLoc := strings.HasPrefix(typ, "__rel_loc ")
+		if dataLoc || relLoc {
+			if field.Size != 4 {
+				return nil, fmt.Errorf("field %s: variable-length descriptor has size %d, want 4", field.Name, field.Size)
+			}
+			desc := binary.NativeEndian.Uint32(data)
+			off, n := int(desc&0xffff), int(desc>>16)
+			if relLoc {
+				off += field.Offset + field.Size
+			}
+			if off+n > len(raw) {
+				return nil, fmt.Errorf("field %s at [%d, %d) past end of %d byte record", field.Name, off, off+n, len(raw))
+			}
+			data = raw[off : off+n]
+			if isCharArray(typ) {
+				vals[field.Name] = cString(data)
+			} else {
+				vals[field.Name] = append([]byte(nil), data...)
+			}
+			continue
+		}
+
+		if i := strings.IndexByte(typ, '['); i >= 0 {
+			if isCharArray(typ) {
+				vals[field.Name] = cString(data)
+				continue
+			}
+			n, err := strconv.Atoi(typ[i+1 : len(typ)-1])
+			if err != nil || n <= 0 || field.Size%n != 0 || !intSize(field.Size/n) {
+				return nil, fmt.Errorf("field %s: can't decode array type %s of size %d", field.Name, typ, field.Size)
+			}
+			elemSize := field.Size / n
+			if field.Signed {
+				vs := make([]int64, n)
+				for j := range vs {
+					vs[j] = decodeSigned(data[j*elemSize : (j+1)*elemSize])
+				}
+				vals[field.Name] = vs
+			} else {
+				vs := make([]uint64, n)
+				for j := range vs {
+					vs[j] = decodeUnsigned(data[j*elemSize : (j+1)*elemSize])
+				}
+				vals[field.Name] = vs
+			}
+			continue
+		}
+
+		if !intSize(field.Size) {
+			return nil, fmt.Errorf("field %s: can't decode type %s of size %d", field.Name, typ, field.Size)
+		}
+		if field.Signed {
+			vals[field.Name] = decodeSigned(data)
+		} else {
+			vals[field.Name] = decodeUnsigned(data)
+		}
+	}
+	return vals, nil
+}
+
+// intSize reports whether size is the size of an integer type.
+func intSize(size int) bool {
+	return size == 1 || size == 2 || size == 4 || size == 8
+}
+
+// isCharArray reports whether typ is an array of char, such as "char[16]" or
+// "__data_loc char[]".
+func isCharArray(typ string) bool {
+	typ = strings.TrimPrefix(typ, "__data_loc ")
+	typ = strings.TrimPrefix(typ, "__rel_loc ")
+	typ = strings.TrimPrefix(typ, "const ")
+	return strings.HasPrefix(typ, "char[")
+}
+
+// cString returns the string in data up to the first NUL.
+func cString(data []byte) string {
+	if i := strings.IndexByte(string(data), 0); i >= 0 {
+		data = data[:i]
+	}
+	return string(data)
+}
+
+func decodeUnsigned(data []byte) uint64 {
+	switch len(data) {
+	case 1:
+		return uint64(data[0])
+	case 2:
+		return uint64(binary.NativeEndian.Uint16(data))
+	case 4:
+		return uint64(binary.NativeEndian.Uint32(data))
+	case 8:
+		return binary.NativeEndian.Uint64(data)
+	}
+	return 0
+}
+
+func decodeSigned(data []byte) int64 {
+	switch len(data) {
+	case 1:
+		return int64(int8(data[0]))
+	case 2:
+		return int64(int16(binary.NativeEndian.Uint16(data)))
+	case 4:
+		return int64(int32(binary.NativeEndian.Uint32(data)))
+	case 8:
+		return int64(binary.NativeEndian.Uint64(data))
+	}
+	return 0
+}
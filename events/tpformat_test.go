@@ -0,0 +1,124 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"encoding/binary"
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+const schedSwitchFormat = `name: sched_switch
+ID: 372
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:char prev_comm[16];	offset:8;	size:16;	signed:0;
+	field:pid_t prev_pid;	offset:24;	size:4;	signed:1;
+	field:int prev_prio;	offset:28;	size:4;	signed:1;
+	field:long prev_state;	offset:32;	size:8;	signed:1;
+	field:__data_loc char[] next_comm;	offset:40;	size:4;	signed:0;
+	field:__rel_loc u8[] data;	offset:44;	size:4;	signed:0;
+	field:const char * ptr;	offset:48;	size:8;	signed:0;
+	field:short args[2];	offset:56;	size:4;	signed:1;
+
+print fmt: "prev_comm=%s prev_pid=%d", REC->prev_comm, REC->prev_pid
+`
+
+func TestTracepointFormat(t *testing.T) {
+	old := tracefsFS
+	tracefsFS = func() fs.FS {
+		return fstest.MapFS{"events/sched/sched_switch/format": {Data: []byte(schedSwitchFormat)}}
+	}
+	defer func() { tracefsFS = old }()
+
+	f, err := ReadTracepointFormat("sched:sched_switch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name != "sched_switch" || f.ID != 372 || len(f.Fields) != 12 {
+		t.Fatalf("got %s ID %d with %d fields, want sched_switch ID 372 with 12 fields", f.Name, f.ID, len(f.Fields))
+	}
+	for i, want := range map[int]TracepointField{
+		3:  {Name: "common_pid", Type: "int", Offset: 4, Size: 4, Signed: true},
+		4:  {Name: "prev_comm", Type: "char[16]", Offset: 8, Size: 16},
+		8:  {Name: "next_comm", Type: "__data_loc char[]", Offset: 40, Size: 4},
+		10: {Name: "ptr", Type: "const char *", Offset: 48, Size: 8},
+	} {
+		if f.Fields[i] != want {
+			t.Errorf("field %d: got %+v, want %+v", i, f.Fields[i], want)
+		}
+	}
+
+	raw := make([]byte, 72)
+	ne := binary.NativeEndian
+	ne.PutUint16(raw[0:], 372)
+	ne.PutUint32(raw[4:], 1234)
+	copy(raw[8:], "swapper\x00")
+	ne.PutUint32(raw[24:], uint32(0xffffffff)) // -1
+	ne.PutUint32(raw[28:], 120)
+	ne.PutUint64(raw[32:], 2)
+	copy(raw[60:], "go\x00")
+	ne.PutUint32(raw[40:], 3<<16|60)
+	copy(raw[64:], []byte{1, 2})
+	ne.PutUint32(raw[44:], 2<<16|(64-48)) // Relative to the end of the descriptor
+	ne.PutUint64(raw[48:], 0xdeadbeef)
+	ne.PutUint16(raw[56:], 5)
+	ne.PutUint16(raw[58:], 0xfffe) // -2
+
+	got, err := f.Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"common_type":          uint64(372),
+		"common_flags":         uint64(0),
+		"common_preempt_count": uint64(0),
+		"common_pid":           int64(1234),
+		"prev_comm":            "swapper",
+		"prev_pid":             int64(-1),
+		"prev_prio":            int64(120),
+		"prev_state":           int64(2),
+		"next_comm":            "go",
+		"data":                 []byte{1, 2},
+		"ptr":                  uint64(0xdeadbeef),
+		"args":                 []int64{5, -2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := f.Decode(raw[:40]); err == nil {
+		t.Errorf("decoding truncated record: want error")
+	}
+	ne.PutUint32(raw[40:], 30<<16|60)
+	if _, err := f.Decode(raw); err == nil {
+		t.Errorf("decoding out of bounds __data_loc: want error")
+	}
+
+	if _, err := ReadTracepointFormat("sched:no_such_event"); err == nil {
+		t.Errorf("reading unknown tracepoint: want error")
+	}
+}
+
+func TestParseTracepointFormatErrors(t *testing.T) {
+	for _, data := range []string{
+		"name: x\nID: 1\nformat:\n",
+		"name: x\nID: bogus\nformat:\n\tfield:int a;\toffset:0;\tsize:4;\tsigned:1;\n",
+		"name: x\nID: 1\nformat:\n\tfield:int a;\toffset:zero;\tsize:4;\tsigned:1;\n",
+		"name: x\nID: 1\nformat:\n\tfield:int;\toffset:0;\tsize:4;\tsigned:1;\n",
+	} {
+		if f, err := parseTracepointFormat([]byte(data)); err == nil {
+			t.Errorf("parsing %q: want error, got %+v", data, f)
+		}
+	}
+}
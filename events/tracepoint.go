@@ -0,0 +1,109 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// tracefsFS returns the root of tracefs, or nil if it isn't mounted. This is a
+// variable so it can be stubbed by tests.
+var tracefsFS = sync.OnceValue(func() fs.FS {
+	dirs := []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"}
+	// Some systems mount tracefs elsewhere.
+	if mounts, err := os.ReadFile("/proc/self/mounts"); err == nil {
+		dirs = append(dirs, tracefsMounts(mounts)...)
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir + "/events"); err == nil {
+			return os.DirFS(dir)
+		}
+	}
+	return nil
+})
+
+// tracefsMounts returns the mount points of tracefs in data, which is in the
+// format of /proc/self/mounts.
+func tracefsMounts(data []byte) []string {
+	var dirs []string
+	for _, line := range strings.Split(string(data), "\n") {
+		// Format: device mountpoint fstype options dump pass
+		f := strings.Fields(line)
+		if len(f) >= 3 && f[2] == "tracefs" {
+			dirs = append(dirs, unescapeMountPath(f[1]))
+		}
+	}
+	return dirs
+}
+
+// unescapeMountPath decodes the octal escapes, such as "\040" for a space,
+// that the kernel uses for whitespace and backslashes in mount paths.
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+4 <= len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(path[i])
+	}
+	return sb.String()
+}
+
+var errNotTracepoint = errors.New("not a tracepoint event")
+
+// parseTracepoint parses a tracepoint event in the form "subsys:event",
+// optionally followed by "@filter" to only count occurrences that match a
+// tracepoint filter, as in "sched:sched_switch@prev_pid==0". It returns
+// errNotTracepoint if name isn't in this form.
+func parseTracepoint(name string) (Event, error) {
+	tp, filter, hasFilter := strings.Cut(name, "@")
+	subsys, event, ok := strings.Cut(tp, ":")
+	if !ok || subsys == "" || event == "" || strings.ContainsAny(tp, "/=,") || strings.Contains(event, ":") {
+		return nil, errNotTracepoint
+	}
+	if hasFilter && strings.TrimSpace(filter) == "" {
+		return nil, fmt.Errorf("event %q: empty filter", name)
+	}
+
+	tfs := tracefsFS()
+	if tfs == nil {
+		if !hasFilter {
+			// This could be an event with a modifier, like "cycles:u".
+			return nil, errNotTracepoint
+		}
+		return nil, fmt.Errorf("event %q: tracefs is not mounted", name)
+	}
+	data, err := fs.ReadFile(tfs, "events/"+subsys+"/"+event+"/id")
+	if errors.Is(err, fs.ErrNotExist) {
+		if !hasFilter {
+			return nil, errNotTracepoint
+		}
+		return nil, fmt.Errorf("unknown tracepoint %q", tp)
+	} else if err != nil {
+		return nil, fmt.Errorf("event %q: %w", name, err)
+	}
+	id, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("event %q: bad tracepoint ID %q", name, data)
+	}
+	return &rawEvent{name: name, pmu: unix.PERF_TYPE_TRACEPOINT, config: id, scale: 1.0, filter: filter}, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"golang.org/x/sys/unix"
+)
+
+var testTracefs = fstest.MapFS{
+	"events/sched/sched_switch/id":       {Data: []byte("372\n")},
+	"events/syscalls/sys_enter_read/id":  {Data: []byte("842\n")},
+	"events/syscalls/sys_enter_write/id": {Data: []byte("bogus\n")},
+}
+
+func TestParseTracepoint(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config uint64
+		filter string
+		err    bool
+	}{
+		{name: "sched:sched_switch", config: 372},
+		{name: "sched:sched_switch@prev_pid == 0", config: 372, filter: "prev_pid == 0"},
+		{name: "syscalls:sys_enter_read@fd==3&&count>16", config: 842, filter: "fd==3&&count>16"},
+		{name: "syscalls:sys_enter_write", err: true},
+		{name: "sched:no_such_event@prev_pid==0", err: true},
+		{name: "sched:sched_switch@", err: true},
+	} {
+		ev, err := ParseEvent(tc.name)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: want error, got %s", tc.name, evString(ev))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var attr unix.PerfEventAttr
+		if err := ev.SetAttrs(&attr); err != nil {
+			t.Fatal(err)
+		}
+		if attr.Type != unix.PERF_TYPE_TRACEPOINT || attr.Config != tc.config {
+			t.Errorf("%s: got type %d, config %d; want type %d, config %d", tc.name, attr.Type, attr.Config, unix.PERF_TYPE_TRACEPOINT, tc.config)
+		}
+		if got := FilterOf(ev); got != tc.filter {
+			t.Errorf("%s: got filter %q, want %q", tc.name, got, tc.filter)
+		}
+		if got := ev.String(); got != tc.name {
+			t.Errorf("%s: got name %q", tc.name, got)
+		}
+	}
+
+	// Names that look like tracepoints but aren't fall through to the
+	// other event syntaxes.
+	if _, err := ParseEvent("no:such_event"); err == nil || err.Error() != `unknown event "no:such_event"` {
+		t.Errorf("no:such_event: got %v, want unknown event", err)
+	}
+
+	// Wrapped events keep their filter.
+	ev, err := ParseEvent("sched:sched_switch@prev_pid==0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, wev := range []Event{WithPrivilege(ev, PrivKernel), WithPriority(ev, PriorityPinned)} {
+		if got := FilterOf(wev); got != "prev_pid==0" {
+			t.Errorf("%s: got filter %q, want %q", wev, got, "prev_pid==0")
+		}
+	}
+}
+
+func TestParseTracepointNoTracefs(t *testing.T) {
+	old := tracefsFS
+	tracefsFS = func() fs.FS { return nil }
+	defer func() { tracefsFS = old }()
+
+	if _, err := ParseEvent("sched:sched_switch@prev_pid==0"); err == nil {
+		t.Errorf("want error without tracefs")
+	}
+	if _, err := ParseEvent("instructions"); err != nil {
+		t.Errorf("instructions: %v", err)
+	}
+}
+
+func TestTracefsMounts(t *testing.T) {
+	mounts := `sysfs /sys sysfs rw,nosuid,nodev,noexec,relatime 0 0
+tracefs /sys/kernel/tracing tracefs rw,nosuid,nodev,noexec,relatime 0 0
+none /mnt/my\040trace tracefs rw,relatime 0 0
+debugfs /sys/kernel/debug debugfs rw,nosuid,nodev,noexec,relatime 0 0
+`
+	got := tracefsMounts([]byte(mounts))
+	want := []string{"/sys/kernel/tracing", "/mnt/my trace"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package events
+
+import "time"
+
+// ScaleUnitOf returns the scale factor and unit of ev, or 1.0, "" if ev
+// doesn't specify them. See [EventScale].
+func ScaleUnitOf(ev Event) (scale float64, unit string) {
+	if es, ok := ev.(EventScale); ok {
+		return es.ScaleUnit()
+	}
+	return 1.0, ""
+}
+
+// ScaleValue converts a raw count of ev into ev's unit. For example, the RAPL
+// energy events count in units of about 2.3e-10 Joules.
+func ScaleValue(ev Event, raw uint64) (val float64, unit string) {
+	scale, unit := ScaleUnitOf(ev)
+	return float64(raw) * scale, unit
+}
+
+// RateUnit returns the unit of a rate of change of a value in the given unit.
+// Joules per second are "Watts"; other units are suffixed with "/s", such as
+// "MiB/s". The rate of a plain count is "/s".
+func RateUnit(unit string) string {
+	switch unit {
+	case "Joules":
+		return "Watts"
+	}
+	return unit + "/s"
+}
+
+// Rate converts delta, the change in a raw count of ev over duration d, into a
+// rate in [RateUnit] of ev's unit. For example, this converts the change in a
+// RAPL energy event into Watts. If d is not positive, the rate is 0.
+//
+// This only makes sense for events that accumulate, not for events that report
+// an instantaneous value, such as a temperature in "C".
+func Rate(ev Event, delta uint64, d time.Duration) (rate float64, unit string) {
+	val, unit := ScaleValue(ev, delta)
+	if d > 0 {
+		rate = val / d.Seconds()
+	}
+	return rate, RateUnit(unit)
+}
@@ -0,0 +1,39 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRate(t *testing.T) {
+	energy := &rawEvent{name: "energy-pkg", scale: 2.5e-10, unit: "Joules"}
+	mem := &rawEvent{name: "data_read", scale: 0.5, unit: "MiB"}
+	for _, test := range []struct {
+		ev    Event
+		delta uint64
+		d     time.Duration
+		rate  float64
+		unit  string
+	}{
+		{energy, 40e9, 2 * time.Second, 5, "Watts"},
+		{mem, 100, 500 * time.Millisecond, 100, "MiB/s"},
+		{EventInstructions, 3000, time.Second, 3000, "/s"},
+		{WithPriority(energy, PriorityPinned), 4e9, time.Second, 1, "Watts"},
+		{energy, 4e9, 0, 0, "Watts"},
+	} {
+		rate, unit := Rate(test.ev, test.delta, test.d)
+		if rate != test.rate || unit != test.unit {
+			t.Errorf("Rate(%s, %d, %s) = %v %s, want %v %s", test.ev, test.delta, test.d, rate, unit, test.rate, test.unit)
+		}
+	}
+
+	if val, unit := ScaleValue(energy, 4e9); val != 1 || unit != "Joules" {
+		t.Errorf("ScaleValue(%s, 4e9) = %v %s, want 1 Joules", energy, val, unit)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"debug/elf"
+	"fmt"
+	"path/filepath"
+)
+
+// Uprobe returns an Event that occurs each time a process executes the
+// instruction at file offset offset in the executable or shared library at
+// path. Use [UprobeOffset] to find the offset of a function. Counting the
+// event on a thread or process only counts that target's executions.
+//
+// Like [Kprobe], Uprobe uses the kernel's uprobe PMU, which creates the probe
+// when a Counter or Sampler opens the event and removes it when it's closed.
+// Opening a uprobe typically requires root.
+func Uprobe(path string, offset uint64) (Event, error) {
+	return newUprobe("uprobe", path, offset, false)
+}
+
+// Uretprobe is like [Uprobe], but returns an Event that occurs each time the
+// function at file offset offset in path returns.
+func Uretprobe(path string, offset uint64) (Event, error) {
+	return newUprobe("uretprobe", path, offset, true)
+}
+
+func newUprobe(kind, path string, offset uint64, retprobe bool) (Event, error) {
+	// The kernel resolves the path when the event is opened, which may be
+	// from another directory.
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", kind, err)
+	}
+	return newProbe("uprobe", fmt.Sprintf("%s:%s+%#x", kind, abs, offset), abs, offset, retprobe)
+}
+
+// UprobeOffset returns the file offset of the function named sym in the ELF
+// executable or shared library at path, for use with [Uprobe]. It looks up
+// sym in both the symbol table and the dynamic symbol table.
+func UprobeOffset(path, sym string) (uint64, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	syms, _ := f.Symbols()
+	dynSyms, _ := f.DynamicSymbols()
+	for _, s := range append(syms, dynSyms...) {
+		if s.Name != sym || elf.ST_TYPE(s.Info) != elf.STT_FUNC || s.Value == 0 {
+			continue
+		}
+		for _, p := range f.Progs {
+			if p.Type == elf.PT_LOAD && p.Vaddr <= s.Value && s.Value < p.Vaddr+p.Filesz {
+				return s.Value - p.Vaddr + p.Off, nil
+			}
+		}
+		return 0, fmt.Errorf("%s: symbol %s at %#x is not in a loadable segment", path, sym, s.Value)
+	}
+	return 0, fmt.Errorf("%s: function symbol %s not found", path, sym)
+}
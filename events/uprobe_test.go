@@ -0,0 +1,99 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package events
+
+import (
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestUprobe(t *testing.T) {
+	for _, tc := range []struct {
+		ev     func() (Event, error)
+		name   string
+		config uint64
+	}{
+		{func() (Event, error) { return Uprobe("/bin/true", 0x1040) }, "uprobe:/bin/true+0x1040", 0},
+		{func() (Event, error) { return Uretprobe("/bin/true", 0x1040) }, "uretprobe:/bin/true+0x1040", 1},
+	} {
+		ev, err := tc.ev()
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := ev.String(); got != tc.name {
+			t.Errorf("got name %q, want %q", got, tc.name)
+		}
+		var attr unix.PerfEventAttr
+		if err := ev.SetAttrs(&attr); err != nil {
+			t.Fatal(err)
+		}
+		if attr.Type != 8 || attr.Config != tc.config || attr.Ext2 != 0x1040 {
+			t.Errorf("%s: got type %d, config %#x, offset %#x; want type 8, config %#x, offset 0x1040", tc.name, attr.Type, attr.Config, attr.Ext2, tc.config)
+		}
+		if got := string(ev.(*probeEvent).target); got != "/bin/true\x00" {
+			t.Errorf("%s: got path %q", tc.name, got)
+		}
+	}
+
+	// Relative paths are made absolute.
+	ev, err := Uprobe("prog", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	if want := "uprobe:" + filepath.Join(wd, "prog") + "+0x0"; ev.String() != want {
+		t.Errorf("got %q, want %q", ev, want)
+	}
+}
+
+func TestUprobeOffset(t *testing.T) {
+	cc, err := exec.LookPath("gcc")
+	if err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "prog.c")
+	if err := os.WriteFile(src, []byte("int probeTarget(int x) { return x + 1; }\nint main() { return probeTarget(0); }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	prog := filepath.Join(dir, "prog")
+	if out, err := exec.Command(cc, "-o", prog, src).CombinedOutput(); err != nil {
+		t.Skipf("building program: %v\n%s", err, out)
+	}
+
+	off, err := UprobeOffset(prog, "probeTarget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Check the offset against the symbol's address.
+	f, err := elf.Open(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	syms, _ := f.Symbols()
+	for _, s := range syms {
+		if s.Name != "probeTarget" {
+			continue
+		}
+		for _, p := range f.Progs {
+			if p.Type == elf.PT_LOAD && p.Off <= off && off < p.Off+p.Filesz && off-p.Off+p.Vaddr != s.Value {
+				t.Errorf("offset %#x maps to address %#x, want %#x", off, off-p.Off+p.Vaddr, s.Value)
+			}
+		}
+	}
+
+	if _, err := UprobeOffset(prog, "noSuchFunction"); err == nil {
+		t.Errorf("UprobeOffset of missing function: want error")
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// AttrString returns a perf-style string describing the event attr selects,
+// such as "cpu-cycles:u" or "cpu/event=0x3c,umask=0x1/upp". This is useful
+// for logging the event that was actually opened, after options and
+// fallbacks have modified the attributes of the requested event.
+//
+// The event name is as returned by [events.AttrName]. It's followed by
+// perf's modifiers for the privilege levels counted (u, k, and h) if attr
+// excludes any, guest or host counting (G or H), precision (p, pp, or ppp),
+// and pinning (D). AttrString doesn't describe other attributes, such as the
+// sample rate.
+func AttrString(attr *unix.PerfEventAttr) string {
+	var sb strings.Builder
+	name := events.AttrName(attr)
+	sb.WriteString(name)
+
+	var mods strings.Builder
+	const excludeAll = unix.PerfBitExcludeUser | unix.PerfBitExcludeKernel | unix.PerfBitExcludeHv
+	if exclude := attr.Bits & excludeAll; exclude != 0 {
+		for _, m := range []struct {
+			bit uint64
+			mod byte
+		}{{unix.PerfBitExcludeUser, 'u'}, {unix.PerfBitExcludeKernel, 'k'}, {unix.PerfBitExcludeHv, 'h'}} {
+			if exclude&m.bit == 0 {
+				mods.WriteByte(m.mod)
+			}
+		}
+	}
+	const excludeVirt = unix.PerfBitExcludeGuest | unix.PerfBitExcludeHost
+	if exclude := attr.Bits & excludeVirt; exclude != 0 && exclude != excludeVirt {
+		if exclude&unix.PerfBitExcludeGuest == 0 {
+			mods.WriteByte('G')
+		} else {
+			mods.WriteByte('H')
+		}
+	}
+	precise := 0
+	if attr.Bits&unix.PerfBitPreciseIPBit1 != 0 {
+		precise |= 1
+	}
+	if attr.Bits&unix.PerfBitPreciseIPBit2 != 0 {
+		precise |= 2
+	}
+	mods.WriteString(strings.Repeat("p", precise))
+	if attr.Bits&unix.PerfBitPinned != 0 {
+		mods.WriteByte('D')
+	}
+
+	if mods.Len() > 0 {
+		// Perf writes the modifiers of PMU events directly after the
+		// closing slash.
+		if !strings.HasSuffix(name, "/") {
+			sb.WriteByte(':')
+		}
+		sb.WriteString(mods.String())
+	}
+	return sb.String()
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestAttrString(t *testing.T) {
+	cycles := unix.PerfEventAttr{Type: unix.PERF_TYPE_HARDWARE, Config: unix.PERF_COUNT_HW_CPU_CYCLES}
+	for _, tc := range []struct {
+		attr unix.PerfEventAttr
+		bits uint64
+		want string
+	}{
+		{cycles, 0, "cpu-cycles"},
+		{cycles, unix.PerfBitExcludeKernel | unix.PerfBitExcludeHv, "cpu-cycles:u"},
+		{cycles, unix.PerfBitExcludeUser, "cpu-cycles:kh"},
+		{cycles, unix.PerfBitPreciseIPBit1 | unix.PerfBitPreciseIPBit2, "cpu-cycles:ppp"},
+		{cycles, unix.PerfBitExcludeHv | unix.PerfBitPreciseIPBit2 | unix.PerfBitPinned, "cpu-cycles:ukppD"},
+		{cycles, unix.PerfBitExcludeHost, "cpu-cycles:G"},
+		{cycles, unix.PerfBitExcludeKernel | unix.PerfBitExcludeHv | unix.PerfBitExcludeGuest | unix.PerfBitPreciseIPBit1, "cpu-cycles:uHp"},
+		{unix.PerfEventAttr{Type: 99, Config: 1}, unix.PerfBitExcludeKernel, "pmu99/config=0x1/uh"},
+	} {
+		tc.attr.Bits |= tc.bits
+		if got := AttrString(&tc.attr); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestAttrStringCounter(t *testing.T) {
+	// AttrString describes the attributes the Counter actually opened.
+	k := useFakeKernel(t)
+	opts := CounterOptions{ExcludeKernel: true}
+	c, err := opts.OpenCounter(TargetThisGoroutine, events.EventInstructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got, want := AttrString(&k.fakeEventFor(t, c, 0).attr), "instructions:u"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// AuxFlags is a set of PERF_AUX_FLAG_* flags, which describe the data
+// reported by an [AuxRecord].
+type AuxFlags uint64
+
+const (
+	// AuxTruncated indicates the AUX area filled up, so the PMU stopped
+	// writing trace data and some was lost. Read AUX data promptly with
+	// [Sampler.AuxData] to make room.
+	AuxTruncated AuxFlags = 1 << iota
+	// AuxOverwrite indicates the data was written in overwrite mode.
+	AuxOverwrite
+	// AuxPartial indicates the data contains gaps.
+	AuxPartial
+	// AuxCollision indicates the PMU's sample collided with another.
+	AuxCollision
+)
+
+var auxFlagNames = []string{"TRUNCATED", "OVERWRITE", "PARTIAL", "COLLISION"}
+
+// String returns the flags in f in the form "TRUNCATED|PARTIAL".
+func (f AuxFlags) String() string {
+	return flagsString(uint64(f), auxFlagNames)
+}
+
+// An AuxRecord is a decoded [RecordAux] record, which reports that the PMU
+// wrote a chunk of data to the AUX area. See [SamplerOptions.AuxPages].
+type AuxRecord struct {
+	// Offset is the position of the chunk in the AUX area. Like positions
+	// in the ring buffer, this increases monotonically and wraps around
+	// the end of the AUX area.
+	Offset uint64
+	// Size is the size of the chunk in bytes.
+	Size uint64
+
+	Flags    AuxFlags
+	RecordID RecordID
+}
+
+// DecodeAux decodes rec, which must be a [RecordAux] record, according to
+// format, which must be the format the record was produced with (see
+// [Sampler.SampleFormat]).
+func DecodeAux(rec RawRecord, format SampleFormat) (AuxRecord, error) {
+	if rec.Type != RecordAux {
+		return AuxRecord{}, fmt.Errorf("cannot decode %s record as an AUX record", rec.Type)
+	}
+	body, id, err := decodeRecordID(rec.Data, format)
+	if err != nil {
+		return AuxRecord{}, err
+	}
+	d := sampleDecoder{data: body}
+	r := AuxRecord{RecordID: id}
+	r.Offset, r.Size, r.Flags = d.u64(), d.u64(), AuxFlags(d.u64())
+	if d.short {
+		return AuxRecord{}, errShortRecord
+	}
+	return r, nil
+}
+
+// mapAux maps an AUX area of at least pages pages after s's ring buffer.
+func (s *Sampler) mapAux(pages int) error {
+	pages = ceilPow2(pages)
+	size := pages * os.Getpagesize()
+	// The kernel requires the AUX area to follow the ring buffer, and takes
+	// its location from the control page.
+	off := uint64(len(s.mmap))
+	atomic.StoreUint64(&s.ring.meta.Aux_offset, off)
+	atomic.StoreUint64(&s.ring.meta.Aux_size, uint64(size))
+	aux, err := sys.mmap(s.fd, int64(off), size)
+	if err != nil {
+		return fmt.Errorf("mapping %d page AUX area: %w", pages, err)
+	}
+	s.aux = aux
+	return nil
+}
+
+// AuxData returns the chunk of AUX data described by r, which must be a
+// record read from s. Chunks must be read in order. The returned data is only
+// valid until the next call to AuxData or Close, at which point its space in
+// the AUX area is returned to the kernel.
+//
+// The PMU stops writing to the AUX area when it fills up, so callers should
+// read every chunk, even ones they don't need, to keep tracing.
+func (s *Sampler) AuxData(r AuxRecord) ([]byte, error) {
+	if s == nil || s.f == nil {
+		return nil, fmt.Errorf("Sampler is closed")
+	}
+	if s.aux == nil {
+		return nil, fmt.Errorf("Sampler has no AUX area")
+	}
+	if r.Size > uint64(len(s.aux)) {
+		return nil, fmt.Errorf("AUX chunk of %d bytes is larger than the %d byte AUX area", r.Size, len(s.aux))
+	}
+	start, n := int(r.Offset&uint64(len(s.aux)-1)), int(r.Size)
+	var data []byte
+	if start+n <= len(s.aux) {
+		data = s.aux[start : start+n]
+	} else {
+		s.auxBuf = append(s.auxBuf[:0], s.aux[start:]...)
+		s.auxBuf = append(s.auxBuf, s.aux[:n-(len(s.aux)-start)]...)
+		data = s.auxBuf
+	}
+	// Release the space of all previous chunks, but not this one, since
+	// the caller is still using it. The kernel only overwrites data
+	// before aux_tail.
+	if atomic.LoadUint64(&s.ring.meta.Aux_tail) < r.Offset {
+		atomic.StoreUint64(&s.ring.meta.Aux_tail, r.Offset)
+	}
+	return data, nil
+}
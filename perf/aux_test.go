@@ -0,0 +1,135 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func auxData(offset, size uint64, flags AuxFlags) []byte {
+	data := binary.NativeEndian.AppendUint64(nil, offset)
+	data = binary.NativeEndian.AppendUint64(data, size)
+	return binary.NativeEndian.AppendUint64(data, uint64(flags))
+}
+
+func TestDecodeAux(t *testing.T) {
+	format := SampleFormat{SampleType: SampleTID, SampleIDAll: true}
+	data := auxData(4096, 100, AuxTruncated|AuxPartial)
+	data = binary.NativeEndian.AppendUint32(data, 10)
+	data = binary.NativeEndian.AppendUint32(data, 11)
+	got, err := DecodeAux(RawRecord{RecordAux, 0, data}, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AuxRecord{Offset: 4096, Size: 100, Flags: AuxTruncated | AuxPartial, RecordID: RecordID{PID: 10, TID: 11}}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got, want := got.Flags.String(), "TRUNCATED|PARTIAL"; got != want {
+		t.Errorf("got flags %s, want %s", got, want)
+	}
+
+	if _, err := DecodeAux(RawRecord{RecordAux, 0, data[:20]}, format); err != errShortRecord {
+		t.Errorf("truncated record: got %v, want %v", err, errShortRecord)
+	}
+	if _, err := DecodeAux(RawRecord{RecordSample, 0, data}, format); err == nil {
+		t.Errorf("decoding a sample as an AUX record: want error")
+	}
+}
+
+// writeAux writes data to ev's AUX area, followed by a RecordAux record
+// describing it.
+func (ev *fakeEvent) writeAux(data []byte) {
+	meta := (*unix.PerfEventMmapPage)(unsafe.Pointer(&ev.mmap[0]))
+	head := atomic.LoadUint64(&meta.Aux_head)
+	for i, b := range data {
+		ev.aux[(head+uint64(i))%uint64(len(ev.aux))] = b
+	}
+	atomic.StoreUint64(&meta.Aux_head, head+uint64(len(data)))
+	ev.writeRecord(RecordAux, 0, auxData(head, uint64(len(data)), 0))
+}
+
+func TestSamplerAux(t *testing.T) {
+	k := useFakeKernel(t)
+	opts := SamplerOptions{SampleType: SampleIP, AuxPages: 1}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ev := k.events[s.fd]
+	meta := (*unix.PerfEventMmapPage)(unsafe.Pointer(&ev.mmap[0]))
+	pageSize := os.Getpagesize()
+	if len(ev.aux) != pageSize || meta.Aux_offset != uint64(len(ev.mmap)) {
+		t.Fatalf("got %d byte AUX area at offset %d, want %d bytes at %d", len(ev.aux), meta.Aux_offset, pageSize, len(ev.mmap))
+	}
+
+	// Write chunks until one wraps around the end of the AUX area.
+	chunk := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i)}, pageSize/3)
+	}
+	for i := 0; i < 4; i++ {
+		ev.writeAux(chunk(i))
+		rec, ok := s.ReadRecord()
+		if !ok || rec.Type != RecordAux {
+			t.Fatalf("chunk %d: got record %v, %v; want %s", i, rec.Type, ok, RecordAux)
+		}
+		r, err := DecodeAux(rec, s.SampleFormat())
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := s.AuxData(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, chunk(i)) {
+			t.Errorf("chunk %d: got wrong data", i)
+		}
+		// The previous chunks have been released.
+		if got := atomic.LoadUint64(&meta.Aux_tail); got != r.Offset {
+			t.Errorf("chunk %d: got aux_tail %d, want %d", i, got, r.Offset)
+		}
+	}
+}
+
+func TestSamplerAuxInvalid(t *testing.T) {
+	useFakeKernel(t)
+	s, err := OpenSampler(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.AuxData(AuxRecord{}); err == nil {
+		t.Errorf("AuxData without an AUX area: want error")
+	}
+
+	opts := SamplerOptions{AuxPages: 1, Output: s}
+	if s2, err := opts.OpenSampler(TargetThisGoroutine, events.EventCPUCycles); err == nil {
+		s2.Close()
+		t.Errorf("AuxPages with Output: want error")
+	}
+}
+
+func TestSamplerAuxUnsupported(t *testing.T) {
+	// Software events don't support an AUX area.
+	opts := SamplerOptions{AuxPages: 1}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err == nil {
+		s.Close()
+		t.Fatalf("opening AUX area for %s: want error", events.EventTaskClock)
+	}
+	t.Log(err)
+}
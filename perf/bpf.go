@@ -0,0 +1,52 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetBPF attaches the BPF program with file descriptor progFD to the first
+// event of c, using PERF_EVENT_IOC_SET_BPF. The kernel runs the program each
+// time the event fires, which lets it filter or aggregate events in the
+// kernel. The program must be of a type that matches the event, such as a
+// kprobe program for a [events.Kprobe] event or a tracepoint program for a
+// tracepoint event. progFD can come from any BPF loader, such as
+// github.com/cilium/ebpf's Program.FD.
+//
+// The event keeps its own reference to the program, so the caller may close
+// progFD after SetBPF returns. The program is detached when c is closed.
+func (c *Counter) SetBPF(progFD int) error {
+	if c == nil || c.fds == nil {
+		return fmt.Errorf("Counter is closed")
+	}
+	return setBPF(c.fds[0], progFD)
+}
+
+// SetBPF is like [Counter.SetBPF], but attaches the BPF program to the
+// sampled event of s. For events that aren't tracepoints or probes, the
+// program must be a perf_event program, which the kernel runs on each
+// sample. If the program returns 0, the kernel drops the sample instead of
+// writing it to the ring buffer.
+func (s *Sampler) SetBPF(progFD int) error {
+	if s == nil || s.f == nil {
+		return fmt.Errorf("Sampler is closed")
+	}
+	return setBPF(s.fd, progFD)
+}
+
+func setBPF(fd, progFD int) error {
+	if progFD < 0 {
+		return fmt.Errorf("invalid BPF program file descriptor %d", progFD)
+	}
+	if err := sys.ioctl(fd, unix.PERF_EVENT_IOC_SET_BPF, progFD); err != nil {
+		return fmt.Errorf("attaching BPF program: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestSetBPF(t *testing.T) {
+	k := useFakeKernel(t)
+
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventInstructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Odd file descriptors look like PERF_IOC_FLAG_GROUP, but shouldn't
+	// apply to the group.
+	if err := c.SetBPF(7); err != nil {
+		t.Fatal(err)
+	}
+	if got := k.events[c.fds[0]].bpfFD; got != 7 {
+		t.Errorf("first event has BPF program %d, want 7", got)
+	}
+	if got := k.events[c.fds[1]].bpfFD; got != 0 {
+		t.Errorf("second event has BPF program %d, want none", got)
+	}
+	if err := c.SetBPF(8); !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("attaching second program: got %v, want EEXIST", err)
+	}
+	if err := c.SetBPF(-1); err == nil {
+		t.Errorf("attaching fd -1: want error")
+	}
+	c.Close()
+	if err := c.SetBPF(7); err == nil {
+		t.Errorf("attaching to closed Counter: want error")
+	}
+
+	s, err := OpenSampler(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetBPF(9); err != nil {
+		t.Fatal(err)
+	}
+	if got := k.events[s.fd].bpfFD; got != 9 {
+		t.Errorf("Sampler has BPF program %d, want 9", got)
+	}
+	s.Close()
+	if err := s.SetBPF(9); err == nil {
+		t.Errorf("attaching to closed Sampler: want error")
+	}
+}
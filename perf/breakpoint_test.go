@@ -0,0 +1,50 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// breakpointTarget is word-sized so each increment is a single store, even
+// on 32-bit platforms.
+var breakpointTarget [2]uintptr
+
+//go:noinline
+func writeBreakpointTarget(i int) {
+	breakpointTarget[i]++
+}
+
+func TestBreakpointCounter(t *testing.T) {
+	ev, err := events.Breakpoint(uint64(uintptr(unsafe.Pointer(&breakpointTarget[0]))), int(unsafe.Sizeof(breakpointTarget[0])), events.BreakpointWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := OpenCounter(TargetThisGoroutine, ev)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer c.Close()
+
+	c.Start()
+	for i := 0; i < 10; i++ {
+		writeBreakpointTarget(0)
+		// Writes to other addresses don't trigger the breakpoint.
+		writeBreakpointTarget(1)
+	}
+	c.Stop()
+	count, err := c.ReadOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.RawValue != 10 {
+		t.Errorf("got %d writes, want 10", count.RawValue)
+	}
+}
@@ -9,12 +9,16 @@ package perf
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"runtime"
 	"strconv"
+	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -41,21 +45,98 @@ var (
 	TargetThisGoroutine = targetThisGoroutine{}
 )
 
+type targetThread int
+
+func (t targetThread) pidCPU() (pid, cpu int) { return int(t), -1 }
+func (targetThread) open()                    {}
+func (targetThread) close()                   {}
+
+// TargetThread monitors the OS thread with thread ID tid, which may be in
+// another process. This only monitors that one thread, not any threads it
+// creates. To monitor all of the threads of a process, see
+// [OpenThreadCounters].
+//
+// Monitoring a thread of another process generally requires the same
+// permissions as ptrace.
+func TargetThread(tid int) Target {
+	return targetThread(tid)
+}
+
+type targetCPU int
+
+func (t targetCPU) pidCPU() (pid, cpu int) { return -1, int(t) }
+func (targetCPU) open()                    {}
+func (targetCPU) close()                   {}
+
+// TargetCPU monitors all tasks running on CPU cpu. To monitor several CPUs,
+// see [OpenCPUCounters].
+//
+// Monitoring a CPU generally requires CAP_PERFMON or a perf_event_paranoid
+// setting of 0 or less.
+func TargetCPU(cpu int) Target {
+	return targetCPU(cpu)
+}
+
 // A Counter reports the number of times a [events.Event] or group of Events
 // occurred.
 type Counter struct {
 	target Target
+	opts   CounterOptions
+	evs    []events.Event
 
 	eventScales []scale
 
-	f []*os.File
+	// fds are the file descriptors of each event, or nil if the Counter is
+	// closed.
+	fds []int
+
+	// leaderFD is the file descriptor of the group leader, fds[0], or -1 if
+	// the Counter is closed. We cache this so Start and Stop can make the
+	// ioctl directly.
+	leaderFD int
+	// groupFlag is the ioctl argument that applies an ioctl to the whole
+	// group, or 0 for a single event.
+	groupFlag int
 
 	running bool
 
 	nEvents int
+	lost    bool // read_format includes PERF_FORMAT_LOST
 	readBuf []byte
+
+	// base is the baseline subtracted from each read. The kernel's reset
+	// doesn't reset the enabled/running times or lost counts, so we track
+	// these ourselves.
+	base []Count
+	// cur is scratch space for reading all events.
+	cur []Count
+
+	// userPages are the mapped control pages of each event, which are
+	// used to read the events with RDPMC, or nil if c can't use RDPMC.
+	// userMmaps are the mappings of the pages, and userSeqs is scratch
+	// space for reading them. userTID is the thread the events count, which
+	// is the only thread that can read them with RDPMC.
+	userPages []*unix.PerfEventMmapPage
+	userMmaps [][]byte
+	userSeqs  []uint32
+	userTID   int
+
+	lastRead ReadPath
 }
 
+// perfIOCFlagGroup is PERF_IOC_FLAG_GROUP, which applies an ioctl to all events
+// in a group.
+const perfIOCFlagGroup = 1
+
+// ErrUnschedulable indicates that a pinned counter could not be scheduled on
+// the PMU, usually because pinned events from this or another process are
+// using all of the hardware counters. The counter stays in this state until it
+// is stopped and started again. See [events.PriorityPinned].
+var ErrUnschedulable = errors.New("pinned counter could not be scheduled")
+
+// noFormatLost is set if the kernel doesn't support PERF_FORMAT_LOST.
+var noFormatLost atomic.Bool
+
 type scale struct {
 	scale float64
 	unit  string
@@ -69,7 +150,38 @@ type scale struct {
 // will all be scheduled onto the hardware at the same time.
 //
 // The counter is initially not running. Call [Counter.Start] to start it.
+//
+// To open a counter with non-default options, use [CounterOptions.OpenCounter].
 func OpenCounter(target Target, evs ...events.Event) (*Counter, error) {
+	return openCounter(target, nil, evs)
+}
+
+// Measure counts the given events on target while running f. It opens a
+// [Counter] for evs, starts it, calls f, stops it, and returns the Count of
+// each event. This is convenient for one-off measurements, but opening the
+// Counter is relatively expensive, so code measuring many regions should
+// open a Counter once and reuse it.
+//
+// If the Counter can't be opened, Measure returns the error without calling f.
+func Measure(target Target, evs []events.Event, f func()) ([]Count, error) {
+	c, err := OpenCounter(target, evs...)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	c.Start()
+	f()
+	c.Stop()
+
+	cs := make([]Count, len(evs))
+	if err := c.ReadGroup(cs); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+func openCounter(target Target, opts *CounterOptions, evs []events.Event) (*Counter, error) {
 	if len(evs) == 0 {
 		return nil, nil
 	}
@@ -77,14 +189,20 @@ func OpenCounter(target Target, evs ...events.Event) (*Counter, error) {
 	// Get event scales.
 	eventScales := make([]scale, len(evs))
 	for i, event := range evs {
-		sc, unit := 1.0, ""
-		if es, ok := event.(events.EventScale); ok {
-			sc, unit = es.ScaleUnit()
-		}
+		sc, unit := events.ScaleUnitOf(event)
 		eventScales[i] = scale{sc, unit}
 	}
 
 	pid, cpu := target.pidCPU()
+	if cpu == -1 {
+		// The kernel rejects events of uncore PMUs for a thread with just
+		// EINVAL, so give a better error.
+		for _, event := range evs {
+			if cpus := events.CPUsOf(event); cpus != nil {
+				return nil, fmt.Errorf("event %s can only be counted on CPUs %v, not for a thread; use OpenCPUCounters", event, cpus)
+			}
+		}
+	}
 
 	// Open the group leader.
 	attr := unix.PerfEventAttr{}
@@ -92,15 +210,30 @@ func OpenCounter(target Target, evs ...events.Event) (*Counter, error) {
 	if err := evs[0].SetAttrs(&attr); err != nil {
 		return nil, err
 	}
+	opts.setAttrs(&attr)
 	attr.Read_format = unix.PERF_FORMAT_TOTAL_TIME_ENABLED |
 		unix.PERF_FORMAT_TOTAL_TIME_RUNNING |
 		unix.PERF_FORMAT_GROUP
-	attr.Bits = unix.PerfBitDisabled
+	if !noFormatLost.Load() {
+		attr.Read_format |= unix.PERF_FORMAT_LOST
+	}
+	attr.Bits |= unix.PerfBitDisabled
+	// Only the group leader can be pinned, so if any event in the group is
+	// pinned, pin the leader.
+	for _, event := range evs {
+		if events.PriorityOf(event) == events.PriorityPinned {
+			attr.Bits |= unix.PerfBitPinned
+		}
+	}
 
 	// TODO: Allow setting flags that make sense.
 
 	var c Counter
 	c.target = target
+	if opts != nil {
+		c.opts = *opts
+	}
+	c.evs = evs
 	c.eventScales = eventScales
 	c.nEvents = len(evs)
 
@@ -112,7 +245,16 @@ func OpenCounter(target Target, evs ...events.Event) (*Counter, error) {
 		}
 	}()
 
-	fd, err := unix.PerfEventOpen(&attr, pid, cpu, -1, unix.PERF_FLAG_FD_CLOEXEC)
+	fd, err := perfEventOpen(&attr, pid, cpu, -1, len(evs))
+	if errors.Is(err, syscall.EINVAL) && attr.Read_format&unix.PERF_FORMAT_LOST != 0 {
+		// PERF_FORMAT_LOST was added in Linux 6.0. Try again without it, and
+		// if that works, don't bother asking for it in the future.
+		attr.Read_format &^= unix.PERF_FORMAT_LOST
+		fd, err = perfEventOpen(&attr, pid, cpu, -1, len(evs))
+		if err == nil {
+			noFormatLost.Store(true)
+		}
+	}
 	if err != nil {
 		if errors.Is(err, syscall.EACCES) {
 			const path = "/proc/sys/kernel/perf_event_paranoid"
@@ -125,74 +267,209 @@ func OpenCounter(target Target, evs ...events.Event) (*Counter, error) {
 		}
 		return nil, err
 	}
-	c.f = append(c.f, os.NewFile(uintptr(fd), "<perf-event>"))
+	c.fds = append(c.fds, fd)
+	c.lost = attr.Read_format&unix.PERF_FORMAT_LOST != 0
 	defer func() {
 		if !success {
-			for _, f := range c.f {
-				f.Close()
+			for _, fd := range c.fds {
+				sys.close(fd)
 			}
 		}
 	}()
+	if err := setFilter(fd, evs[0]); err != nil {
+		return nil, err
+	}
 
 	// Open other events.
-	for _, event := range evs[1:] {
+	for i, event := range evs[1:] {
 		attr = unix.PerfEventAttr{}
 		attr.Size = uint32(unsafe.Sizeof(attr))
 		if err := event.SetAttrs(&attr); err != nil {
 			return nil, err
 		}
+		opts.setAttrs(&attr)
+		attr.Bits &^= unix.PerfBitPinned // Pinning is controlled by the leader
 		// Note that we do *not* set PerfBitDisabled, since child events run
 		// only when both the parent and the child are enabled, and we want all
 		// control to be on the parent.
 
-		fd2, err := unix.PerfEventOpen(&attr, pid, cpu, fd, unix.PERF_FLAG_FD_CLOEXEC)
+		fd2, err := perfEventOpen(&attr, pid, cpu, fd, len(evs)-1-i)
 		if err != nil {
 			return nil, err
 		}
 
 		// I'm honestly not sure what this FD is for, but we shouldn't close it,
 		// so we hold on to it.
-		c.f = append(c.f, os.NewFile(uintptr(fd2), "<perf-event>"))
+		c.fds = append(c.fds, fd2)
+		if err := setFilter(fd2, event); err != nil {
+			return nil, err
+		}
+	}
+
+	c.leaderFD = fd
+	if len(evs) > 1 {
+		c.groupFlag = perfIOCFlagGroup
 	}
 
 	// Allocate a large enough read buffer.
-	c.readBuf = make([]byte, 3*8+len(evs)*8)
+	c.readBuf = make([]byte, 3*8+len(evs)*c.valueSize())
+	c.base = make([]Count, len(evs))
+	c.cur = make([]Count, len(evs))
+
+	c.mapUserPages()
 
 	success = true
 	return &c, nil
 }
 
+// Events returns the events counted by c, in the order of the Counts returned
+// by [Counter.ReadGroup]. The caller must not modify the returned slice.
+func (c *Counter) Events() []events.Event {
+	if c == nil {
+		return nil
+	}
+	return c.evs
+}
+
 // Close closes this counter and unlocks the goroutine from the OS thread.
 func (c *Counter) Close() {
-	if c == nil || c.f == nil {
+	if c == nil || c.fds == nil {
 		return
 	}
-	for _, f := range c.f {
-		f.Close()
+	c.unmapUserPages()
+	for _, fd := range c.fds {
+		sys.close(fd)
 	}
-	c.f = nil
+	c.fds = nil
+	c.leaderFD = -1
 	c.target.close()
 	c.target = nil
 }
 
 // Start the counter.
+//
+// For a group of events, this atomically enables all events in the group,
+// including any that were disabled by [Counter.DisableEvent].
+//
+// Start and Stop are intended to bracket small regions, so they are as cheap
+// as possible: each makes exactly one ioctl system call (or none if the
+// counter is already in the requested state) and does not allocate. Any
+// events that occur during the ioctl itself may still be counted; see
+// [Counter.EstimateOverhead].
 func (c *Counter) Start() {
 	if c == nil || c.running {
 		return
 	}
 	c.running = true
-	unix.IoctlGetInt(int(c.f[0].Fd()), unix.PERF_EVENT_IOC_ENABLE)
+	c.ioctlLeader(unix.PERF_EVENT_IOC_ENABLE)
 }
 
 // Stop the counter.
+//
+// For a group of events, this atomically disables all events in the group.
+//
+// Like [Counter.Start], this makes at most one system call and does not
+// allocate.
 func (c *Counter) Stop() {
 	if c == nil || !c.running {
 		return
 	}
-	unix.IoctlGetInt(int(c.f[0].Fd()), unix.PERF_EVENT_IOC_DISABLE)
+	c.ioctlLeader(unix.PERF_EVENT_IOC_DISABLE)
 	c.running = false
 }
 
+// ioctlLeader applies ioctl req to the group leader and, if there is more
+// than one event, the whole group.
+func (c *Counter) ioctlLeader(req uint) error {
+	return sys.ioctl(c.leaderFD, req, c.groupFlag)
+}
+
+// StopAndRead stops the counter and reads the values of all events in c, like
+// calling [Counter.Stop] followed by [Counter.ReadGroup].
+//
+// For a group of events, this disables all events with a single system call,
+// so the values are all captured at the same instant and nothing after that
+// instant is counted, including the cost of the read itself. Unlike Stop, it
+// reports errors from disabling the counter.
+func (c *Counter) StopAndRead(cs []Count) error {
+	if c == nil {
+		return nil
+	}
+	if c.fds == nil {
+		return fmt.Errorf("Counter is closed")
+	}
+	if c.running {
+		if err := c.ioctlLeader(unix.PERF_EVENT_IOC_DISABLE); err != nil {
+			return err
+		}
+		c.running = false
+	}
+	return c.ReadGroup(cs)
+}
+
+// EnableEvent enables only the i'th event of a group, where i is the index
+// of the event passed to [OpenCounter]. Most callers should use
+// [Counter.Start] instead.
+//
+// An event only counts if both it and the group's first event (the group
+// leader) are enabled, so EnableEvent(0) enables counting of every other
+// event in the group that is enabled.
+func (c *Counter) EnableEvent(i int) error {
+	return c.ioctlEvent(i, unix.PERF_EVENT_IOC_ENABLE)
+}
+
+// DisableEvent disables only the i'th event of a group, where i is the index
+// of the event passed to [OpenCounter]. The other events in the group
+// continue counting. Most callers should use [Counter.Stop] instead.
+//
+// An event only counts if both it and the group's first event (the group
+// leader) are enabled, so DisableEvent(0) stops counting of all events in
+// the group.
+func (c *Counter) DisableEvent(i int) error {
+	return c.ioctlEvent(i, unix.PERF_EVENT_IOC_DISABLE)
+}
+
+func (c *Counter) ioctlEvent(i int, req uint) error {
+	if c == nil {
+		return nil
+	}
+	if c.fds == nil {
+		return fmt.Errorf("Counter is closed")
+	}
+	if i < 0 || i >= len(c.fds) {
+		return fmt.Errorf("event index %d out of range [0, %d)", i, len(c.fds))
+	}
+	return sys.ioctl(c.fds[i], req, 0)
+}
+
+// Reset resets the values of all events in the Counter to zero. Following
+// reads report the counts and times since the reset. This does not change
+// whether or not the counter is running.
+//
+// Events that occur between a read and a following Reset are lost. Use
+// [Counter.ReadAndReset] to avoid this.
+func (c *Counter) Reset() error {
+	if c == nil {
+		return nil
+	}
+	if c.fds == nil {
+		return fmt.Errorf("Counter is closed")
+	}
+
+	// Reset the hardware counters. This resets the values, but not the times.
+	if err := c.ioctlLeader(unix.PERF_EVENT_IOC_RESET); err != nil {
+		return err
+	}
+	// Snapshot the times and lost counts.
+	if err := c.readGroupRaw(c.base); err != nil {
+		return err
+	}
+	for i := range c.base {
+		c.base[i].RawValue = 0
+	}
+	return nil
+}
+
 // Count is the value of a Counter.
 type Count struct {
 	RawValue uint64 // The number of events while this counter was running.
@@ -202,9 +479,15 @@ type Count struct {
 	// the hardware. In that case, TimeRunning < TimeEnabled, and the raw
 	// counter value should be scaled under the assumption that the event is
 	// happening at a regular rate and the sampled time is representative.
+	// See [Count.Multiplexed].
 
-	TimeEnabled uint64 // Total time the Counter was started.
-	TimeRunning uint64 // Total time the Counter was actually counting.
+	TimeEnabled uint64 // Total time in nanoseconds the Counter was started.
+	TimeRunning uint64 // Total time in nanoseconds the Counter was actually counting.
+
+	// Lost is the number of samples this event lost, for example because a
+	// sampling ring buffer was full. This is only reported by Linux 6.0 and
+	// later, and is always 0 on older kernels.
+	Lost uint64
 
 	scale scale
 }
@@ -212,6 +495,9 @@ type Count struct {
 // Value returns the measured value of Count, scaled to account for time the
 // counter was scheduled, and to account for any conversion factors in the
 // underlying event.
+//
+// Specifically, this is RawValue × [Count.Enabled] / [Count.Running] × the
+// event's scale factor, and the unit is the event's unit.
 func (c Count) Value() (float64, string) {
 	raw := float64(c.RawValue)
 	if c.TimeEnabled == c.TimeRunning && c.scale.scale == 1.0 {
@@ -225,20 +511,104 @@ func (c Count) Value() (float64, string) {
 	return raw * (float64(c.TimeEnabled) / float64(c.TimeRunning)) * c.scale.scale, c.scale.unit
 }
 
+// String returns a human-readable representation of c, such as "1234 Joules".
+// If the counter was multiplexed, this also reports the fraction of time it was
+// running, such as "1234 Joules (running 50.0%)".
+func (c Count) String() string {
+	val, unit := c.Value()
+	var s string
+	if val == math.Trunc(val) && math.Abs(val) < 1e15 {
+		s = strconv.FormatFloat(val, 'f', -1, 64)
+	} else {
+		s = strconv.FormatFloat(val, 'g', 6, 64)
+	}
+	if unit != "" {
+		s += " " + unit
+	}
+	if c.Multiplexed() {
+		s += fmt.Sprintf(" (running %.1f%%)", 100*float64(c.TimeRunning)/float64(c.TimeEnabled))
+	}
+	return s
+}
+
+// MarshalJSON encodes c as a JSON object with the computed "Value" and "Unit"
+// (see [Count.Value]), along with the raw fields of c.
+func (c Count) MarshalJSON() ([]byte, error) {
+	val, unit := c.Value()
+	return json.Marshal(struct {
+		Value       float64
+		Unit        string `json:",omitempty"`
+		RawValue    uint64
+		TimeEnabled uint64
+		TimeRunning uint64
+		Lost        uint64 `json:",omitempty"`
+	}{val, unit, c.RawValue, c.TimeEnabled, c.TimeRunning, c.Lost})
+}
+
+// Enabled returns the total time the Counter was started. This is
+// TimeEnabled as a [time.Duration].
+func (c Count) Enabled() time.Duration {
+	return time.Duration(c.TimeEnabled)
+}
+
+// Running returns the total time the Counter was actually counting. This is
+// TimeRunning as a [time.Duration].
+func (c Count) Running() time.Duration {
+	return time.Duration(c.TimeRunning)
+}
+
+// Multiplexed reports whether the event was not running for the whole time it
+// was enabled because it was multiplexed with other events onto the hardware.
+// If so, [Count.Value] is an estimate extrapolated from the time it was
+// running. This is typically fine for events that happen at a steady rate over
+// long periods, but can be misleading for short or irregular regions. Use
+// [ReadPMUUsage] to find other consumers of the hardware counters.
+func (c Count) Multiplexed() bool {
+	return c.TimeRunning < c.TimeEnabled
+}
+
+// Sub returns the difference between c and base, which must be Counts of the
+// same event. This is useful for computing the count over a region given Counts
+// read at the beginning and end of the region. The result has the same scale
+// factor and unit as c.
+func (c Count) Sub(base Count) Count {
+	c.RawValue -= base.RawValue
+	c.TimeEnabled -= base.TimeEnabled
+	c.TimeRunning -= base.TimeRunning
+	c.Lost -= base.Lost
+	return c
+}
+
+// Add returns the sum of c and o, which must be Counts of the same event. This
+// is useful for accumulating Counts over several regions. The result has the
+// same scale factor and unit as c.
+func (c Count) Add(o Count) Count {
+	c.RawValue += o.RawValue
+	c.TimeEnabled += o.TimeEnabled
+	c.TimeRunning += o.TimeRunning
+	c.Lost += o.Lost
+	return c
+}
+
+// Scale returns a Count whose [Count.Value] is f times c's Value. For example,
+// this can be used to compute a per-operation Count by scaling by 1/N. The raw
+// values of the result are the same as c.
+func (c Count) Scale(f float64) Count {
+	c.scale.scale *= f
+	return c
+}
+
 // ReadOne returns the current value of the first event in c. For counters that
 // only have a single Event, this is faster and more ergonomic than
 // [Counter.ReadGroup].
 func (c *Counter) ReadOne() (Count, error) {
-	// TODO: Use RDPMC when possible.
 	if c == nil {
 		return Count{}, nil
 	}
 
 	var cs [1]Count
-	if err := c.ReadGroup(cs[:]); err != nil {
-		return Count{}, err
-	}
-	return cs[0], nil
+	err := c.ReadGroup(cs[:])
+	return cs[0], err
 }
 
 // ReadGroup returns the current value of all events in c.
@@ -246,14 +616,67 @@ func (c *Counter) ReadGroup(cs []Count) error {
 	if c == nil {
 		return nil
 	}
-	if c.f == nil {
+	if err := c.readGroupRaw(cs); err != nil {
+		return err
+	}
+	for i := 0; i < len(cs) && i < c.nEvents; i++ {
+		cs[i] = cs[i].Sub(c.base[i])
+	}
+	if len(cs) > 0 && cs[0].Multiplexed() {
+		// All events in a group have the same times, so we only need to
+		// check one.
+		return c.multiplexed(cs)
+	}
+	return nil
+}
+
+// ReadAndReset is like [Counter.ReadGroup], but also resets the values of all
+// events in c to zero like [Counter.Reset]. Unlike calling ReadGroup followed
+// by Reset, this doesn't lose events that occur between the two operations,
+// so it's useful for periodically collecting counts from a running Counter.
+func (c *Counter) ReadAndReset(cs []Count) error {
+	if c == nil {
+		return nil
+	}
+	// Rather than resetting the hardware counters, we move the baseline up to
+	// the values we read, so the read and reset happen at the same instant.
+	if err := c.readGroupRaw(c.cur); err != nil {
+		return err
+	}
+	for i := range c.cur {
+		if i < len(cs) {
+			cs[i] = c.cur[i].Sub(c.base[i])
+		}
+		c.base[i] = c.cur[i]
+	}
+	if len(cs) > 0 && cs[0].Multiplexed() {
+		return c.multiplexed(cs)
+	}
+	return nil
+}
+
+// readGroupRaw is like ReadGroup, but returns the values without subtracting
+// the baseline.
+func (c *Counter) readGroupRaw(cs []Count) error {
+	if c.fds == nil {
 		return fmt.Errorf("Counter is closed")
 	}
 
+	if c.readUser(cs) {
+		c.lastRead = ReadPathRDPMC
+		return nil
+	}
+	c.lastRead = ReadPathSyscall
+
 	buf := c.readBuf
-	_, err := c.f[0].Read(buf)
+	n, err := sys.read(c.leaderFD, buf)
 	if err != nil {
 		return err
+	} else if n == 0 {
+		// The kernel returns EOF if a pinned group couldn't be scheduled.
+		return ErrUnschedulable
+	} else if n < len(buf) {
+		return fmt.Errorf("short read: got %d bytes, expected %d", n, len(buf))
 	}
 
 	nr := binary.NativeEndian.Uint64(buf[0:])
@@ -263,11 +686,25 @@ func (c *Counter) ReadGroup(cs []Count) error {
 
 	timeEnabled := binary.NativeEndian.Uint64(buf[8:])
 	timeRunning := binary.NativeEndian.Uint64(buf[16:])
+	stride := c.valueSize()
 	for i := 0; i < len(cs) && i < c.nEvents; i++ {
+		val := buf[24+i*stride:]
 		cs[i].TimeEnabled = timeEnabled
 		cs[i].TimeRunning = timeRunning
-		cs[i].RawValue = binary.NativeEndian.Uint64(buf[24+i*8:])
+		cs[i].RawValue = binary.NativeEndian.Uint64(val)
+		if c.lost {
+			cs[i].Lost = binary.NativeEndian.Uint64(val[8:])
+		}
 		cs[i].scale = c.eventScales[i]
 	}
 	return nil
 }
+
+// valueSize returns the size in bytes of each per-event entry in the group
+// read format.
+func (c *Counter) valueSize() int {
+	if c.lost {
+		return 16 // value, lost
+	}
+	return 8 // value
+}
@@ -7,7 +7,15 @@
 package perf
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
 	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
 
 	"github.com/aclements/go-perfevent/events"
 )
@@ -112,3 +120,571 @@ func checkCount(t *testing.T, count Count, min Count) {
 		t.Fatal("TimeRunning decreased")
 	}
 }
+
+func TestCountArith(t *testing.T) {
+	sc := scale{2, "Joules"}
+	a := Count{RawValue: 100, TimeEnabled: 40, TimeRunning: 20, Lost: 3, scale: sc}
+	b := Count{RawValue: 10, TimeEnabled: 20, TimeRunning: 10, Lost: 1, scale: sc}
+
+	d := a.Sub(b)
+	if want := (Count{RawValue: 90, TimeEnabled: 20, TimeRunning: 10, Lost: 2, scale: sc}); d != want {
+		t.Errorf("Sub: got %+v, want %+v", d, want)
+	}
+	if got := d.Add(b); got != a {
+		t.Errorf("Add: got %+v, want %+v", got, a)
+	}
+
+	val, unit := d.Value()
+	if val != 90*2*2 || unit != "Joules" {
+		t.Errorf("Value: got %v %s, want %v Joules", val, unit, 90*2*2)
+	}
+	val, unit = d.Scale(0.5).Value()
+	if val != 90*2 || unit != "Joules" {
+		t.Errorf("Scale(0.5).Value: got %v %s, want %v Joules", val, unit, 90*2)
+	}
+}
+
+func TestReset(t *testing.T) {
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Start()
+	for i := 0; i < 100000; i++ {
+	}
+	c.Stop()
+	c1, err := c.ReadOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1.RawValue == 0 || c1.TimeEnabled == 0 {
+		t.Fatalf("counter is zero after running: %+v", c1)
+	}
+
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	c2, err := c.ReadOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.RawValue != 0 || c2.TimeEnabled != 0 || c2.TimeRunning != 0 {
+		t.Fatalf("counter is non-zero after reset: %+v", c2)
+	}
+
+	c.Start()
+	c3, err := c.ReadOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCount(t, c3, c2)
+}
+
+func TestDisableEvent(t *testing.T) {
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Run the group with just the second event disabled.
+	c.Start()
+	if err := c.DisableEvent(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100000; i++ {
+	}
+	if err := c.DisableEvent(0); err != nil {
+		t.Fatal(err)
+	}
+	var cs [2]Count
+	if err := c.ReadGroup(cs[:]); err != nil {
+		t.Fatal(err)
+	}
+	if cs[0].RawValue == 0 {
+		t.Errorf("leader did not count")
+	}
+	if cs[1].RawValue != 0 {
+		t.Errorf("disabled event counted %d", cs[1].RawValue)
+	}
+	c.Stop()
+
+	// Start should re-enable the whole group.
+	c.Start()
+	for i := 0; i < 100000; i++ {
+	}
+	c.Stop()
+	if err := c.ReadGroup(cs[:]); err != nil {
+		t.Fatal(err)
+	}
+	if cs[1].RawValue == 0 {
+		t.Errorf("Start did not re-enable event")
+	}
+
+	if err := c.EnableEvent(2); err == nil {
+		t.Errorf("EnableEvent(2) succeeded on a group of 2")
+	}
+}
+
+func TestMultiplexed(t *testing.T) {
+	full := Count{RawValue: 10, TimeEnabled: 20, TimeRunning: 20}
+	partial := Count{RawValue: 10, TimeEnabled: 20, TimeRunning: 5}
+	if full.Multiplexed() {
+		t.Errorf("%+v: Multiplexed() = true, want false", full)
+	}
+	if !partial.Multiplexed() {
+		t.Errorf("%+v: Multiplexed() = false, want true", partial)
+	}
+
+	var called []Count
+	c := &Counter{opts: CounterOptions{
+		StrictMultiplexing: true,
+		OnMultiplexed:      func(cs []Count) { called = cs },
+	}}
+	err := c.multiplexed([]Count{partial})
+	if !errors.Is(err, ErrMultiplexed) {
+		t.Errorf("want ErrMultiplexed, got %v", err)
+	}
+	if len(called) != 1 || called[0] != partial {
+		t.Errorf("OnMultiplexed called with %+v, want %+v", called, partial)
+	}
+}
+
+func TestCountString(t *testing.T) {
+	for _, tc := range []struct {
+		c    Count
+		want string
+	}{
+		{Count{RawValue: 1234, TimeEnabled: 10, TimeRunning: 10, scale: scale{1, ""}}, "1234"},
+		{Count{RawValue: 4, TimeEnabled: 10, TimeRunning: 10, scale: scale{0.25, "Joules"}}, "1 Joules"},
+		{Count{RawValue: 1234, TimeEnabled: 10, TimeRunning: 5, scale: scale{1, ""}}, "2468 (running 50.0%)"},
+		{Count{RawValue: 1, TimeEnabled: 10, TimeRunning: 10, scale: scale{1.0 / 3, "%"}}, "0.333333 %"},
+	} {
+		if got := tc.c.String(); got != tc.want {
+			t.Errorf("%+v: got %q, want %q", tc.c, got, tc.want)
+		}
+	}
+}
+
+func TestCountJSON(t *testing.T) {
+	c := Count{RawValue: 4, TimeEnabled: 20, TimeRunning: 10, scale: scale{0.25, "Joules"}}
+	got, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"Value":2,"Unit":"Joules","RawValue":4,"TimeEnabled":20,"TimeRunning":10}`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestRateSince(t *testing.T) {
+	t0 := time.Unix(100, 0)
+	start := TimedCount{Count{RawValue: 1000, TimeEnabled: 1e9, TimeRunning: 1e9, scale: scale{2, "Joules"}}, t0}
+	// Over the next 2 seconds, the counter runs for 1 second and counts 500.
+	end := TimedCount{Count{RawValue: 1500, TimeEnabled: 3e9, TimeRunning: 2e9, scale: scale{2, "Joules"}}, t0.Add(2 * time.Second)}
+	r := end.RateSince(start)
+	// Extrapolated to 2 seconds enabled, the value is 500×2×2 Joules.
+	want := Rate{PerWallSecond: 1000, PerRunningSecond: 1000, Unit: "Joules"}
+	if r != want {
+		t.Errorf("got %+v, want %+v", r, want)
+	}
+
+	if r := start.RateSince(start); r.PerWallSecond != 0 || r.PerRunningSecond != 0 {
+		t.Errorf("rate over zero time: got %+v, want 0", r)
+	}
+}
+
+func TestStream(t *testing.T) {
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Start()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := c.Stream(ctx, 10*time.Millisecond)
+	var total Count
+	for i := 0; i < 3; i++ {
+		// Spin on this goroutine so the counter has something to count. Task
+		// counters don't accumulate time while the thread is blocked.
+		var iv Interval
+		var ok bool
+	spin:
+		for {
+			select {
+			case iv, ok = <-ch:
+				break spin
+			default:
+			}
+		}
+		if !ok {
+			t.Fatal("channel closed early")
+		}
+		if iv.Err != nil {
+			t.Fatal(iv.Err)
+		}
+		if len(iv.Counts) != 1 {
+			t.Fatalf("got %d counts, want 1", len(iv.Counts))
+		}
+		if !iv.End.After(iv.Start) {
+			t.Errorf("interval %d: end %v not after start %v", i, iv.End, iv.Start)
+		}
+		if iv.Counts[0].TimeEnabled == 0 {
+			t.Errorf("interval %d: counter not enabled: %+v", i, iv.Counts[0])
+		}
+		total = total.Add(iv.Counts[0])
+	}
+	cancel()
+	for range ch {
+		// Drain until Stream exits.
+	}
+
+	// The deltas should add up to no more than the total count.
+	c.Stop()
+	count, err := c.ReadOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total.RawValue > count.RawValue || total.TimeEnabled > count.TimeEnabled {
+		t.Errorf("sum of intervals %+v exceeds total %+v", total, count)
+	}
+}
+
+func TestEstimateOverhead(t *testing.T) {
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventInstructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ohs, err := c.EstimateOverhead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ohs) != 2 {
+		t.Fatalf("got %d overheads, want 2", len(ohs))
+	}
+	for i, oh := range ohs {
+		t.Logf("event %d: %+v", i, oh)
+		if oh.Min < 0 || oh.Min > oh.Mean {
+			t.Errorf("event %d: want 0 <= min <= mean, got %+v", i, oh)
+		}
+	}
+
+	// c itself should be unaffected.
+	count, err := c.ReadOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.RawValue != 0 || count.TimeEnabled != 0 {
+		t.Errorf("counter changed by EstimateOverhead: %+v", count)
+	}
+}
+
+func TestEstimateOverheadOptions(t *testing.T) {
+	k := useFakeKernel(t)
+	opts := LowOverheadCounting
+	opts.StrictMultiplexing = true
+	opts.OnMultiplexed = func([]Count) { t.Errorf("OnMultiplexed called for overhead counter") }
+	c, err := opts.OpenCounter(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.EstimateOverhead(); err != nil {
+		t.Fatal(err)
+	}
+	// The overhead counter measures the same configuration as c.
+	n := 0
+	for fd, ev := range k.events {
+		if fd == c.fds[0] {
+			continue
+		}
+		n++
+		if ev.attr.Bits&unix.PerfBitExcludeKernel == 0 {
+			t.Errorf("overhead counter counts the kernel, but c doesn't")
+		}
+	}
+	if n == 0 {
+		t.Errorf("no overhead counter opened")
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	ran := false
+	cs, err := Measure(TargetThisGoroutine, []events.Event{events.EventCPUCycles, events.EventInstructions}, func() {
+		ran = true
+		for i := 0; i < 1000; i++ {
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ran {
+		t.Fatal("f not called")
+	}
+	if len(cs) != 2 {
+		t.Fatalf("got %d counts, want 2", len(cs))
+	}
+	if cs[0].RawValue == 0 || cs[0].TimeEnabled == 0 {
+		t.Errorf("cycles not counted: %+v", cs[0])
+	}
+}
+
+func TestReadAndReset(t *testing.T) {
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Start()
+	for i := 0; i < 100000; i++ {
+	}
+	c.Stop()
+	var cs [2]Count
+	if err := c.ReadAndReset(cs[:]); err != nil {
+		t.Fatal(err)
+	}
+	if cs[0].RawValue == 0 || cs[0].TimeEnabled == 0 {
+		t.Fatalf("counter is zero after running: %+v", cs[0])
+	}
+	if err := c.ReadGroup(cs[:]); err != nil {
+		t.Fatal(err)
+	}
+	for i, count := range cs {
+		if count.RawValue != 0 || count.TimeEnabled != 0 || count.TimeRunning != 0 {
+			t.Fatalf("event %d is non-zero after ReadAndReset: %+v", i, count)
+		}
+	}
+
+	// While running, successive ReadAndResets should partition the counts.
+	c.Start()
+	var total [2]Count
+	for i := 0; i < 3; i++ {
+		for i := 0; i < 100000; i++ {
+		}
+		if err := c.ReadAndReset(cs[:]); err != nil {
+			t.Fatal(err)
+		}
+		for j := range cs {
+			total[j] = total[j].Add(cs[j])
+		}
+	}
+	c.Stop()
+	if err := c.ReadGroup(cs[:]); err != nil {
+		t.Fatal(err)
+	}
+	// Only the tail after the last ReadAndReset should remain, and that
+	// should be much less than the total.
+	if cs[0].RawValue >= total[0].RawValue {
+		t.Errorf("remaining count %d not less than total %d", cs[0].RawValue, total[0].RawValue)
+	}
+}
+
+func TestStopAndRead(t *testing.T) {
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventInstructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Start()
+	for i := 0; i < 100000; i++ {
+	}
+	var cs1, cs2 [2]Count
+	if err := c.StopAndRead(cs1[:]); err != nil {
+		t.Fatal(err)
+	}
+	if cs1[0].RawValue == 0 {
+		t.Fatalf("counter is zero after running: %+v", cs1[0])
+	}
+	for i := 0; i < 100000; i++ {
+	}
+	// The counter should be stopped.
+	if err := c.ReadGroup(cs2[:]); err != nil {
+		t.Fatal(err)
+	}
+	if cs1 != cs2 {
+		t.Errorf("counter changed after StopAndRead: %+v != %+v", cs1, cs2)
+	}
+
+	// StopAndRead on a stopped counter just reads it.
+	if err := c.StopAndRead(cs2[:]); err != nil {
+		t.Fatal(err)
+	}
+	if cs1 != cs2 {
+		t.Errorf("counter changed after StopAndRead: %+v != %+v", cs1, cs2)
+	}
+}
+
+func TestStartStopAllocs(t *testing.T) {
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventInstructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		c.Start()
+		c.Stop()
+	})
+	if allocs != 0 {
+		t.Errorf("Start/Stop allocated %v times, want 0", allocs)
+	}
+}
+
+func BenchmarkStartStop(b *testing.B) {
+	for _, n := range []int{1, 2} {
+		b.Run(fmt.Sprintf("events=%d", n), func(b *testing.B) {
+			evs := []events.Event{events.EventCPUCycles, events.EventInstructions}[:n]
+			c, err := OpenCounter(TargetThisGoroutine, evs...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer c.Close()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c.Start()
+				c.Stop()
+			}
+		})
+	}
+}
+
+func TestLowOverheadCounting(t *testing.T) {
+	attr := unix.PerfEventAttr{
+		Sample: 1000,
+		Bits:   unix.PerfBitFreq | unix.PerfBitExcludeUser,
+	}
+	LowOverheadCounting.setAttrs(&attr)
+	if attr.Sample != 0 || attr.Bits&unix.PerfBitFreq != 0 {
+		t.Errorf("sampling not disabled: sample %d, bits %#x", attr.Sample, attr.Bits)
+	}
+	if want := uint64(unix.PerfBitExcludeKernel | unix.PerfBitExcludeHv); attr.Bits != want {
+		t.Errorf("bits are %#x, want %#x", attr.Bits, want)
+	}
+
+	// Check that the options survive opening a counter, even when the
+	// event asks to count only the kernel.
+	ev := events.WithPrivilege(events.EventCPUCycles, events.PrivKernel)
+	c, err := LowOverheadCounting.OpenCounter(TargetThisGoroutine, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Start()
+	for i := 0; i < 100000; i++ {
+	}
+	c.Stop()
+	count, err := c.ReadOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.RawValue == 0 {
+		t.Errorf("no user cycles counted")
+	}
+}
+
+// BenchmarkContextSwitch measures the added cost of context switches of a
+// thread with a counter open. Each iteration is a round trip between two
+// threads, so it includes two context switches of the monitored thread.
+func BenchmarkContextSwitch(b *testing.B) {
+	for _, tc := range []struct {
+		name string
+		opts *CounterOptions
+		evs  []events.Event
+	}{
+		{"none", nil, nil},
+		{"software", nil, []events.Event{events.EventTaskClock}},
+		{"hardware", nil, []events.Event{events.EventCPUCycles, events.EventInstructions}},
+		{"lowoverhead", &LowOverheadCounting, []events.Event{events.EventCPUCycles, events.EventInstructions}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			var ping, pong [2]int
+			if err := unix.Pipe(ping[:]); err != nil {
+				b.Fatal(err)
+			}
+			defer unix.Close(ping[0])
+			if err := unix.Pipe(pong[:]); err != nil {
+				b.Fatal(err)
+			}
+			defer unix.Close(pong[0])
+			defer unix.Close(pong[1])
+
+			// Echo bytes from ping to pong on another thread until ping
+			// is closed. We use blocking system calls rather than os.File
+			// so each wakeup is an OS context switch.
+			done := make(chan struct{})
+			go func() {
+				runtime.LockOSThread()
+				defer close(done)
+				var buf [1]byte
+				for {
+					if n, _ := unix.Read(ping[0], buf[:]); n <= 0 {
+						return
+					}
+					unix.Write(pong[1], buf[:])
+				}
+			}()
+			defer func() {
+				unix.Close(ping[1])
+				<-done
+			}()
+
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			if tc.evs != nil {
+				c, err := tc.opts.OpenCounter(TargetThisGoroutine, tc.evs...)
+				if err != nil {
+					b.Skip(err)
+				}
+				defer c.Close()
+				c.Start()
+			}
+
+			var buf [1]byte
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				unix.Write(ping[1], buf[:])
+				unix.Read(pong[0], buf[:])
+			}
+		})
+	}
+}
+
+func TestPinned(t *testing.T) {
+	// Pinning any event in a group pins the group.
+	pinned := events.WithPriority(events.EventInstructions, events.PriorityPinned)
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, pinned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Start()
+	for i := 0; i < 100000; i++ {
+	}
+	c.Stop()
+	cs := make([]Count, 2)
+	if err := c.ReadGroup(cs); errors.Is(err, ErrUnschedulable) {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	for i, count := range cs {
+		if count.Multiplexed() {
+			t.Errorf("event %d of pinned group was multiplexed: %v", i, count)
+		}
+		if count.RawValue == 0 {
+			t.Errorf("event %d of pinned group was not counted", i)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// A DataWriter writes records to a perf.data file, which can be read by
+// "perf report", "perf script", and other perf tools.
+//
+// A perf.data file describes the events it contains with their perf event
+// attributes, so the records must come from the Samplers passed to
+// [NewDataWriter]. If there is more than one Sampler, their sample types must
+// include SampleIdentifier, which perf uses to tell which event each record
+// came from. Only the attributes of the sampled events are written, not of
+// other events in their groups.
+//
+// perf needs side-band records to attribute samples to binaries and threads,
+// so the Samplers should be opened with [SamplerOptions.SideBand]. Samplers
+// monitoring a thread describe the thread as it was when they started, but
+// Samplers monitoring a CPU don't, so use [DataWriter.WriteProcessInfo] to
+// describe processes that were already running.
+type DataWriter struct {
+	w      io.WriteSeeker
+	bw     *bufio.Writer
+	format SampleFormat // Format of the first Sampler
+	id     uint64       // ID of the first Sampler
+
+	header    dataHeader
+	dataStart int64
+	dataSize  int64
+	err       error
+}
+
+// dataHeader is perf's struct perf_file_header.
+type dataHeader struct {
+	Magic      uint64
+	Size       uint64
+	AttrSize   uint64
+	Attrs      dataSection
+	Data       dataSection
+	EventTypes dataSection
+	Features   [4]uint64 // Bitmap of HEADER_* feature sections
+}
+
+// dataSection is perf's struct perf_file_section.
+type dataSection struct {
+	Offset, Size uint64
+}
+
+// dataMagic is the magic number of a perf.data file, which is "PERFILE2" in
+// the byte order of the file.
+const dataMagic = 0x32454c4946524550
+
+// NewDataWriter writes the header of a perf.data file describing the events
+// of samplers to the start of w, and returns a DataWriter for writing records
+// to it. The caller must call [DataWriter.Close] to finish the file. The
+// Samplers can be closed once NewDataWriter returns.
+func NewDataWriter(w io.WriteSeeker, samplers ...*Sampler) (*DataWriter, error) {
+	if len(samplers) == 0 {
+		return nil, fmt.Errorf("no Samplers")
+	}
+	ids := make([]uint64, len(samplers))
+	for i, s := range samplers {
+		id, err := s.ID()
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+		if len(samplers) > 1 && s.format.SampleType&SampleIdentifier == 0 {
+			return nil, fmt.Errorf("writing records of multiple Samplers requires SampleIdentifier")
+		}
+	}
+
+	// Offsets in the file are from the start of the file.
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	dw := &DataWriter{w: w, bw: bufio.NewWriter(w), format: samplers[0].format, id: ids[0]}
+	attrSize := uint64(samplers[0].attr.Size)
+	dw.header = dataHeader{
+		Magic:    dataMagic,
+		Size:     uint64(unsafe.Sizeof(dataHeader{})),
+		AttrSize: attrSize + uint64(unsafe.Sizeof(dataSection{})),
+	}
+
+	// Lay out the file: the header, the ID arrays, the attributes, and
+	// then the data. Each attribute is followed by the section of its IDs.
+	off := dw.header.Size
+	idsOff := off
+	off += uint64(8 * len(ids))
+	dw.header.Attrs = dataSection{off, dw.header.AttrSize * uint64(len(samplers))}
+	off += dw.header.Attrs.Size
+	dw.dataStart = int64(off)
+
+	dw.write(&dw.header)
+	for _, id := range ids {
+		dw.write(id)
+	}
+	for i, s := range samplers {
+		attr := s.attr
+		dw.write(unsafe.Slice((*byte)(unsafe.Pointer(&attr)), attrSize))
+		dw.write(dataSection{idsOff + uint64(8*i), 8})
+	}
+	if dw.err != nil {
+		return nil, dw.err
+	}
+	return dw, nil
+}
+
+func (w *DataWriter) write(data any) {
+	if w.err == nil {
+		w.err = binary.Write(w.bw, binary.NativeEndian, data)
+	}
+}
+
+// WriteRecord writes rec to the data section of the file.
+func (w *DataWriter) WriteRecord(rec RawRecord) error {
+	size := 8 + len(rec.Data)
+	if size > 0xffff {
+		return fmt.Errorf("%s record of %d bytes is too large", rec.Type, size)
+	}
+	w.write(uint32(rec.Type))
+	w.write(rec.Misc)
+	w.write(uint16(size))
+	if w.err == nil {
+		_, w.err = w.bw.Write(rec.Data)
+	}
+	w.dataSize += int64(size)
+	return w.err
+}
+
+// CopyRecords reads all of the records available in s's ring buffer and
+// writes them to the file. It returns the number of records written.
+func (w *DataWriter) CopyRecords(s *Sampler) (int, error) {
+	n := 0
+	for {
+		rec, ok := s.ReadRecord()
+		if !ok {
+			return n, nil
+		}
+		if err := w.WriteRecord(rec); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// WriteProcessInfo writes synthetic [RecordComm] records for the threads of
+// info and [RecordMmap2] records for its executable mappings, like
+// "perf record" does for processes that were already running. This lets perf
+// tools symbolize samples from those mappings.
+func (w *DataWriter) WriteProcessInfo(info *ProcessInfo) error {
+	for _, rec := range processInfoRecords(info, w.id, w.format) {
+		if err := w.WriteRecord(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendCString appends s to buf with a NUL terminator, padded to a multiple
+// of 8 bytes, as in the string fields of records.
+func appendCString(buf []byte, s string) []byte {
+	buf = append(buf, s...)
+	buf = append(buf, 0)
+	for len(buf)%8 != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// Close finishes the file by writing the size of its data section to the
+// header. It doesn't close the underlying writer.
+func (w *DataWriter) Close() error {
+	if w.err == nil {
+		w.err = w.bw.Flush()
+	}
+	if w.err != nil {
+		return w.err
+	}
+	w.header.Data = dataSection{uint64(w.dataStart), uint64(w.dataSize)}
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Write(w.w, binary.NativeEndian, &w.header); err != nil {
+		return err
+	}
+	_, err := w.w.Seek(w.dataStart+w.dataSize, io.SeekStart)
+	return err
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestDataWriter(t *testing.T) {
+	k := useFakeKernel(t)
+	opts := SamplerOptions{SampleType: SampleIP | SampleTID | SampleTime}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ev := k.events[s.fd]
+	for i := 0; i < 3; i++ {
+		var data []byte
+		data = binary.NativeEndian.AppendUint64(data, 0x1000+uint64(i))
+		data = binary.NativeEndian.AppendUint32(data, 10)
+		data = binary.NativeEndian.AppendUint32(data, 11)
+		data = binary.NativeEndian.AppendUint64(data, uint64(i))
+		ev.writeRecord(RecordSample, unix.PERF_RECORD_MISC_USER, data)
+	}
+
+	path := filepath.Join(t.TempDir(), "perf.data")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := NewDataWriter(f, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &ProcessInfo{
+		PID:     10,
+		Threads: []ThreadInfo{{TID: 10, Comm: "main"}, {TID: 11, Comm: "worker"}},
+		Maps: []Mapping{
+			{Start: 0x400000, End: 0x401000, Perm: "r-xp", Inode: 5, Path: "/bin/true"},
+			{Start: 0x600000, End: 0x601000, Perm: "rw-p", Path: "/bin/true"},
+		},
+	}
+	if err := w.WriteProcessInfo(info); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := w.CopyRecords(s); err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Errorf("copied %d records, want 3", n)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h dataHeader
+	if err := binary.Read(bytes.NewReader(data), binary.NativeEndian, &h); err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:8]) != "PERFILE2" {
+		t.Errorf("got magic %q, want PERFILE2", data[:8])
+	}
+	if h.Size != 104 || h.AttrSize != uint64(s.attr.Size)+16 || h.Attrs.Size != h.AttrSize {
+		t.Errorf("bad header sizes: %+v", h)
+	}
+	if h.Data.Offset+h.Data.Size != uint64(len(data)) {
+		t.Errorf("data section %+v doesn't end at end of %d byte file", h.Data, len(data))
+	}
+
+	// Check the attribute and its IDs.
+	attrBytes := data[h.Attrs.Offset : h.Attrs.Offset+uint64(s.attr.Size)]
+	if want := unsafe.Slice((*byte)(unsafe.Pointer(&s.attr)), s.attr.Size); !bytes.Equal(attrBytes, want) {
+		t.Errorf("attribute doesn't match Sampler's attribute")
+	}
+	var ids dataSection
+	binary.Read(bytes.NewReader(data[h.Attrs.Offset+uint64(s.attr.Size):]), binary.NativeEndian, &ids)
+	if ids.Size != 8 || binary.NativeEndian.Uint64(data[ids.Offset:]) != uint64(s.fd) {
+		t.Errorf("got IDs %+v, want one ID %d", ids, s.fd)
+	}
+
+	// Check the records.
+	var types []RecordType
+	var comms []string
+	var ips []uint64
+	recs := data[h.Data.Offset:]
+	for len(recs) > 0 {
+		typ := RecordType(binary.NativeEndian.Uint32(recs))
+		misc := binary.NativeEndian.Uint16(recs[4:])
+		size := binary.NativeEndian.Uint16(recs[6:])
+		rec := RawRecord{typ, misc, recs[8:size]}
+		recs = recs[size:]
+		types = append(types, typ)
+		switch typ {
+		case RecordSample:
+			var smpl Sample
+			if err := DecodeSample(rec, s.SampleFormat(), &smpl); err != nil {
+				t.Fatal(err)
+			}
+			ips = append(ips, smpl.IP)
+		default:
+			r, err := DecodeSideBand(rec, s.SampleFormat())
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch r := r.(type) {
+			case *CommRecord:
+				comms = append(comms, r.Comm)
+				if r.PID != 10 || r.RecordID.TID != r.TID {
+					t.Errorf("bad COMM record %+v", r)
+				}
+			case *MmapRecord:
+				want := &MmapRecord{PID: 10, TID: 10, Addr: 0x400000, Len: 0x1000, Ino: 5, Prot: unix.PROT_READ | unix.PROT_EXEC, Flags: unix.MAP_PRIVATE, Filename: "/bin/true", RecordID: RecordID{PID: 10, TID: 10}, typ: RecordMmap2}
+				if !reflect.DeepEqual(r, want) {
+					t.Errorf("got %+v, want %+v", r, want)
+				}
+			}
+		}
+	}
+	if want := []RecordType{RecordComm, RecordComm, RecordMmap2, RecordSample, RecordSample, RecordSample}; !reflect.DeepEqual(types, want) {
+		t.Errorf("got records %v, want %v", types, want)
+	}
+	if want := []string{"main", "worker"}; !reflect.DeepEqual(comms, want) {
+		t.Errorf("got comms %q, want %q", comms, want)
+	}
+	if want := []uint64{0x1000, 0x1001, 0x1002}; !reflect.DeepEqual(ips, want) {
+		t.Errorf("got IPs %#x, want %#x", ips, want)
+	}
+}
+
+func TestDataWriterIdentifier(t *testing.T) {
+	useFakeKernel(t)
+	s1, err := OpenSampler(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s1.Close()
+	s2, err := OpenSampler(TargetThisGoroutine, events.EventInstructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "perf.data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := NewDataWriter(f, s1, s2); err == nil {
+		t.Errorf("writing multiple Samplers without SampleIdentifier: want error")
+	}
+}
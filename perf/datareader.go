@@ -0,0 +1,271 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// A DataReader reads records from a perf.data file, such as one written by
+// "perf record" or [DataWriter].
+//
+// It only supports perf.data files in the native byte order, and doesn't
+// support the pipe-mode files "perf record -o -" writes or compressed
+// records.
+type DataReader struct {
+	// Events are the events recorded in the file.
+	Events []*DataEvent
+
+	byID map[uint64]*DataEvent
+
+	// sampleIDPos and otherIDPos are the positions of the event ID in
+	// the records of every event (see idPos), or -1 if they differ.
+	sampleIDPos, otherIDPos int
+
+	data *bufio.Reader
+	buf  []byte
+}
+
+// A DataEvent is an event recorded in a perf.data file.
+type DataEvent struct {
+	// Attr is the perf event attributes the event was opened with.
+	Attr unix.PerfEventAttr
+
+	// IDs are the kernel IDs of the event, which identify its records.
+	// perf opens an event on each CPU or thread, so an event can have
+	// several IDs.
+	IDs []uint64
+
+	// Format is the format of the event's records, for use with
+	// [DecodeSample] and [DecodeSideBand]. Since perf.data files don't
+	// record event scales, the counts of samples are unscaled.
+	Format SampleFormat
+}
+
+var errDataCorrupt = errors.New("corrupt perf.data file")
+
+// perfRecordUserTypeStart is perf's PERF_RECORD_USER_TYPE_START. perf uses
+// record types from this on for records it synthesizes, which have no ID.
+const perfRecordUserTypeStart = 64
+
+// maxDataAttrSize bounds the size of each attribute in a perf.data file. The
+// kernel limits perf_event_attr to a page, which is at most 64 KiB, and each
+// attribute in the file is a perf_event_attr followed by a section.
+const maxDataAttrSize = 64 << 10
+
+// NewDataReader reads the header of the perf.data file r and returns a
+// DataReader for reading its records.
+func NewDataReader(r io.ReaderAt) (*DataReader, error) {
+	var h dataHeader
+	if err := binary.Read(io.NewSectionReader(r, 0, int64(unsafe.Sizeof(h))), binary.NativeEndian, &h); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errDataCorrupt
+		}
+		return nil, err
+	}
+	switch {
+	case h.Magic == bits.ReverseBytes64(dataMagic):
+		return nil, fmt.Errorf("perf.data file has the wrong byte order")
+	case h.Magic != dataMagic:
+		return nil, fmt.Errorf("not a perf.data file")
+	case h.Size == 16:
+		// Pipe-mode files have only the magic and size.
+		return nil, fmt.Errorf("pipe-mode perf.data files are not supported")
+	case h.Size != uint64(unsafe.Sizeof(h)):
+		return nil, fmt.Errorf("unsupported perf.data format: header is %d bytes", h.Size)
+	case h.AttrSize <= uint64(unsafe.Sizeof(dataSection{})) || h.AttrSize > maxDataAttrSize:
+		return nil, fmt.Errorf("%w: attribute size %d out of range", errDataCorrupt, h.AttrSize)
+	case h.Attrs.Size < h.AttrSize || h.Attrs.Size%h.AttrSize != 0 || h.Attrs.Offset+h.Attrs.Size < h.Attrs.Offset:
+		return nil, fmt.Errorf("%w: attribute section of %d bytes", errDataCorrupt, h.Attrs.Size)
+	}
+
+	dr := &DataReader{byID: make(map[uint64]*DataEvent)}
+	buf := make([]byte, h.AttrSize)
+	for off := h.Attrs.Offset; off < h.Attrs.Offset+h.Attrs.Size; off += h.AttrSize {
+		if _, err := r.ReadAt(buf, int64(off)); err != nil {
+			return nil, err
+		}
+		ev := new(DataEvent)
+		// The attribute may be larger or smaller than ours, depending on
+		// the version of perf that wrote it. Ignore any fields we don't
+		// know about.
+		attrBytes := buf[:len(buf)-int(unsafe.Sizeof(dataSection{}))]
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(&ev.Attr)), unsafe.Sizeof(ev.Attr)), attrBytes)
+		ids := dataSection{
+			Offset: binary.NativeEndian.Uint64(buf[len(attrBytes):]),
+			Size:   binary.NativeEndian.Uint64(buf[len(attrBytes)+8:]),
+		}
+		if ids.Size%8 != 0 || ids.Size > 1<<30 {
+			return nil, errDataCorrupt
+		}
+		idBuf := make([]byte, ids.Size)
+		if _, err := r.ReadAt(idBuf, int64(ids.Offset)); err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(idBuf); i += 8 {
+			id := binary.NativeEndian.Uint64(idBuf[i:])
+			ev.IDs = append(ev.IDs, id)
+			dr.byID[id] = ev
+		}
+		ev.Format = SampleFormat{
+			SampleType:       SampleTypeFlags(ev.Attr.Sample_type),
+			BranchSampleType: BranchSampleFlags(ev.Attr.Branch_sample_type),
+			ReadFormat:       ReadFormatFlags(ev.Attr.Read_format),
+			RegsUser:         RegMask(ev.Attr.Sample_regs_user),
+			SampleIDAll:      ev.Attr.Bits&unix.PerfBitSampleIDAll != 0,
+		}
+		sampleIDPos, otherIDPos := idPos(ev.Format.SampleType)
+		if len(dr.Events) == 0 {
+			dr.sampleIDPos, dr.otherIDPos = sampleIDPos, otherIDPos
+		} else if sampleIDPos != dr.sampleIDPos || otherIDPos != dr.otherIDPos {
+			dr.sampleIDPos, dr.otherIDPos = -1, -1
+		}
+		dr.Events = append(dr.Events, ev)
+	}
+	if len(dr.Events) == 0 {
+		return nil, fmt.Errorf("perf.data file has no events")
+	}
+
+	dr.data = bufio.NewReader(io.NewSectionReader(r, int64(h.Data.Offset), int64(h.Data.Size)))
+	return dr, nil
+}
+
+// ReadRecord returns the next record from the file, or io.EOF if there are no
+// more records.
+//
+// The returned record's Data is only valid until the next call to
+// ReadRecord.
+func (r *DataReader) ReadRecord() (RawRecord, error) {
+	var hdr [perfEventHeaderSize]byte
+	if _, err := io.ReadFull(r.data, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errDataCorrupt
+		}
+		return RawRecord{}, err
+	}
+	rec := RawRecord{
+		Type: RecordType(binary.NativeEndian.Uint32(hdr[0:])),
+		Misc: binary.NativeEndian.Uint16(hdr[4:]),
+	}
+	size := int(binary.NativeEndian.Uint16(hdr[6:]))
+	if size < perfEventHeaderSize {
+		return RawRecord{}, errDataCorrupt
+	}
+	if cap(r.buf) < size-perfEventHeaderSize {
+		r.buf = make([]byte, 0xffff)
+	}
+	rec.Data = r.buf[:size-perfEventHeaderSize]
+	if _, err := io.ReadFull(r.data, rec.Data); err != nil {
+		return RawRecord{}, errDataCorrupt
+	}
+	return rec, nil
+}
+
+// Event returns the event that produced rec. Like perf, if the file has more
+// than one event, it identifies the event from the ID in rec, which requires
+// the ID to be in the same place in the records of every event. Records
+// without an ID are attributed to the first event.
+func (r *DataReader) Event(rec RawRecord) (*DataEvent, error) {
+	first := r.Events[0]
+	if len(r.Events) == 1 || (rec.Type != RecordSample && !first.Format.SampleIDAll) || rec.Type >= perfRecordUserTypeStart {
+		return first, nil
+	}
+	var id uint64
+	if rec.Type == RecordSample {
+		if r.sampleIDPos < 0 || len(rec.Data) < 8*(r.sampleIDPos+1) {
+			return nil, fmt.Errorf("cannot identify event of %s record", rec.Type)
+		}
+		id = binary.NativeEndian.Uint64(rec.Data[8*r.sampleIDPos:])
+	} else {
+		if r.otherIDPos < 0 || len(rec.Data) < 8*r.otherIDPos {
+			return nil, fmt.Errorf("cannot identify event of %s record", rec.Type)
+		}
+		id = binary.NativeEndian.Uint64(rec.Data[len(rec.Data)-8*r.otherIDPos:])
+	}
+	if id == 0 {
+		return first, nil
+	}
+	ev, ok := r.byID[id]
+	if !ok {
+		return nil, fmt.Errorf("%s record has unknown event ID %d", rec.Type, id)
+	}
+	return ev, nil
+}
+
+// idPos returns the position of the event ID in sample records of sample type
+// st, in u64s from the start, and in other records, in u64s from the end. It
+// returns -1, -1 if st doesn't include the ID.
+func idPos(st SampleTypeFlags) (sample, other int) {
+	if st&SampleIdentifier != 0 {
+		return 0, 1
+	}
+	if st&SampleID == 0 {
+		return -1, -1
+	}
+	sample = bits.OnesCount64(uint64(st & (SampleIP | SampleTID | SampleTime | SampleAddr)))
+	other = 1 + bits.OnesCount64(uint64(st&(SampleStreamID|SampleCPU)))
+	return sample, other
+}
+
+// ReadSamples calls f with each sample in the file, in order, until f returns
+// false. Records other than samples are skipped.
+//
+// f must not retain s or any of its slices after returning; they are reused
+// for the next sample.
+func (r *DataReader) ReadSamples(f func(s *Sample) bool) error {
+	return r.ReadSamplesAndSideBand(f, func(SideBandRecord) bool { return true })
+}
+
+// ReadSamplesAndSideBand is like [DataReader.ReadSamples], but also decodes
+// side-band records and calls sideBand with each of them, in the order they
+// appear in the file. It stops early if either callback returns false.
+//
+// sideBand may retain its argument.
+func (r *DataReader) ReadSamplesAndSideBand(sample func(s *Sample) bool, sideBand func(r SideBandRecord) bool) error {
+	var smpl Sample
+	for {
+		rec, err := r.ReadRecord()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch rec.Type {
+		case RecordSample:
+			ev, err := r.Event(rec)
+			if err != nil {
+				return err
+			}
+			if err := DecodeSample(rec, ev.Format, &smpl); err != nil {
+				return err
+			}
+			if !sample(&smpl) {
+				return nil
+			}
+		case RecordMmap, RecordMmap2, RecordComm, RecordFork, RecordExit, RecordSwitch, RecordSwitchCPUWide:
+			ev, err := r.Event(rec)
+			if err != nil {
+				return err
+			}
+			sb, err := DecodeSideBand(rec, ev.Format)
+			if err != nil {
+				return err
+			}
+			if !sideBand(sb) {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestDataReader(t *testing.T) {
+	// Write a perf.data file with two events and read it back.
+	k := useFakeKernel(t)
+	opts := SamplerOptions{SampleType: SampleIdentifier | SampleIP | SampleTID}
+	var samplers []*Sampler
+	for _, ev := range []events.Event{events.EventCPUCycles, events.EventInstructions} {
+		s, err := opts.OpenSampler(TargetThisGoroutine, ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		samplers = append(samplers, s)
+	}
+	for i, s := range samplers {
+		var data []byte
+		data = binary.NativeEndian.AppendUint64(data, uint64(s.fd))
+		data = binary.NativeEndian.AppendUint64(data, 0x1000+uint64(i))
+		data = binary.NativeEndian.AppendUint32(data, 10)
+		data = binary.NativeEndian.AppendUint32(data, 11)
+		k.events[s.fd].writeRecord(RecordSample, unix.PERF_RECORD_MISC_USER, data)
+	}
+
+	path := filepath.Join(t.TempDir(), "perf.data")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := NewDataWriter(f, samplers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &ProcessInfo{PID: 10, Threads: []ThreadInfo{{TID: 11, Comm: "worker"}}}
+	if err := w.WriteProcessInfo(info); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range samplers {
+		if _, err := w.CopyRecords(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A PERF_RECORD_FINISHED_ROUND record, which perf synthesizes and
+	// has no ID.
+	if err := w.WriteRecord(RawRecord{Type: 68}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewDataReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Events) != 2 {
+		t.Fatalf("got %d events, want 2", len(r.Events))
+	}
+	for i, ev := range r.Events {
+		s := samplers[i]
+		if ev.Attr != s.attr {
+			t.Errorf("event %d: got attr %+v, want %+v", i, ev.Attr, s.attr)
+		}
+		if len(ev.IDs) != 1 || ev.IDs[0] != uint64(s.fd) {
+			t.Errorf("event %d: got IDs %v, want [%d]", i, ev.IDs, s.fd)
+		}
+		if ev.Format.SampleType != s.format.SampleType || !ev.Format.SampleIDAll {
+			t.Errorf("event %d: got format %+v, want %+v", i, ev.Format, s.format)
+		}
+	}
+
+	// Check each record is attributed to the right event.
+	var got []string
+	for {
+		rec, err := r.ReadRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		ev, err := r.Event(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, rec.Type.String()+":"+AttrString(&ev.Attr))
+	}
+	want := "COMM:cpu-cycles SAMPLE:cpu-cycles SAMPLE:instructions RecordType(68):cpu-cycles"
+	if strings.Join(got, " ") != want {
+		t.Errorf("got records %s, want %s", strings.Join(got, " "), want)
+	}
+
+	// Read the file again as samples and side-band records.
+	r, err = NewDataReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ips []uint64
+	var comms []string
+	err = r.ReadSamplesAndSideBand(func(s *Sample) bool {
+		ips = append(ips, s.IP)
+		return true
+	}, func(sb SideBandRecord) bool {
+		if c, ok := sb.(*CommRecord); ok {
+			comms = append(comms, c.Comm)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 || ips[0] != 0x1000 || ips[1] != 0x1001 {
+		t.Errorf("got sample IPs %#x, want [0x1000 0x1001]", ips)
+	}
+	if len(comms) != 1 || comms[0] != "worker" {
+		t.Errorf("got comms %q, want [worker]", comms)
+	}
+}
+
+func TestDataReaderInvalid(t *testing.T) {
+	encodeHeader := func(h dataHeader) []byte {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.NativeEndian, &h)
+		return buf.Bytes()
+	}
+	header := func(magic, size uint64) []byte {
+		return encodeHeader(dataHeader{Magic: magic, Size: size})
+	}
+	swapped := binary.BigEndian.Uint64(binary.NativeEndian.AppendUint64(nil, dataMagic))
+	for _, test := range []struct {
+		data []byte
+		want string
+	}{
+		{[]byte("PERFILE2"), "corrupt perf.data file"},
+		{header(0x1234, 104), "not a perf.data file"},
+		{header(swapped, 104), "perf.data file has the wrong byte order"},
+		{header(dataMagic, 16), "pipe-mode perf.data files are not supported"},
+		{header(dataMagic, 104), "corrupt perf.data file: attribute size 0 out of range"},
+		// A huge attribute size must not be allocated.
+		{encodeHeader(dataHeader{Magic: dataMagic, Size: 104, AttrSize: 1 << 62, Attrs: dataSection{104, 1 << 62}}),
+			"corrupt perf.data file: attribute size 4611686018427387904 out of range"},
+		{encodeHeader(dataHeader{Magic: dataMagic, Size: 104, AttrSize: 136}),
+			"corrupt perf.data file: attribute section of 0 bytes"},
+		{encodeHeader(dataHeader{Magic: dataMagic, Size: 104, AttrSize: 136, Attrs: dataSection{104, 200}}),
+			"corrupt perf.data file: attribute section of 200 bytes"},
+	} {
+		_, err := NewDataReader(bytes.NewReader(test.data))
+		if err == nil || err.Error() != test.want {
+			t.Errorf("got error %v, want %s", err, test.want)
+		}
+	}
+}
+
+func TestIDPos(t *testing.T) {
+	for _, test := range []struct {
+		st                    SampleTypeFlags
+		wantSample, wantOther int
+	}{
+		{SampleIP | SampleTID, -1, -1},
+		{SampleIdentifier | SampleIP | SampleID | SampleCPU, 0, 1},
+		{SampleIP | SampleTime | SampleID, 2, 1},
+		{SampleTID | SampleAddr | SampleID | SampleStreamID | SampleCPU | SamplePeriod, 2, 3},
+	} {
+		sample, other := idPos(test.st)
+		if sample != test.wantSample || other != test.wantOther {
+			t.Errorf("idPos(%s) = %d, %d, want %d, %d", test.st, sample, other, test.wantSample, test.wantOther)
+		}
+	}
+}
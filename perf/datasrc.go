@@ -0,0 +1,240 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// A DataSrc describes the memory access that caused a sample
+// (SampleDataSrc): the type of access, where in the memory hierarchy the data
+// was found, and how the address was translated. This is the union
+// perf_mem_data_src.
+//
+// Only memory access events fill in a DataSrc, and these are specific to the
+// CPU. For example, Intel's "cpu/mem-loads,ldlat=30/" and "cpu/mem-stores/"
+// and AMD's "ibs_op//". These events usually require [SamplerOptions.Precise]
+// to be set. For other events, the DataSrc is typically all "not available".
+type DataSrc uint64
+
+func (d DataSrc) field(shift, bits int) uint64 {
+	return uint64(d) >> shift & (1<<bits - 1)
+}
+
+// MemOp is a set of PERF_MEM_OP_* flags giving the type of a memory access.
+type MemOp uint8
+
+const (
+	MemOpNA       MemOp = unix.PERF_MEM_OP_NA // Not available
+	MemOpLoad     MemOp = unix.PERF_MEM_OP_LOAD
+	MemOpStore    MemOp = unix.PERF_MEM_OP_STORE
+	MemOpPrefetch MemOp = unix.PERF_MEM_OP_PFETCH
+	MemOpExec     MemOp = unix.PERF_MEM_OP_EXEC
+)
+
+var memOpNames = []string{"NA", "LOAD", "STORE", "PFETCH", "EXEC"}
+
+// String returns the flags in o in the form "LOAD|STORE".
+func (o MemOp) String() string {
+	return flagsString(uint64(o), memOpNames)
+}
+
+// MemSnoop is a set of flags giving the result of a cache coherence snoop.
+// These combine the PERF_MEM_SNOOP_* and PERF_MEM_SNOOPX_* flags.
+type MemSnoop uint8
+
+const (
+	MemSnoopNA   MemSnoop = unix.PERF_MEM_SNOOP_NA // Not available
+	MemSnoopNone MemSnoop = unix.PERF_MEM_SNOOP_NONE
+	MemSnoopHit  MemSnoop = unix.PERF_MEM_SNOOP_HIT
+	MemSnoopMiss MemSnoop = unix.PERF_MEM_SNOOP_MISS
+	MemSnoopHitM MemSnoop = unix.PERF_MEM_SNOOP_HITM // Hit a modified line
+	MemSnoopFwd  MemSnoop = unix.PERF_MEM_SNOOPX_FWD << 5
+	MemSnoopPeer MemSnoop = unix.PERF_MEM_SNOOPX_PEER << 5
+)
+
+var memSnoopNames = []string{"NA", "NONE", "HIT", "MISS", "HITM", "FWD", "PEER"}
+
+// String returns the flags in s in the form "HIT|FWD".
+func (s MemSnoop) String() string {
+	return flagsString(uint64(s), memSnoopNames)
+}
+
+// MemTLB is a set of PERF_MEM_TLB_* flags giving the result of the TLB
+// lookup for a memory access.
+type MemTLB uint8
+
+const (
+	MemTLBNA     MemTLB = unix.PERF_MEM_TLB_NA // Not available
+	MemTLBHit    MemTLB = unix.PERF_MEM_TLB_HIT
+	MemTLBMiss   MemTLB = unix.PERF_MEM_TLB_MISS
+	MemTLBL1     MemTLB = unix.PERF_MEM_TLB_L1
+	MemTLBL2     MemTLB = unix.PERF_MEM_TLB_L2
+	MemTLBWalker MemTLB = unix.PERF_MEM_TLB_WK // Hardware page walker
+	MemTLBOS     MemTLB = unix.PERF_MEM_TLB_OS // OS fault handler
+)
+
+var memTLBNames = []string{"NA", "HIT", "MISS", "L1", "L2", "WK", "OS"}
+
+// String returns the flags in t in the form "L1|HIT".
+func (t MemTLB) String() string {
+	return flagsString(uint64(t), memTLBNames)
+}
+
+// MemLevel is a level of the memory hierarchy. These correspond to the
+// PERF_MEM_LVLNUM_* constants.
+type MemLevel uint8
+
+const (
+	MemLevelL1       MemLevel = unix.PERF_MEM_LVLNUM_L1
+	MemLevelL2       MemLevel = unix.PERF_MEM_LVLNUM_L2
+	MemLevelL3       MemLevel = unix.PERF_MEM_LVLNUM_L3
+	MemLevelL4       MemLevel = unix.PERF_MEM_LVLNUM_L4
+	MemLevelUncached MemLevel = unix.PERF_MEM_LVLNUM_UNC
+	MemLevelCXL      MemLevel = unix.PERF_MEM_LVLNUM_CXL
+	MemLevelIO       MemLevel = unix.PERF_MEM_LVLNUM_IO
+	MemLevelAnyCache MemLevel = unix.PERF_MEM_LVLNUM_ANY_CACHE
+	MemLevelLFB      MemLevel = unix.PERF_MEM_LVLNUM_LFB // Line fill buffer
+	MemLevelRAM      MemLevel = unix.PERF_MEM_LVLNUM_RAM
+	MemLevelPMEM     MemLevel = unix.PERF_MEM_LVLNUM_PMEM // Persistent memory
+	MemLevelNA       MemLevel = unix.PERF_MEM_LVLNUM_NA   // Not available
+)
+
+var memLevelNames = []string{
+	MemLevelL1:       "L1",
+	MemLevelL2:       "L2",
+	MemLevelL3:       "L3",
+	MemLevelL4:       "L4",
+	MemLevelUncached: "UNC",
+	MemLevelCXL:      "CXL",
+	MemLevelIO:       "IO",
+	MemLevelAnyCache: "ANY_CACHE",
+	MemLevelLFB:      "LFB",
+	MemLevelRAM:      "RAM",
+	MemLevelPMEM:     "PMEM",
+	MemLevelNA:       "NA",
+}
+
+// String returns the name of l without the "PERF_MEM_LVLNUM_" prefix, such
+// as "L2".
+func (l MemLevel) String() string {
+	if int(l) < len(memLevelNames) && memLevelNames[l] != "" {
+		return memLevelNames[l]
+	}
+	return fmt.Sprintf("MemLevel(%d)", uint8(l))
+}
+
+// Op returns the type of the memory access.
+func (d DataSrc) Op() MemOp {
+	return MemOp(d.field(unix.PERF_MEM_OP_SHIFT, 5))
+}
+
+// legacy mem_lvl flags for remote levels.
+const memLvlRemote = unix.PERF_MEM_LVL_REM_RAM1 | unix.PERF_MEM_LVL_REM_RAM2 |
+	unix.PERF_MEM_LVL_REM_CCE1 | unix.PERF_MEM_LVL_REM_CCE2
+
+// Level returns the level of the memory hierarchy that serviced the access,
+// or MemLevelNA if unknown. Use [DataSrc.Remote] to tell whether the level
+// was on a remote node.
+func (d DataSrc) Level() MemLevel {
+	if lvl := MemLevel(d.field(unix.PERF_MEM_LVLNUM_SHIFT, 4)); lvl != 0 && lvl != MemLevelNA {
+		return lvl
+	}
+	// Older kernels and some CPUs only report the original mem_lvl bit
+	// set. Map it to a level number.
+	lvl := d.field(unix.PERF_MEM_LVL_SHIFT, 14)
+	switch {
+	case lvl&unix.PERF_MEM_LVL_L1 != 0:
+		return MemLevelL1
+	case lvl&unix.PERF_MEM_LVL_LFB != 0:
+		return MemLevelLFB
+	case lvl&unix.PERF_MEM_LVL_L2 != 0:
+		return MemLevelL2
+	case lvl&unix.PERF_MEM_LVL_L3 != 0:
+		return MemLevelL3
+	case lvl&(unix.PERF_MEM_LVL_LOC_RAM|unix.PERF_MEM_LVL_REM_RAM1|unix.PERF_MEM_LVL_REM_RAM2) != 0:
+		return MemLevelRAM
+	case lvl&(unix.PERF_MEM_LVL_REM_CCE1|unix.PERF_MEM_LVL_REM_CCE2) != 0:
+		return MemLevelAnyCache
+	case lvl&unix.PERF_MEM_LVL_IO != 0:
+		return MemLevelIO
+	case lvl&unix.PERF_MEM_LVL_UNC != 0:
+		return MemLevelUncached
+	}
+	return MemLevelNA
+}
+
+// Remote reports whether the access was serviced by a remote NUMA node, such
+// as remote DRAM or a cache on another socket.
+func (d DataSrc) Remote() bool {
+	return d.field(unix.PERF_MEM_REMOTE_SHIFT, 1) != 0 ||
+		d.field(unix.PERF_MEM_LVL_SHIFT, 14)&memLvlRemote != 0
+}
+
+// Hit and Miss report whether the access hit or missed at [DataSrc.Level].
+// If neither is true, this is not known.
+func (d DataSrc) Hit() bool {
+	return d.field(unix.PERF_MEM_LVL_SHIFT, 14)&unix.PERF_MEM_LVL_HIT != 0
+}
+
+func (d DataSrc) Miss() bool {
+	return d.field(unix.PERF_MEM_LVL_SHIFT, 14)&unix.PERF_MEM_LVL_MISS != 0
+}
+
+// Snoop returns the result of the cache coherence snoop for the access.
+func (d DataSrc) Snoop() MemSnoop {
+	return MemSnoop(d.field(unix.PERF_MEM_SNOOP_SHIFT, 5) | d.field(unix.PERF_MEM_SNOOPX_SHIFT, 2)<<5)
+}
+
+// Locked reports whether the access was a locked (atomic) operation.
+func (d DataSrc) Locked() bool {
+	return d.field(unix.PERF_MEM_LOCK_SHIFT, 2)&unix.PERF_MEM_LOCK_LOCKED != 0
+}
+
+// TLB returns the result of the TLB lookup for the access.
+func (d DataSrc) TLB() MemTLB {
+	return MemTLB(d.field(unix.PERF_MEM_TLB_SHIFT, 7))
+}
+
+// String returns a human-readable summary of d, such as
+// "LOAD, remote RAM hit, snoop HIT, TLB L2|HIT, locked". It omits
+// components that are not available.
+func (d DataSrc) String() string {
+	var parts []string
+	if op := d.Op(); op != 0 && op != MemOpNA {
+		parts = append(parts, op.String())
+	}
+	if lvl := d.Level(); lvl != MemLevelNA {
+		var sb strings.Builder
+		if d.Remote() {
+			sb.WriteString("remote ")
+		}
+		sb.WriteString(lvl.String())
+		if d.Hit() {
+			sb.WriteString(" hit")
+		} else if d.Miss() {
+			sb.WriteString(" miss")
+		}
+		parts = append(parts, sb.String())
+	}
+	if snoop := d.Snoop(); snoop != 0 && snoop != MemSnoopNA {
+		parts = append(parts, "snoop "+snoop.String())
+	}
+	if tlb := d.TLB(); tlb != 0 && tlb != MemTLBNA {
+		parts = append(parts, "TLB "+tlb.String())
+	}
+	if d.Locked() {
+		parts = append(parts, "locked")
+	}
+	if len(parts) == 0 {
+		return "NA"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestDataSrc(t *testing.T) {
+	op := func(x uint64) uint64 { return x << unix.PERF_MEM_OP_SHIFT }
+	lvl := func(x uint64) uint64 { return x << unix.PERF_MEM_LVL_SHIFT }
+	lvlNum := func(x uint64) uint64 { return x << unix.PERF_MEM_LVLNUM_SHIFT }
+	snoop := func(x uint64) uint64 { return x << unix.PERF_MEM_SNOOP_SHIFT }
+	snoopx := func(x uint64) uint64 { return x << unix.PERF_MEM_SNOOPX_SHIFT }
+	lock := func(x uint64) uint64 { return x << unix.PERF_MEM_LOCK_SHIFT }
+	tlb := func(x uint64) uint64 { return x << unix.PERF_MEM_TLB_SHIFT }
+	const remote = unix.PERF_MEM_REMOTE_REMOTE << unix.PERF_MEM_REMOTE_SHIFT
+
+	for _, tc := range []struct {
+		d     uint64
+		level MemLevel
+		want  string
+	}{
+		// All not available. This is what the kernel reports for
+		// events that don't support data sources.
+		{op(unix.PERF_MEM_OP_NA) | lvl(unix.PERF_MEM_LVL_NA) | snoop(unix.PERF_MEM_SNOOP_NA) | lock(unix.PERF_MEM_LOCK_NA) | tlb(unix.PERF_MEM_TLB_NA),
+			MemLevelNA, "NA"},
+		// Legacy level encodings.
+		{op(unix.PERF_MEM_OP_LOAD) | lvl(unix.PERF_MEM_LVL_L1|unix.PERF_MEM_LVL_HIT) | snoop(unix.PERF_MEM_SNOOP_NONE) | tlb(unix.PERF_MEM_TLB_L1|unix.PERF_MEM_TLB_HIT),
+			MemLevelL1, "LOAD, L1 hit, snoop NONE, TLB HIT|L1"},
+		{op(unix.PERF_MEM_OP_LOAD) | lvl(unix.PERF_MEM_LVL_LFB|unix.PERF_MEM_LVL_HIT),
+			MemLevelLFB, "LOAD, LFB hit"},
+		{op(unix.PERF_MEM_OP_LOAD) | lvl(unix.PERF_MEM_LVL_LOC_RAM|unix.PERF_MEM_LVL_HIT) | tlb(unix.PERF_MEM_TLB_MISS|unix.PERF_MEM_TLB_WK),
+			MemLevelRAM, "LOAD, RAM hit, TLB MISS|WK"},
+		{op(unix.PERF_MEM_OP_LOAD) | lvl(unix.PERF_MEM_LVL_REM_CCE1|unix.PERF_MEM_LVL_HIT) | snoop(unix.PERF_MEM_SNOOP_HITM),
+			MemLevelAnyCache, "LOAD, remote ANY_CACHE hit, snoop HITM"},
+		{op(unix.PERF_MEM_OP_STORE) | lvl(unix.PERF_MEM_LVL_L1|unix.PERF_MEM_LVL_MISS) | lock(unix.PERF_MEM_LOCK_LOCKED),
+			MemLevelL1, "STORE, L1 miss, locked"},
+		// Level numbers take precedence over the legacy level.
+		{op(unix.PERF_MEM_OP_LOAD) | lvl(unix.PERF_MEM_LVL_HIT) | lvlNum(unix.PERF_MEM_LVLNUM_RAM) | remote | snoopx(unix.PERF_MEM_SNOOPX_FWD),
+			MemLevelRAM, "LOAD, remote RAM hit, snoop FWD"},
+		{op(unix.PERF_MEM_OP_LOAD) | lvl(unix.PERF_MEM_LVL_L3|unix.PERF_MEM_LVL_HIT) | lvlNum(unix.PERF_MEM_LVLNUM_L3),
+			MemLevelL3, "LOAD, L3 hit"},
+	} {
+		d := DataSrc(tc.d)
+		if got := d.Level(); got != tc.level {
+			t.Errorf("%#x: Level() = %s, want %s", tc.d, got, tc.level)
+		}
+		if got := d.String(); got != tc.want {
+			t.Errorf("%#x: String() = %q, want %q", tc.d, got, tc.want)
+		}
+	}
+}
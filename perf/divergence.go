@@ -0,0 +1,143 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// A RatioMonitor tracks the ratio between two events over a sequence of
+// [Interval]s, such as from [Counter.Stream], and detects when the
+// distribution of the ratio shifts. For example, monitoring instructions per
+// cycle or cache misses per reference can detect performance regressions in
+// a long-running process without a fixed baseline.
+//
+// The monitor compares the most recent Window intervals against the Window
+// intervals before those using Welch's t-test. When it detects a shift, the
+// recent intervals become the new baseline, so a single change in behavior is
+// reported once.
+type RatioMonitor struct {
+	// Num and Den are the indexes of the numerator and denominator events in
+	// each Interval's Counts.
+	Num, Den int
+
+	// Window is the number of intervals in each of the baseline and recent
+	// windows. The monitor can't detect anything until it has observed
+	// 2*Window intervals.
+	Window int
+
+	// Threshold is the minimum absolute value of Welch's t statistic to
+	// report as a divergence. Lower values detect smaller shifts but report
+	// more false positives. If Threshold is 0 or negative, it defaults to
+	// [DefaultRatioThreshold].
+	Threshold float64
+
+	ratios []float64 // Up to 2*Window ratios; baseline followed by recent
+}
+
+// DefaultRatioThreshold is the default [RatioMonitor.Threshold]. A t statistic
+// this large is very unlikely between windows with the same distribution.
+const DefaultRatioThreshold = 5
+
+// A Divergence is a shift in the ratio between two events detected by a
+// [RatioMonitor].
+type Divergence struct {
+	// Time is the end time of the interval that triggered the divergence.
+	Time time.Time
+
+	// Baseline and Recent summarize the ratio in the two windows.
+	Baseline, Recent RatioStats
+
+	// Score is Welch's t statistic comparing Recent to Baseline. It is
+	// positive if the ratio increased.
+	Score float64
+}
+
+// RatioStats summarizes the ratio between two events over a window of
+// intervals.
+type RatioStats struct {
+	Mean, StdDev float64
+	N            int
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("ratio changed from %.4g±%.2g to %.4g±%.2g (t=%.1f)", d.Baseline.Mean, d.Baseline.StdDev, d.Recent.Mean, d.Recent.StdDev, d.Score)
+}
+
+// Observe adds iv to the monitor. If this causes the ratio to diverge from
+// the baseline, it returns the Divergence and true. Intervals with an error or
+// where the denominator is zero are ignored.
+func (m *RatioMonitor) Observe(iv Interval) (Divergence, bool) {
+	if iv.Err != nil || m.Num >= len(iv.Counts) || m.Den >= len(iv.Counts) || m.Window <= 0 {
+		return Divergence{}, false
+	}
+	num, _ := iv.Counts[m.Num].Value()
+	den, _ := iv.Counts[m.Den].Value()
+	if den == 0 {
+		return Divergence{}, false
+	}
+	m.ratios = append(m.ratios, num/den)
+	if len(m.ratios) > 2*m.Window {
+		m.ratios = m.ratios[len(m.ratios)-2*m.Window:]
+	}
+	if len(m.ratios) < 2*m.Window {
+		return Divergence{}, false
+	}
+
+	base := ratioStats(m.ratios[:m.Window])
+	recent := ratioStats(m.ratios[m.Window:])
+	score := welchT(base, recent)
+	threshold := m.Threshold
+	if threshold <= 0 {
+		threshold = DefaultRatioThreshold
+	}
+	if math.Abs(score) < threshold {
+		return Divergence{}, false
+	}
+
+	// Make the recent window the new baseline.
+	m.ratios = append(m.ratios[:0], m.ratios[m.Window:]...)
+	return Divergence{iv.End, base, recent, score}, true
+}
+
+// Reset discards all observed intervals.
+func (m *RatioMonitor) Reset() {
+	m.ratios = m.ratios[:0]
+}
+
+func ratioStats(xs []float64) RatioStats {
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	mean := sum / float64(len(xs))
+	var ss float64
+	for _, x := range xs {
+		ss += (x - mean) * (x - mean)
+	}
+	var sd float64
+	if len(xs) > 1 {
+		sd = math.Sqrt(ss / float64(len(xs)-1))
+	}
+	return RatioStats{mean, sd, len(xs)}
+}
+
+// welchT returns Welch's t statistic for the difference b.Mean - a.Mean.
+func welchT(a, b RatioStats) float64 {
+	se := math.Sqrt(a.StdDev*a.StdDev/float64(a.N) + b.StdDev*b.StdDev/float64(b.N))
+	diff := b.Mean - a.Mean
+	if se == 0 {
+		// Both windows are constant.
+		if diff == 0 {
+			return 0
+		}
+		return math.Copysign(math.Inf(1), diff)
+	}
+	return diff / se
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestRatioMonitor(t *testing.T) {
+	t.Run("threshold", func(t *testing.T) { testRatioMonitor(t, 5) })
+	// The zero Threshold uses the default rather than reporting every
+	// window.
+	t.Run("default", func(t *testing.T) { testRatioMonitor(t, 0) })
+}
+
+func testRatioMonitor(t *testing.T, threshold float64) {
+	m := RatioMonitor{Num: 0, Den: 1, Window: 20, Threshold: threshold}
+	rng := rand.New(rand.NewSource(1))
+	observe := func(ratio float64) (Divergence, bool) {
+		// Add some noise.
+		ratio *= 1 + 0.01*rng.NormFloat64()
+		den := uint64(1000000)
+		iv := Interval{Counts: []Count{
+			{RawValue: uint64(ratio * float64(den)), scale: scale{1, ""}},
+			{RawValue: den, scale: scale{1, ""}},
+		}}
+		return m.Observe(iv)
+	}
+
+	// A stable ratio should never diverge.
+	for i := 0; i < 200; i++ {
+		if d, ok := observe(2); ok {
+			t.Fatalf("interval %d: unexpected divergence %s", i, d)
+		}
+	}
+
+	// Shift the ratio. This should be detected exactly once, within a window.
+	detected := -1
+	for i := 0; i < 200; i++ {
+		d, ok := observe(2.5)
+		if !ok {
+			continue
+		}
+		if detected >= 0 {
+			t.Fatalf("interval %d: divergence reported again: %s", i, d)
+		}
+		detected = i
+		t.Logf("interval %d: %s", i, d)
+		if d.Score <= 0 {
+			t.Errorf("want positive score for increase, got %s", d)
+		}
+	}
+	if detected < 0 || detected >= m.Window {
+		t.Errorf("divergence detected at interval %d, want within %d", detected, m.Window)
+	}
+
+	// Intervals with a zero denominator are ignored.
+	if _, ok := m.Observe(Interval{Counts: []Count{{RawValue: 1, scale: scale{1, ""}}, {scale: scale{1, ""}}}}); ok {
+		t.Errorf("divergence on zero denominator")
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"sort"
+)
+
+// An elfFile is the symbol and line tables of an ELF object, for symbolizing
+// PCs outside Go code, such as in cgo code or shared libraries.
+type elfFile struct {
+	loads []*elf.Prog  // PT_LOAD segments
+	syms  []elf.Symbol // Function symbols, sorted by Value
+	dwarf *dwarf.Data  // nil if the file has no DWARF
+}
+
+// openELFFile reads the symbol and line tables of the ELF file at path.
+func openELFFile(path string) (*elfFile, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ef := new(elfFile)
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD {
+			ef.loads = append(ef.loads, p)
+		}
+	}
+	// Stripped shared libraries often have only dynamic symbols, so use
+	// both tables. Either may be missing.
+	syms, _ := f.Symbols()
+	dynSyms, _ := f.DynamicSymbols()
+	for _, sym := range append(syms, dynSyms...) {
+		if elf.ST_TYPE(sym.Info) == elf.STT_FUNC && sym.Value != 0 {
+			ef.syms = append(ef.syms, sym)
+		}
+	}
+	sort.Slice(ef.syms, func(i, j int) bool { return ef.syms[i].Value < ef.syms[j].Value })
+	// The line table is optional, so ignore errors.
+	ef.dwarf, _ = f.DWARF()
+	return ef, nil
+}
+
+// fileAddr returns the address in ef's virtual address space of pc, which is
+// in mapping m of ef.
+func (ef *elfFile) fileAddr(m *Mapping, pc uint64) (uint64, bool) {
+	off := pc - m.Start + m.Offset
+	for _, p := range ef.loads {
+		if p.Off <= off && off < p.Off+p.Filesz {
+			return off - p.Off + p.Vaddr, true
+		}
+	}
+	return 0, false
+}
+
+// symbolize returns the function, file, and line of address addr in ef.
+// Function is "" if addr isn't in a function symbol, and file and line are
+// zero if ef has no line table for addr.
+func (ef *elfFile) symbolize(addr uint64) (fn, file string, line int) {
+	i := sort.Search(len(ef.syms), func(i int) bool { return ef.syms[i].Value > addr }) - 1
+	if i < 0 {
+		return "", "", 0
+	}
+	sym := &ef.syms[i]
+	if sym.Size != 0 && addr >= sym.Value+sym.Size {
+		return "", "", 0
+	}
+	fn = sym.Name
+
+	if ef.dwarf == nil {
+		return
+	}
+	cu, err := ef.dwarf.Reader().SeekPC(addr)
+	if err != nil {
+		return
+	}
+	lr, err := ef.dwarf.LineReader(cu)
+	if err != nil || lr == nil {
+		return
+	}
+	var entry dwarf.LineEntry
+	if lr.SeekPC(addr, &entry) != nil {
+		return
+	}
+	if entry.File != nil {
+		file = entry.File.Name
+	}
+	return fn, file, entry.Line
+}
@@ -0,0 +1,26 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"fmt"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// setFilter applies ev's filter expression, if it has one, to the open event
+// fd. See [events.EventFilter].
+func setFilter(fd int, ev events.Event) error {
+	filter := events.FilterOf(ev)
+	if filter == "" {
+		return nil
+	}
+	if err := sys.setFilter(fd, filter); err != nil {
+		return fmt.Errorf("setting filter %q on %s: %w", filter, ev, err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"unsafe"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// filterEvent adds a filter to an Event.
+type filterEvent struct {
+	events.Event
+	filter string
+}
+
+func (e filterEvent) Filter() string { return e.filter }
+
+func TestFilterFake(t *testing.T) {
+	k := useFakeKernel(t)
+	evs := []events.Event{
+		filterEvent{events.EventCPUCycles, "a == 1"},
+		events.EventInstructions,
+		filterEvent{events.EventBranches, "b == 2"},
+	}
+	c, err := OpenCounter(TargetThisGoroutine, evs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for i, want := range []string{"a == 1", "", "b == 2"} {
+		if got := k.fakeEventFor(t, c, i).filter; got != want {
+			t.Errorf("event %d: got filter %q, want %q", i, got, want)
+		}
+	}
+
+	s, err := OpenSampler(TargetThisGoroutine, filterEvent{events.EventCPUClock, "c == 3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := k.events[s.fd].filter; got != "c == 3" {
+		t.Errorf("Sampler: got filter %q, want %q", got, "c == 3")
+	}
+}
+
+func TestFilterTracepoint(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) == 4 {
+		// 32-bit processes on 64-bit kernels make compat system calls,
+		// which don't fire the syscalls tracepoints.
+		t.Skip("syscalls tracepoints don't fire for compat system calls")
+	}
+	all, err := events.ParseEvent("syscalls:sys_enter_read")
+	if err != nil {
+		t.Skipf("tracepoint unavailable: %v", err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	// Only count reads of the pipe.
+	mine, err := events.ParseEvent("syscalls:sys_enter_read@fd == " + strconv.Itoa(int(r.Fd())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := OpenCounter(TargetThisGoroutine, all, mine)
+	if err != nil {
+		t.Skipf("opening tracepoints: %v", err)
+	}
+	defer c.Close()
+
+	buf := make([]byte, 1)
+	c.Start()
+	for i := 0; i < 10; i++ {
+		w.Write(buf)
+		r.Read(buf)
+	}
+	// Reads of other files only match the unfiltered event.
+	if f, err := os.Open("/proc/self/stat"); err == nil {
+		f.Read(buf)
+		f.Close()
+	}
+	c.Stop()
+
+	var cs [2]Count
+	if err := c.ReadGroup(cs[:]); err != nil {
+		t.Fatal(err)
+	}
+	if got := cs[1].RawValue; got != 10 {
+		t.Errorf("got %d filtered reads, want 10", got)
+	}
+	if got := cs[0].RawValue; got <= 10 {
+		t.Errorf("got %d unfiltered reads, want > 10", got)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// SampleTypeFlags is a set of PERF_SAMPLE_* flags, which select the fields
+// recorded in each sample.
+type SampleTypeFlags uint64
+
+const (
+	SampleIP           SampleTypeFlags = unix.PERF_SAMPLE_IP
+	SampleTID          SampleTypeFlags = unix.PERF_SAMPLE_TID
+	SampleTime         SampleTypeFlags = unix.PERF_SAMPLE_TIME
+	SampleAddr         SampleTypeFlags = unix.PERF_SAMPLE_ADDR
+	SampleRead         SampleTypeFlags = unix.PERF_SAMPLE_READ
+	SampleCallchain    SampleTypeFlags = unix.PERF_SAMPLE_CALLCHAIN
+	SampleID           SampleTypeFlags = unix.PERF_SAMPLE_ID
+	SampleCPU          SampleTypeFlags = unix.PERF_SAMPLE_CPU
+	SamplePeriod       SampleTypeFlags = unix.PERF_SAMPLE_PERIOD
+	SampleStreamID     SampleTypeFlags = unix.PERF_SAMPLE_STREAM_ID
+	SampleRaw          SampleTypeFlags = unix.PERF_SAMPLE_RAW
+	SampleBranchStack  SampleTypeFlags = unix.PERF_SAMPLE_BRANCH_STACK
+	SampleRegsUser     SampleTypeFlags = unix.PERF_SAMPLE_REGS_USER
+	SampleStackUser    SampleTypeFlags = unix.PERF_SAMPLE_STACK_USER
+	SampleWeight       SampleTypeFlags = unix.PERF_SAMPLE_WEIGHT
+	SampleDataSrc      SampleTypeFlags = unix.PERF_SAMPLE_DATA_SRC
+	SampleIdentifier   SampleTypeFlags = unix.PERF_SAMPLE_IDENTIFIER
+	SampleTransaction  SampleTypeFlags = unix.PERF_SAMPLE_TRANSACTION
+	SampleRegsIntr     SampleTypeFlags = unix.PERF_SAMPLE_REGS_INTR
+	SamplePhysAddr     SampleTypeFlags = unix.PERF_SAMPLE_PHYS_ADDR
+	SampleAux          SampleTypeFlags = unix.PERF_SAMPLE_AUX
+	SampleCgroup       SampleTypeFlags = unix.PERF_SAMPLE_CGROUP
+	SampleDataPageSize SampleTypeFlags = unix.PERF_SAMPLE_DATA_PAGE_SIZE
+	SampleCodePageSize SampleTypeFlags = unix.PERF_SAMPLE_CODE_PAGE_SIZE
+	SampleWeightStruct SampleTypeFlags = unix.PERF_SAMPLE_WEIGHT_STRUCT
+)
+
+var sampleTypeNames = []string{
+	"IP", "TID", "TIME", "ADDR", "READ", "CALLCHAIN", "ID", "CPU", "PERIOD",
+	"STREAM_ID", "RAW", "BRANCH_STACK", "REGS_USER", "STACK_USER", "WEIGHT",
+	"DATA_SRC", "IDENTIFIER", "TRANSACTION", "REGS_INTR", "PHYS_ADDR", "AUX",
+	"CGROUP", "DATA_PAGE_SIZE", "CODE_PAGE_SIZE", "WEIGHT_STRUCT",
+}
+
+// String returns the flags in s in the form "IP|TID|TIME".
+func (s SampleTypeFlags) String() string {
+	return flagsString(uint64(s), sampleTypeNames)
+}
+
+// Validate returns an error if s contains unknown flags or combinations of
+// flags the kernel will reject.
+func (s SampleTypeFlags) Validate() error {
+	if unknown := uint64(s) &^ (1<<len(sampleTypeNames) - 1); unknown != 0 {
+		return fmt.Errorf("sample type %s: unknown flags %#x", s, unknown)
+	}
+	if s&SampleWeight != 0 && s&SampleWeightStruct != 0 {
+		return fmt.Errorf("sample type %s: WEIGHT and WEIGHT_STRUCT are mutually exclusive", s)
+	}
+	return nil
+}
+
+// ReadFormatFlags is a set of PERF_FORMAT_* flags, which select the values
+// returned when reading a counter.
+type ReadFormatFlags uint64
+
+const (
+	ReadFormatTotalTimeEnabled ReadFormatFlags = unix.PERF_FORMAT_TOTAL_TIME_ENABLED
+	ReadFormatTotalTimeRunning ReadFormatFlags = unix.PERF_FORMAT_TOTAL_TIME_RUNNING
+	ReadFormatID               ReadFormatFlags = unix.PERF_FORMAT_ID
+	ReadFormatGroup            ReadFormatFlags = unix.PERF_FORMAT_GROUP
+	ReadFormatLost             ReadFormatFlags = unix.PERF_FORMAT_LOST
+)
+
+var readFormatNames = []string{
+	"TOTAL_TIME_ENABLED", "TOTAL_TIME_RUNNING", "ID", "GROUP", "LOST",
+}
+
+// String returns the flags in f in the form "TOTAL_TIME_ENABLED|GROUP".
+func (f ReadFormatFlags) String() string {
+	return flagsString(uint64(f), readFormatNames)
+}
+
+// Validate returns an error if f contains unknown flags.
+func (f ReadFormatFlags) Validate() error {
+	if unknown := uint64(f) &^ (1<<len(readFormatNames) - 1); unknown != 0 {
+		return fmt.Errorf("read format %s: unknown flags %#x", f, unknown)
+	}
+	return nil
+}
+
+// BranchSampleFlags is a set of PERF_SAMPLE_BRANCH_* flags, which select
+// the branches recorded in a sample's branch stack when the sample type
+// includes [SampleBranchStack].
+type BranchSampleFlags uint64
+
+const (
+	BranchUser      BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_USER
+	BranchKernel    BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_KERNEL
+	BranchHV        BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_HV
+	BranchAny       BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_ANY
+	BranchAnyCall   BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_ANY_CALL
+	BranchAnyReturn BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_ANY_RETURN
+	BranchIndCall   BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_IND_CALL
+	BranchAbortTx   BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_ABORT_TX
+	BranchInTx      BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_IN_TX
+	BranchNoTx      BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_NO_TX
+	BranchCond      BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_COND
+	BranchCallStack BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_CALL_STACK
+	BranchIndJump   BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_IND_JUMP
+	BranchCall      BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_CALL
+	BranchNoFlags   BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_NO_FLAGS
+	BranchNoCycles  BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_NO_CYCLES
+	BranchTypeSave  BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_TYPE_SAVE
+	BranchHWIndex   BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_HW_INDEX
+	BranchPrivSave  BranchSampleFlags = unix.PERF_SAMPLE_BRANCH_PRIV_SAVE
+)
+
+var branchSampleNames = []string{
+	"USER", "KERNEL", "HV", "ANY", "ANY_CALL", "ANY_RETURN", "IND_CALL",
+	"ABORT_TX", "IN_TX", "NO_TX", "COND", "CALL_STACK", "IND_JUMP", "CALL",
+	"NO_FLAGS", "NO_CYCLES", "TYPE_SAVE", "HW_INDEX", "PRIV_SAVE",
+}
+
+// String returns the flags in b in the form "USER|ANY".
+func (b BranchSampleFlags) String() string {
+	return flagsString(uint64(b), branchSampleNames)
+}
+
+// Validate returns an error if b contains unknown flags.
+func (b BranchSampleFlags) Validate() error {
+	if unknown := uint64(b) &^ (1<<len(branchSampleNames) - 1); unknown != 0 {
+		return fmt.Errorf("branch sample type %s: unknown flags %#x", b, unknown)
+	}
+	return nil
+}
+
+// flagsString formats a bit set, where names[i] is the name of bit i. Bits
+// beyond the end of names are formatted as a hex number.
+func flagsString(x uint64, names []string) string {
+	if x == 0 {
+		return "0"
+	}
+	var sb strings.Builder
+	for x != 0 {
+		i := bits.TrailingZeros64(x)
+		if i >= len(names) {
+			break
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('|')
+		}
+		sb.WriteString(names[i])
+		x &^= 1 << i
+	}
+	if x != 0 {
+		if sb.Len() > 0 {
+			sb.WriteByte('|')
+		}
+		fmt.Fprintf(&sb, "%#x", x)
+	}
+	return sb.String()
+}
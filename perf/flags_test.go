@@ -0,0 +1,52 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import "testing"
+
+func TestFlagsString(t *testing.T) {
+	for _, tc := range []struct {
+		s    interface{ String() string }
+		want string
+	}{
+		{SampleTypeFlags(0), "0"},
+		{SampleIP | SampleTID | SampleTime, "IP|TID|TIME"},
+		{SampleWeightStruct, "WEIGHT_STRUCT"},
+		{SampleIP | 1<<40, "IP|0x10000000000"},
+		{ReadFormatTotalTimeEnabled | ReadFormatGroup, "TOTAL_TIME_ENABLED|GROUP"},
+		{ReadFormatFlags(1 << 5), "0x20"},
+		{BranchUser | BranchAny, "USER|ANY"},
+	} {
+		if got := tc.s.String(); got != tc.want {
+			t.Errorf("got %s, want %s", got, tc.want)
+		}
+	}
+}
+
+func TestFlagsValidate(t *testing.T) {
+	if err := (SampleIP | SampleCallchain | SampleWeightStruct).Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := (SampleWeight | SampleWeightStruct).Validate(); err == nil {
+		t.Errorf("WEIGHT|WEIGHT_STRUCT: expected error")
+	}
+	if err := SampleTypeFlags(1 << 40).Validate(); err == nil {
+		t.Errorf("unknown sample type: expected error")
+	}
+	if err := (ReadFormatGroup | ReadFormatLost).Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := ReadFormatFlags(1 << 5).Validate(); err == nil {
+		t.Errorf("unknown read format: expected error")
+	}
+	if err := (BranchUser | BranchAny | BranchPrivSave).Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := BranchSampleFlags(1 << 30).Validate(); err == nil {
+		t.Errorf("unknown branch sample type: expected error")
+	}
+}
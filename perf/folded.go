@@ -0,0 +1,102 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// FoldedStacks accumulates [Sample]s and writes them as folded stacks, which
+// is the input format of flamegraph.pl and can be imported by speedscope.
+//
+// Each line of folded stacks is a stack of frames from outermost to
+// innermost, separated by semicolons, followed by a space and the number of
+// samples with that stack. Each sample's stack is its Callchain if the sample
+// type includes [SampleCallchain], or otherwise just its IP.
+type FoldedStacks struct {
+	sym    *SelfSymbolizer
+	counts map[string]uint64
+
+	frames []string
+	buf    strings.Builder
+}
+
+// NewFoldedStacks returns a new, empty FoldedStacks. If sym is non-nil, it is
+// used to symbolize PCs. Otherwise, frames are written as hex addresses.
+func NewFoldedStacks(sym *SelfSymbolizer) *FoldedStacks {
+	return &FoldedStacks{sym: sym, counts: make(map[string]uint64)}
+}
+
+// Add adds s to the folded stacks.
+func (f *FoldedStacks) Add(s *Sample) {
+	f.AddWeight(s, 1)
+}
+
+// AddWeight is like [FoldedStacks.Add], but adds weight to the count of s's
+// stack instead of 1. This is useful for weighting stacks by something other
+// than the number of samples, such as time.
+func (f *FoldedStacks) AddWeight(s *Sample, weight uint64) {
+	f.frames = f.frames[:0]
+	for _, fr := range sampleFrames(f.sym, s) {
+		f.frames = append(f.frames, foldedFrame(fr))
+	}
+
+	// Frames are innermost first, but folded stacks are outermost first.
+	f.buf.Reset()
+	for i := len(f.frames) - 1; i >= 0; i-- {
+		f.buf.WriteString(f.frames[i])
+		if i > 0 {
+			f.buf.WriteByte(';')
+		}
+	}
+	f.counts[f.buf.String()] += weight
+}
+
+// foldedFrame returns the name of fr in folded stacks. Like perf's
+// stackcollapse scripts, it annotates kernel frames with "_[k]".
+func foldedFrame(fr Frame) string {
+	name := frameName(fr)
+	if fr.Kernel {
+		name += "_[k]"
+	}
+	return foldedEscaper.Replace(name)
+}
+
+// frameName returns a name for fr: its function if known, or otherwise its
+// file and offset if it's in a mapped file, or otherwise its address.
+func frameName(fr Frame) string {
+	switch {
+	case fr.Function != "":
+		return fr.Function
+	case fr.Mapping != nil && fr.Mapping.Path != "":
+		return fmt.Sprintf("%s+%#x", filepath.Base(fr.Mapping.Path), fr.PC-fr.Mapping.Start+fr.Mapping.Offset)
+	}
+	return fmt.Sprintf("%#x", fr.PC)
+}
+
+// foldedEscaper replaces the characters that can't appear in a frame name:
+// semicolons separate frames and a space separates the count.
+var foldedEscaper = strings.NewReplacer(";", ":", " ", "_")
+
+// Write writes the folded stacks to w, sorted by stack.
+func (f *FoldedStacks) Write(w io.Writer) error {
+	stacks := make([]string, 0, len(f.counts))
+	for stack := range f.counts {
+		stacks = append(stacks, stack)
+	}
+	slices.Sort(stacks)
+	bw := bufio.NewWriter(w)
+	for _, stack := range stacks {
+		fmt.Fprintf(bw, "%s %d\n", stack, f.counts[stack])
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestFoldedStacks(t *testing.T) {
+	sym, err := NewSelfSymbolizer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcs := pprofTestLeaf()
+	leaf := uint64(reflect.ValueOf(pprofTestLeaf).Pointer())
+	user := []uint64{1<<64 + unix.PERF_CONTEXT_USER, leaf, uint64(pcs[1])}
+	kernel := append([]uint64{1<<64 + unix.PERF_CONTEXT_KERNEL, 0xffffffff81000000}, user...)
+
+	f := NewFoldedStacks(sym)
+	f.Add(&Sample{Callchain: kernel})
+	f.Add(&Sample{Callchain: user})
+	f.Add(&Sample{Callchain: user})
+	var buf strings.Builder
+	if err := f.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	const pkg = "github.com/aclements/go-perfevent/perf."
+	stack := pkg + "TestFoldedStacks;" + pkg + "pprofTestLeaf"
+	want := stack + " 2\n" + stack + ";0xffffffff81000000_[k] 1\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%swant:\n%s", got, want)
+	}
+
+	// Without a symbolizer, frames are addresses.
+	f = NewFoldedStacks(nil)
+	f.Add(&Sample{IP: 0x1000})
+	f.Add(&Sample{Callchain: []uint64{1<<64 + unix.PERF_CONTEXT_KERNEL, 0x3000, 1<<64 + unix.PERF_CONTEXT_USER, 0x2000, 0x1000}})
+	f.AddWeight(&Sample{IP: 0x1000}, 10)
+	buf.Reset()
+	if err := f.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want = "0x1000 11\n0x1000;0x2000;0x3000_[k] 1\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%swant:\n%s", got, want)
+	}
+}
+
+func TestFoldedFrame(t *testing.T) {
+	m := &Mapping{Start: 0x7f0000000000, End: 0x7f0000100000, Offset: 0x2000, Path: "/lib/x86_64-linux-gnu/libc.so.6"}
+	for _, test := range []struct {
+		fr   Frame
+		want string
+	}{
+		{Frame{PC: 0x1234, Function: "main.main"}, "main.main"},
+		{Frame{PC: 0x7f0000000100, Mapping: m}, "libc.so.6+0x2100"},
+		{Frame{PC: 0x1234, Mapping: &Mapping{}}, "0x1234"},
+		{Frame{PC: 0xffffffff81000000, Kernel: true}, "0xffffffff81000000_[k]"},
+		{Frame{Function: "main.f[go;shape int]"}, "main.f[go:shape_int]"},
+	} {
+		if got := foldedFrame(test.fr); got != test.want {
+			t.Errorf("foldedFrame(%+v) = %q, want %q", test.fr, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// FuzzDecodeRing reads and decodes the records in a ring buffer with arbitrary
+// contents and positions. The seed corpus in testdata/fuzz/FuzzDecodeRing was
+// captured from real kernels by TestCaptureRing.
+func FuzzDecodeRing(f *testing.F) {
+	f.Fuzz(func(t *testing.T, sampleType, branchSampleType, readFormat, tail, head uint64, data []byte) {
+		// The data area of a ring is always a power of two.
+		size := 1
+		for size*2 <= len(data) {
+			size *= 2
+		}
+		if size < perfEventHeaderSize {
+			return
+		}
+		r := newTestRing(size)
+		copy(r.data, data)
+		r.tail, r.meta.Data_tail, r.meta.Data_head = tail, tail, head
+
+		format := SampleFormat{
+			SampleType:       SampleTypeFlags(sampleType),
+			BranchSampleType: BranchSampleFlags(branchSampleType),
+			ReadFormat:       ReadFormatFlags(readFormat),
+		}
+		var s Sample
+		for n := 0; ; n++ {
+			rec, ok := r.next()
+			if !ok {
+				break
+			}
+			if n >= size/perfEventHeaderSize {
+				t.Fatalf("read more than %d records from %d byte ring", n, size)
+			}
+			if len(rec.Data) > size-perfEventHeaderSize {
+				t.Fatalf("%d byte record from %d byte ring", len(rec.Data), size)
+			}
+			if rec.Type != RecordSample {
+				for _, all := range []bool{false, true} {
+					format := format
+					format.SampleIDAll = all
+					DecodeSideBand(rec, format)
+					DecodeThrottle(rec, format)
+					DecodeLost(rec, format)
+				}
+				continue
+			}
+			if err := DecodeSample(rec, format, &s); err != nil {
+				continue
+			}
+			// Every decoded entry must have come from the record.
+			if n := len(s.Counts)*8 + len(s.Callchain)*8 + len(s.BranchStack)*24 + len(s.RegsUser.Values)*8 + len(s.Raw); n > len(rec.Data) {
+				t.Fatalf("decoded %d bytes of entries from a %d byte record", n, len(rec.Data))
+			}
+		}
+		if r.tail != head {
+			t.Fatalf("stopped reading at %d, want head %d", r.tail, head)
+		}
+	})
+}
+
+var flagCapture = flag.Bool("capture", false, "capture the seed corpus for FuzzDecodeRing from the running kernel")
+
+// TestCaptureRing captures the seed corpus for FuzzDecodeRing by sampling this
+// test with several configurations and saving the contents of the ring
+// buffers. It only runs with the -capture flag.
+func TestCaptureRing(t *testing.T) {
+	if !*flagCapture {
+		t.Skip("skipping without -capture")
+	}
+
+	spin := func(d time.Duration) {
+		start := time.Now()
+		for time.Since(start) < d {
+		}
+	}
+	type capture struct {
+		name   string
+		opts   SamplerOptions
+		evs    []events.Event
+		reads  int // Number of times to spin and drain the ring before the capture
+		remain time.Duration
+	}
+	const all = SampleIdentifier | SampleIP | SampleTID | SampleTime |
+		SampleAddr | SampleID | SampleStreamID | SampleCPU | SamplePeriod |
+		SampleWeight | SampleDataSrc | SamplePhysAddr
+	captures := []capture{
+		// Records wrap around the end of the ring because we drain it
+		// several times first. The 1 page ring overflows between
+		// drains, so most captures also include lost records.
+		{name: "wrap", opts: SamplerOptions{SampleType: SampleIP | SampleTID | SampleTime | SamplePeriod, Period: 50000}, reads: 3, remain: 5 * time.Millisecond},
+		{name: "callchain", opts: SamplerOptions{SampleType: SampleIP | SampleTID | SampleCallchain, Period: 50000}, reads: 2, remain: 5 * time.Millisecond},
+		{name: "all", opts: SamplerOptions{SampleType: all, Period: 50000}, reads: 1, remain: 5 * time.Millisecond},
+		// Sampling this fast makes the kernel throttle the event.
+		{name: "throttle", opts: SamplerOptions{SampleType: SampleIP | SampleTID, Period: 10000}, reads: 1, remain: 50 * time.Millisecond},
+		{name: "group", opts: SamplerOptions{SampleType: SampleIP, Period: 50000}, evs: []events.Event{events.EventCPUClock}, reads: 1, remain: 5 * time.Millisecond},
+		{name: "branch", opts: SamplerOptions{SampleType: SampleIP | SampleBranchStack, Period: 100000}, reads: 1, remain: 5 * time.Millisecond},
+	}
+	dir := filepath.Join("testdata", "fuzz", "FuzzDecodeRing")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range captures {
+		c.opts.RingSize = RingSizeConfig{Pages: 1}
+		ev := events.Event(events.EventTaskClock)
+		if c.name == "branch" {
+			ev = events.EventCPUCycles
+		}
+		s, err := c.opts.OpenSampler(TargetThisGoroutine, ev, c.evs...)
+		if err != nil {
+			t.Logf("%s: %v", c.name, err)
+			continue
+		}
+		s.Start()
+		for i := 0; i < c.reads; i++ {
+			spin(c.remain)
+			for {
+				if _, ok := s.ReadRecord(); !ok {
+					break
+				}
+			}
+		}
+		spin(c.remain)
+		s.Stop()
+
+		f := s.SampleFormat()
+		meta := s.ring.meta
+		buf := fmt.Sprintf("go test fuzz v1\nuint64(%d)\nuint64(%d)\nuint64(%d)\nuint64(%d)\nuint64(%d)\n[]byte(%+q)\n",
+			f.SampleType, f.BranchSampleType, f.ReadFormat, meta.Data_tail, meta.Data_head, s.ring.data)
+		s.Close()
+		if err := os.WriteFile(filepath.Join(dir, c.name), []byte(buf), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
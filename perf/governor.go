@@ -0,0 +1,175 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Period returns s's current sample period, or 0 if s samples at a fixed
+// frequency.
+func (s *Sampler) Period() uint64 {
+	return s.period
+}
+
+// SetPeriod changes the sample period of s, which must have been opened with
+// a sample period rather than a frequency. The new period takes effect after
+// the next sample.
+func (s *Sampler) SetPeriod(period uint64) error {
+	if s == nil || s.f == nil {
+		return fmt.Errorf("Sampler is closed")
+	}
+	if s.period == 0 {
+		return fmt.Errorf("cannot set the period of a Sampler that samples at a frequency")
+	}
+	if period == 0 {
+		return fmt.Errorf("sample period must be non-zero")
+	}
+	if err := sys.setPeriod(s.fd, period); err != nil {
+		return err
+	}
+	s.period = period
+	return nil
+}
+
+// GovernorConfig configures a [Governor].
+type GovernorConfig struct {
+	// TargetRate is the number of samples per second the Governor aims
+	// for. It must be positive.
+	TargetRate float64
+
+	// MinPeriod and MaxPeriod bound the sample periods the Governor
+	// chooses. MinPeriod must be positive, and should be large enough that
+	// a burst of activity at that period doesn't overwhelm the consumer
+	// before the Governor can react. For example, for CPU cycles, a
+	// reasonable MinPeriod is the CPU's clock rate divided by the highest
+	// acceptable sample rate of one thread. If MaxPeriod is 0, there is no
+	// upper bound.
+	MinPeriod, MaxPeriod uint64
+}
+
+// A Governor adjusts the sample period of a [Sampler] to keep its sample rate
+// near a target. A fixed sample period or frequency may produce far more
+// samples than a consumer can handle when the target is busy, or too few to
+// be useful when it's mostly idle.
+//
+// The Governor backs off quickly when the kernel loses records or throttles
+// the event, and otherwise moves the period toward the one that would have
+// produced the target rate over the last interval. If there were no samples
+// at all, the target was probably idle, so the Governor keeps the period
+// rather than lowering it toward a period that would flood the consumer once
+// the target is busy again.
+//
+// The kernel can also adjust the period automatically to hold a frequency
+// (see [SamplerOptions.Freq]), but it does so per-event without regard for
+// lost records, and throttles samples when the frequency is too high.
+type Governor struct {
+	s   *Sampler
+	cfg GovernorConfig
+
+	last      time.Time
+	lost      uint64 // Total lost records and samples as of last
+	throttles int    // Throttles as of last
+}
+
+// governorNow returns the current time. This is a variable so tests can
+// control time.
+var governorNow = time.Now
+
+// Governor backoff and adjustment limits.
+const (
+	// governorBackoff is the factor to increase the period by when records
+	// are lost or the event is throttled.
+	governorBackoff = 2
+	// governorMaxStep bounds the factor by which the period changes in one
+	// update, to avoid overreacting to a burst or a lull.
+	governorMaxStep = 4
+	// governorSlack is how much the ideal period must differ from the
+	// current period before the Governor changes it.
+	governorSlack = 1.0 / 8
+)
+
+// NewGovernor returns a Governor that adjusts the sample period of s.
+// s must have been opened with a sample period rather than a frequency.
+//
+// The caller drives the Governor by periodically calling [Governor.Update].
+func NewGovernor(s *Sampler, cfg GovernorConfig) (*Governor, error) {
+	if s.period == 0 {
+		return nil, fmt.Errorf("Governor requires a Sampler with a sample period, not a frequency")
+	}
+	if !(cfg.TargetRate > 0) {
+		return nil, fmt.Errorf("TargetRate must be positive, got %v", cfg.TargetRate)
+	}
+	if cfg.MinPeriod == 0 {
+		return nil, fmt.Errorf("MinPeriod must be positive")
+	}
+	if cfg.MaxPeriod != 0 && cfg.MaxPeriod < cfg.MinPeriod {
+		return nil, fmt.Errorf("MaxPeriod %d is less than MinPeriod %d", cfg.MaxPeriod, cfg.MinPeriod)
+	}
+	g := &Governor{s: s, cfg: cfg}
+	g.reset()
+	return g, nil
+}
+
+// reset starts a new interval at the current time.
+func (g *Governor) reset() {
+	lost := g.s.Lost()
+	g.last = governorNow()
+	g.lost = lost.Records + lost.Samples
+	g.throttles = g.s.Throttled().Throttles
+}
+
+// Update adjusts the sample period based on the number of samples the caller
+// read from the Sampler since the last call to Update or NewGovernor, and any
+// lost records or throttling in that time. It returns the new sample period.
+//
+// Update uses the lost and throttle records the Sampler has read, so it
+// should be called after reading the ring buffer. It works best when called
+// at a regular interval that spans many samples, such as every 100ms.
+func (g *Governor) Update(samples int) (uint64, error) {
+	prevLast, prevLost, prevThrottles := g.last, g.lost, g.throttles
+	g.reset()
+	elapsed := g.last.Sub(prevLast)
+	if elapsed <= 0 {
+		return g.s.period, nil
+	}
+
+	period := float64(g.s.period)
+	switch {
+	case g.lost > prevLost || g.throttles > prevThrottles:
+		// The consumer or the kernel can't keep up. Back off.
+		period *= governorBackoff
+	case samples == 0:
+		// We can't tell an idle target from a period that's much too
+		// long, and lowering the period of an idle target would only
+		// flood the consumer when it wakes up, so keep the period.
+		return g.s.period, nil
+	default:
+		rate := float64(samples) / elapsed.Seconds()
+		factor := rate / g.cfg.TargetRate
+		factor = min(max(factor, 1.0/governorMaxStep), governorMaxStep)
+		if math.Abs(factor-1) < governorSlack {
+			return g.s.period, nil
+		}
+		period *= factor
+	}
+
+	period = max(period, float64(g.cfg.MinPeriod))
+	if g.cfg.MaxPeriod != 0 {
+		period = min(period, float64(g.cfg.MaxPeriod))
+	}
+	newPeriod := uint64(min(period, math.MaxInt64))
+	if newPeriod == g.s.period {
+		return newPeriod, nil
+	}
+	if err := g.s.SetPeriod(newPeriod); err != nil {
+		return g.s.period, err
+	}
+	return newPeriod, nil
+}
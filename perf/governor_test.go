@@ -0,0 +1,124 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestGovernor(t *testing.T) {
+	k := useFakeKernel(t)
+	now := time.Unix(0, 0)
+	defer func(old func() time.Time) { governorNow = old }(governorNow)
+	governorNow = func() time.Time { return now }
+
+	opts := SamplerOptions{SampleType: SampleIP, Period: 1000}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ev := k.events[s.fd]
+
+	g, err := NewGovernor(s, GovernorConfig{TargetRate: 100, MinPeriod: 100, MaxPeriod: 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := func(samples int, want uint64) {
+		t.Helper()
+		now = now.Add(time.Second)
+		got, err := g.Update(samples)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want || s.Period() != want || ev.attr.Sample != want {
+			t.Errorf("%d samples: got period %d (Sampler %d, kernel %d), want %d", samples, got, s.Period(), ev.attr.Sample, want)
+		}
+	}
+
+	update(200, 2000)     // Twice the target rate
+	update(105, 2000)     // Close enough to the target
+	update(1000, 8000)    // Large changes are limited
+	update(100000, 10000) // Limited by MaxPeriod
+	update(50, 5000)
+
+	// Lost records back off, even if the rate is low.
+	lost := binary.NativeEndian.AppendUint64(nil, 0)
+	lost = binary.NativeEndian.AppendUint64(lost, 5)
+	ev.writeRecord(RecordLost, 0, lost)
+	for {
+		if _, ok := s.ReadRecord(); !ok {
+			break
+		}
+	}
+	update(10, 10000)
+
+	// No samples at all keeps the period, since the target may be idle.
+	update(0, 10000)
+	update(0, 10000)
+
+	// Few samples lower the period, limited by MinPeriod.
+	update(25, 2500)
+	update(1, 625)
+	update(1, 156)
+	update(1, 100)
+}
+
+func TestGovernorInvalid(t *testing.T) {
+	useFakeKernel(t)
+	s, err := OpenSampler(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// The default is to sample at a frequency.
+	if _, err := NewGovernor(s, GovernorConfig{TargetRate: 100}); err == nil {
+		t.Errorf("NewGovernor with frequency Sampler: want error")
+	}
+	if err := s.SetPeriod(1000); err == nil {
+		t.Errorf("SetPeriod of frequency Sampler: want error")
+	}
+
+	opts := SamplerOptions{Period: 1000}
+	s2, err := opts.OpenSampler(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	for _, cfg := range []GovernorConfig{
+		{},
+		{TargetRate: -1},
+		{TargetRate: 100}, // No MinPeriod
+		{TargetRate: 100, MinPeriod: 10, MaxPeriod: 5},
+	} {
+		if _, err := NewGovernor(s2, cfg); err == nil {
+			t.Errorf("NewGovernor(%+v): want error", cfg)
+		}
+	}
+}
+
+func TestSamplerSetPeriod(t *testing.T) {
+	opts := SamplerOptions{SampleType: SampleIP, Period: 1000000}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Period() != 1000000 {
+		t.Errorf("got period %d, want %d", s.Period(), 1000000)
+	}
+	if err := s.SetPeriod(100000); err != nil {
+		t.Fatal(err)
+	}
+	if s.Period() != 100000 {
+		t.Errorf("got period %d, want %d", s.Period(), 100000)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// kernel is the interface to the perf_event system calls. [Counter] and
+// [Sampler] make all perf system calls through sys, so tests can substitute a
+// fake kernel and exercise them without access to a PMU.
+type kernel interface {
+	perfEventOpen(attr *unix.PerfEventAttr, pid, cpu, groupFD, flags int) (int, error)
+	ioctl(fd int, req uint, arg int) error
+	eventID(fd int) (uint64, error)
+	setFilter(fd int, filter string) error
+	setPeriod(fd int, period uint64) error
+	read(fd int, buf []byte) (int, error)
+	close(fd int) error
+	mmap(fd int, offset int64, size int) ([]byte, error)
+	munmap(b []byte) error
+
+	// rdpmc and rdtsc execute the RDPMC and RDTSC instructions. These
+	// aren't system calls, but they read hardware state that the kernel
+	// manages on our behalf, so they're part of the interface. They must
+	// only be called if haveRDPMC is true.
+	rdpmc(counter uint32) uint64
+	rdtsc() uint64
+}
+
+// sys is the kernel used by this package.
+var sys kernel = linuxKernel{}
+
+// linuxKernel implements kernel using real system calls.
+type linuxKernel struct{}
+
+func (linuxKernel) perfEventOpen(attr *unix.PerfEventAttr, pid, cpu, groupFD, flags int) (int, error) {
+	return unix.PerfEventOpen(attr, pid, cpu, groupFD, flags)
+}
+
+func (linuxKernel) ioctl(fd int, req uint, arg int) error {
+	// We make the system call directly rather than using unix.IoctlSetInt
+	// because this is on the path of Counter.Start and Stop, and
+	// IoctlSetInt allocates when it returns an error.
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (linuxKernel) eventID(fd int) (uint64, error) {
+	var id uint64
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.PERF_EVENT_IOC_ID, uintptr(unsafe.Pointer(&id)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return id, nil
+}
+
+func (linuxKernel) setFilter(fd int, filter string) error {
+	p, err := unix.BytePtrFromString(filter)
+	if err != nil {
+		return err
+	}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.PERF_EVENT_IOC_SET_FILTER, uintptr(unsafe.Pointer(p)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (linuxKernel) setPeriod(fd int, period uint64) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.PERF_EVENT_IOC_PERIOD, uintptr(unsafe.Pointer(&period)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (linuxKernel) read(fd int, buf []byte) (int, error) {
+	for {
+		n, err := unix.Read(fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		return n, err
+	}
+}
+
+func (linuxKernel) close(fd int) error {
+	return unix.Close(fd)
+}
+
+func (linuxKernel) mmap(fd int, offset int64, size int) ([]byte, error) {
+	return unix.Mmap(fd, offset, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+}
+
+func (linuxKernel) munmap(b []byte) error {
+	return unix.Munmap(b)
+}
+
+func (linuxKernel) rdpmc(counter uint32) uint64 {
+	return rdpmc(counter)
+}
+
+func (linuxKernel) rdtsc() uint64 {
+	return rdtsc()
+}
@@ -0,0 +1,639 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// fakeKernel is a scripted implementation of kernel for testing Counter and
+// Sampler without a PMU. Each perf_event_open returns a real file descriptor
+// (an eventfd), so it works with os.File and the runtime poller, but all perf
+// operations on it are simulated.
+type fakeKernel struct {
+	events map[int]*fakeEvent
+
+	// openErr, if non-nil, is called by each perfEventOpen and can fail
+	// the call by returning an error.
+	openErr func(attr *unix.PerfEventAttr) error
+
+	// userRDPMC makes the control pages of events support reading them
+	// with RDPMC while they're counting.
+	userRDPMC bool
+	// onRDPMC, if non-nil, is called by each rdpmc.
+	onRDPMC func()
+}
+
+type fakeEvent struct {
+	fd      int
+	attr    unix.PerfEventAttr
+	pid     int
+	leader  *fakeEvent   // Group leader; this event if it is the leader
+	members []*fakeEvent // If the leader, all events in the group, in order
+
+	enabled   bool
+	value     uint64
+	timeTotal uint64 // Time enabled
+	closed    bool
+
+	// unschedulable makes reads of this group fail as if it were a pinned
+	// group that couldn't be scheduled.
+	unschedulable bool
+
+	mmap []byte
+	aux  []byte // AUX area
+
+	filter string // Set by PERF_EVENT_IOC_SET_FILTER
+	bpfFD  int    // Set by PERF_EVENT_IOC_SET_BPF, or 0
+}
+
+// useFakeKernel replaces the kernel with a new fakeKernel for the duration of
+// test t.
+func useFakeKernel(t *testing.T) *fakeKernel {
+	k := &fakeKernel{events: make(map[int]*fakeEvent)}
+	old := sys
+	sys = k
+	t.Cleanup(func() { sys = old })
+	return k
+}
+
+func (k *fakeKernel) perfEventOpen(attr *unix.PerfEventAttr, pid, cpu, groupFD, flags int) (int, error) {
+	if k.openErr != nil {
+		if err := k.openErr(attr); err != nil {
+			return -1, err
+		}
+	}
+	ev := &fakeEvent{attr: *attr, pid: pid}
+	if groupFD == -1 {
+		ev.leader = ev
+	} else {
+		leader, ok := k.events[groupFD]
+		if !ok || leader.closed || leader.leader != leader {
+			return -1, syscall.EINVAL
+		}
+		ev.leader = leader
+	}
+	ev.enabled = attr.Bits&unix.PerfBitDisabled == 0
+	ev.leader.members = append(ev.leader.members, ev)
+
+	fd, err := unix.Eventfd(0, unix.EFD_CLOEXEC)
+	if err != nil {
+		return -1, err
+	}
+	ev.fd = fd
+	k.events[fd] = ev
+	return fd, nil
+}
+
+func (k *fakeKernel) ioctl(fd int, req uint, arg int) error {
+	ev, ok := k.events[fd]
+	if !ok || ev.closed {
+		return syscall.EBADF
+	}
+	if req == unix.PERF_EVENT_IOC_SET_BPF {
+		// arg is a file descriptor, not flags.
+		if ev.bpfFD != 0 {
+			return syscall.EEXIST
+		}
+		ev.bpfFD = arg
+		return nil
+	}
+	if req == unix.PERF_EVENT_IOC_SET_OUTPUT {
+		// Records are written directly to the output event's ring
+		// buffer, so there's nothing to redirect.
+		if _, ok := k.events[arg]; !ok {
+			return syscall.EBADF
+		}
+		return nil
+	}
+	evs := []*fakeEvent{ev}
+	if arg&perfIOCFlagGroup != 0 {
+		evs = ev.leader.members
+	}
+	for _, ev := range evs {
+		switch req {
+		case unix.PERF_EVENT_IOC_ENABLE:
+			ev.enabled = true
+		case unix.PERF_EVENT_IOC_DISABLE:
+			ev.enabled = false
+		case unix.PERF_EVENT_IOC_RESET:
+			ev.value = 0
+		default:
+			return syscall.ENOTTY
+		}
+	}
+	k.updatePages()
+	return nil
+}
+
+func (k *fakeKernel) eventID(fd int) (uint64, error) {
+	ev, ok := k.events[fd]
+	if !ok || ev.closed {
+		return 0, syscall.EBADF
+	}
+	// Like PERF_FORMAT_ID in read, the ID is the fd.
+	return uint64(fd), nil
+}
+
+func (k *fakeKernel) setFilter(fd int, filter string) error {
+	ev, ok := k.events[fd]
+	if !ok || ev.closed {
+		return syscall.EBADF
+	}
+	ev.filter = filter
+	return nil
+}
+
+func (k *fakeKernel) setPeriod(fd int, period uint64) error {
+	ev, ok := k.events[fd]
+	if !ok || ev.closed {
+		return syscall.EBADF
+	}
+	if period == 0 {
+		return syscall.EINVAL
+	}
+	ev.attr.Sample = period
+	return nil
+}
+
+// advance simulates n events on each enabled event. An event counts only if
+// it and its group leader are enabled.
+func (k *fakeKernel) advance(n uint64) {
+	for _, ev := range k.events {
+		if ev.closed || !ev.enabled || !ev.leader.enabled {
+			continue
+		}
+		ev.value += n
+		ev.timeTotal += n
+	}
+	k.updatePages()
+}
+
+// updatePages updates the control pages of mapped events to reflect whether
+// they're counting, like the kernel does when it schedules events.
+func (k *fakeKernel) updatePages() {
+	for fd, ev := range k.events {
+		if ev.mmap == nil || ev.closed {
+			continue
+		}
+		pg := (*unix.PerfEventMmapPage)(unsafe.Pointer(&ev.mmap[0]))
+		var index uint32
+		if k.userRDPMC && ev.enabled && ev.leader.enabled {
+			// The RDPMC counter number is index-1.
+			index = uint32(fd) + 1
+		}
+		if pg.Index == index && pg.Time_enabled == ev.timeTotal {
+			continue
+		}
+		pg.Lock++
+		pg.Index = index
+		pg.Time_enabled, pg.Time_running = ev.timeTotal, ev.timeTotal
+		pg.Lock++
+	}
+}
+
+func (k *fakeKernel) read(fd int, buf []byte) (int, error) {
+	ev, ok := k.events[fd]
+	if !ok || ev.closed {
+		return 0, syscall.EBADF
+	}
+	if ev.leader.unschedulable {
+		return 0, nil
+	}
+	rf := ev.attr.Read_format
+	if rf&unix.PERF_FORMAT_GROUP == 0 {
+		// Counter and Sampler only use group reads.
+		return 0, syscall.EINVAL
+	}
+	var out []byte
+	u64 := func(v uint64) { out = binary.NativeEndian.AppendUint64(out, v) }
+	u64(uint64(len(ev.members)))
+	if rf&unix.PERF_FORMAT_TOTAL_TIME_ENABLED != 0 {
+		u64(ev.timeTotal)
+	}
+	if rf&unix.PERF_FORMAT_TOTAL_TIME_RUNNING != 0 {
+		u64(ev.timeTotal)
+	}
+	for _, m := range ev.members {
+		u64(m.value)
+		if rf&unix.PERF_FORMAT_ID != 0 {
+			u64(uint64(m.fd))
+		}
+		if rf&unix.PERF_FORMAT_LOST != 0 {
+			u64(0)
+		}
+	}
+	if len(buf) < len(out) {
+		return 0, syscall.ENOSPC
+	}
+	return copy(buf, out), nil
+}
+
+func (k *fakeKernel) close(fd int) error {
+	ev, ok := k.events[fd]
+	if !ok || ev.closed {
+		return syscall.EBADF
+	}
+	ev.closed = true
+	return unix.Close(fd)
+}
+
+func (k *fakeKernel) mmap(fd int, offset int64, size int) ([]byte, error) {
+	ev, ok := k.events[fd]
+	if !ok || ev.closed {
+		return nil, syscall.EBADF
+	}
+	if offset != 0 {
+		// Mapping the AUX area requires first mapping the ring buffer and
+		// setting aux_offset and aux_size.
+		if ev.mmap == nil {
+			return nil, syscall.EINVAL
+		}
+		meta := (*unix.PerfEventMmapPage)(unsafe.Pointer(&ev.mmap[0]))
+		if meta.Aux_offset != uint64(offset) || meta.Aux_size != uint64(size) {
+			return nil, syscall.EINVAL
+		}
+		ev.aux = make([]byte, size)
+		return ev.aux, nil
+	}
+	// Allocate as []uint64 so the control page is suitably aligned.
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(make([]uint64, size/8)))), size)
+	meta := (*unix.PerfEventMmapPage)(unsafe.Pointer(&buf[0]))
+	pageSize := os.Getpagesize()
+	meta.Data_offset = uint64(pageSize)
+	meta.Data_size = uint64(size - pageSize)
+	if k.userRDPMC {
+		// Time_mult is 0, so the times don't advance between updates.
+		meta.Capabilities = capBit0IsDeprecated | capUserRDPMC | capUserTime
+		meta.Pmc_width = 48
+	}
+	ev.mmap = buf
+	k.updatePages()
+	return buf, nil
+}
+
+func (k *fakeKernel) munmap(b []byte) error {
+	return nil
+}
+
+func (k *fakeKernel) rdpmc(counter uint32) uint64 {
+	if k.onRDPMC != nil {
+		k.onRDPMC()
+	}
+	ev, ok := k.events[int(counter)]
+	if !ok {
+		panic("rdpmc of unknown counter")
+	}
+	return ev.value
+}
+
+func (k *fakeKernel) rdtsc() uint64 {
+	return 0
+}
+
+// writeRecord writes a record to ev's ring buffer, wrapping around the end of
+// the buffer if necessary.
+func (ev *fakeEvent) writeRecord(typ RecordType, misc uint16, data []byte) {
+	meta := (*unix.PerfEventMmapPage)(unsafe.Pointer(&ev.mmap[0]))
+	ring := ev.mmap[meta.Data_offset : meta.Data_offset+meta.Data_size]
+	var rec []byte
+	rec = binary.NativeEndian.AppendUint32(rec, uint32(typ))
+	rec = binary.NativeEndian.AppendUint16(rec, misc)
+	rec = binary.NativeEndian.AppendUint16(rec, uint16(perfEventHeaderSize+len(data)))
+	rec = append(rec, data...)
+	head := atomic.LoadUint64(&meta.Data_head)
+	for i, b := range rec {
+		ring[(head+uint64(i))%uint64(len(ring))] = b
+	}
+	atomic.StoreUint64(&meta.Data_head, head+uint64(len(rec)))
+}
+
+// fakeEventFor returns the fakeEvent for the i'th event of c.
+func (k *fakeKernel) fakeEventFor(t *testing.T, c *Counter, i int) *fakeEvent {
+	t.Helper()
+	ev, ok := k.events[c.fds[i]]
+	if !ok {
+		t.Fatalf("event %d of Counter has unknown fd %d", i, c.fds[i])
+	}
+	return ev
+}
+
+func TestFakeGroup(t *testing.T) {
+	k := useFakeKernel(t)
+	evs := []events.Event{events.EventCPUCycles, events.EventInstructions, events.EventBranches}
+	c, err := OpenCounter(TargetThisGoroutine, evs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Check the group structure. Only the leader should be disabled.
+	leader := k.fakeEventFor(t, c, 0)
+	if len(leader.members) != len(evs) {
+		t.Fatalf("leader has %d group members, want %d", len(leader.members), len(evs))
+	}
+	for i := range evs {
+		ev := k.fakeEventFor(t, c, i)
+		if ev.leader != leader {
+			t.Errorf("event %d not in leader's group", i)
+		}
+		if disabled := ev.attr.Bits&unix.PerfBitDisabled != 0; disabled != (i == 0) {
+			t.Errorf("event %d: disabled is %v, want %v", i, disabled, i == 0)
+		}
+	}
+	if leader.attr.Read_format&unix.PERF_FORMAT_GROUP == 0 {
+		t.Errorf("leader read format %#x does not include GROUP", leader.attr.Read_format)
+	}
+
+	// Nothing counts until the counter is started.
+	k.advance(10)
+	c.Start()
+	k.advance(100)
+	c.Stop()
+	k.advance(10)
+	cs := make([]Count, len(evs))
+	if err := c.ReadGroup(cs); err != nil {
+		t.Fatal(err)
+	}
+	for i, count := range cs {
+		if count.RawValue != 100 || count.TimeEnabled != 100 {
+			t.Errorf("event %d: got %+v, want 100 events in 100 time", i, count)
+		}
+	}
+
+	// Disabling one event of the group only stops that event.
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	if err := c.DisableEvent(1); err != nil {
+		t.Fatal(err)
+	}
+	k.advance(50)
+	c.Stop()
+	if err := c.ReadGroup(cs); err != nil {
+		t.Fatal(err)
+	}
+	if cs[0].RawValue != 50 || cs[1].RawValue != 0 || cs[2].RawValue != 50 {
+		t.Errorf("after disabling event 1, got values %d, %d, %d; want 50, 0, 50", cs[0].RawValue, cs[1].RawValue, cs[2].RawValue)
+	}
+
+	// Close closes every event.
+	c.Close()
+	for fd, ev := range k.events {
+		if !ev.closed {
+			t.Errorf("fd %d not closed", fd)
+		}
+	}
+}
+
+func TestFakeFormatLostFallback(t *testing.T) {
+	k := useFakeKernel(t)
+	defer noFormatLost.Store(noFormatLost.Load())
+	noFormatLost.Store(false)
+	// Simulate a kernel before Linux 6.0.
+	k.openErr = func(attr *unix.PerfEventAttr) error {
+		if attr.Read_format&unix.PERF_FORMAT_LOST != 0 {
+			return syscall.EINVAL
+		}
+		return nil
+	}
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventInstructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.lost {
+		t.Errorf("counter expects lost counts")
+	}
+	if !noFormatLost.Load() {
+		t.Errorf("noFormatLost not set")
+	}
+	c.Start()
+	k.advance(10)
+	c.Stop()
+	if _, err := c.ReadOne(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFakeOpenError(t *testing.T) {
+	k := useFakeKernel(t)
+	// Fail opening the second event of the group.
+	k.openErr = func(attr *unix.PerfEventAttr) error {
+		if attr.Config == unix.PERF_COUNT_HW_INSTRUCTIONS {
+			return syscall.ENOENT
+		}
+		return nil
+	}
+	_, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventInstructions)
+	if !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("got error %v, want ENOENT", err)
+	}
+	// The leader should have been closed.
+	if len(k.events) != 1 {
+		t.Fatalf("got %d events, want 1", len(k.events))
+	}
+	for fd, ev := range k.events {
+		if !ev.closed {
+			t.Errorf("fd %d leaked", fd)
+		}
+	}
+}
+
+func TestFakeUnschedulable(t *testing.T) {
+	k := useFakeKernel(t)
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	k.fakeEventFor(t, c, 0).unschedulable = true
+	if _, err := c.ReadOne(); !errors.Is(err, ErrUnschedulable) {
+		t.Errorf("got error %v, want %v", err, ErrUnschedulable)
+	}
+}
+
+func TestFakeSamplerRing(t *testing.T) {
+	k := useFakeKernel(t)
+	opts := SamplerOptions{
+		SampleType: SampleIP | SampleTID,
+		RingSize:   RingSizeConfig{Pages: 1},
+	}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ev := k.events[s.fd]
+
+	// Write enough records to wrap around the ring several times, reading
+	// them as we go.
+	const recSize = perfEventHeaderSize + 16
+	n := 3 * len(s.ring.data) / recSize
+	var data []byte
+	for i := 0; i < n; i++ {
+		data = binary.NativeEndian.AppendUint64(data[:0], uint64(i))
+		data = binary.NativeEndian.AppendUint32(data, 1)
+		data = binary.NativeEndian.AppendUint32(data, 2)
+		ev.writeRecord(RecordSample, 0, data)
+		if i%3 == 0 {
+			// Interleave other record types, which ReadSamples skips.
+			ev.writeRecord(RecordLost, 0, make([]byte, 16))
+		}
+		if i%5 != 4 {
+			continue
+		}
+		// Read the last 5 samples.
+		want := uint64(i - 4)
+		err := s.ReadSamples(func(s *Sample) bool {
+			if s.IP != want || s.PID != 1 || s.TID != 2 {
+				t.Errorf("got sample %+v, want IP %d", s, want)
+			}
+			want++
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want != uint64(i+1) {
+			t.Fatalf("read samples up to %d, want %d", want, i+1)
+		}
+	}
+}
+
+func TestFakeRDPMC(t *testing.T) {
+	if !haveRDPMC {
+		t.Skip("RDPMC not supported")
+	}
+	k := useFakeKernel(t)
+	k.userRDPMC = true
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventInstructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	read := func(cs []Count, wantPath ReadPath, want uint64) {
+		t.Helper()
+		if err := c.ReadGroup(cs); err != nil {
+			t.Fatal(err)
+		}
+		if c.LastReadPath() != wantPath {
+			t.Errorf("read using %s, want %s", c.LastReadPath(), wantPath)
+		}
+		for i, count := range cs {
+			if count.RawValue != want || count.TimeEnabled != want || count.TimeRunning != want {
+				t.Errorf("event %d: got %+v, want %d events in %d time", i, count, want, want)
+			}
+		}
+	}
+	cs := make([]Count, 2)
+
+	// A stopped counter must be read with a system call.
+	read(cs, ReadPathSyscall, 0)
+
+	c.Start()
+	k.advance(100)
+	read(cs, ReadPathRDPMC, 100)
+
+	// If the events are rescheduled while reading them, the read retries.
+	k.onRDPMC = func() {
+		k.onRDPMC = nil
+		k.advance(5)
+	}
+	read(cs, ReadPathRDPMC, 105)
+
+	// If they keep getting rescheduled, it falls back to a system call.
+	leader := (*unix.PerfEventMmapPage)(unsafe.Pointer(&k.fakeEventFor(t, c, 0).mmap[0]))
+	k.onRDPMC = func() { leader.Lock += 2 }
+	if err := c.ReadGroup(cs); err != nil {
+		t.Fatal(err)
+	}
+	if c.LastReadPath() != ReadPathSyscall {
+		t.Errorf("constantly rescheduled counter read using %s, want %s", c.LastReadPath(), ReadPathSyscall)
+	}
+	k.onRDPMC = nil
+
+	// If one event isn't counting, the whole group is read with a system
+	// call, but the other events can still be read alone with RDPMC.
+	if err := c.DisableEvent(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ReadGroup(cs); err != nil {
+		t.Fatal(err)
+	}
+	if c.LastReadPath() != ReadPathSyscall {
+		t.Errorf("partly disabled group read using %s, want %s", c.LastReadPath(), ReadPathSyscall)
+	}
+	if _, err := c.ReadOne(); err != nil {
+		t.Fatal(err)
+	}
+	if c.LastReadPath() != ReadPathRDPMC {
+		t.Errorf("ReadOne of partly disabled group read using %s, want %s", c.LastReadPath(), ReadPathRDPMC)
+	}
+}
+
+func TestFakeRDPMCOtherThread(t *testing.T) {
+	if !haveRDPMC {
+		t.Skip("RDPMC not supported")
+	}
+	k := useFakeKernel(t)
+	k.userRDPMC = true
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Start()
+	k.advance(100)
+
+	// RDPMC on another thread would read that thread's counters, so reads
+	// from another goroutine must use a system call.
+	type result struct {
+		count Count
+		path  ReadPath
+		err   error
+	}
+	done := make(chan result)
+	go func() {
+		// The test goroutine is locked to its thread, so this runs on
+		// another thread.
+		k.onRDPMC = func() { t.Errorf("RDPMC on another thread") }
+		count, err := c.ReadOne()
+		k.onRDPMC = nil
+		done <- result{count, c.LastReadPath(), err}
+	}()
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.path != ReadPathSyscall {
+		t.Errorf("read from another goroutine using %s, want %s", r.path, ReadPathSyscall)
+	}
+	if r.count.RawValue != 100 {
+		t.Errorf("read from another goroutine got %+v, want 100 events", r.count)
+	}
+
+	// The target goroutine still uses RDPMC.
+	if _, err := c.ReadOne(); err != nil {
+		t.Fatal(err)
+	}
+	if c.LastReadPath() != ReadPathRDPMC {
+		t.Errorf("read from target goroutine using %s, want %s", c.LastReadPath(), ReadPathRDPMC)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"github.com/aclements/go-perfevent/events"
+)
+
+// A KVMCounter counts the activity of a KVM vCPU thread, split between running
+// the guest and running the host, such as when handling VM exits. This is
+// useful for measuring the virtualization overhead of a guest from the host.
+type KVMCounter struct {
+	c      *Counter
+	counts []Count
+}
+
+// A KVMCount is the value of a [KVMCounter].
+type KVMCount struct {
+	GuestCycles Count // CPU cycles spent running the guest
+	HostCycles  Count // CPU cycles spent in the host
+
+	// Exits is the number of VM exits. This is zero if the kvm:kvm_exit
+	// tracepoint isn't available.
+	Exits Count
+}
+
+// OpenKVMCounter returns a new KVMCounter for target, which is typically
+// [TargetThread] of a vCPU thread of a virtual machine monitor such as QEMU.
+// Callers are expected to call [KVMCounter.Close] when done.
+//
+// The counter is initially not running. Call [KVMCounter.Start] to start it.
+func OpenKVMCounter(target Target) (*KVMCounter, error) {
+	const all = events.PrivUser | events.PrivKernel | events.PrivHypervisor
+	evs := []events.Event{
+		events.WithPrivilege(events.EventCPUCycles, all|events.PrivGuest),
+		events.WithPrivilege(events.EventCPUCycles, all|events.PrivHost),
+	}
+	if exit, err := events.ParseEvent("kvm:kvm_exit"); err == nil {
+		evs = append(evs, exit)
+	}
+	c, err := OpenCounter(target, evs...)
+	if err != nil {
+		return nil, err
+	}
+	return &KVMCounter{c: c, counts: make([]Count, len(evs))}, nil
+}
+
+// Close closes the counter.
+func (k *KVMCounter) Close() {
+	k.c.Close()
+}
+
+// Start the counter.
+func (k *KVMCounter) Start() {
+	k.c.Start()
+}
+
+// Stop the counter.
+func (k *KVMCounter) Stop() {
+	k.c.Stop()
+}
+
+// Reset the counts to zero.
+func (k *KVMCounter) Reset() error {
+	return k.c.Reset()
+}
+
+// Read returns the current counts.
+func (k *KVMCounter) Read() (KVMCount, error) {
+	if err := k.c.ReadGroup(k.counts); err != nil {
+		return KVMCount{}, err
+	}
+	kc := KVMCount{GuestCycles: k.counts[0], HostCycles: k.counts[1]}
+	if len(k.counts) > 2 {
+		kc.Exits = k.counts[2]
+	}
+	return kc, nil
+}
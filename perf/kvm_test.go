@@ -0,0 +1,40 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"testing"
+)
+
+func TestKVMCounter(t *testing.T) {
+	fk := useFakeKernel(t)
+	k, err := OpenKVMCounter(TargetThisGoroutine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	for i, want := range []string{"cpu-cycles:G", "cpu-cycles:H"} {
+		if got := AttrString(&fk.fakeEventFor(t, k.c, i).attr); got != want {
+			t.Errorf("event %d: got %s, want %s", i, got, want)
+		}
+	}
+
+	k.Start()
+	fk.advance(100)
+	k.Stop()
+	kc, err := k.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kc.GuestCycles.RawValue != 100 || kc.HostCycles.RawValue != 100 {
+		t.Errorf("got %+v, want 100 guest and host cycles", kc)
+	}
+	if len(k.counts) == 2 && kc.Exits != (Count{}) {
+		t.Errorf("got exits %+v without kvm:kvm_exit, want zero", kc.Exits)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// LabelCounters attributes counts of a set of events to [runtime/pprof] label
+// sets. Each call to [LabelCounters.Do] measures the events on the calling
+// goroutine while running a function with a set of labels, and adds the
+// counts to the total for that label set.
+//
+// This is useful for services that already use pprof labels to identify
+// requests or tenants, since it requires no changes beyond replacing calls to
+// [pprof.Do].
+//
+// It is safe to call methods on LabelCounters from multiple goroutines.
+type LabelCounters struct {
+	evs []events.Event
+
+	mu     sync.Mutex
+	totals map[string]*LabelCount // Keyed by canonical label string
+}
+
+// LabelCount is the total count of a set of events while running with a
+// given label set.
+type LabelCount struct {
+	// Labels is the complete set of pprof labels, including any inherited
+	// from the context passed to [LabelCounters.Do].
+	Labels map[string]string
+
+	// Counts is the total count of each event, in the order they were passed
+	// to [NewLabelCounters].
+	Counts []Count
+
+	// Calls is the number of calls to [LabelCounters.Do] with this label set.
+	Calls int
+}
+
+// NewLabelCounters returns a LabelCounters that counts the given events. The
+// events are opened as a group.
+func NewLabelCounters(evs ...events.Event) *LabelCounters {
+	return &LabelCounters{evs: evs, totals: make(map[string]*LabelCount)}
+}
+
+// Do calls [pprof.Do] with ctx, labels, and f, and adds the counts of the
+// events while running f to the total for f's complete label set.
+//
+// Do opens a new [Counter] on the calling goroutine for each call, so it adds
+// the overhead of several system calls. This is fine for coarse-grained
+// regions, such as handling a request, but too expensive for tight loops.
+//
+// The counters are inclusive: if f calls Do again with more labels, those
+// counts are attributed to both the inner and outer label sets.
+//
+// If the counter cannot be opened or read, Do still runs f, but returns the
+// error.
+func (lc *LabelCounters) Do(ctx context.Context, labels pprof.LabelSet, f func(context.Context)) error {
+	var err error
+	pprof.Do(ctx, labels, func(ctx context.Context) {
+		var c *Counter
+		c, err = OpenCounter(TargetThisGoroutine, lc.evs...)
+		if err != nil {
+			f(ctx)
+			return
+		}
+		defer c.Close()
+
+		c.Start()
+		f(ctx)
+		c.Stop()
+
+		cs := make([]Count, len(lc.evs))
+		if err = c.ReadGroup(cs); err != nil {
+			return
+		}
+		lc.add(ctx, cs)
+	})
+	return err
+}
+
+func (lc *LabelCounters) add(ctx context.Context, cs []Count) {
+	var keys []string
+	m := make(map[string]string)
+	pprof.ForLabels(ctx, func(k, v string) bool {
+		m[k] = v
+		keys = append(keys, k)
+		return true
+	})
+	slices.Sort(keys)
+	var sb strings.Builder
+	for _, k := range keys {
+		// Quote to avoid ambiguity if labels contain our separators.
+		fmt.Fprintf(&sb, "%q=%q,", k, m[k])
+	}
+	key := sb.String()
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if total, ok := lc.totals[key]; ok {
+		for i := range cs {
+			total.Counts[i] = total.Counts[i].Add(cs[i])
+		}
+		total.Calls++
+	} else {
+		lc.totals[key] = &LabelCount{Labels: m, Counts: cs, Calls: 1}
+	}
+}
+
+// Counts returns the total counts for each label set seen so far, sorted by
+// label set.
+func (lc *LabelCounters) Counts() []LabelCount {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	keys := make([]string, 0, len(lc.totals))
+	for k := range lc.totals {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	out := make([]LabelCount, 0, len(keys))
+	for _, k := range keys {
+		t := lc.totals[k]
+		out = append(out, LabelCount{maps.Clone(t.Labels), slices.Clone(t.Counts), t.Calls})
+	}
+	return out
+}
+
+// Reset discards all accumulated counts.
+func (lc *LabelCounters) Reset() {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	clear(lc.totals)
+}
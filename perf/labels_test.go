@@ -0,0 +1,68 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"context"
+	"runtime/pprof"
+	"testing"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestLabelCounters(t *testing.T) {
+	lc := NewLabelCounters(events.EventCPUCycles, events.EventTaskClock)
+	ctx := context.Background()
+	spin := func(n int) func(context.Context) {
+		return func(context.Context) {
+			for i := 0; i < n; i++ {
+			}
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := lc.Do(ctx, pprof.Labels("op", "a"), spin(100000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := lc.Do(ctx, pprof.Labels("op", "b"), func(ctx context.Context) {
+		// Test nested labels.
+		if err := lc.Do(ctx, pprof.Labels("sub", "c"), spin(100000)); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := lc.Counts()
+	type want struct {
+		labels string
+		calls  int
+	}
+	wants := []want{{"op=a", 2}, {"op=b", 1}, {"op=b,sub=c", 1}}
+	if len(counts) != len(wants) {
+		t.Fatalf("got %d label sets, want %d: %+v", len(counts), len(wants), counts)
+	}
+	for i, w := range wants {
+		got := counts[i]
+		labels := ""
+		for _, k := range []string{"op", "sub"} {
+			if v, ok := got.Labels[k]; ok {
+				if labels != "" {
+					labels += ","
+				}
+				labels += k + "=" + v
+			}
+		}
+		if labels != w.labels || got.Calls != w.calls {
+			t.Errorf("label set %d: got %s × %d, want %s × %d", i, labels, got.Calls, w.labels, w.calls)
+		}
+		if got.Counts[0].RawValue == 0 {
+			t.Errorf("label set %s: zero cycles", labels)
+		}
+	}
+}
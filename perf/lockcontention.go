@@ -0,0 +1,192 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// LockContention profiles contention on kernel locks, such as the mutexes,
+// rwsems, and spinlocks behind futexes, mmap_lock, and file systems. It
+// measures how long threads wait for each lock and groups the waits by the
+// stack that waited. This is the analysis done by "perf lock contention".
+//
+// LockContention computes this from samples of the lock:contention_begin and
+// lock:contention_end tracepoints (see [LockContention.Events]), which were
+// added in Linux 5.19. These must be sampled for the threads of interest,
+// recording every occurrence (a Period of 1) with a sample type that includes
+// SampleRaw, SampleTID, SampleTime, and SampleCallchain, and each sample
+// passed to [LockContention.Add].
+type LockContention struct {
+	evs     []events.Event
+	formats map[uint64]*events.TracepointFormat // By tracepoint ID
+	beginID uint64
+
+	pending []lockEvent
+	waiting map[uint32]lockEvent // Waiting contention_begin, by TID
+	stacks  map[string]*LockContentionStack
+	key     []byte
+}
+
+// A lockEvent is a decoded lock tracepoint sample.
+type lockEvent struct {
+	time      uint64
+	tid       uint32
+	isBegin   bool
+	flags     LockFlags
+	callchain []uint64
+}
+
+// A LockContentionStack is the contention on kernel locks at one stack.
+type LockContentionStack struct {
+	// Callchain is the stack that waited for the lock, as recorded by
+	// the contention_begin tracepoint.
+	Callchain []uint64
+
+	// Flags is the type of the lock.
+	Flags LockFlags
+
+	// Dist is the distribution of the time spent waiting.
+	Dist LatencyDist
+}
+
+// LockFlags is a set of LCB_F_* flags, which describe the type of a contended
+// kernel lock.
+type LockFlags uint32
+
+const (
+	LockSpin   LockFlags = 1 << iota // Spinning rather than sleeping
+	LockRead                         // Reader side of a rwlock or rwsem
+	LockWrite                        // Writer side of a rwlock or rwsem
+	LockRT                           // rtmutex
+	LockPerCPU                       // percpu-rwsem
+	LockMutex                        // mutex
+)
+
+var lockFlagNames = []string{"SPIN", "READ", "WRITE", "RT", "PERCPU", "MUTEX"}
+
+// String returns the flags in f in the form "SPIN|MUTEX".
+func (f LockFlags) String() string {
+	return flagsString(uint64(f), lockFlagNames)
+}
+
+// NewLockContention returns a new LockContention with no samples. It reads
+// the formats of the lock tracepoints from tracefs.
+func NewLockContention() (*LockContention, error) {
+	evs, formats, err := openTracepoints("lock:contention_begin", "lock:contention_end")
+	if err != nil {
+		return nil, err
+	}
+	l, err := newLockContention(formats[0], formats[1])
+	if err != nil {
+		return nil, err
+	}
+	l.evs = evs
+	return l, nil
+}
+
+func newLockContention(begin, end *events.TracepointFormat) (*LockContention, error) {
+	if !hasFields(begin, "flags") {
+		return nil, fmt.Errorf("tracepoint %s is missing flags field", begin.Name)
+	}
+	return &LockContention{
+		formats: map[uint64]*events.TracepointFormat{begin.ID: begin, end.ID: end},
+		beginID: begin.ID,
+		waiting: make(map[uint32]lockEvent),
+		stacks:  make(map[string]*LockContentionStack),
+	}, nil
+}
+
+// Events returns the tracepoint events whose samples l expects.
+func (l *LockContention) Events() []events.Event {
+	return l.evs
+}
+
+// Add adds a sample of one of the lock tracepoints. It ignores samples of
+// other events.
+//
+// Samples from different CPUs may be added in any order, as long as all of
+// the samples up to some time have been added before calling
+// [LockContention.Stacks].
+func (l *LockContention) Add(s *Sample) error {
+	// Every tracepoint's raw data starts with its ID in common_type.
+	if len(s.Raw) < 2 {
+		return fmt.Errorf("sample has no raw tracepoint data")
+	}
+	id := uint64(binary.NativeEndian.Uint16(s.Raw))
+	f, ok := l.formats[id]
+	if !ok {
+		return nil
+	}
+	ev := lockEvent{time: s.Time, tid: s.TID}
+	if id == l.beginID {
+		fields, err := f.Decode(s.Raw)
+		if err != nil {
+			return err
+		}
+		ev.isBegin = true
+		ev.flags = LockFlags(intField(fields, "flags"))
+		ev.callchain = slices.Clone(s.Callchain)
+	}
+	l.pending = append(l.pending, ev)
+	return nil
+}
+
+// process processes the pending events in time order.
+func (l *LockContention) process() {
+	slices.SortStableFunc(l.pending, func(a, b lockEvent) int {
+		return cmp.Compare(a.time, b.time)
+	})
+	for _, ev := range l.pending {
+		if ev.isBegin {
+			// Some locks begin contention again without ending
+			// it, such as a mutex that spins and then sleeps.
+			// Count from the first.
+			if _, ok := l.waiting[ev.tid]; !ok {
+				l.waiting[ev.tid] = ev
+			}
+			continue
+		}
+
+		begin, ok := l.waiting[ev.tid]
+		if !ok {
+			continue
+		}
+		delete(l.waiting, ev.tid)
+		l.key = binary.NativeEndian.AppendUint32(l.key[:0], uint32(begin.flags))
+		for _, pc := range begin.callchain {
+			l.key = binary.NativeEndian.AppendUint64(l.key, pc)
+		}
+		st, ok := l.stacks[string(l.key)]
+		if !ok {
+			st = &LockContentionStack{Callchain: begin.callchain, Flags: begin.flags}
+			l.stacks[string(l.key)] = st
+		}
+		st.Dist.add(time.Duration(ev.time - begin.time))
+	}
+	l.pending = l.pending[:0]
+}
+
+// Stacks returns the contention at each stack, sorted from the most to the
+// least total time waiting.
+func (l *LockContention) Stacks() []*LockContentionStack {
+	l.process()
+	stacks := make([]*LockContentionStack, 0, len(l.stacks))
+	for _, st := range l.stacks {
+		stacks = append(stacks, st)
+	}
+	slices.SortFunc(stacks, func(a, b *LockContentionStack) int {
+		return cmp.Compare(b.Dist.Total, a.Dist.Total)
+	})
+	return stacks
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+var (
+	testBeginFormat = &events.TracepointFormat{Name: "contention_begin", ID: 400, Fields: []events.TracepointField{
+		{Name: "common_type", Type: "unsigned short", Offset: 0, Size: 2},
+		{Name: "lock_addr", Type: "void *", Offset: 8, Size: 8},
+		{Name: "flags", Type: "unsigned int", Offset: 16, Size: 4},
+	}}
+	testEndFormat = &events.TracepointFormat{Name: "contention_end", ID: 401, Fields: []events.TracepointField{
+		{Name: "common_type", Type: "unsigned short", Offset: 0, Size: 2},
+		{Name: "lock_addr", Type: "void *", Offset: 8, Size: 8},
+		{Name: "ret", Type: "int", Offset: 16, Size: 4, Signed: true},
+	}}
+)
+
+func testContentionBegin(time uint64, tid uint32, flags LockFlags, callchain ...uint64) *Sample {
+	raw := make([]byte, 24)
+	binary.NativeEndian.PutUint16(raw, 400)
+	binary.NativeEndian.PutUint32(raw[16:], uint32(flags))
+	return &Sample{Time: time, TID: tid, Raw: raw, Callchain: callchain}
+}
+
+func testContentionEnd(time uint64, tid uint32) *Sample {
+	raw := make([]byte, 24)
+	binary.NativeEndian.PutUint16(raw, 401)
+	return &Sample{Time: time, TID: tid, Raw: raw}
+}
+
+func TestLockContention(t *testing.T) {
+	l, err := newLockContention(testBeginFormat, testEndFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := []*Sample{
+		// Thread 1 waits on a mutex twice from the same stack, the
+		// first time spinning and then sleeping.
+		testContentionBegin(100, 1, LockSpin|LockMutex, 0x10, 0x20),
+		testContentionBegin(150, 1, LockMutex, 0x10, 0x30),
+		testContentionEnd(200, 1),
+		testContentionBegin(1000, 1, LockSpin|LockMutex, 0x10, 0x20),
+		testContentionEnd(1500, 1),
+		// Thread 2 waits on a rwsem, with samples out of order.
+		testContentionEnd(2000, 2),
+		testContentionBegin(1000, 2, LockRead, 0x40),
+		// An end without a begin is ignored.
+		testContentionEnd(3000, 3),
+	}
+	for _, s := range samples {
+		if err := l.Add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The Sampler may reuse the callchain.
+	samples[0].Callchain[0] = 0
+
+	stacks := l.Stacks()
+	if len(stacks) != 2 {
+		t.Fatalf("got %d stacks, want 2", len(stacks))
+	}
+	st := stacks[0]
+	if !slices.Equal(st.Callchain, []uint64{0x40}) || st.Flags != LockRead || st.Dist.Count != 1 || st.Dist.Total != 1000 {
+		t.Errorf("stack 0: got %v %s %+v, want [0x40] READ with 1 wait of 1000ns", st.Callchain, st.Flags, st.Dist)
+	}
+	st = stacks[1]
+	if !slices.Equal(st.Callchain, []uint64{0x10, 0x20}) || st.Flags != LockSpin|LockMutex || st.Dist.Count != 2 || st.Dist.Total != 600 {
+		t.Errorf("stack 1: got %v %s %+v, want [0x10 0x20] SPIN|MUTEX with 2 waits totaling 600ns", st.Callchain, st.Flags, st.Dist)
+	}
+}
+
+func TestLockFlagsString(t *testing.T) {
+	for f, want := range map[LockFlags]string{
+		0:                    "0",
+		LockSpin | LockMutex: "SPIN|MUTEX",
+		LockWrite | 0x100:    "WRITE|0x100",
+	} {
+		if got := f.String(); got != want {
+			t.Errorf("%#x.String() = %q, want %q", uint32(f), got, want)
+		}
+	}
+}
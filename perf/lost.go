@@ -0,0 +1,98 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"fmt"
+	"slices"
+)
+
+// A LostRecord is a decoded [RecordLost] or [RecordLostSamples] record.
+// [Sampler.Lost] summarizes these records.
+type LostRecord struct {
+	// Samples indicates a RecordLostSamples record, which reports samples
+	// that were dropped before they reached the ring buffer, for example
+	// by hardware sampling such as Intel's PEBS. Otherwise, this is a
+	// RecordLost record, which reports records that were dropped because
+	// the ring buffer was full.
+	Samples bool
+
+	ID       uint64 // The event's ID (RecordLost only)
+	Lost     uint64 // The number of records or samples lost
+	RecordID RecordID
+}
+
+// DecodeLost decodes rec, which must be a [RecordLost] or [RecordLostSamples]
+// record, according to format, which must be the format the record was
+// produced with (see [Sampler.SampleFormat]).
+func DecodeLost(rec RawRecord, format SampleFormat) (LostRecord, error) {
+	if rec.Type != RecordLost && rec.Type != RecordLostSamples {
+		return LostRecord{}, fmt.Errorf("cannot decode %s record as a lost record", rec.Type)
+	}
+	body, id, err := decodeRecordID(rec.Data, format)
+	if err != nil {
+		return LostRecord{}, err
+	}
+	d := sampleDecoder{data: body}
+	r := LostRecord{Samples: rec.Type == RecordLostSamples, RecordID: id}
+	if !r.Samples {
+		r.ID = d.u64()
+	}
+	r.Lost = d.u64()
+	if d.short {
+		return LostRecord{}, errShortRecord
+	}
+	return r, nil
+}
+
+// LostStats summarizes the records and samples a [Sampler] lost. If Records is
+// non-zero, the profile is missing data because the ring buffer overflowed,
+// so consider reading it more often or increasing [SamplerOptions.RingSize].
+type LostStats struct {
+	// Records is the number of records lost because the ring buffer was
+	// full.
+	Records uint64
+
+	// Samples is the number of samples dropped before they reached the
+	// ring buffer.
+	Samples uint64
+
+	// PerCPU is the sum of Records and Samples lost on each CPU, indexed
+	// by CPU number. This is only recorded if the sample type includes
+	// SampleCPU.
+	PerCPU []uint64
+}
+
+// Lost returns a summary of the lost and lost samples records the Sampler has
+// read. This includes records read by any method, such as
+// [Sampler.ReadSamples] and [Sampler.ReadRecord].
+func (s *Sampler) Lost() LostStats {
+	l := s.lost
+	l.PerCPU = slices.Clone(l.PerCPU)
+	return l
+}
+
+// noteLost updates s's lost statistics from rec, which must be a RecordLost or
+// RecordLostSamples record.
+func (s *Sampler) noteLost(rec RawRecord) {
+	r, err := DecodeLost(rec, s.format)
+	if err != nil {
+		return
+	}
+	if r.Samples {
+		s.lost.Samples += r.Lost
+	} else {
+		s.lost.Records += r.Lost
+	}
+	if s.format.SampleType&SampleCPU != 0 {
+		cpu := int(r.RecordID.CPU)
+		if cpu >= len(s.lost.PerCPU) {
+			s.lost.PerCPU = slices.Grow(s.lost.PerCPU, cpu+1-len(s.lost.PerCPU))[:cpu+1]
+		}
+		s.lost.PerCPU[cpu] += r.Lost
+	}
+}
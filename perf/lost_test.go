@@ -0,0 +1,118 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestDecodeLost(t *testing.T) {
+	format := SampleFormat{SampleType: SampleIP | SampleCPU, SampleIDAll: true}
+	var data []byte
+	data = binary.NativeEndian.AppendUint64(data, 7)  // id
+	data = binary.NativeEndian.AppendUint64(data, 42) // lost
+	data = binary.NativeEndian.AppendUint32(data, 3)  // cpu
+	data = binary.NativeEndian.AppendUint32(data, 0)  // res
+	got, err := DecodeLost(RawRecord{Type: RecordLost, Data: data}, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LostRecord{ID: 7, Lost: 42, RecordID: RecordID{CPU: 3}}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// LOST_SAMPLES records don't have an ID.
+	got, err = DecodeLost(RawRecord{Type: RecordLostSamples, Data: data[8:]}, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = LostRecord{Samples: true, Lost: 42, RecordID: RecordID{CPU: 3}}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := DecodeLost(RawRecord{Type: RecordLost, Data: data[8:]}, format); err != errShortRecord {
+		t.Errorf("truncated record: got %v, want %v", err, errShortRecord)
+	}
+}
+
+func TestSamplerLostFake(t *testing.T) {
+	k := useFakeKernel(t)
+	opts := SamplerOptions{SampleType: SampleIP | SampleCPU}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ev := k.events[s.fd]
+
+	lost := func(typ RecordType, n uint64, cpu uint32) {
+		var data []byte
+		if typ == RecordLost {
+			data = binary.NativeEndian.AppendUint64(data, 0)
+		}
+		data = binary.NativeEndian.AppendUint64(data, n)
+		data = binary.NativeEndian.AppendUint32(data, cpu)
+		data = binary.NativeEndian.AppendUint32(data, 0)
+		ev.writeRecord(typ, 0, data)
+	}
+	lost(RecordLost, 10, 2)
+	lost(RecordLost, 5, 0)
+	lost(RecordLostSamples, 1, 2)
+	s.ReadSamples(func(*Sample) bool { return true })
+
+	want := LostStats{Records: 15, Samples: 1, PerCPU: []uint64{5, 0, 11}}
+	if got := s.Lost(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestSamplerLost(t *testing.T) {
+	// Overflow a small ring buffer.
+	opts := SamplerOptions{
+		SampleType: SampleIP | SampleTID | SampleCPU,
+		Period:     50000, // 50µs of task-clock
+		RingSize:   RingSizeConfig{Pages: 1},
+	}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	spin := func() {
+		start := time.Now()
+		for time.Since(start) < 30*time.Millisecond {
+		}
+	}
+	s.Start()
+	spin()
+	// The kernel writes the lost record once there's space again.
+	s.ReadSamples(func(*Sample) bool { return true })
+	spin()
+	s.Stop()
+	s.ReadSamples(func(*Sample) bool { return true })
+
+	l := s.Lost()
+	t.Logf("%+v", l)
+	if l.Records == 0 {
+		t.Fatalf("no lost records")
+	}
+	var perCPU uint64
+	for _, n := range l.PerCPU {
+		perCPU += n
+	}
+	if perCPU != l.Records+l.Samples {
+		t.Errorf("per-CPU lost counts sum to %d, want %d", perCPU, l.Records+l.Samples)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A NUMAMap maps physical addresses to NUMA nodes. Together with
+// [SamplePhysAddr] and [SampleDataSrc], this can find memory accesses that
+// go to a remote node.
+type NUMAMap struct {
+	blockSize uint64
+	nodes     map[uint64]int // Memory block number -> node
+}
+
+// ReadNUMAMap reads the current assignment of physical memory to NUMA nodes
+// from /sys/devices/system. This can change if memory is hot-plugged.
+func ReadNUMAMap() (*NUMAMap, error) {
+	return readNUMAMap(os.DirFS("/sys/devices/system"))
+}
+
+func readNUMAMap(sysfs fs.FS) (*NUMAMap, error) {
+	// Physical memory is divided into fixed-size blocks, and each node
+	// directory links to the blocks on that node.
+	data, err := fs.ReadFile(sysfs, "memory/block_size_bytes")
+	if err != nil {
+		return nil, err
+	}
+	blockSize, err := strconv.ParseUint(strings.TrimSpace(string(data)), 16, 64)
+	if err != nil || blockSize == 0 {
+		return nil, fmt.Errorf("bad memory block size %q", data)
+	}
+
+	m := &NUMAMap{blockSize: blockSize, nodes: make(map[uint64]int)}
+	nodeEnts, err := fs.ReadDir(sysfs, "node")
+	if err != nil {
+		return nil, err
+	}
+	for _, nodeEnt := range nodeEnts {
+		node, err := strconv.Atoi(strings.TrimPrefix(nodeEnt.Name(), "node"))
+		if err != nil || !strings.HasPrefix(nodeEnt.Name(), "node") {
+			continue
+		}
+		ents, err := fs.ReadDir(sysfs, "node/"+nodeEnt.Name())
+		if err != nil {
+			return nil, err
+		}
+		for _, ent := range ents {
+			block, err := strconv.ParseUint(strings.TrimPrefix(ent.Name(), "memory"), 10, 64)
+			if err != nil || !strings.HasPrefix(ent.Name(), "memory") {
+				continue
+			}
+			m.nodes[block] = node
+		}
+	}
+	return m, nil
+}
+
+// Node returns the NUMA node containing physical address addr, or -1 if
+// unknown. A physical address of 0 is always unknown, since that's what the
+// kernel reports for samples with no physical address.
+func (m *NUMAMap) Node(addr uint64) int {
+	if addr == 0 {
+		return -1
+	}
+	if node, ok := m.nodes[addr/m.blockSize]; ok {
+		return node
+	}
+	return -1
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestNUMAMap(t *testing.T) {
+	sysfs := fstest.MapFS{
+		"memory/block_size_bytes": {Data: []byte("8000000\n")},
+		"node/online":             {Data: []byte("0-1\n")},
+		"node/node0/cpu0":         {},
+		"node/node0/memory0":      {},
+		"node/node0/memory1":      {},
+		"node/node1/memory2":      {},
+		"node/node1/memory5":      {},
+	}
+	m, err := readNUMAMap(sysfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const block = 0x8000000
+	for _, tc := range []struct {
+		addr uint64
+		want int
+	}{
+		{0, -1},
+		{0x1000, 0},
+		{2*block - 1, 0},
+		{2 * block, 1},
+		{3 * block, -1}, // Not present
+		{5*block + 0x1234, 1},
+	} {
+		if got := m.Node(tc.addr); got != tc.want {
+			t.Errorf("Node(%#x) = %d, want %d", tc.addr, got, tc.want)
+		}
+	}
+}
+
+func TestReadNUMAMap(t *testing.T) {
+	m, err := ReadNUMAMap()
+	if err != nil {
+		t.Skip(err)
+	}
+	if len(m.nodes) == 0 {
+		t.Errorf("no memory blocks found")
+	}
+}
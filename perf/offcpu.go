@@ -0,0 +1,137 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"io"
+	"slices"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// An OffCPUProfiler profiles where a thread spends time off CPU, such as
+// blocked in system calls, waiting on locks, or waiting to run after being
+// preempted. This complements CPU profiles, which only see time on CPU.
+//
+// It samples every context switch out of the thread, which records the stack
+// where the thread stopped running, and uses [SamplerOptions.ContextSwitch]
+// records to find when the thread started running again. Each such interval
+// becomes a profile sample weighted by the time the thread was off CPU.
+type OffCPUProfiler struct {
+	s       *Sampler
+	pending map[uint32]offCPUSwitch // By TID
+
+	profile *ProfileBuilder
+	folded  *FoldedStacks
+}
+
+// offCPUSwitch is a switch out of a thread that hasn't switched back in.
+type offCPUSwitch struct {
+	time      uint64
+	callchain []uint64
+}
+
+// offCPUEvent names the value of off-CPU profiles.
+type offCPUEvent struct{ events.Event }
+
+func (offCPUEvent) String() string               { return "off-cpu" }
+func (offCPUEvent) ScaleUnit() (float64, string) { return 1, "nanoseconds" }
+
+// OpenOffCPUProfiler returns a new OffCPUProfiler for target. If sym is
+// non-nil, it is used to symbolize the profiles. Callers are expected to call
+// [OffCPUProfiler.Close] when done.
+//
+// The profiler is initially not running. Call [OffCPUProfiler.Start] to start
+// it.
+func OpenOffCPUProfiler(target Target, sym *SelfSymbolizer) (*OffCPUProfiler, error) {
+	opts := SamplerOptions{
+		SampleType:    SampleTID | SampleTime | SampleCallchain,
+		Period:        1,
+		ContextSwitch: true,
+	}
+	s, err := opts.OpenSampler(target, events.EventContextSwitches)
+	if err != nil {
+		return nil, err
+	}
+	return &OffCPUProfiler{
+		s:       s,
+		pending: make(map[uint32]offCPUSwitch),
+		profile: NewProfileBuilder(ProfileConfig{Event: offCPUEvent{events.EventContextSwitches}, Symbolizer: sym}),
+		folded:  NewFoldedStacks(sym),
+	}, nil
+}
+
+// Close closes the profiler.
+func (p *OffCPUProfiler) Close() {
+	p.s.Close()
+}
+
+// Start the profiler.
+func (p *OffCPUProfiler) Start() {
+	p.s.Start()
+}
+
+// Stop the profiler. Call [OffCPUProfiler.Read] to process the intervals
+// recorded before it stopped.
+func (p *OffCPUProfiler) Stop() {
+	p.s.Stop()
+}
+
+// Read processes the records in the profiler's ring buffer. For each
+// completed off-CPU interval, it adds a sample to the profiles and, if f is
+// non-nil, calls f with a Sample whose Callchain is the stack where the thread
+// stopped running, Time is when it stopped, and Period is how long it was off
+// CPU, in nanoseconds. If f returns false, Read stops processing records.
+//
+// Read must be called often enough to keep the ring buffer from filling up.
+// Intervals that haven't ended are kept until a later Read.
+func (p *OffCPUProfiler) Read(f func(s *Sample) bool) error {
+	return p.s.ReadSamplesAndSideBand(func(s *Sample) bool {
+		p.pending[s.TID] = offCPUSwitch{s.Time, slices.Clone(s.Callchain)}
+		return true
+	}, func(r SideBandRecord) bool {
+		sw, ok := r.(*SwitchRecord)
+		if !ok || sw.Out {
+			return true
+		}
+		id := sw.ID()
+		out, ok := p.pending[id.TID]
+		if !ok {
+			// The thread switched out before the profiler started.
+			return true
+		}
+		delete(p.pending, id.TID)
+		if id.Time < out.time {
+			return true
+		}
+		s := &Sample{
+			PID:       id.PID,
+			TID:       id.TID,
+			Time:      out.time,
+			Period:    id.Time - out.time,
+			Callchain: out.callchain,
+		}
+		p.profile.Add(s)
+		p.folded.AddWeight(s, s.Period)
+		if f != nil {
+			return f(s)
+		}
+		return true
+	})
+}
+
+// WriteProfile writes the off-CPU intervals read so far to w as a pprof
+// profile. Its values are the number of intervals and their total time.
+func (p *OffCPUProfiler) WriteProfile(w io.Writer) error {
+	return p.profile.Write(w)
+}
+
+// WriteFolded writes the off-CPU intervals read so far to w as folded stacks,
+// weighted by their time in nanoseconds.
+func (p *OffCPUProfiler) WriteFolded(w io.Writer) error {
+	return p.folded.Write(w)
+}
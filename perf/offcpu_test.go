@@ -0,0 +1,80 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// framePointers reports whether Go code on this architecture maintains frame
+// pointers, which the kernel uses to unwind user stacks.
+const framePointers = runtime.GOARCH == "amd64" || runtime.GOARCH == "arm64"
+
+func TestOffCPUProfiler(t *testing.T) {
+	sym, err := NewSelfSymbolizer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := OpenOffCPUProfiler(TargetThisGoroutine, sym)
+	if err != nil {
+		t.Skipf("opening off-CPU profiler: %v", err)
+	}
+	defer p.Close()
+
+	const sleep = 5 * time.Millisecond
+	p.Start()
+	ts := unix.NsecToTimespec(int64(sleep))
+	for i := 0; i < 4; i++ {
+		unix.Nanosleep(&ts, nil)
+	}
+	p.Stop()
+
+	tid := uint32(unix.Gettid())
+	var n int
+	var total time.Duration
+	err = p.Read(func(s *Sample) bool {
+		if s.TID != tid {
+			t.Errorf("got sample for TID %d, want %d", s.TID, tid)
+		}
+		if len(s.Callchain) == 0 {
+			t.Errorf("sample has no callchain")
+		}
+		n++
+		total += time.Duration(s.Period)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n < 4 || total < 4*sleep {
+		t.Errorf("got %d off-CPU intervals totaling %v, want at least 4 totaling at least %v", n, total, 4*sleep)
+	}
+
+	var buf bytes.Buffer
+	if err := p.WriteFolded(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// Without frame pointers, the kernel can only unwind the user stack to
+	// the innermost Go frame, which is in the runtime's system call wrapper.
+	if framePointers && !strings.Contains(buf.String(), "TestOffCPUProfiler") {
+		t.Errorf("folded stacks don't mention TestOffCPUProfiler:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := p.WriteProfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() == 0 {
+		t.Errorf("empty pprof profile")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// CounterOptions configures how a [Counter] is opened and read. The zero value
+// is the default configuration used by [OpenCounter].
+type CounterOptions struct {
+	// StrictMultiplexing, if true, causes [Counter.ReadOne] and
+	// [Counter.ReadGroup] to return an error wrapping [ErrMultiplexed] if the
+	// counter was multiplexed. The Counts are still filled in.
+	StrictMultiplexing bool
+
+	// OnMultiplexed, if non-nil, is called by [Counter.ReadOne] and
+	// [Counter.ReadGroup] if the counter was multiplexed, with the Counts that
+	// were read. This is called before the read returns, regardless of
+	// StrictMultiplexing.
+	OnMultiplexed func(cs []Count)
+
+	// ExcludeKernel, if true, counts events only while the target is running
+	// in user space, overriding the privilege levels requested by the events.
+	// This also excludes the hypervisor. Unprivileged processes can typically
+	// only count user space anyway (see /proc/sys/kernel/perf_event_paranoid).
+	ExcludeKernel bool
+
+	// NoSampling, if true, clears any sample period or frequency requested by
+	// the events, such as with a "period=" parameter. A Counter never reads
+	// samples, but a sample period still causes the kernel to take an
+	// interrupt each time the period elapses.
+	NoSampling bool
+}
+
+// LowOverheadCounting is a set of options for counters that are left open for
+// a long time, such as for the lifetime of a process. It counts only user
+// space and disables sampling, so the only costs while the counter is open
+// are saving and restoring the hardware counters when the target thread is
+// switched out and back in, plus the cost of reads. Counters never count
+// child threads (perf's "inherit" mode), which would add this cost to every
+// thread created by the target.
+//
+// The context switch cost depends heavily on the CPU and on whether it's
+// virtualized, since hypervisors often trap PMU accesses, so it's worth
+// measuring with BenchmarkContextSwitch in this package. Software events,
+// such as task-clock, are much cheaper to switch than hardware events.
+// Threads that aren't monitored pay no cost.
+//
+// To modify these options, copy LowOverheadCounting first:
+//
+//	opts := perf.LowOverheadCounting
+//	opts.StrictMultiplexing = true
+//	c, err := opts.OpenCounter(perf.TargetThisGoroutine, evs...)
+var LowOverheadCounting = CounterOptions{
+	ExcludeKernel: true,
+	NoSampling:    true,
+}
+
+// ErrMultiplexed indicates that a counter did not run for the whole time it
+// was enabled. See [Count.Multiplexed] and [CounterOptions.StrictMultiplexing].
+var ErrMultiplexed = errors.New("counter was multiplexed")
+
+// OpenCounter is like the top-level [OpenCounter] function, but uses the
+// options in o.
+func (o *CounterOptions) OpenCounter(target Target, evs ...events.Event) (*Counter, error) {
+	return openCounter(target, o, evs)
+}
+
+// setAttrs applies the options in o to the attributes of each event. o may be
+// nil.
+func (o *CounterOptions) setAttrs(attr *unix.PerfEventAttr) {
+	if o == nil {
+		return
+	}
+	if o.ExcludeKernel {
+		attr.Bits &^= unix.PerfBitExcludeUser
+		attr.Bits |= unix.PerfBitExcludeKernel | unix.PerfBitExcludeHv
+	}
+	if o.NoSampling {
+		attr.Sample = 0
+		attr.Bits &^= unix.PerfBitFreq
+	}
+}
+
+// multiplexed handles a read that observed multiplexing.
+func (c *Counter) multiplexed(cs []Count) error {
+	if c.opts.OnMultiplexed != nil {
+		c.opts.OnMultiplexed(cs)
+	}
+	if c.opts.StrictMultiplexing {
+		pct := 100 * float64(cs[0].TimeRunning) / float64(cs[0].TimeEnabled)
+		return fmt.Errorf("%w (running %.1f%% of enabled time)", ErrMultiplexed, pct)
+	}
+	return nil
+}
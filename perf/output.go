@@ -0,0 +1,45 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+var errOutputRedirected = errors.New("Sampler output is redirected to another Sampler")
+
+// ID returns the kernel's unique ID for s's sampled event. This is the value
+// of [Sample.Identifier] and [RecordID.ID] in records from this event, which
+// distinguishes its records from those of other Samplers sharing its ring
+// buffer (see [SamplerOptions.Output]).
+func (s *Sampler) ID() (uint64, error) {
+	if s == nil || s.f == nil {
+		return 0, fmt.Errorf("Sampler is closed")
+	}
+	return sys.eventID(s.fd)
+}
+
+// redirectOutput makes s write its records to to's ring buffer.
+func (s *Sampler) redirectOutput(to *Sampler) error {
+	if to.f == nil {
+		return fmt.Errorf("output Sampler is closed")
+	}
+	if to.mmap == nil {
+		return errOutputRedirected
+	}
+	f1, f2 := s.format, to.format
+	if f1.SampleType != f2.SampleType || f1.BranchSampleType != f2.BranchSampleType || f1.ReadFormat != f2.ReadFormat || len(f1.scales) != len(f2.scales) {
+		return fmt.Errorf("output Sampler has a different sample format")
+	}
+	if err := sys.ioctl(s.fd, unix.PERF_EVENT_IOC_SET_OUTPUT, to.fd); err != nil {
+		return fmt.Errorf("redirecting Sampler output: %w (both Samplers must monitor the same thread)", err)
+	}
+	return nil
+}
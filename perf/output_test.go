@@ -0,0 +1,108 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestSamplerOutput(t *testing.T) {
+	opts := SamplerOptions{
+		SampleType: SampleIdentifier | SampleIP,
+		Period:     100000, // 100µs
+	}
+	s1, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s1.Close()
+	opts.Output = s1
+	s2, err := opts.OpenSampler(TargetThisGoroutine, events.EventCPUClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+
+	id1, err := s1.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := s2.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id1 == id2 {
+		t.Fatalf("both Samplers have ID %d", id1)
+	}
+
+	s1.Start()
+	s2.Start()
+	start := time.Now()
+	for time.Since(start) < 20*time.Millisecond {
+	}
+	s2.Stop()
+	s1.Stop()
+
+	if _, ok := s2.ReadRecord(); ok {
+		t.Errorf("read record from redirected Sampler")
+	}
+	if err := s2.Wait(context.Background()); err != errOutputRedirected {
+		t.Errorf("Wait on redirected Sampler: got %v, want %v", err, errOutputRedirected)
+	}
+
+	counts := make(map[uint64]int)
+	err = s1.ReadSamples(func(s *Sample) bool {
+		counts[s.Identifier]++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[id1] == 0 || counts[id2] == 0 || len(counts) != 2 {
+		t.Errorf("got samples by ID %v, want samples from %d and %d", counts, id1, id2)
+	}
+
+	// Samplers can only share a ring buffer if they have the same format.
+	opts.SampleType |= SampleTime
+	if s3, err := opts.OpenSampler(TargetThisGoroutine, events.EventCPUClock); err == nil {
+		s3.Close()
+		t.Errorf("redirecting output with a different format: want error")
+	}
+}
+
+func TestSamplerOutputFake(t *testing.T) {
+	k := useFakeKernel(t)
+	opts := SamplerOptions{SampleType: SampleIP}
+	s1, err := opts.OpenSampler(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s1.Close()
+	opts.Output = s1
+	s2, err := opts.OpenSampler(TargetThisGoroutine, events.EventInstructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	if k.events[s2.fd].mmap != nil {
+		t.Errorf("redirected Sampler has a ring buffer")
+	}
+
+	ip := binary.NativeEndian.AppendUint64(nil, 0x1234)
+	k.events[s1.fd].writeRecord(RecordSample, 0, ip)
+	if _, ok := s2.ReadRecord(); ok {
+		t.Errorf("read record from redirected Sampler")
+	}
+	if rec, ok := s1.ReadRecord(); !ok || rec.Type != RecordSample {
+		t.Errorf("got %v, %v from output Sampler, want sample", rec, ok)
+	}
+}
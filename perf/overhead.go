@@ -0,0 +1,88 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import "math"
+
+// Overhead is the estimated measurement overhead of one event, as computed by
+// [Counter.EstimateOverhead]. The values are in the units of the event,
+// as returned by [Count.Value].
+type Overhead struct {
+	Mean float64
+	Min  float64
+	Unit string
+}
+
+// overheadRounds is the number of empty regions measured by
+// EstimateOverhead.
+const overheadRounds = 100
+
+// EstimateOverhead estimates how much of each of c's events is counted by
+// bracketing a region with [Counter.Start] and [Counter.Stop], which count
+// the end of the enabling system call and the start of the disabling one.
+// Reading a stopped counter doesn't count anything, so this doesn't include
+// the cost of [Counter.ReadGroup]. This is useful when measuring very small
+// regions, where this overhead can be a significant fraction of the counts.
+// It returns one Overhead for each event in c.
+//
+// This measures the overhead by repeatedly measuring an empty region on the
+// calling goroutine using a new Counter for the same events and with the same
+// options, so it doesn't disturb c's counts. However, if c is running, the two
+// Counters compete for the hardware and may be multiplexed. Multiplexing of
+// the new Counter is ignored rather than reported to
+// [CounterOptions.OnMultiplexed].
+//
+// The minimum is typically the best estimate of the overhead to subtract from
+// a measurement, since the mean includes occasional interference such as
+// interrupts.
+func (c *Counter) EstimateOverhead() ([]Overhead, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	// Measure the same configuration as c, such as whether it counts the
+	// kernel, but don't report on the probe counter.
+	opts := c.opts
+	opts.OnMultiplexed = nil
+	opts.StrictMultiplexing = false
+	tc, err := openCounter(TargetThisGoroutine, &opts, c.evs)
+	if err != nil {
+		return nil, err
+	}
+	defer tc.Close()
+
+	ohs := make([]Overhead, c.nEvents)
+	for i := range ohs {
+		ohs[i].Min = math.Inf(1)
+		ohs[i].Unit = c.eventScales[i].unit
+	}
+	before := make([]Count, c.nEvents)
+	after := make([]Count, c.nEvents)
+	// Run one extra round first to warm up caches.
+	for round := -1; round < overheadRounds; round++ {
+		if err := tc.ReadGroup(before); err != nil {
+			return nil, err
+		}
+		tc.Start()
+		tc.Stop()
+		if err := tc.ReadGroup(after); err != nil {
+			return nil, err
+		}
+		if round < 0 {
+			continue
+		}
+		for i := range ohs {
+			v, _ := after[i].Sub(before[i]).Value()
+			ohs[i].Mean += v
+			ohs[i].Min = min(ohs[i].Min, v)
+		}
+	}
+	for i := range ohs {
+		ohs[i].Mean /= overheadRounds
+	}
+	return ohs, nil
+}
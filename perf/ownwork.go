@@ -0,0 +1,79 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ownWork records when threads of this process run this package's own
+// monitoring and profiling work. Its spans all have ownWorkLabels.
+var ownWork = &SampleLabels{spans: make(map[uint32][]*labelSpan), maxSpans: ownWorkMaxSpans}
+
+var ownWorkLabels = map[string]string{}
+
+// ownWorkUsers is the number of open Samplers with
+// [SamplerOptions.ExcludeOwnWork]. DoOwnWork only records spans while this is
+// non-zero, so processes that don't exclude their own work don't pay for it.
+var ownWorkUsers atomic.Int32
+
+// ownWorkHistory is how long ownWork remembers spans after they end, and
+// ownWorkMaxSpans is how many spans it remembers for each thread. Either
+// limit may discard a span first.
+const (
+	ownWorkHistory  = time.Minute
+	ownWorkMaxSpans = 1024
+)
+
+// ownWorkDiscarded is the CLOCK_MONOTONIC time ownWork last discarded old
+// spans.
+var ownWorkDiscarded atomic.Uint64
+
+// DoOwnWork calls f, recording that the calling thread is doing monitoring or
+// profiling work, such as reading counters, decoding samples, or exporting
+// metrics, until f returns. Samples of that thread during that time are
+// reported by [IsOwnWork] and dropped by Samplers opened with
+// [SamplerOptions.ExcludeOwnWork], so in-process profiling doesn't pollute
+// the profile of the application with its own overhead.
+//
+// This package already does this for its own background reads, such as those
+// of [Counter.Stream], and for [Sampler.ReadSamples], and the exporter
+// packages do it when they read Counters. Callers can use DoOwnWork for their
+// own processing, such as writing profiles.
+//
+// DoOwnWork only records this while a Sampler with ExcludeOwnWork is open.
+// Otherwise, it just calls f. While recording, like [SampleLabels.Do],
+// DoOwnWork locks the calling goroutine to its OS thread while running f.
+func DoOwnWork(f func()) {
+	if ownWorkUsers.Load() == 0 {
+		f()
+		return
+	}
+	ownWork.run(ownWorkLabels, f)
+
+	// Bound the memory used by old spans.
+	now := monotonicNow()
+	last := ownWorkDiscarded.Load()
+	if now-last > uint64(ownWorkHistory) && ownWorkDiscarded.CompareAndSwap(last, now) {
+		ownWork.Discard(now - uint64(ownWorkHistory))
+	}
+}
+
+// IsOwnWork reports whether s was taken while its thread was running
+// [DoOwnWork]. Like [SampleLabels.Labels], this requires s to include
+// SampleTID and SampleTime and come from a Sampler opened with
+// [SamplerOptions.MonotonicClock]. DoOwnWork only records work while a
+// Sampler with [SamplerOptions.ExcludeOwnWork] is open, and only remembers it
+// for about a minute or the last thousand or so calls on each thread, so
+// samples must be checked promptly.
+func IsOwnWork(s *Sample) bool {
+	if ownWorkUsers.Load() == 0 {
+		return false
+	}
+	return ownWork.Labels(s) != nil
+}
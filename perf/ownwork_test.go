@@ -0,0 +1,157 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// enableOwnWork makes DoOwnWork record spans for the rest of the test, as
+// if a Sampler with ExcludeOwnWork were open.
+func enableOwnWork(t *testing.T) {
+	ownWorkUsers.Add(1)
+	t.Cleanup(func() { ownWorkUsers.Add(-1) })
+}
+
+func TestIsOwnWork(t *testing.T) {
+	enableOwnWork(t)
+	var tid uint32
+	var during uint64
+	before := monotonicNow()
+	DoOwnWork(func() {
+		tid = uint32(unix.Gettid())
+		during = monotonicNow()
+	})
+	after := monotonicNow()
+
+	for _, test := range []struct {
+		s    Sample
+		want bool
+	}{
+		{Sample{TID: tid, Time: during}, true},
+		{Sample{TID: tid, Time: before}, false},
+		{Sample{TID: tid, Time: after}, false},
+		{Sample{TID: tid + 1, Time: during}, false},
+	} {
+		if got := IsOwnWork(&test.s); got != test.want {
+			t.Errorf("IsOwnWork(TID %d, time %d) = %v, want %v", test.s.TID, test.s.Time, got, test.want)
+		}
+	}
+}
+
+func TestOwnWorkDisabled(t *testing.T) {
+	if n := ownWorkUsers.Load(); n != 0 {
+		t.Fatalf("%d own work users before test", n)
+	}
+	countSpans := func() int {
+		ownWork.mu.RLock()
+		defer ownWork.mu.RUnlock()
+		n := 0
+		for _, spans := range ownWork.spans {
+			n += len(spans)
+		}
+		return n
+	}
+	// Without a Sampler that excludes own work, DoOwnWork records nothing.
+	before := countSpans()
+	var tid uint32
+	var during uint64
+	DoOwnWork(func() {
+		tid = uint32(unix.Gettid())
+		during = monotonicNow()
+	})
+	if n := countSpans() - before; n != 0 {
+		t.Errorf("DoOwnWork recorded %d spans with no users", n)
+	}
+	if IsOwnWork(&Sample{TID: tid, Time: during}) {
+		t.Errorf("IsOwnWork with no users = true, want false")
+	}
+}
+
+func TestOwnWorkMaxSpans(t *testing.T) {
+	enableOwnWork(t)
+	var tid uint32
+	for i := 0; i < 3*ownWorkMaxSpans; i++ {
+		DoOwnWork(func() { tid = uint32(unix.Gettid()) })
+	}
+	// Spans nested in a span that hasn't ended don't discard it.
+	var outer uint64
+	DoOwnWork(func() {
+		tid = uint32(unix.Gettid())
+		outer = monotonicNow()
+		for i := 0; i < 2*ownWorkMaxSpans; i++ {
+			DoOwnWork(func() {})
+		}
+		ownWork.mu.RLock()
+		n := len(ownWork.spans[tid])
+		ownWork.mu.RUnlock()
+		if n > ownWorkMaxSpans {
+			t.Errorf("got %d spans for thread, want at most %d", n, ownWorkMaxSpans)
+		}
+		if !IsOwnWork(&Sample{TID: tid, Time: outer}) {
+			t.Errorf("outer span was discarded while running")
+		}
+	})
+}
+
+func TestSamplerExcludeOwnWork(t *testing.T) {
+	spin := func() {
+		start := time.Now()
+		for time.Since(start) < 20*time.Millisecond {
+		}
+	}
+
+	open := func(exclude bool) *Sampler {
+		opts := SamplerOptions{
+			SampleType:     SampleIP,
+			Period:         100000, // 100µs
+			ExcludeOwnWork: exclude,
+		}
+		s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+		if err != nil {
+			t.Skip(err)
+		}
+		return s
+	}
+	all, excl := open(false), open(true)
+	defer all.Close()
+	defer excl.Close()
+	if got, want := excl.SampleType(), SampleIP|SampleTID|SampleTime; got != want {
+		t.Errorf("got sample type %s, want %s", got, want)
+	}
+
+	all.Start()
+	excl.Start()
+	DoOwnWork(spin)
+	spin()
+	excl.Stop()
+	all.Stop()
+
+	var nAll, nExcl int
+	if err := all.ReadSamples(func(*Sample) bool { nAll++; return true }); err != nil {
+		t.Fatal(err)
+	}
+	err := excl.ReadSamples(func(s *Sample) bool {
+		nExcl++
+		if IsOwnWork(s) {
+			t.Errorf("got sample of own work at %d", s.Time)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%d samples, %d excluding own work", nAll, nExcl)
+	if nExcl == 0 || nExcl >= nAll {
+		t.Errorf("got %d samples excluding own work, want between 0 and %d", nExcl, nAll)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"cmp"
+	"encoding/binary"
+	"slices"
+	"time"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// A PageFaultTracer records every page fault of a [Target] with its callchain
+// and faulting address, and attributes the faults to the call sites that
+// caused them. This can show, for example, which code paths cause major faults
+// after a cold start.
+//
+// A major fault requires I/O to service, so it usually blocks the thread. The
+// PageFaultTracer uses [SamplerOptions.ContextSwitch] records to measure the
+// service time of each major fault that blocks, from the fault until the
+// thread runs again.
+type PageFaultTracer struct {
+	major, minor *Sampler
+	majorID      uint64
+
+	pending map[uint32]*pageFault // Last major fault of each thread, by TID
+	sites   map[string]*PageFaultSite
+	key     []byte
+}
+
+// pageFault is a major fault that may be waiting for the thread to run again.
+type pageFault struct {
+	time    uint64
+	site    *PageFaultSite
+	blocked bool
+}
+
+// A PageFaultSite is the page faults at one call site.
+type PageFaultSite struct {
+	// Callchain is the stack that caused the faults.
+	Callchain []uint64
+
+	// Major and Minor are the number of major and minor faults.
+	Major, Minor uint64
+
+	// ServiceTime is the distribution of time to service the major faults
+	// that blocked the thread.
+	ServiceTime LatencyDist
+}
+
+// OpenPageFaultTracer returns a new PageFaultTracer for target. Callers are
+// expected to call [PageFaultTracer.Close] when done.
+//
+// The tracer is initially not running. Call [PageFaultTracer.Start] to start
+// it.
+func OpenPageFaultTracer(target Target) (*PageFaultTracer, error) {
+	opts := SamplerOptions{
+		SampleType:    SampleIdentifier | SampleIP | SampleTID | SampleTime | SampleAddr | SampleCallchain,
+		Period:        1,
+		ContextSwitch: true,
+	}
+	major, err := opts.OpenSampler(target, events.EventMajorFaults)
+	if err != nil {
+		return nil, err
+	}
+	majorID, err := major.ID()
+	if err != nil {
+		major.Close()
+		return nil, err
+	}
+	opts.ContextSwitch = false
+	opts.Output = major
+	minor, err := opts.OpenSampler(target, events.EventMinorFaults)
+	if err != nil {
+		major.Close()
+		return nil, err
+	}
+	return &PageFaultTracer{
+		major:   major,
+		minor:   minor,
+		majorID: majorID,
+		pending: make(map[uint32]*pageFault),
+		sites:   make(map[string]*PageFaultSite),
+	}, nil
+}
+
+// Close closes the tracer.
+func (p *PageFaultTracer) Close() {
+	// The major fault Sampler owns the ring buffer, so close it last.
+	p.minor.Close()
+	p.major.Close()
+}
+
+// Start the tracer.
+func (p *PageFaultTracer) Start() {
+	p.major.Start()
+	p.minor.Start()
+}
+
+// Stop the tracer. Call [PageFaultTracer.Read] to process the faults recorded
+// before it stopped.
+func (p *PageFaultTracer) Stop() {
+	p.minor.Stop()
+	p.major.Stop()
+}
+
+// Read processes the records in the tracer's ring buffer. For each page fault,
+// it adds the fault to its call site and, if f is non-nil, calls f with a
+// Sample whose Addr is the faulting address and whether the fault was major.
+// If f returns false, Read stops processing records.
+//
+// Read must be called often enough to keep the ring buffer from filling up.
+func (p *PageFaultTracer) Read(f func(s *Sample, major bool) bool) error {
+	return p.major.ReadSamplesAndSideBand(func(s *Sample) bool {
+		major := s.Identifier == p.majorID
+		site := p.site(s)
+		// A new fault means any earlier major fault has been serviced.
+		delete(p.pending, s.TID)
+		if major {
+			site.Major++
+			p.pending[s.TID] = &pageFault{time: s.Time, site: site}
+		} else {
+			site.Minor++
+		}
+		if f != nil {
+			return f(s, major)
+		}
+		return true
+	}, func(r SideBandRecord) bool {
+		sw, ok := r.(*SwitchRecord)
+		if !ok {
+			return true
+		}
+		id := sw.ID()
+		fault, ok := p.pending[id.TID]
+		if !ok {
+			return true
+		}
+		if sw.Out {
+			fault.blocked = true
+			return true
+		}
+		delete(p.pending, id.TID)
+		if fault.blocked && id.Time >= fault.time {
+			fault.site.ServiceTime.add(time.Duration(id.Time - fault.time))
+		}
+		return true
+	})
+}
+
+// site returns the call site of s, creating it if necessary.
+func (p *PageFaultTracer) site(s *Sample) *PageFaultSite {
+	callchain := s.Callchain
+	if len(callchain) == 0 {
+		callchain = []uint64{s.IP}
+	}
+	p.key = p.key[:0]
+	for _, pc := range callchain {
+		p.key = binary.NativeEndian.AppendUint64(p.key, pc)
+	}
+	site, ok := p.sites[string(p.key)]
+	if !ok {
+		site = &PageFaultSite{Callchain: slices.Clone(callchain)}
+		p.sites[string(p.key)] = site
+	}
+	return site
+}
+
+// Sites returns the page faults at each call site read so far, sorted from
+// the most to the fewest major faults, and then minor faults.
+func (p *PageFaultTracer) Sites() []*PageFaultSite {
+	sites := make([]*PageFaultSite, 0, len(p.sites))
+	for _, site := range p.sites {
+		sites = append(sites, site)
+	}
+	slices.SortFunc(sites, func(a, b *PageFaultSite) int {
+		if c := cmp.Compare(b.Major, a.Major); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Minor, a.Minor)
+	})
+	return sites
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPageFaultTracerFake(t *testing.T) {
+	k := useFakeKernel(t)
+	p, err := OpenPageFaultTracer(TargetThisGoroutine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	ev := k.events[p.major.fd]
+	minorID, err := p.minor.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Records have the sample type
+	// Identifier|IP|TID|Time|Addr|Callchain, and side-band records
+	// end with TID|Time|Identifier.
+	const tid = 10
+	fault := func(id, time, addr uint64, callchain ...uint64) {
+		var data []byte
+		data = binary.NativeEndian.AppendUint64(data, id)
+		data = binary.NativeEndian.AppendUint64(data, callchain[0])
+		data = binary.NativeEndian.AppendUint32(data, tid)
+		data = binary.NativeEndian.AppendUint32(data, tid)
+		data = binary.NativeEndian.AppendUint64(data, time)
+		data = binary.NativeEndian.AppendUint64(data, addr)
+		data = binary.NativeEndian.AppendUint64(data, uint64(len(callchain)))
+		for _, pc := range callchain {
+			data = binary.NativeEndian.AppendUint64(data, pc)
+		}
+		ev.writeRecord(RecordSample, 0, data)
+	}
+	sw := func(out bool, time uint64) {
+		var misc uint16
+		if out {
+			misc = unix.PERF_RECORD_MISC_SWITCH_OUT
+		}
+		var data []byte
+		data = binary.NativeEndian.AppendUint32(data, tid)
+		data = binary.NativeEndian.AppendUint32(data, tid)
+		data = binary.NativeEndian.AppendUint64(data, time)
+		data = binary.NativeEndian.AppendUint64(data, p.majorID)
+		ev.writeRecord(RecordSwitch, misc, data)
+	}
+
+	// A major fault that blocks for 1000ns.
+	fault(p.majorID, 100, 0xa000, 0x10, 0x20)
+	sw(true, 150)
+	sw(false, 1100)
+	// A major fault at the same site that doesn't block.
+	fault(p.majorID, 2000, 0xb000, 0x10, 0x20)
+	// Minor faults at another site. The first one resolves the earlier
+	// major fault, so the switch doesn't count toward it.
+	fault(minorID, 3000, 0xc000, 0x30)
+	sw(true, 3100)
+	sw(false, 3200)
+	fault(minorID, 4000, 0xc008, 0x30)
+
+	var addrs []uint64
+	var majors int
+	err = p.Read(func(s *Sample, major bool) bool {
+		addrs = append(addrs, s.Addr)
+		if major {
+			majors++
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{0xa000, 0xb000, 0xc000, 0xc008}; !slices.Equal(addrs, want) || majors != 2 {
+		t.Errorf("got fault addresses %#x with %d major, want %#x with 2 major", addrs, majors, want)
+	}
+
+	sites := p.Sites()
+	if len(sites) != 2 {
+		t.Fatalf("got %d sites, want 2", len(sites))
+	}
+	site := sites[0]
+	if !slices.Equal(site.Callchain, []uint64{0x10, 0x20}) || site.Major != 2 || site.Minor != 0 {
+		t.Errorf("site 0: got %#x with %d major and %d minor faults, want [0x10 0x20] with 2 major", site.Callchain, site.Major, site.Minor)
+	}
+	if d := site.ServiceTime; d.Count != 1 || d.Total != 1000 {
+		t.Errorf("site 0: got service time %+v, want 1 fault of 1000ns", d)
+	}
+	site = sites[1]
+	if !slices.Equal(site.Callchain, []uint64{0x30}) || site.Major != 0 || site.Minor != 2 || site.ServiceTime.Count != 0 {
+		t.Errorf("site 1: got %#x with %d major and %d minor faults, want [0x30] with 2 minor", site.Callchain, site.Major, site.Minor)
+	}
+}
+
+func TestPageFaultTracer(t *testing.T) {
+	p, err := OpenPageFaultTracer(TargetThisGoroutine)
+	if err != nil {
+		t.Skipf("opening page fault tracer: %v", err)
+	}
+	defer p.Close()
+
+	// Touch fresh anonymous pages, which causes minor faults.
+	const pages = 16
+	pageSize := unix.Getpagesize()
+	mem, err := unix.Mmap(-1, 0, pages*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Munmap(mem)
+	p.Start()
+	for i := 0; i < pages; i++ {
+		mem[i*pageSize] = 1
+	}
+	p.Stop()
+
+	var n int
+	err = p.Read(func(s *Sample, major bool) bool {
+		if !major && uintptr(s.Addr)-uintptr(unsafe.Pointer(&mem[0])) < uintptr(len(mem)) {
+			n++
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != pages {
+		t.Errorf("got %d minor faults in mapping, want %d", n, pages)
+	}
+	var minor uint64
+	for _, site := range p.Sites() {
+		minor += site.Minor
+	}
+	if minor < pages {
+		t.Errorf("got %d minor faults at all sites, want at least %d", minor, pages)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// PauseOutput stops the kernel from writing records to s's ring buffer,
+// without stopping the event itself. This quiesces the ring buffer, so the
+// caller can, for example, read a consistent snapshot of the most recent
+// records. Call [Sampler.ResumeOutput] to resume writing.
+//
+// Unlike [Sampler.Stop], the event keeps counting while output is paused. The
+// kernel drops the records it would have written and reports them in a lost
+// record once output resumes (see [Sampler.Lost]).
+//
+// This requires Linux 4.7 or later.
+func (s *Sampler) PauseOutput() error {
+	return s.pauseOutput(true)
+}
+
+// ResumeOutput resumes writing records to s's ring buffer after
+// [Sampler.PauseOutput].
+func (s *Sampler) ResumeOutput() error {
+	return s.pauseOutput(false)
+}
+
+func (s *Sampler) pauseOutput(pause bool) error {
+	if s == nil || s.f == nil {
+		return fmt.Errorf("Sampler is closed")
+	}
+	arg := 0
+	if pause {
+		arg = 1
+	}
+	return sys.ioctl(s.fd, unix.PERF_EVENT_IOC_PAUSE_OUTPUT, arg)
+}
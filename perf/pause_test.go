@@ -0,0 +1,60 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestSamplerPauseOutput(t *testing.T) {
+	opts := SamplerOptions{
+		SampleType: SampleIP | SampleCPU,
+		Period:     100000, // 100µs of task-clock
+	}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	spin := func() {
+		start := time.Now()
+		for time.Since(start) < 10*time.Millisecond {
+		}
+	}
+	count := func() int {
+		n := 0
+		s.ReadSamples(func(*Sample) bool {
+			n++
+			return true
+		})
+		return n
+	}
+
+	s.Start()
+	if err := s.PauseOutput(); err != nil {
+		t.Skip(err)
+	}
+	spin()
+	if n := count(); n != 0 {
+		t.Errorf("got %d samples while paused, want 0", n)
+	}
+	if err := s.ResumeOutput(); err != nil {
+		t.Fatal(err)
+	}
+	spin()
+	s.Stop()
+	if n := count(); n == 0 {
+		t.Errorf("got no samples after resuming")
+	}
+	if s.Lost().Records == 0 {
+		t.Errorf("samples dropped while paused not reported as lost")
+	}
+}
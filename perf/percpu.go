@@ -0,0 +1,245 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// CPUCounters counts events separately on each of a set of CPUs, like
+// "perf stat -a -A".
+type CPUCounters struct {
+	evs   []events.Event
+	cpus  []int      // Sorted
+	cs    []*Counter // Parallel to cpus
+	stats OpenStats
+}
+
+// CPUCount is the count of a group of events on one CPU.
+type CPUCount struct {
+	CPU int
+
+	// Counts has one Count for each event passed to OpenCPUCounters.
+	Counts []Count
+}
+
+// OpenStats reports how long it took to open a set of counters. On large
+// machines, opening per-CPU events can take a significant amount of time.
+type OpenStats struct {
+	// Duration is the total time spent opening the counters.
+	Duration time.Duration
+
+	// Local is the number of CPUs whose counters were opened from a thread
+	// running on that CPU, and Remote is the number that were opened from
+	// another CPU. Opening a counter for a remote CPU requires the kernel
+	// to interrupt that CPU for each event, which is much slower.
+	Local, Remote int
+}
+
+// OpenCPUCounters opens counters for evs on each CPU in cpus, or on every
+// online CPU if cpus is nil. The events on each CPU are opened as a group, as
+// in [OpenCounter]. Callers are expected to call [CPUCounters.Close] when
+// done.
+//
+// Some events can only be counted on certain CPUs (see [events.CPUsOf]). For
+// example, an uncore PMU counts for a whole socket, so its events must be
+// opened on one designated CPU of each socket. If cpus is nil and evs includes
+// such events, OpenCPUCounters opens the counters on only those CPUs.
+//
+// Installing an event on a CPU requires running code on that CPU, so opening
+// an event for another CPU makes the kernel send it an inter-processor
+// interrupt and wait for it. On machines with many CPUs, these interrupts can
+// make opening take seconds. To avoid them, OpenCPUCounters opens the counters
+// for each CPU from a thread that it temporarily binds to that CPU, as "perf
+// stat" does. If the process isn't allowed to run on a CPU, it opens that
+// CPU's counters remotely. See [CPUCounters.OpenStats].
+//
+// The counters are initially not running. Call [CPUCounters.Start] to start
+// them.
+func OpenCPUCounters(cpus []int, evs ...events.Event) (*CPUCounters, error) {
+	var opts CounterOptions
+	return opts.OpenCPUCounters(cpus, evs...)
+}
+
+// OpenCPUCounters is like the top-level [OpenCPUCounters] function, but uses
+// the options in o for each CPU's Counter.
+func (o *CounterOptions) OpenCPUCounters(cpus []int, evs ...events.Event) (*CPUCounters, error) {
+	if cpus == nil {
+		var err error
+		cpus, err = eventCPUs(evs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cpus == nil {
+		var err error
+		cpus, err = onlineCPUs()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		cpus = slices.Clone(cpus)
+	}
+	// Open CPUs in order, so we move between nearby CPUs.
+	slices.Sort(cpus)
+	cpus = slices.Compact(cpus)
+
+	c := &CPUCounters{evs: evs, cpus: cpus}
+	start := time.Now()
+
+	// Bind this goroutine's thread to each CPU in turn, and restore its
+	// affinity when we're done.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var oldMask unix.CPUSet
+	canBind := unix.SchedGetaffinity(0, &oldMask) == nil
+	if canBind {
+		defer unix.SchedSetaffinity(0, &oldMask)
+	}
+
+	for _, cpu := range cpus {
+		local := false
+		if canBind {
+			var mask unix.CPUSet
+			mask.Set(cpu)
+			local = unix.SchedSetaffinity(0, &mask) == nil
+		}
+		counter, err := o.OpenCounter(TargetCPU(cpu), evs...)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("CPU %d: %w", cpu, err)
+		}
+		c.cs = append(c.cs, counter)
+		if local {
+			c.stats.Local++
+		} else {
+			c.stats.Remote++
+		}
+	}
+	c.stats.Duration = time.Since(start)
+	return c, nil
+}
+
+// eventCPUs returns the CPUs that all of evs can be counted on, or nil if
+// they can be counted on any CPU.
+func eventCPUs(evs []events.Event) ([]int, error) {
+	var cpus []int
+	var first events.Event
+	for _, ev := range evs {
+		evCPUs := events.CPUsOf(ev)
+		if evCPUs == nil {
+			continue
+		}
+		if cpus == nil {
+			cpus, first = slices.Clone(evCPUs), ev
+			continue
+		}
+		cpus = slices.DeleteFunc(cpus, func(cpu int) bool {
+			return !slices.Contains(evCPUs, cpu)
+		})
+		if len(cpus) == 0 {
+			return nil, fmt.Errorf("events %s and %s have no CPUs in common", first, ev)
+		}
+	}
+	return cpus, nil
+}
+
+// onlineCPUs returns the list of online CPUs.
+func onlineCPUs() ([]int, error) {
+	data, err := os.ReadFile("/sys/devices/system/cpu/online")
+	if err != nil {
+		return nil, err
+	}
+	return parseCPUList(strings.TrimSpace(string(data)))
+}
+
+// parseCPUList parses a kernel CPU list, such as "0-3,8".
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for _, r := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(r, "-")
+		l, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("bad CPU list %q", s)
+		}
+		h := l
+		if isRange {
+			h, err = strconv.Atoi(hi)
+			if err != nil || h < l {
+				return nil, fmt.Errorf("bad CPU list %q", s)
+			}
+		}
+		for cpu := l; cpu <= h; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// OpenStats returns statistics about opening c's counters.
+func (c *CPUCounters) OpenStats() OpenStats {
+	return c.stats
+}
+
+// Events returns the events counted on each CPU, in the order of the Counts
+// returned by [CPUCounters.Read]. The caller must not modify the returned
+// slice.
+func (c *CPUCounters) Events() []events.Event {
+	return c.evs
+}
+
+// Close closes the counters on all CPUs.
+func (c *CPUCounters) Close() {
+	for _, counter := range c.cs {
+		counter.Close()
+	}
+	c.cs = nil
+}
+
+// Start the counters on all CPUs.
+func (c *CPUCounters) Start() {
+	for _, counter := range c.cs {
+		counter.Start()
+	}
+}
+
+// Stop the counters on all CPUs.
+func (c *CPUCounters) Stop() {
+	for _, counter := range c.cs {
+		counter.Stop()
+	}
+}
+
+// Reset the counters on all CPUs to 0.
+func (c *CPUCounters) Reset() {
+	for _, counter := range c.cs {
+		counter.Reset()
+	}
+}
+
+// Read returns the counts of each CPU, sorted by CPU number.
+func (c *CPUCounters) Read() ([]CPUCount, error) {
+	out := make([]CPUCount, len(c.cs))
+	for i, counter := range c.cs {
+		out[i].CPU = c.cpus[i]
+		out[i].Counts = make([]Count, len(c.evs))
+		if err := counter.ReadGroup(out[i].Counts); err != nil {
+			return nil, fmt.Errorf("CPU %d: %w", c.cpus[i], err)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"errors"
+	"runtime"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestParseCPUList(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []int
+	}{
+		{"0", []int{0}},
+		{"0-3", []int{0, 1, 2, 3}},
+		{"0-1,8,10-11", []int{0, 1, 8, 10, 11}},
+	} {
+		got, err := parseCPUList(tc.in)
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("parseCPUList(%q) = %v, %v; want %v", tc.in, got, err, tc.want)
+		}
+	}
+	for _, in := range []string{"", "a", "3-1", "1-"} {
+		if got, err := parseCPUList(in); err == nil {
+			t.Errorf("parseCPUList(%q) = %v, want error", in, got)
+		}
+	}
+}
+
+func TestCPUCounters(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var before unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &before); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := OpenCPUCounters(nil, events.EventCPUClock)
+	if errors.Is(err, syscall.EACCES) {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var after unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &after); err != nil {
+		t.Fatal(err)
+	}
+	if after != before {
+		t.Errorf("OpenCPUCounters didn't restore the thread's CPU affinity")
+	}
+	stats := c.OpenStats()
+	t.Logf("%+v", stats)
+	if stats.Local+stats.Remote != len(c.cpus) || stats.Local == 0 {
+		t.Errorf("got %+v opening %d CPUs, want all CPUs and some local", stats, len(c.cpus))
+	}
+
+	c.Start()
+	start := time.Now()
+	for time.Since(start) < 20*time.Millisecond {
+	}
+	c.Stop()
+
+	counts, err := c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total uint64
+	for i, cc := range counts {
+		if i > 0 && counts[i-1].CPU >= cc.CPU {
+			t.Errorf("CPUs not sorted: %d before %d", counts[i-1].CPU, cc.CPU)
+		}
+		total += cc.Counts[0].RawValue
+	}
+	// All CPUs together were running for at least as long as we spun.
+	if total < uint64(20*time.Millisecond) {
+		t.Errorf("got %v of cpu-clock across %d CPUs, want at least 20ms", time.Duration(total), len(counts))
+	}
+}
+
+// cpusEvent is an event that can only be counted on certain CPUs, like an
+// uncore event.
+type cpusEvent struct {
+	events.Event
+	cpus []int
+}
+
+func (e cpusEvent) CPUs() []int {
+	return e.cpus
+}
+
+func TestEventCPUs(t *testing.T) {
+	a := cpusEvent{events.EventCPUClock, []int{0, 2, 4}}
+	b := cpusEvent{events.EventTaskClock, []int{2, 3, 4}}
+	for _, tc := range []struct {
+		evs  []events.Event
+		want []int
+	}{
+		{[]events.Event{events.EventCPUClock}, nil},
+		{[]events.Event{events.EventCPUClock, a}, []int{0, 2, 4}},
+		{[]events.Event{a, b}, []int{2, 4}},
+	} {
+		got, err := eventCPUs(tc.evs)
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("eventCPUs(%v) = %v, %v; want %v", tc.evs, got, err, tc.want)
+		}
+	}
+	c := cpusEvent{events.EventTaskClock, []int{1}}
+	if _, err := eventCPUs([]events.Event{a, c}); err == nil {
+		t.Errorf("eventCPUs with disjoint CPUs: want error")
+	}
+
+	// Counting such an event for a thread fails without asking the kernel.
+	if _, err := OpenCounter(TargetThisGoroutine, a); err == nil {
+		t.Errorf("OpenCounter(TargetThisGoroutine) of event with CPUs: want error")
+	}
+
+	// OpenCPUCounters opens it on just its CPUs.
+	cc, err := OpenCPUCounters(nil, cpusEvent{events.EventCPUClock, []int{0}})
+	if errors.Is(err, syscall.EACCES) {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	if !slices.Equal(cc.cpus, []int{0}) {
+		t.Errorf("opened counters on CPUs %v, want [0]", cc.cpus)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"syscall"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// ThreadCounters counts events separately on each thread of a process, which
+// can find hot spots that are confined to one thread of a multithreaded
+// process.
+//
+// ThreadCounters opens a [Counter] on each thread, so threads created after
+// the ThreadCounters is opened are not counted until [ThreadCounters.Refresh]
+// is called. If a thread exits and a new thread reuses its TID, both threads
+// are reported, so counts may include more than one thread with a TID.
+type ThreadCounters struct {
+	pid  int
+	opts CounterOptions
+	evs  []events.Event
+
+	threads []threadCounter // Sorted by TID
+	running bool
+}
+
+type threadCounter struct {
+	ThreadInfo
+	start uint64 // Start time of the thread, to detect TID reuse
+	c     *Counter
+}
+
+// ThreadCount is the count of a group of events on one thread.
+type ThreadCount struct {
+	ThreadInfo
+
+	// Counts has one Count for each event passed to OpenThreadCounters.
+	Counts []Count
+}
+
+// OpenThreadCounters opens counters for evs on each thread of process pid.
+// The events on each thread are opened as a group, as in [OpenCounter].
+// Callers are expected to call [ThreadCounters.Close] when done.
+//
+// The counters are initially not running. Call [ThreadCounters.Start] to
+// start them.
+func OpenThreadCounters(pid int, evs ...events.Event) (*ThreadCounters, error) {
+	var opts CounterOptions
+	return opts.OpenThreadCounters(pid, evs...)
+}
+
+// OpenThreadCounters is like the top-level [OpenThreadCounters] function, but
+// uses the options in o for each thread's Counter.
+func (o *CounterOptions) OpenThreadCounters(pid int, evs ...events.Event) (*ThreadCounters, error) {
+	t := &ThreadCounters{pid: pid, opts: *o, evs: evs}
+	if err := t.Refresh(); err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Refresh opens counters for any threads created since the counters were
+// opened or last refreshed. If the counters are running, the new counters
+// start running, too. Threads that have exited keep their final counts, even
+// if a new thread has reused their TID.
+func (t *ThreadCounters) Refresh() error {
+	threads, err := readThreads(t.pid)
+	if err != nil {
+		return err
+	}
+	// Threads with the same TID are sorted by when they were opened, so this
+	// maps each TID to the start time of the last thread with that TID.
+	have := make(map[int]uint64)
+	for _, th := range t.threads {
+		have[th.TID] = th.start
+	}
+	for _, th := range threads {
+		start, err := readThreadStart(t.pid, th.TID)
+		if err != nil {
+			// The thread probably exited.
+			continue
+		}
+		if prev, ok := have[th.TID]; ok && prev == start {
+			continue
+		}
+		c, err := t.opts.OpenCounter(TargetThread(th.TID), t.evs...)
+		if errors.Is(err, syscall.ESRCH) {
+			// The thread exited.
+			continue
+		} else if err != nil {
+			return err
+		}
+		if t.running {
+			c.Start()
+		}
+		t.threads = append(t.threads, threadCounter{th, start, c})
+	}
+	sort.SliceStable(t.threads, func(i, j int) bool { return t.threads[i].TID < t.threads[j].TID })
+	return nil
+}
+
+// Close closes the counters on all threads.
+func (t *ThreadCounters) Close() {
+	for _, th := range t.threads {
+		th.c.Close()
+	}
+	t.threads = nil
+}
+
+// Start the counters on all threads.
+func (t *ThreadCounters) Start() {
+	t.running = true
+	for _, th := range t.threads {
+		th.c.Start()
+	}
+}
+
+// Stop the counters on all threads.
+func (t *ThreadCounters) Stop() {
+	for _, th := range t.threads {
+		th.c.Stop()
+	}
+	t.running = false
+}
+
+// Reset the counters on all threads to 0.
+func (t *ThreadCounters) Reset() {
+	for _, th := range t.threads {
+		th.c.Reset()
+	}
+}
+
+// Read returns the counts of each thread, sorted by thread ID. Threads with
+// the same TID are sorted by when they were first counted. The thread names
+// are the names at the time each thread's counter was opened.
+func (t *ThreadCounters) Read() ([]ThreadCount, error) {
+	out := make([]ThreadCount, len(t.threads))
+	for i, th := range t.threads {
+		out[i].ThreadInfo = th.ThreadInfo
+		out[i].Counts = make([]Count, len(t.evs))
+		if err := th.c.ReadGroup(out[i].Counts); err != nil {
+			return nil, fmt.Errorf("thread %d (%s): %w", th.TID, th.Comm, err)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestThreadCounters(t *testing.T) {
+	// Start a thread that spins, so it's guaranteed to accumulate some time.
+	tidCh := make(chan int)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		tidCh <- unix.Gettid()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}()
+	tid := <-tidCh
+
+	tc, err := OpenThreadCounters(os.Getpid(), events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.Close()
+	tc.Start()
+	time.Sleep(20 * time.Millisecond)
+	tc.Stop()
+
+	counts, err := tc.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for i, c := range counts {
+		if i > 0 && counts[i-1].TID >= c.TID {
+			t.Errorf("threads not sorted: %d before %d", counts[i-1].TID, c.TID)
+		}
+		if c.TID == tid {
+			found = true
+			if c.Counts[0].RawValue == 0 {
+				t.Errorf("spinning thread %d has no task-clock", tid)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("spinning thread %d not found in %+v", tid, counts)
+	}
+
+	// Refreshing with no new threads doesn't open anything new.
+	n := len(tc.threads)
+	if err := tc.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if len(tc.threads) < n {
+		t.Errorf("Refresh dropped threads: had %d, now %d", n, len(tc.threads))
+	}
+
+	// If the thread had a different start time, its TID was reused by a new
+	// thread, so Refresh counts the new thread while keeping the old one.
+	n = len(tc.threads)
+	for i := range tc.threads {
+		if tc.threads[i].TID == tid {
+			tc.threads[i].start--
+		}
+	}
+	if err := tc.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	var reused []threadCounter
+	for _, th := range tc.threads {
+		if th.TID == tid {
+			reused = append(reused, th)
+		}
+	}
+	if len(reused) != 2 || len(tc.threads) < n+1 {
+		t.Fatalf("after TID reuse, got %d counters for thread %d, want 2", len(reused), tid)
+	}
+	if reused[0].c == reused[1].c || reused[0].start >= reused[1].start {
+		t.Errorf("after TID reuse, old thread's counter should be first, got %+v", reused)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PMUUsage reports other consumers of perf events on the system. Hardware
+// counters are a limited resource, so if a [Counter] is unexpectedly
+// multiplexed (see [Count.Multiplexed]), this can help find what else is using
+// them.
+type PMUUsage struct {
+	// Processes lists processes that have perf event file descriptors open,
+	// including the calling process, sorted by PID.
+	Processes []PMUUser
+
+	// Incomplete is set if some processes could not be inspected, typically
+	// because inspecting another user's file descriptors requires privileges.
+	Incomplete bool
+
+	// NMIWatchdog is set if the kernel's hard lockup detector is enabled. On
+	// many systems this permanently uses one hardware counter on each CPU.
+	NMIWatchdog bool
+}
+
+// PMUUser is a process with perf event file descriptors open.
+type PMUUser struct {
+	PID  int
+	Comm string
+	FDs  int // Number of open perf event file descriptors
+}
+
+// ReadPMUUsage scans /proc for other consumers of perf events.
+//
+// This can't determine which events other processes are using or which CPUs
+// or tasks they're monitoring, so not all of the reported users necessarily
+// compete with a given Counter.
+func ReadPMUUsage() (*PMUUsage, error) {
+	ents, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var u PMUUsage
+	for _, ent := range ents {
+		pid, err := strconv.Atoi(ent.Name())
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join("/proc", ent.Name())
+		n, err := countPerfFDs(filepath.Join(dir, "fd"))
+		if err != nil {
+			if os.IsPermission(err) {
+				u.Incomplete = true
+			}
+			// Otherwise, the process probably exited.
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+		comm, _ := readComm(filepath.Join(dir, "comm"))
+		u.Processes = append(u.Processes, PMUUser{pid, comm, n})
+	}
+	slices.SortFunc(u.Processes, func(a, b PMUUser) int { return a.PID - b.PID })
+
+	if data, err := os.ReadFile("/proc/sys/kernel/nmi_watchdog"); err == nil {
+		u.NMIWatchdog = string(bytes.TrimSpace(data)) != "0"
+	}
+
+	return &u, nil
+}
+
+// countPerfFDs returns the number of perf event file descriptors in fd
+// directory dir.
+func countPerfFDs(dir string) (int, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, ent := range ents {
+		target, err := os.Readlink(filepath.Join(dir, ent.Name()))
+		if err != nil {
+			continue
+		}
+		if target == "anon_inode:[perf_event]" {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// String returns a human-readable summary of u.
+func (u *PMUUsage) String() string {
+	var sb strings.Builder
+	for _, p := range u.Processes {
+		fmt.Fprintf(&sb, "pid %d (%s): %d perf event FDs\n", p.PID, p.Comm, p.FDs)
+	}
+	if len(u.Processes) == 0 {
+		sb.WriteString("no processes have perf event FDs open\n")
+	}
+	if u.Incomplete {
+		sb.WriteString("some processes could not be inspected (try running as root)\n")
+	}
+	if u.NMIWatchdog {
+		sb.WriteString("NMI watchdog is enabled and may use a counter on each CPU (disable with: echo 0 | sudo tee /proc/sys/kernel/nmi_watchdog)\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,37 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"os"
+	"testing"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestReadPMUUsage(t *testing.T) {
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventInstructions)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer c.Close()
+
+	u, err := ReadPMUUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%s", u)
+	for _, p := range u.Processes {
+		if p.PID == os.Getpid() {
+			if p.FDs < 2 {
+				t.Errorf("got %d perf event FDs for this process, want at least 2", p.FDs)
+			}
+			return
+		}
+	}
+	t.Errorf("this process (pid %d) not found in %+v", os.Getpid(), u.Processes)
+}
@@ -0,0 +1,435 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// ProfileConfig configures a [ProfileBuilder].
+type ProfileConfig struct {
+	// Event is the sampled event. Its name and unit name the profile's
+	// sample value.
+	Event events.Event
+
+	// Period is the sample period, which is used as the value of samples
+	// that don't record their own period (see [SamplePeriod]). If 0, each
+	// such sample has a value of 1.
+	Period uint64
+
+	// Symbolizer, if non-nil, symbolizes the PCs of samples. Otherwise,
+	// the profile only records addresses.
+	Symbolizer *SelfSymbolizer
+
+	// Labels, if non-nil, attaches the pprof labels of the goroutine
+	// that was running at each sample to the profile sample, so the
+	// profile can be filtered by label like a CPU profile, such as with
+	// "go tool pprof -tagfocus".
+	Labels *SampleLabels
+}
+
+// A ProfileBuilder accumulates [Sample]s and writes them as a pprof profile,
+// which can be viewed with "go tool pprof".
+//
+// Each sample's stack is its Callchain if the sample type includes
+// [SampleCallchain], or otherwise just its IP. Each profile sample has two
+// values: the number of samples, and the total sample period in units of the
+// event, such as CPU cycles or nanoseconds of CPU time.
+type ProfileBuilder struct {
+	cfg       ProfileConfig
+	valueType [2]profileValueType
+	start     time.Time
+
+	strings   map[string]int64
+	strs      []string
+	locs      map[uint64]uint64 // PC -> location ID
+	locations []profileLocation
+	funcs     map[profileFunction]uint64 // -> function ID
+	functions []profileFunction
+	maps      map[Mapping]uint64 // -> mapping ID
+	mappings  []Mapping
+	samples   map[string]int // Stack key -> index in stacks
+	stacks    []profileSample
+
+	pcs    []uint64
+	ids    []uint64
+	labels []int64
+	idKey  []byte
+}
+
+type profileValueType struct {
+	typ, unit string
+}
+
+type profileLocation struct {
+	pc      uint64
+	mapping uint64 // Mapping ID, or 0
+	lines   []profileLine
+}
+
+type profileLine struct {
+	function uint64 // Function ID
+	line     int64
+}
+
+type profileFunction struct {
+	name, file string
+}
+
+type profileSample struct {
+	locs   []uint64 // Location IDs
+	labels []int64  // Pairs of key and value string indexes
+	values [2]int64
+}
+
+// NewProfileBuilder returns a new ProfileBuilder for samples of cfg.Event.
+func NewProfileBuilder(cfg ProfileConfig) *ProfileBuilder {
+	b := &ProfileBuilder{
+		cfg:     cfg,
+		start:   time.Now(),
+		strings: make(map[string]int64),
+		locs:    make(map[uint64]uint64),
+		funcs:   make(map[profileFunction]uint64),
+		maps:    make(map[Mapping]uint64),
+		samples: make(map[string]int),
+	}
+	b.valueType = [2]profileValueType{{"samples", "count"}, {cfg.Event.String(), profileUnit(cfg.Event)}}
+	// The string table must start with "".
+	b.str("")
+	return b
+}
+
+// profileUnit returns the pprof unit of the period of ev.
+func profileUnit(ev events.Event) string {
+	var attr unix.PerfEventAttr
+	if ev.SetAttrs(&attr) == nil && attr.Type == unix.PERF_TYPE_SOFTWARE {
+		switch attr.Config {
+		case unix.PERF_COUNT_SW_CPU_CLOCK, unix.PERF_COUNT_SW_TASK_CLOCK:
+			return "nanoseconds"
+		}
+	}
+	if scale, unit := events.ScaleUnitOf(ev); scale == 1 && unit != "" {
+		return unit
+	}
+	return "count"
+}
+
+// Add adds s to the profile.
+func (b *ProfileBuilder) Add(s *Sample) {
+	value := int64(s.Period)
+	if s.Period == 0 {
+		value = int64(max(b.cfg.Period, 1))
+	}
+
+	b.pcs = b.pcs[:0]
+	if s.Callchain != nil {
+		b.pcs = append(b.pcs, s.Callchain...)
+	} else {
+		b.pcs = append(b.pcs, s.IP)
+	}
+
+	// Map PCs to locations, dropping context markers. The first PC of
+	// each context is the exact PC, and the rest are return addresses.
+	b.ids = b.ids[:0]
+	exact, kernel := true, false
+	for _, pc := range b.pcs {
+		if pc >= perfContextMax {
+			kernel = isKernelContext(pc)
+			exact = true
+			continue
+		}
+		b.ids = append(b.ids, b.location(pc, exact, kernel))
+		exact = false
+	}
+
+	b.labels = b.labels[:0]
+	if b.cfg.Labels != nil {
+		labels := b.cfg.Labels.Labels(s)
+		keys := make([]string, 0, len(labels))
+		for k := range labels {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			b.labels = append(b.labels, b.str(k), b.str(labels[k]))
+		}
+	}
+
+	b.idKey = b.idKey[:0]
+	for _, id := range b.ids {
+		b.idKey = fmt.Appendf(b.idKey, "%x,", id)
+	}
+	for _, l := range b.labels {
+		b.idKey = fmt.Appendf(b.idKey, "l%x,", l)
+	}
+	i, ok := b.samples[string(b.idKey)]
+	if !ok {
+		i = len(b.stacks)
+		b.samples[string(b.idKey)] = i
+		b.stacks = append(b.stacks, profileSample{
+			locs:   append([]uint64(nil), b.ids...),
+			labels: slices.Clone(b.labels),
+		})
+	}
+	b.stacks[i].values[0]++
+	b.stacks[i].values[1] += value
+}
+
+// location returns the location ID of pc. If exact is set, pc is the exact
+// PC of a sample rather than a return address.
+func (b *ProfileBuilder) location(pc uint64, exact, kernel bool) uint64 {
+	if id, ok := b.locs[pc]; ok {
+		return id
+	}
+	loc := profileLocation{pc: pc}
+	if b.cfg.Symbolizer != nil && !kernel {
+		for _, f := range b.cfg.Symbolizer.appendFrames(nil, pc, exact) {
+			if f.Mapping != nil {
+				loc.mapping = b.mapping(*f.Mapping)
+			}
+			if f.Function != "" {
+				// Like pprof, the symbolizer returns inlined
+				// frames first.
+				loc.lines = append(loc.lines, profileLine{b.function(f.Function, f.File), int64(f.Line)})
+			}
+		}
+	}
+	id := uint64(len(b.locations) + 1)
+	b.locations = append(b.locations, loc)
+	b.locs[pc] = id
+	return id
+}
+
+func (b *ProfileBuilder) function(name, file string) uint64 {
+	f := profileFunction{name, file}
+	if id, ok := b.funcs[f]; ok {
+		return id
+	}
+	id := uint64(len(b.functions) + 1)
+	b.functions = append(b.functions, f)
+	b.funcs[f] = id
+	return id
+}
+
+func (b *ProfileBuilder) mapping(m Mapping) uint64 {
+	if id, ok := b.maps[m]; ok {
+		return id
+	}
+	id := uint64(len(b.mappings) + 1)
+	b.mappings = append(b.mappings, m)
+	b.maps[m] = id
+	return id
+}
+
+func (b *ProfileBuilder) str(s string) int64 {
+	if i, ok := b.strings[s]; ok {
+		return i
+	}
+	i := int64(len(b.strs))
+	b.strs = append(b.strs, s)
+	b.strings[s] = i
+	return i
+}
+
+// Write writes the profile to w as a gzip-compressed pprof protobuf.
+func (b *ProfileBuilder) Write(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b.encode()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Field numbers of profile.proto.
+const (
+	tagProfileSampleType    = 1
+	tagProfileSample        = 2
+	tagProfileMapping       = 3
+	tagProfileLocation      = 4
+	tagProfileFunction      = 5
+	tagProfileStringTable   = 6
+	tagProfileTimeNanos     = 9
+	tagProfileDurationNanos = 10
+	tagProfilePeriodType    = 11
+	tagProfilePeriod        = 12
+
+	tagValueTypeType = 1
+	tagValueTypeUnit = 2
+
+	tagSampleLocation = 1
+	tagSampleValue    = 2
+	tagSampleLabel    = 3
+
+	tagLabelKey = 1
+	tagLabelStr = 2
+
+	tagMappingID       = 1
+	tagMappingStart    = 2
+	tagMappingLimit    = 3
+	tagMappingOffset   = 4
+	tagMappingFilename = 5
+
+	tagLocationID        = 1
+	tagLocationMappingID = 2
+	tagLocationAddress   = 3
+	tagLocationLine      = 4
+
+	tagLineFunctionID = 1
+	tagLineLine       = 2
+
+	tagFunctionID         = 1
+	tagFunctionName       = 2
+	tagFunctionSystemName = 3
+	tagFunctionFilename   = 4
+)
+
+// encode returns the uncompressed protobuf encoding of the profile.
+func (b *ProfileBuilder) encode() []byte {
+	var pb protobuf
+	valueType := func(tag int, vt profileValueType) {
+		start := pb.startMessage()
+		pb.int64(tagValueTypeType, b.str(vt.typ))
+		pb.int64(tagValueTypeUnit, b.str(vt.unit))
+		pb.endMessage(tag, start)
+	}
+	for _, vt := range b.valueType {
+		valueType(tagProfileSampleType, vt)
+	}
+	for _, s := range b.stacks {
+		start := pb.startMessage()
+		pb.uint64s(tagSampleLocation, s.locs)
+		pb.int64s(tagSampleValue, s.values[:])
+		for i := 0; i < len(s.labels); i += 2 {
+			lstart := pb.startMessage()
+			pb.int64(tagLabelKey, s.labels[i])
+			pb.int64(tagLabelStr, s.labels[i+1])
+			pb.endMessage(tagSampleLabel, lstart)
+		}
+		pb.endMessage(tagProfileSample, start)
+	}
+	for i, m := range b.mappings {
+		start := pb.startMessage()
+		pb.uint64(tagMappingID, uint64(i+1))
+		pb.uint64(tagMappingStart, m.Start)
+		pb.uint64(tagMappingLimit, m.End)
+		pb.uint64(tagMappingOffset, m.Offset)
+		pb.int64(tagMappingFilename, b.str(m.Path))
+		pb.endMessage(tagProfileMapping, start)
+	}
+	for i, loc := range b.locations {
+		start := pb.startMessage()
+		pb.uint64(tagLocationID, uint64(i+1))
+		pb.uint64(tagLocationMappingID, loc.mapping)
+		pb.uint64(tagLocationAddress, loc.pc)
+		for _, line := range loc.lines {
+			start := pb.startMessage()
+			pb.uint64(tagLineFunctionID, line.function)
+			pb.int64(tagLineLine, line.line)
+			pb.endMessage(tagLocationLine, start)
+		}
+		pb.endMessage(tagProfileLocation, start)
+	}
+	for i, f := range b.functions {
+		start := pb.startMessage()
+		pb.uint64(tagFunctionID, uint64(i+1))
+		name := b.str(f.name)
+		pb.int64(tagFunctionName, name)
+		pb.int64(tagFunctionSystemName, name)
+		pb.int64(tagFunctionFilename, b.str(f.file))
+		pb.endMessage(tagProfileFunction, start)
+	}
+	pb.int64(tagProfileTimeNanos, b.start.UnixNano())
+	pb.int64(tagProfileDurationNanos, int64(time.Since(b.start)))
+	valueType(tagProfilePeriodType, b.valueType[1])
+	pb.int64(tagProfilePeriod, int64(b.cfg.Period))
+	// Encode the string table last, since encoding the other fields adds
+	// strings.
+	for _, s := range b.strs {
+		pb.string(tagProfileStringTable, s)
+	}
+	return pb.data
+}
+
+// protobuf is a minimal protocol buffer encoder, sufficient for pprof
+// profiles.
+type protobuf struct {
+	data []byte
+}
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 128 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protobuf) length(tag int, n int) {
+	b.varint(uint64(tag)<<3 | 2)
+	b.varint(uint64(n))
+}
+
+func (b *protobuf) uint64(tag int, x uint64) {
+	if x == 0 {
+		// Zero is the default, so it's omitted.
+		return
+	}
+	b.varint(uint64(tag) << 3)
+	b.varint(x)
+}
+
+func (b *protobuf) int64(tag int, x int64) {
+	b.uint64(tag, uint64(x))
+}
+
+func (b *protobuf) uint64s(tag int, xs []uint64) {
+	// Repeated scalars are packed.
+	var p protobuf
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.length(tag, len(p.data))
+	b.data = append(b.data, p.data...)
+}
+
+func (b *protobuf) int64s(tag int, xs []int64) {
+	var p protobuf
+	for _, x := range xs {
+		p.varint(uint64(x))
+	}
+	b.length(tag, len(p.data))
+	b.data = append(b.data, p.data...)
+}
+
+func (b *protobuf) string(tag int, s string) {
+	b.length(tag, len(s))
+	b.data = append(b.data, s...)
+}
+
+// startMessage starts an embedded message, which must be finished with
+// endMessage.
+func (b *protobuf) startMessage() int {
+	return len(b.data)
+}
+
+// endMessage finishes the embedded message that began at start, prefixing it
+// with tag and its length.
+func (b *protobuf) endMessage(tag int, start int) {
+	msg := strings.Clone(string(b.data[start:]))
+	b.data = b.data[:start]
+	b.length(tag, len(msg))
+	b.data = append(b.data, msg...)
+}
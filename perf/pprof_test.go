@@ -0,0 +1,300 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// pbField is a decoded protobuf field. For length-delimited fields, data is
+// the contents; otherwise, val is the value.
+type pbField struct {
+	tag  int
+	val  uint64
+	data []byte
+}
+
+// decodePB decodes the fields of a protobuf message. It expands packed
+// repeated varints into separate fields.
+func decodePB(t *testing.T, data []byte, packed ...int) []pbField {
+	t.Helper()
+	var fields []pbField
+	for len(data) > 0 {
+		key := pbVarint(t, &data)
+		tag := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			fields = append(fields, pbField{tag: tag, val: pbVarint(t, &data)})
+		case 2:
+			n := pbVarint(t, &data)
+			body := data[:n]
+			data = data[n:]
+			if !slices.Contains(packed, tag) {
+				fields = append(fields, pbField{tag: tag, data: body})
+				break
+			}
+			for len(body) > 0 {
+				fields = append(fields, pbField{tag: tag, val: pbVarint(t, &body)})
+			}
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return fields
+}
+
+func pbVarint(t *testing.T, data *[]byte) uint64 {
+	t.Helper()
+	var x uint64
+	for shift := 0; ; shift += 7 {
+		if len(*data) == 0 {
+			t.Fatalf("truncated varint")
+		}
+		b := (*data)[0]
+		*data = (*data)[1:]
+		x |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return x
+		}
+	}
+}
+
+// testProfile is a decoded pprof profile.
+type testProfile struct {
+	sampleTypes []string            // "type/unit"
+	samples     map[string][]int64  // Stack of function names, joined by ";", then any labels -> values
+	locFuncs    map[uint64][]string // Location ID -> function names, or address
+}
+
+func decodeProfile(t *testing.T, data []byte) *testProfile {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := decodePB(t, data)
+	var strs []string
+	for _, f := range fields {
+		if f.tag == tagProfileStringTable {
+			strs = append(strs, string(f.data))
+		}
+	}
+	if len(strs) == 0 || strs[0] != "" {
+		t.Fatalf("string table doesn't start with \"\": %q", strs)
+	}
+
+	p := &testProfile{samples: make(map[string][]int64), locFuncs: make(map[uint64][]string)}
+	funcs := make(map[uint64]string)
+	type sample struct {
+		locs   []uint64
+		labels []string
+		values []int64
+	}
+	var samples []sample
+	for _, f := range fields {
+		switch f.tag {
+		case tagProfileSampleType:
+			var typ, unit uint64
+			for _, g := range decodePB(t, f.data) {
+				switch g.tag {
+				case tagValueTypeType:
+					typ = g.val
+				case tagValueTypeUnit:
+					unit = g.val
+				}
+			}
+			p.sampleTypes = append(p.sampleTypes, strs[typ]+"/"+strs[unit])
+		case tagProfileSample:
+			var s sample
+			for _, g := range decodePB(t, f.data, tagSampleLocation, tagSampleValue) {
+				switch g.tag {
+				case tagSampleLocation:
+					s.locs = append(s.locs, g.val)
+				case tagSampleValue:
+					s.values = append(s.values, int64(g.val))
+				case tagSampleLabel:
+					var key, str uint64
+					for _, h := range decodePB(t, g.data) {
+						switch h.tag {
+						case tagLabelKey:
+							key = h.val
+						case tagLabelStr:
+							str = h.val
+						}
+					}
+					s.labels = append(s.labels, strs[key]+"="+strs[str])
+				}
+			}
+			samples = append(samples, s)
+		case tagProfileFunction:
+			var id, name uint64
+			for _, g := range decodePB(t, f.data) {
+				switch g.tag {
+				case tagFunctionID:
+					id = g.val
+				case tagFunctionName:
+					name = g.val
+				}
+			}
+			funcs[id] = strs[name]
+		}
+	}
+	for _, f := range fields {
+		if f.tag != tagProfileLocation {
+			continue
+		}
+		var id, addr uint64
+		var names []string
+		for _, g := range decodePB(t, f.data) {
+			switch g.tag {
+			case tagLocationID:
+				id = g.val
+			case tagLocationAddress:
+				addr = g.val
+			case tagLocationLine:
+				for _, h := range decodePB(t, g.data) {
+					if h.tag == tagLineFunctionID {
+						names = append(names, funcs[h.val])
+					}
+				}
+			}
+		}
+		if len(names) == 0 {
+			names = []string{fmt.Sprintf("%#x", addr)}
+		}
+		p.locFuncs[id] = names
+	}
+	for _, s := range samples {
+		var stack []string
+		for _, loc := range s.locs {
+			stack = append(stack, p.locFuncs[loc]...)
+		}
+		key := strings.Join(stack, ";")
+		if s.labels != nil {
+			key += " " + strings.Join(s.labels, ",")
+		}
+		if _, ok := p.samples[key]; ok {
+			t.Errorf("duplicate sample for stack %s", key)
+		}
+		p.samples[key] = s.values
+	}
+	return p
+}
+
+//go:noinline
+func pprofTestLeaf() []uintptr {
+	pcs := make([]uintptr, 2)
+	runtime.Callers(1, pcs)
+	return pcs
+}
+
+func TestProfileBuilder(t *testing.T) {
+	sym, err := NewSelfSymbolizer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Construct a callchain from pprofTestLeaf to this function.
+	pcs := pprofTestLeaf()
+	leaf := uint64(reflect.ValueOf(pprofTestLeaf).Pointer())
+	user := []uint64{1<<64 + unix.PERF_CONTEXT_USER, leaf, uint64(pcs[1])}
+	kernel := append([]uint64{1<<64 + unix.PERF_CONTEXT_KERNEL, 0xffffffff81000000}, user...)
+
+	b := NewProfileBuilder(ProfileConfig{Event: events.EventCPUCycles, Period: 1000, Symbolizer: sym})
+	b.Add(&Sample{Callchain: user})
+	b.Add(&Sample{Callchain: user})
+	b.Add(&Sample{Callchain: kernel, Period: 500})
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	p := decodeProfile(t, buf.Bytes())
+
+	if want := []string{"samples/count", "cpu-cycles/count"}; !reflect.DeepEqual(p.sampleTypes, want) {
+		t.Errorf("got sample types %q, want %q", p.sampleTypes, want)
+	}
+	const pkg = "github.com/aclements/go-perfevent/perf."
+	userStack := pkg + "pprofTestLeaf;" + pkg + "TestProfileBuilder"
+	want := map[string][]int64{
+		userStack:                         {2, 2000},
+		"0xffffffff81000000;" + userStack: {1, 500},
+	}
+	if !reflect.DeepEqual(p.samples, want) {
+		t.Errorf("got samples %v, want %v", p.samples, want)
+	}
+}
+
+func TestProfileBuilderUnits(t *testing.T) {
+	// Without a symbolizer or callchains, the profile records only IPs.
+	b := NewProfileBuilder(ProfileConfig{Event: events.EventTaskClock})
+	b.Add(&Sample{IP: 0x1000})
+	b.Add(&Sample{IP: 0x2000})
+	b.Add(&Sample{IP: 0x1000})
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	p := decodeProfile(t, buf.Bytes())
+	if want := []string{"samples/count", "task-clock/nanoseconds"}; !reflect.DeepEqual(p.sampleTypes, want) {
+		t.Errorf("got sample types %q, want %q", p.sampleTypes, want)
+	}
+	// The samples at 0x1000 are merged.
+	want := map[string][]int64{"0x1000": {2, 2}, "0x2000": {1, 1}}
+	if !reflect.DeepEqual(p.samples, want) {
+		t.Errorf("got samples %v, want %v", p.samples, want)
+	}
+}
+
+func TestProfileBuilderLabels(t *testing.T) {
+	sl := NewSampleLabels()
+	var in, nested Sample
+	ctx := context.Background()
+	sl.Do(ctx, pprof.Labels("req", "a", "user", "x"), func(ctx context.Context) {
+		tid := uint32(unix.Gettid())
+		in = Sample{IP: 0x1000, TID: tid, Time: monotonicNow()}
+		sl.Do(ctx, pprof.Labels("req", "b"), func(context.Context) {
+			nested = Sample{IP: 0x1000, TID: tid, Time: monotonicNow()}
+		})
+	})
+
+	b := NewProfileBuilder(ProfileConfig{Event: events.EventTaskClock, Labels: sl})
+	b.Add(&in)
+	b.Add(&nested)
+	b.Add(&in)
+	b.Add(&Sample{IP: 0x1000, TID: in.TID, Time: monotonicNow()})
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	p := decodeProfile(t, buf.Bytes())
+	want := map[string][]int64{
+		"0x1000 req=a,user=x": {2, 2},
+		"0x1000 req=b,user=x": {1, 1},
+		"0x1000":              {1, 1},
+	}
+	if !reflect.DeepEqual(p.samples, want) {
+		t.Errorf("got samples %v, want %v", p.samples, want)
+	}
+}
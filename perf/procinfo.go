@@ -0,0 +1,232 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ProcessInfo is a snapshot of the threads and memory mappings of a process,
+// read from /proc. This is useful for attributing samples from a process that
+// was already running before sampling started, since the kernel only reports
+// comm and mmap events that happen after a sampler is opened.
+type ProcessInfo struct {
+	PID     int
+	Comm    string
+	Threads []ThreadInfo
+	Maps    []Mapping
+}
+
+// ThreadInfo describes one thread of a process.
+type ThreadInfo struct {
+	TID  int
+	Comm string
+}
+
+// Mapping describes one memory mapping of a process, as reported by
+// /proc/<pid>/maps.
+type Mapping struct {
+	Start, End uint64 // Address range [Start, End)
+	Offset     uint64 // Offset in the mapped file
+	Perm       string // Permissions, such as "r-xp"
+	Inode      uint64
+	Path       string // Mapped file path or pseudo-path like "[heap]", if any
+}
+
+// ReadProcessInfo reads a snapshot of process pid from /proc.
+//
+// Threads may start or exit while this is running, so the result is only
+// approximately consistent.
+func ReadProcessInfo(pid int) (*ProcessInfo, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	info := &ProcessInfo{PID: pid}
+
+	comm, err := readComm(filepath.Join(dir, "comm"))
+	if err != nil {
+		return nil, err
+	}
+	info.Comm = comm
+
+	info.Threads, err = readThreads(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	maps, err := os.ReadFile(filepath.Join(dir, "maps"))
+	if err != nil {
+		return nil, err
+	}
+	info.Maps, err = parseMaps(maps)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, "maps"), err)
+	}
+
+	return info, nil
+}
+
+// readThreads returns the threads of process pid.
+func readThreads(pid int) ([]ThreadInfo, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid), "task")
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var threads []ThreadInfo
+	for _, ent := range ents {
+		tid, err := strconv.Atoi(ent.Name())
+		if err != nil {
+			continue
+		}
+		comm, err := readComm(filepath.Join(dir, ent.Name(), "comm"))
+		if err != nil {
+			// The thread probably exited.
+			continue
+		}
+		threads = append(threads, ThreadInfo{tid, comm})
+	}
+	return threads, nil
+}
+
+// readTgid returns the ID of the process containing thread tid.
+func readTgid(tid int) (int, error) {
+	path := filepath.Join("/proc", strconv.Itoa(tid), "status")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "Tgid:"); ok {
+			return strconv.Atoi(strings.TrimSpace(v))
+		}
+	}
+	return 0, fmt.Errorf("%s: no Tgid", path)
+}
+
+// readThreadStart returns the start time of thread tid of process pid, in
+// clock ticks since boot. Together with the TID, this identifies a thread even
+// if its TID is reused after it exits.
+func readThreadStart(pid, tid int) (uint64, error) {
+	path := filepath.Join("/proc", strconv.Itoa(pid), "task", strconv.Itoa(tid), "stat")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	start, err := parseStatStart(data)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return start, nil
+}
+
+// parseStatStart returns the starttime field of the contents of
+// /proc/<pid>/stat.
+func parseStatStart(data []byte) (uint64, error) {
+	// The comm field is in parentheses and may contain anything, including
+	// spaces and parentheses, so find the fields after its last ")".
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("malformed stat %q", data)
+	}
+	// The fields after comm start at field 3, and starttime is field 22.
+	f := strings.Fields(string(data[i+1:]))
+	if len(f) < 20 {
+		return 0, fmt.Errorf("malformed stat %q", data)
+	}
+	return strconv.ParseUint(f[19], 10, 64)
+}
+
+func readComm(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSuffix(data, []byte("\n"))), nil
+}
+
+// parseMaps parses the contents of /proc/<pid>/maps.
+func parseMaps(data []byte) ([]Mapping, error) {
+	var maps []Mapping
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		// Format: start-end perm offset dev inode path
+		line := sc.Text()
+		f := strings.Fields(line)
+		if len(f) < 5 {
+			return nil, fmt.Errorf("malformed line %q", line)
+		}
+		lo, hi, ok := strings.Cut(f[0], "-")
+		if !ok {
+			return nil, fmt.Errorf("malformed address range in %q", line)
+		}
+		var m Mapping
+		var err1, err2, err3, err4 error
+		m.Start, err1 = strconv.ParseUint(lo, 16, 64)
+		m.End, err2 = strconv.ParseUint(hi, 16, 64)
+		m.Perm = f[1]
+		m.Offset, err3 = strconv.ParseUint(f[2], 16, 64)
+		m.Inode, err4 = strconv.ParseUint(f[4], 10, 64)
+		for _, err := range []error{err1, err2, err3, err4} {
+			if err != nil {
+				return nil, fmt.Errorf("malformed line %q: %w", line, err)
+			}
+		}
+		// The path may contain spaces, so take everything after the inode
+		// field.
+		rest := line
+		for i := 0; i < 5; i++ {
+			_, rest, _ = strings.Cut(strings.TrimLeft(rest, " "), " ")
+		}
+		m.Path = strings.TrimSpace(rest)
+		maps = append(maps, m)
+	}
+	return maps, sc.Err()
+}
+
+// processInfoRecords returns synthetic [RecordComm] records for the threads of
+// info and [RecordMmap2] records for its executable mappings, like the records
+// "perf record" synthesizes for processes that were already running. The
+// records have the given ID and are encoded according to format.
+func processInfoRecords(info *ProcessInfo, id uint64, format SampleFormat) []RawRecord {
+	pid := uint32(info.PID)
+	u32, u64 := binary.NativeEndian.AppendUint32, binary.NativeEndian.AppendUint64
+	var recs []RawRecord
+	for _, t := range info.Threads {
+		buf := u32(u32(nil, pid), uint32(t.TID))
+		buf = appendCString(buf, t.Comm)
+		buf = appendRecordID(buf, RecordID{PID: pid, TID: uint32(t.TID), ID: id}, format)
+		recs = append(recs, RawRecord{Type: RecordComm, Data: buf})
+	}
+	for _, m := range info.Maps {
+		if !strings.Contains(m.Perm, "x") {
+			continue
+		}
+		buf := u32(u32(nil, pid), pid)
+		buf = u64(u64(u64(buf, m.Start), m.End-m.Start), m.Offset)
+		buf = u32(u32(buf, 0), 0) // maj, min
+		buf = u64(u64(buf, m.Inode), 0)
+		buf = u32(u32(buf, unix.PROT_READ|unix.PROT_EXEC), unix.MAP_PRIVATE)
+		path := m.Path
+		if path == "" {
+			path = "//anon"
+		}
+		buf = appendCString(buf, path)
+		buf = appendRecordID(buf, RecordID{PID: pid, TID: pid, ID: id}, format)
+		// perf uses the CPU mode in misc to find the address space.
+		recs = append(recs, RawRecord{Type: RecordMmap2, Misc: unix.PERF_RECORD_MISC_USER, Data: buf})
+	}
+	return recs
+}
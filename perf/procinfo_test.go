@@ -0,0 +1,80 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseMaps(t *testing.T) {
+	const data = `00400000-00452000 r-xp 00000000 08:02 173521      /usr/bin/my prog
+7ffd1000-7ffd2000 rw-p 00001000 00:00 0                          [stack]
+7ffd3000-7ffd4000 rw-p 00000000 00:00 0
+`
+	want := []Mapping{
+		{0x400000, 0x452000, 0, "r-xp", 173521, "/usr/bin/my prog"},
+		{0x7ffd1000, 0x7ffd2000, 0x1000, "rw-p", 0, "[stack]"},
+		{0x7ffd3000, 0x7ffd4000, 0, "rw-p", 0, ""},
+	}
+	got, err := parseMaps([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d mappings, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("mapping %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseStatStart(t *testing.T) {
+	// The comm may contain spaces and parentheses.
+	const data = "1234 (a) b (c) S 1 1234 1234 0 -1 4194560 100 0 0 0 5 3 0 0 20 0 4 0 987654 10000000 500 18446744073709551615\n"
+	got, err := parseStatStart([]byte(data))
+	if err != nil || got != 987654 {
+		t.Errorf("got %d, %v; want 987654", got, err)
+	}
+	if _, err := parseStatStart([]byte("1234 (a) S 1")); err == nil {
+		t.Errorf("truncated stat: want error")
+	}
+}
+
+func TestReadProcessInfo(t *testing.T) {
+	info, err := ReadProcessInfo(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Comm == "" {
+		t.Errorf("empty comm")
+	}
+	foundMain := false
+	for _, th := range info.Threads {
+		if th.TID == os.Getpid() {
+			foundMain = true
+		}
+	}
+	if !foundMain {
+		t.Errorf("main thread %d not found in %+v", os.Getpid(), info.Threads)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	foundExe := false
+	for _, m := range info.Maps {
+		if m.Path == exe {
+			foundExe = true
+		}
+	}
+	if !foundExe {
+		t.Errorf("executable %s not found in mappings", exe)
+	}
+}
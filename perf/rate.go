@@ -0,0 +1,56 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import "time"
+
+// A TimedCount is a [Count] along with the wall-clock time it was read.
+type TimedCount struct {
+	Count Count
+	Time  time.Time
+}
+
+// ReadOneTimed is like [Counter.ReadOne], but also records the current time.
+func (c *Counter) ReadOneTimed() (TimedCount, error) {
+	count, err := c.ReadOne()
+	return TimedCount{count, time.Now()}, err
+}
+
+// Rate is the rate of an event over some period, as computed by
+// [TimedCount.RateSince].
+type Rate struct {
+	// PerWallSecond is the number of events per second of wall-clock time.
+	// This is computed from the scaled [Count.Value], so it accounts for
+	// multiplexing and for the event's scale factor. If the counter was
+	// stopped for some of the period, this rate is lower than the rate while
+	// it was running.
+	PerWallSecond float64
+
+	// PerRunningSecond is the number of events per second the counter was
+	// actually running on the hardware. This is the directly measured rate,
+	// without any extrapolation for multiplexing.
+	PerRunningSecond float64
+
+	// Unit is the unit of the event, or "" for a plain count. The unit of the
+	// rates is Unit per second.
+	Unit string
+}
+
+// RateSince returns the rate of the event between start and c, which must be
+// Counts of the same event. If no time elapsed, the rates are 0.
+func (c TimedCount) RateSince(start TimedCount) Rate {
+	d := c.Count.Sub(start.Count)
+	val, unit := d.Value()
+	r := Rate{Unit: unit}
+	if wall := c.Time.Sub(start.Time); wall > 0 {
+		r.PerWallSecond = val / wall.Seconds()
+	}
+	if d.TimeRunning > 0 {
+		r.PerRunningSecond = float64(d.RawValue) * d.scale.scale / d.Running().Seconds()
+	}
+	return r
+}
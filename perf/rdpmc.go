@@ -0,0 +1,193 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ReadPath is how a [Counter] read the values of its events.
+type ReadPath uint8
+
+const (
+	// ReadPathNone indicates the Counter hasn't been read.
+	ReadPathNone ReadPath = iota
+	// ReadPathSyscall indicates the Counter read all events with one read
+	// system call.
+	ReadPathSyscall
+	// ReadPathRDPMC indicates the Counter read all events directly from
+	// the hardware counters using the RDPMC instruction, which is much
+	// faster than a system call.
+	ReadPathRDPMC
+)
+
+func (p ReadPath) String() string {
+	switch p {
+	case ReadPathNone:
+		return "none"
+	case ReadPathSyscall:
+		return "syscall"
+	case ReadPathRDPMC:
+		return "rdpmc"
+	}
+	return fmt.Sprintf("ReadPath(%d)", uint8(p))
+}
+
+// LastReadPath returns how c read its events for the most recent read, such
+// as [Counter.ReadGroup] or [Counter.Reset].
+//
+// c uses RDPMC only if it monitors [TargetThisGoroutine] and is read by that
+// goroutine (not, for example, by [Counter.Stream]), the architecture
+// supports it (currently only amd64), the kernel can extrapolate the enabled
+// and running times from the time stamp counter (which some virtual machines
+// don't support), and every event being read is currently scheduled on a
+// hardware counter. If any event isn't, such as a software event, a stopped
+// event, or an event that is multiplexed out, c reads the whole group with a
+// system call. It never mixes the two, since they would read the events at
+// different instants.
+func (c *Counter) LastReadPath() ReadPath {
+	if c == nil {
+		return ReadPathNone
+	}
+	return c.lastRead
+}
+
+// Bits of perf_event_mmap_page.capabilities.
+const (
+	capBit0IsDeprecated = 1 << 1
+	capUserRDPMC        = 1 << 2
+	capUserTime         = 1 << 3
+	capUserTimeShort    = 1 << 5
+)
+
+// mapUserPages maps the control page of each event in c so it can read them
+// with RDPMC. If this fails, c will always read its events with a system
+// call.
+func (c *Counter) mapUserPages() {
+	if !haveRDPMC {
+		return
+	}
+	if _, ok := c.target.(targetThisGoroutine); !ok {
+		// RDPMC reads the counters of the current thread.
+		return
+	}
+	pageSize := os.Getpagesize()
+	for _, fd := range c.fds {
+		m, err := sys.mmap(fd, 0, pageSize)
+		if err != nil {
+			c.unmapUserPages()
+			return
+		}
+		c.userMmaps = append(c.userMmaps, m)
+		c.userPages = append(c.userPages, (*unix.PerfEventMmapPage)(unsafe.Pointer(&m[0])))
+	}
+	c.userSeqs = make([]uint32, len(c.userPages))
+	// The target goroutine is locked to this thread until c is closed.
+	c.userTID = unix.Gettid()
+}
+
+func (c *Counter) unmapUserPages() {
+	for _, m := range c.userMmaps {
+		sys.munmap(m)
+	}
+	c.userMmaps, c.userPages, c.userSeqs, c.userTID = nil, nil, nil, 0
+}
+
+// readUser reads the values of the first len(cs) events in c using RDPMC. If
+// any of these events can't be read this way, it returns false, and the
+// caller must read the whole group with a system call instead.
+func (c *Counter) readUser(cs []Count) bool {
+	if c.userPages == nil {
+		return false
+	}
+	// RDPMC reads the counters of the current thread, which are only c's
+	// events on the thread c monitors. That thread is locked to the target
+	// goroutine, so no other goroutine can be running on it.
+	if unix.Gettid() != c.userTID {
+		return false
+	}
+	n := min(len(cs), c.nEvents)
+	pages, seqs := c.userPages[:n], c.userSeqs[:n]
+	// Each page is protected by a sequence lock that the kernel updates
+	// whenever it reschedules the event, so the values are consistent if no
+	// page's sequence number changed while we read them all.
+	for try := 0; try < 4; try++ {
+		for i, pg := range pages {
+			seqs[i] = atomic.LoadUint32(&pg.Lock)
+		}
+		// All events in a group are scheduled together, so we use the
+		// leader's times for all of them, like the group read format.
+		enabled, running, ok := userTimes(pages[0])
+		if !ok {
+			return false
+		}
+		for i, pg := range pages {
+			val, ok := userValue(pg)
+			if !ok {
+				return false
+			}
+			cs[i].RawValue = val
+			cs[i].TimeEnabled = enabled
+			cs[i].TimeRunning = running
+			// Lost samples only apply to sampling events.
+			cs[i].Lost = 0
+			cs[i].scale = c.eventScales[i]
+		}
+		consistent := true
+		for i, pg := range pages {
+			if atomic.LoadUint32(&pg.Lock) != seqs[i] {
+				consistent = false
+				break
+			}
+		}
+		if consistent {
+			return true
+		}
+	}
+	return false
+}
+
+// userValue reads the current value of the event controlled by pg with RDPMC,
+// if the event is currently scheduled on a hardware counter.
+func userValue(pg *unix.PerfEventMmapPage) (uint64, bool) {
+	caps := atomic.LoadUint64(&pg.Capabilities)
+	idx := atomic.LoadUint32(&pg.Index)
+	if caps&capBit0IsDeprecated == 0 || caps&capUserRDPMC == 0 || idx == 0 {
+		return 0, false
+	}
+	// The counter is only pmc_width bits wide, so sign extend it.
+	shift := 64 - pg.Pmc_width
+	pmc := int64(sys.rdpmc(idx-1)<<shift) >> shift
+	return uint64(atomic.LoadInt64(&pg.Offset) + pmc), true
+}
+
+// userTimes returns the current enabled and running times of the running
+// event controlled by pg. The page only records the times as of when the
+// event was last scheduled, so this extrapolates from there using the time
+// stamp counter.
+func userTimes(pg *unix.PerfEventMmapPage) (enabled, running uint64, ok bool) {
+	caps := atomic.LoadUint64(&pg.Capabilities)
+	if caps&capBit0IsDeprecated == 0 || caps&capUserTime == 0 {
+		return 0, 0, false
+	}
+	enabled = atomic.LoadUint64(&pg.Time_enabled)
+	running = atomic.LoadUint64(&pg.Time_running)
+
+	cyc := sys.rdtsc()
+	if caps&capUserTimeShort != 0 {
+		cyc = pg.Time_cycles + (cyc-pg.Time_cycles)&pg.Time_mask
+	}
+	shift, mult := pg.Time_shift, uint64(pg.Time_mult)
+	quot, rem := cyc>>shift, cyc&(1<<shift-1)
+	delta := pg.Time_offset + quot*mult + (rem*mult)>>shift
+	return enabled + delta, running + delta, true
+}
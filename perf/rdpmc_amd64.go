@@ -0,0 +1,16 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+// haveRDPMC indicates that this architecture implements rdpmc and rdtsc.
+const haveRDPMC = true
+
+// rdpmc reads hardware performance counter counter.
+func rdpmc(counter uint32) uint64
+
+// rdtsc reads the time stamp counter.
+func rdtsc() uint64
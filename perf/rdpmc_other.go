@@ -0,0 +1,13 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux && !amd64
+
+package perf
+
+const haveRDPMC = false
+
+func rdpmc(counter uint32) uint64 { panic("rdpmc not supported") }
+
+func rdtsc() uint64 { panic("rdtsc not supported") }
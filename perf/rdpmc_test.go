@@ -0,0 +1,115 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"testing"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestRDPMC(t *testing.T) {
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventInstructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if c.LastReadPath() != ReadPathNone {
+		t.Errorf("before reading, got path %s, want %s", c.LastReadPath(), ReadPathNone)
+	}
+
+	c.Start()
+	var prev [2]Count
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10000; j++ {
+		}
+		var cs [2]Count
+		if err := c.ReadGroup(cs[:]); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 && c.LastReadPath() != ReadPathRDPMC {
+			t.Skipf("running counter read using %s", c.LastReadPath())
+		}
+		for i, count := range cs {
+			checkCount(t, count, prev[i])
+		}
+		prev = cs
+	}
+	c.Stop()
+
+	// The stopped counter is read with a system call, which should be
+	// consistent with the values read with RDPMC.
+	var cs [2]Count
+	if err := c.ReadGroup(cs[:]); err != nil {
+		t.Fatal(err)
+	}
+	if c.LastReadPath() != ReadPathSyscall {
+		t.Errorf("stopped counter read using %s, want %s", c.LastReadPath(), ReadPathSyscall)
+	}
+	for i, count := range cs {
+		checkCount(t, count, prev[i])
+	}
+}
+
+func TestRDPMCMixedGroup(t *testing.T) {
+	// The software event can't be read with RDPMC, so the whole group must
+	// be read with a system call.
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Start()
+	defer c.Stop()
+	var cs [2]Count
+	if err := c.ReadGroup(cs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	leaderPath := c.LastReadPath()
+	if err := c.ReadGroup(cs[:]); err != nil {
+		t.Fatal(err)
+	}
+	if c.LastReadPath() != ReadPathSyscall {
+		t.Errorf("mixed group read using %s, want %s", c.LastReadPath(), ReadPathSyscall)
+	}
+	t.Logf("leader alone read using %s", leaderPath)
+}
+
+func TestReadAllocs(t *testing.T) {
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventInstructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Start()
+	defer c.Stop()
+	cs := make([]Count, 2)
+	allocs := testing.AllocsPerRun(100, func() {
+		c.ReadGroup(cs)
+	})
+	if allocs != 0 {
+		t.Errorf("ReadGroup using %s allocated %v times, want 0", c.LastReadPath(), allocs)
+	}
+}
+
+func BenchmarkReadOne(b *testing.B) {
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Start()
+	defer c.Stop()
+	for i := 0; i < b.N; i++ {
+		c.ReadOne()
+	}
+	b.Logf("read using %s", c.LastReadPath())
+}
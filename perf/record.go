@@ -0,0 +1,87 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// RecordType is the type of a record in a perf ring buffer. These correspond
+// to the PERF_RECORD_* constants.
+type RecordType uint32
+
+const (
+	RecordMmap          RecordType = unix.PERF_RECORD_MMAP
+	RecordLost          RecordType = unix.PERF_RECORD_LOST
+	RecordComm          RecordType = unix.PERF_RECORD_COMM
+	RecordExit          RecordType = unix.PERF_RECORD_EXIT
+	RecordThrottle      RecordType = unix.PERF_RECORD_THROTTLE
+	RecordUnthrottle    RecordType = unix.PERF_RECORD_UNTHROTTLE
+	RecordFork          RecordType = unix.PERF_RECORD_FORK
+	RecordRead          RecordType = unix.PERF_RECORD_READ
+	RecordSample        RecordType = unix.PERF_RECORD_SAMPLE
+	RecordMmap2         RecordType = unix.PERF_RECORD_MMAP2
+	RecordAux           RecordType = unix.PERF_RECORD_AUX
+	RecordItraceStart   RecordType = unix.PERF_RECORD_ITRACE_START
+	RecordLostSamples   RecordType = unix.PERF_RECORD_LOST_SAMPLES
+	RecordSwitch        RecordType = unix.PERF_RECORD_SWITCH
+	RecordSwitchCPUWide RecordType = unix.PERF_RECORD_SWITCH_CPU_WIDE
+	RecordNamespaces    RecordType = unix.PERF_RECORD_NAMESPACES
+	RecordKsymbol       RecordType = unix.PERF_RECORD_KSYMBOL
+	RecordBPFEvent      RecordType = unix.PERF_RECORD_BPF_EVENT
+	RecordCgroup        RecordType = unix.PERF_RECORD_CGROUP
+	RecordTextPoke      RecordType = unix.PERF_RECORD_TEXT_POKE
+	RecordAuxOutputHWID RecordType = unix.PERF_RECORD_AUX_OUTPUT_HW_ID
+)
+
+var recordTypeNames = []string{
+	RecordMmap:          "MMAP",
+	RecordLost:          "LOST",
+	RecordComm:          "COMM",
+	RecordExit:          "EXIT",
+	RecordThrottle:      "THROTTLE",
+	RecordUnthrottle:    "UNTHROTTLE",
+	RecordFork:          "FORK",
+	RecordRead:          "READ",
+	RecordSample:        "SAMPLE",
+	RecordMmap2:         "MMAP2",
+	RecordAux:           "AUX",
+	RecordItraceStart:   "ITRACE_START",
+	RecordLostSamples:   "LOST_SAMPLES",
+	RecordSwitch:        "SWITCH",
+	RecordSwitchCPUWide: "SWITCH_CPU_WIDE",
+	RecordNamespaces:    "NAMESPACES",
+	RecordKsymbol:       "KSYMBOL",
+	RecordBPFEvent:      "BPF_EVENT",
+	RecordCgroup:        "CGROUP",
+	RecordTextPoke:      "TEXT_POKE",
+	RecordAuxOutputHWID: "AUX_OUTPUT_HW_ID",
+}
+
+// String returns the name of t without the "PERF_RECORD_" prefix, such as
+// "SAMPLE".
+func (t RecordType) String() string {
+	if int(t) < len(recordTypeNames) && recordTypeNames[t] != "" {
+		return recordTypeNames[t]
+	}
+	return fmt.Sprintf("RecordType(%d)", uint32(t))
+}
+
+// A RawRecord is an undecoded record from a perf ring buffer.
+type RawRecord struct {
+	Type RecordType
+	Misc uint16 // PERF_RECORD_MISC_* flags
+
+	// Data is the body of the record following the perf_event_header. Its
+	// layout depends on Type and on how the event was configured.
+	//
+	// Data may point directly into the ring buffer, so it's only valid until
+	// the next call to [Sampler.ReadRecord] or [Sampler.Close].
+	Data []byte
+}
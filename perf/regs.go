@@ -0,0 +1,151 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"fmt"
+	"math/bits"
+	"runtime"
+	"strings"
+)
+
+// A Reg is a register number in the kernel's PERF_REG_* numbering for the
+// current architecture. This package defines constants for the registers of
+// amd64 and arm64, such as RegIP and RegSP.
+type Reg uint8
+
+func (r Reg) String() string {
+	if int(r) < len(regNames) && regNames[r] != "" {
+		return regNames[r]
+	}
+	return fmt.Sprintf("Reg(%d)", uint8(r))
+}
+
+// RegMask is a set of [Reg]s, with bit r set if register r is in the set.
+type RegMask uint64
+
+// RegMaskOf returns the RegMask containing regs.
+func RegMaskOf(regs ...Reg) RegMask {
+	var m RegMask
+	for _, r := range regs {
+		m |= 1 << r
+	}
+	return m
+}
+
+// DefaultRegMask is the set of user registers the kernel can sample on this
+// architecture. It is 0 if this package doesn't support sampling registers on
+// this architecture.
+const DefaultRegMask RegMask = defaultRegMask
+
+// String returns the registers in m in the form "IP|SP".
+func (m RegMask) String() string {
+	return flagsString(uint64(m), regNames)
+}
+
+// Has reports whether r is in m.
+func (m RegMask) Has(r Reg) bool {
+	return r < 64 && m&(1<<r) != 0
+}
+
+// RegsABI is the ABI of the registers in a [Regs], which is the ABI of the
+// thread that was sampled.
+type RegsABI uint64
+
+const (
+	// RegsABINone indicates that no registers were sampled, such as for
+	// a sample taken in a kernel thread.
+	RegsABINone RegsABI = iota
+	RegsABI32           // 32-bit ABI, such as a 386 process on amd64
+	RegsABI64           // 64-bit ABI
+)
+
+func (a RegsABI) String() string {
+	switch a {
+	case RegsABINone:
+		return "none"
+	case RegsABI32:
+		return "32"
+	case RegsABI64:
+		return "64"
+	}
+	return fmt.Sprintf("RegsABI(%d)", uint64(a))
+}
+
+// Regs is the register state of a sampled thread.
+type Regs struct {
+	ABI RegsABI
+
+	// Mask is the set of registers in Values. If ABI is RegsABINone, this
+	// is 0.
+	Mask RegMask
+
+	// Values are the values of the registers in Mask, in increasing order
+	// of register number. Use [Regs.Get] to look up a specific register.
+	Values []uint64
+}
+
+// Get returns the value of register r, or false if r wasn't sampled.
+func (r *Regs) Get(reg Reg) (uint64, bool) {
+	if !r.Mask.Has(reg) {
+		return 0, false
+	}
+	// The index of reg is the number of lower registers in the mask.
+	i := bits.OnesCount64(uint64(r.Mask) & (1<<reg - 1))
+	if i >= len(r.Values) {
+		return 0, false
+	}
+	return r.Values[i], true
+}
+
+// String returns the registers in r in the form "IP=0x401000 SP=0xc000010000".
+func (r *Regs) String() string {
+	if r.ABI == RegsABINone {
+		return "<none>"
+	}
+	var sb strings.Builder
+	for reg := Reg(0); reg < 64; reg++ {
+		val, ok := r.Get(reg)
+		if !ok {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, "%s=%#x", reg, val)
+	}
+	return sb.String()
+}
+
+// validateRegMask returns an error if m isn't a valid sample_regs_user mask.
+func validateRegMask(m RegMask) error {
+	if defaultRegMask == 0 {
+		return fmt.Errorf("sampling registers is not supported on %s", runtime.GOARCH)
+	}
+	if m == 0 {
+		return fmt.Errorf("register mask is empty")
+	}
+	if bad := m &^ defaultRegMask; bad != 0 {
+		return fmt.Errorf("register mask %s includes unsupported registers %s", m, bad)
+	}
+	return nil
+}
+
+// regs decodes a struct of the ABI and the registers in mask, as used by
+// PERF_SAMPLE_REGS_USER, appending the values to vals.
+func (d *sampleDecoder) regs(mask RegMask, vals []uint64) Regs {
+	r := Regs{ABI: RegsABI(d.u64())}
+	if r.ABI == RegsABINone {
+		return r
+	}
+	r.Mask = mask
+	for i := 0; i < bits.OnesCount64(uint64(mask)); i++ {
+		vals = append(vals, d.u64())
+	}
+	r.Values = vals
+	return r
+}
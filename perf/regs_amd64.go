@@ -0,0 +1,45 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+// Registers of amd64. See arch/x86/include/uapi/asm/perf_regs.h.
+const (
+	RegAX Reg = iota
+	RegBX
+	RegCX
+	RegDX
+	RegSI
+	RegDI
+	RegBP
+	RegSP
+	RegIP
+	RegFLAGS
+	RegCS
+	RegSS
+	RegDS
+	RegES
+	RegFS
+	RegGS
+	RegR8
+	RegR9
+	RegR10
+	RegR11
+	RegR12
+	RegR13
+	RegR14
+	RegR15
+)
+
+var regNames = []string{
+	"AX", "BX", "CX", "DX", "SI", "DI", "BP", "SP", "IP", "FLAGS", "CS",
+	"SS", "DS", "ES", "FS", "GS", "R8", "R9", "R10", "R11", "R12", "R13",
+	"R14", "R15",
+}
+
+// The kernel doesn't support sampling the DS, ES, FS, and GS segment
+// registers of 64-bit threads.
+const defaultRegMask = (1<<(RegR15+1) - 1) &^ (1<<RegDS | 1<<RegES | 1<<RegFS | 1<<RegGS)
@@ -0,0 +1,56 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+// Registers of arm64. See arch/arm64/include/uapi/asm/perf_regs.h.
+const (
+	RegX0 Reg = iota
+	RegX1
+	RegX2
+	RegX3
+	RegX4
+	RegX5
+	RegX6
+	RegX7
+	RegX8
+	RegX9
+	RegX10
+	RegX11
+	RegX12
+	RegX13
+	RegX14
+	RegX15
+	RegX16
+	RegX17
+	RegX18
+	RegX19
+	RegX20
+	RegX21
+	RegX22
+	RegX23
+	RegX24
+	RegX25
+	RegX26
+	RegX27
+	RegX28
+	RegX29
+	RegLR
+	RegSP
+	RegPC
+
+	RegFP = RegX29
+	RegIP = RegPC
+)
+
+var regNames = []string{
+	"X0", "X1", "X2", "X3", "X4", "X5", "X6", "X7", "X8", "X9", "X10",
+	"X11", "X12", "X13", "X14", "X15", "X16", "X17", "X18", "X19", "X20",
+	"X21", "X22", "X23", "X24", "X25", "X26", "X27", "X28", "X29", "LR",
+	"SP", "PC",
+}
+
+const defaultRegMask = 1<<(RegPC+1) - 1
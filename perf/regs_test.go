@@ -0,0 +1,222 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux && (amd64 || arm64)
+
+package perf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestDecodeRegsUser(t *testing.T) {
+	mask := RegMaskOf(RegSP, RegIP)
+	format := SampleFormat{SampleType: SampleIP | SampleRegsUser, RegsUser: mask}
+	var data []byte
+	data = binary.NativeEndian.AppendUint64(data, 0x401000) // ip
+	data = binary.NativeEndian.AppendUint64(data, uint64(RegsABI64))
+	// Registers are in order of register number.
+	vals := map[Reg]uint64{RegSP: 0xc000010000, RegIP: 0x401000}
+	lo, hi := RegSP, RegIP
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	data = binary.NativeEndian.AppendUint64(data, vals[lo])
+	data = binary.NativeEndian.AppendUint64(data, vals[hi])
+
+	var s Sample
+	if err := DecodeSample(RawRecord{Type: RecordSample, Data: data}, format, &s); err != nil {
+		t.Fatal(err)
+	}
+	want := Regs{ABI: RegsABI64, Mask: mask, Values: []uint64{vals[lo], vals[hi]}}
+	if !reflect.DeepEqual(s.RegsUser, want) {
+		t.Errorf("got %+v, want %+v", s.RegsUser, want)
+	}
+	for reg, val := range vals {
+		if got, ok := s.RegsUser.Get(reg); !ok || got != val {
+			t.Errorf("Get(%s) = %#x, %v; want %#x, true", reg, got, ok, val)
+		}
+	}
+	if _, ok := s.RegsUser.Get(0); ok {
+		t.Errorf("Get(%s) of unsampled register succeeded", Reg(0))
+	}
+	if got, want := s.RegsUser.String(), fmt.Sprintf("%s=%#x %s=%#x", lo, vals[lo], hi, vals[hi]); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Truncated registers are an error.
+	if err := DecodeSample(RawRecord{Type: RecordSample, Data: data[:len(data)-1]}, format, &s); err != errShortSample {
+		t.Errorf("truncated: got %v, want %v", err, errShortSample)
+	}
+
+	// A thread without user state has only the ABI.
+	data = binary.NativeEndian.AppendUint64(data[:8], uint64(RegsABINone))
+	if err := DecodeSample(RawRecord{Type: RecordSample, Data: data}, format, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.RegsUser.ABI != RegsABINone || s.RegsUser.Mask != 0 || len(s.RegsUser.Values) != 0 {
+		t.Errorf("got %+v, want no registers", s.RegsUser)
+	}
+}
+
+func TestRegMask(t *testing.T) {
+	m := RegMaskOf(RegSP, RegIP)
+	if !m.Has(RegSP) || !m.Has(RegIP) || m.Has(0) {
+		t.Errorf("%s has the wrong registers", m)
+	}
+	if !DefaultRegMask.Has(RegIP) || !DefaultRegMask.Has(RegSP) {
+		t.Errorf("DefaultRegMask %s doesn't include IP and SP", DefaultRegMask)
+	}
+
+	opts := SamplerOptions{SampleType: SampleIP | SampleRegsUser, RegsUser: DefaultRegMask | 1<<63}
+	if s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock); err == nil {
+		s.Close()
+		t.Errorf("opening with register mask %s: want error", opts.RegsUser)
+	}
+}
+
+//go:noinline
+func spinForRegsTest(d time.Duration) {
+	start := time.Now()
+	for time.Since(start) < d {
+	}
+}
+
+// spinStackTest spins for n iterations without calling into the runtime, so
+// samples of it are on the goroutine stack even under the race detector,
+// which runs most runtime calls on the system stack.
+//
+//go:noinline
+func spinStackTest(n int) int {
+	x := 0
+	for i := 0; i < n; i++ {
+		x += i * i
+	}
+	return x
+}
+
+func TestSamplerRegsUser(t *testing.T) {
+	opts := SamplerOptions{
+		SampleType: SampleIP | SampleRegsUser,
+		Period:     100000,
+	}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := s.SampleFormat().RegsUser; got != DefaultRegMask {
+		t.Errorf("got register mask %s, want %s", got, DefaultRegMask)
+	}
+
+	s.Start()
+	spinForRegsTest(50 * time.Millisecond)
+	s.Stop()
+
+	var smpl Sample
+	user := 0
+	for {
+		rec, ok := s.ReadRecord()
+		if !ok {
+			break
+		}
+		if rec.Type != RecordSample {
+			continue
+		}
+		if err := DecodeSample(rec, s.SampleFormat(), &smpl); err != nil {
+			t.Fatal(err)
+		}
+		if smpl.RegsUser.ABI != RegsABI64 {
+			t.Fatalf("got registers with ABI %s, want %s", smpl.RegsUser.ABI, RegsABI64)
+		}
+		if rec.Misc&unix.PERF_RECORD_MISC_CPUMODE_MASK != unix.PERF_RECORD_MISC_USER {
+			continue
+		}
+		// In a user-space sample, the user IP is the sample IP.
+		user++
+		if ip, _ := smpl.RegsUser.Get(RegIP); ip != smpl.IP {
+			t.Errorf("got user IP %#x, want sample IP %#x", ip, smpl.IP)
+		}
+		if sp, _ := smpl.RegsUser.Get(RegSP); sp == 0 {
+			t.Errorf("got zero SP")
+		}
+	}
+	if user == 0 {
+		t.Skipf("no user-space samples")
+	}
+}
+
+func TestSamplerStackUser(t *testing.T) {
+	opts := SamplerOptions{
+		SampleType: SampleIP | SampleStackUser,
+		StackUser:  4096,
+		Period:     100000,
+	}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.SampleType()&SampleRegsUser == 0 {
+		t.Errorf("sample type %s doesn't include REGS_USER", s.SampleType())
+	}
+
+	s.Start()
+	for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+		spinStackTest(1e5)
+	}
+	s.Stop()
+
+	// Some user sample should have our return address from spinStackTest
+	// on the stack.
+	var smpl Sample
+	user, found := 0, false
+	for {
+		rec, ok := s.ReadRecord()
+		if !ok {
+			break
+		}
+		if rec.Type != RecordSample {
+			continue
+		}
+		if err := DecodeSample(rec, s.SampleFormat(), &smpl); err != nil {
+			t.Fatal(err)
+		}
+		if len(smpl.StackUser) > 4096 {
+			t.Fatalf("got %d byte stack, want at most 4096", len(smpl.StackUser))
+		}
+		if rec.Misc&unix.PERF_RECORD_MISC_CPUMODE_MASK != unix.PERF_RECORD_MISC_USER {
+			continue
+		}
+		user++
+		for i := 0; i+8 <= len(smpl.StackUser); i += 8 {
+			pc := binary.NativeEndian.Uint64(smpl.StackUser[i:])
+			if f := runtime.FuncForPC(uintptr(pc)); f != nil && strings.HasSuffix(f.Name(), ".TestSamplerStackUser") {
+				found = true
+			}
+		}
+	}
+	if user == 0 {
+		t.Skipf("no user-space samples")
+	}
+	if !found {
+		t.Errorf("no return address into TestSamplerStackUser in %d user stack dumps", user)
+	}
+
+	opts.StackUser = 100
+	if s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock); err == nil {
+		s.Close()
+		t.Errorf("StackUser of 100: want error")
+	}
+}
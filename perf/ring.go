@@ -0,0 +1,97 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// ring reads records from a perf ring buffer.
+//
+// The kernel writes records at data_head and we consume records at data_tail.
+// Both positions increase monotonically and are reduced modulo the size of
+// the data area to index it. The kernel publishes data_head after it writes
+// the records before it, and doesn't overwrite data until we publish a
+// data_tail past it. Go's atomics are sequentially consistent, so they
+// provide the barriers the kernel's protocol requires: loading data_head
+// happens before reading the records, and reading the records happens before
+// storing data_tail.
+type ring struct {
+	meta *unix.PerfEventMmapPage
+	data []byte // Length is a power of two
+
+	// tail is the position of the next unread record. This is ahead of
+	// meta.Data_tail while the caller holds the most recent record.
+	tail uint64
+
+	// buf holds a copy of records that wrap around the end of data.
+	buf []byte
+}
+
+// perfEventHeaderSize is the size of struct perf_event_header.
+const perfEventHeaderSize = 8
+
+// available reports whether there are unread records in r.
+func (r *ring) available() bool {
+	return atomic.LoadUint64(&r.meta.Data_head) != r.tail
+}
+
+// next returns the next record in r, or false if r is empty. It releases the
+// space of the previously returned record to the kernel, so the previously
+// returned record's data is no longer valid.
+func (r *ring) next() (RawRecord, bool) {
+	r.release()
+
+	head := atomic.LoadUint64(&r.meta.Data_head)
+	if head == r.tail {
+		return RawRecord{}, false
+	}
+	if head-r.tail > uint64(len(r.data)) {
+		// The kernel never writes more than the ring holds, so the
+		// control page is corrupted. Skip everything.
+		r.tail = head
+		return RawRecord{}, false
+	}
+
+	// Records are always 8-byte aligned, so the header never wraps.
+	hdr := r.read(r.tail, perfEventHeaderSize)
+	typ := binary.NativeEndian.Uint32(hdr[0:])
+	misc := binary.NativeEndian.Uint16(hdr[4:])
+	size := uint64(binary.NativeEndian.Uint16(hdr[6:]))
+	if size < perfEventHeaderSize || size > head-r.tail {
+		// The kernel never does this, but if something has corrupted the
+		// ring, there's no way to find the next record, so skip everything.
+		r.tail = head
+		return RawRecord{}, false
+	}
+
+	data := r.read(r.tail+perfEventHeaderSize, int(size-perfEventHeaderSize))
+	r.tail += size
+	return RawRecord{RecordType(typ), misc, data}, true
+}
+
+// read returns n bytes of the ring at position pos. If these bytes wrap
+// around the end of the ring, it copies them to r.buf.
+func (r *ring) read(pos uint64, n int) []byte {
+	start := int(pos & uint64(len(r.data)-1))
+	if start+n <= len(r.data) {
+		return r.data[start : start+n]
+	}
+	r.buf = append(r.buf[:0], r.data[start:]...)
+	r.buf = append(r.buf, r.data[:n-(len(r.data)-start)]...)
+	return r.buf
+}
+
+// release tells the kernel it can overwrite all records before r.tail.
+func (r *ring) release() {
+	if atomic.LoadUint64(&r.meta.Data_tail) != r.tail {
+		atomic.StoreUint64(&r.meta.Data_tail, r.tail)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// testRing is a ring buffer written by the test instead of the kernel.
+type testRing struct {
+	ring
+}
+
+func newTestRing(size int) *testRing {
+	r := &testRing{}
+	// Allocate the control page as []uint64 so its 64-bit fields are
+	// aligned for atomic access even on 32-bit platforms.
+	page := make([]uint64, (unsafe.Sizeof(unix.PerfEventMmapPage{})+7)/8)
+	r.meta = (*unix.PerfEventMmapPage)(unsafe.Pointer(&page[0]))
+	r.data = make([]byte, size)
+	return r
+}
+
+// write appends a record with the given type and body to the ring, like the
+// kernel would.
+func (r *testRing) write(typ RecordType, body []byte) {
+	rec := make([]byte, perfEventHeaderSize+len(body))
+	binary.NativeEndian.PutUint32(rec[0:], uint32(typ))
+	binary.NativeEndian.PutUint16(rec[4:], 0)
+	binary.NativeEndian.PutUint16(rec[6:], uint16(len(rec)))
+	copy(rec[perfEventHeaderSize:], body)
+	if r.meta.Data_head+uint64(len(rec))-r.meta.Data_tail > uint64(len(r.data)) {
+		panic("ring full")
+	}
+	for i, b := range rec {
+		r.data[(r.meta.Data_head+uint64(i))%uint64(len(r.data))] = b
+	}
+	r.meta.Data_head += uint64(len(rec))
+}
+
+func TestRing(t *testing.T) {
+	r := newTestRing(64)
+
+	if _, ok := r.next(); ok {
+		t.Fatal("empty ring returned a record")
+	}
+
+	// Write records of various sizes so they eventually wrap around the end
+	// of the ring.
+	for i := 0; i < 20; i++ {
+		body := bytes.Repeat([]byte{byte(i)}, 8*(i%4))
+		r.write(RecordType(i+1), body)
+		if !r.available() {
+			t.Fatalf("record %d: ring not available after write", i)
+		}
+		rec, ok := r.next()
+		if !ok {
+			t.Fatalf("record %d: no record", i)
+		}
+		if rec.Type != RecordType(i+1) || !bytes.Equal(rec.Data, body) {
+			t.Fatalf("record %d: got type %d, data %v; want type %d, data %v", i, rec.Type, rec.Data, i+1, body)
+		}
+		// The tail isn't released until the next call.
+		if r.meta.Data_tail == r.meta.Data_head {
+			t.Fatalf("record %d: tail released while record is held", i)
+		}
+		if _, ok := r.next(); ok {
+			t.Fatalf("record %d: unexpected extra record", i)
+		}
+		if r.meta.Data_tail != r.meta.Data_head {
+			t.Fatalf("record %d: tail %d not released to head %d", i, r.meta.Data_tail, r.meta.Data_head)
+		}
+	}
+}
+
+func TestRingCorrupt(t *testing.T) {
+	// A record larger than the ring, with a head that claims the ring
+	// holds more than its size.
+	r := newTestRing(64)
+	binary.NativeEndian.PutUint32(r.data[0:], uint32(RecordSample))
+	binary.NativeEndian.PutUint16(r.data[6:], 128)
+	r.meta.Data_head = 128
+	if rec, ok := r.next(); ok {
+		t.Fatalf("corrupt ring returned record %+v", rec)
+	}
+	if r.tail != r.meta.Data_head {
+		t.Fatalf("tail %d not advanced to head %d", r.tail, r.meta.Data_head)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"strconv"
+	"time"
+)
+
+// RingSize is the size of a perf ring buffer, as chosen by [ChooseRingSize].
+type RingSize struct {
+	// Pages is the number of data pages in the ring buffer. This is always a
+	// power of two. The kernel requires one additional page for the ring
+	// buffer's control page.
+	Pages int
+
+	// Reason is a human-readable explanation of how Pages was chosen.
+	Reason string
+}
+
+// Bytes returns the size of the ring buffer's data area in bytes.
+func (r RingSize) Bytes() int {
+	return r.Pages * os.Getpagesize()
+}
+
+// RingSizeConfig is the input to [ChooseRingSize]. The zero value is valid
+// and chooses a size suitable for perf's default sampling frequency.
+type RingSizeConfig struct {
+	// Pages, if non-zero, overrides the automatic size selection. It is
+	// rounded up to a power of two.
+	Pages int
+
+	// SampleRate is the expected number of records per second written to the
+	// ring buffer. If 0, this uses 4000, which is perf's default sampling
+	// frequency.
+	SampleRate float64
+
+	// RecordSize is the expected average size of a record in bytes. If 0, this
+	// is estimated from SampleType.
+	RecordSize int
+
+	// SampleType is used to estimate RecordSize if it is not set.
+	SampleType SampleTypeFlags
+
+	// DrainInterval is the expected maximum time between the consumer reading
+	// the ring buffer. If 0, this uses 100ms.
+	DrainInterval time.Duration
+}
+
+const (
+	defaultSampleRate    = 4000
+	defaultDrainInterval = 100 * time.Millisecond
+
+	// ringSlack is how much larger than the expected fill the ring buffer
+	// should be, to absorb bursts and a late consumer.
+	ringSlack = 2
+
+	// minRingPages is the smallest automatically chosen ring buffer size.
+	minRingPages = 8
+)
+
+// ChooseRingSize chooses the number of data pages for a perf ring buffer that
+// can hold cfg.DrainInterval's worth of records with some slack.
+//
+// If the calling process is not privileged, the size is limited to
+// /proc/sys/kernel/perf_event_mlock_kb, since the kernel will otherwise refuse
+// to map the buffer.
+func ChooseRingSize(cfg RingSizeConfig) RingSize {
+	pageSize := os.Getpagesize()
+
+	if cfg.Pages > 0 {
+		pages := ceilPow2(cfg.Pages)
+		reason := fmt.Sprintf("%d pages requested", cfg.Pages)
+		if pages != cfg.Pages {
+			reason += fmt.Sprintf(", rounded up to %d", pages)
+		}
+		return RingSize{pages, reason}
+	}
+
+	rate := cfg.SampleRate
+	if rate <= 0 {
+		rate = defaultSampleRate
+	}
+	recSize := cfg.RecordSize
+	if recSize <= 0 {
+		recSize = cfg.SampleType.estimateRecordSize()
+	}
+	interval := cfg.DrainInterval
+	if interval <= 0 {
+		interval = defaultDrainInterval
+	}
+
+	want := rate * float64(recSize) * interval.Seconds() * ringSlack
+	pages := ceilPow2(int(math.Ceil(want / float64(pageSize))))
+	reason := fmt.Sprintf("%g records/s × %d B/record × %s × %d (slack) = %d B",
+		rate, recSize, interval, ringSlack, int(want))
+	if pages < minRingPages {
+		pages = minRingPages
+		reason += fmt.Sprintf("; using minimum of %d pages", pages)
+	} else {
+		reason += fmt.Sprintf("; rounded up to %d pages", pages)
+	}
+
+	if limit := mlockLimitPages(); limit > 0 && pages > limit {
+		pages = 1 << (bits.Len(uint(limit)) - 1)
+		reason += fmt.Sprintf("; limited to %d pages by perf_event_mlock_kb", pages)
+	}
+
+	return RingSize{pages, reason}
+}
+
+// estimateRecordSize returns an estimate of the size of a PERF_RECORD_SAMPLE
+// with sample type s.
+func (s SampleTypeFlags) estimateRecordSize() int {
+	size := 8 // perf_event_header
+	// Most fields are a single u64 (or two u32s).
+	fixed := SampleIP | SampleTID | SampleTime | SampleAddr | SampleID |
+		SampleCPU | SamplePeriod | SampleStreamID | SampleWeight |
+		SampleDataSrc | SampleIdentifier | SampleTransaction |
+		SamplePhysAddr | SampleCgroup | SampleDataPageSize |
+		SampleCodePageSize | SampleWeightStruct
+	size += 8 * bits.OnesCount64(uint64(s&fixed))
+	// Guess at the size of variable-length fields.
+	if s&SampleRead != 0 {
+		size += 8 * 4
+	}
+	if s&SampleCallchain != 0 {
+		size += 8 * 32
+	}
+	if s&SampleRaw != 0 {
+		size += 64
+	}
+	if s&SampleBranchStack != 0 {
+		size += 8 + 24*32
+	}
+	if s&(SampleRegsUser|SampleRegsIntr) != 0 {
+		size += 8 + 8*20
+	}
+	if s&SampleStackUser != 0 {
+		size += 16 + defaultStackUser
+	}
+	return size
+}
+
+// mlockLimitPages returns the number of ring buffer data pages an
+// unprivileged process can map, or 0 if there is no limit.
+func mlockLimitPages() int {
+	if os.Geteuid() == 0 {
+		return 0
+	}
+	data, err := os.ReadFile("/proc/sys/kernel/perf_event_mlock_kb")
+	if err != nil {
+		return 0
+	}
+	kb, err := strconv.Atoi(string(bytes.TrimSpace(data)))
+	if err != nil || kb <= 0 {
+		return 0
+	}
+	// The limit includes the control page.
+	return kb*1024/os.Getpagesize() - 1
+}
+
+// ceilPow2 returns the smallest power of two >= x.
+func ceilPow2(x int) int {
+	if x <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(x-1))
+}
@@ -0,0 +1,36 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestChooseRingSize(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("results depend on perf_event_mlock_kb when not root")
+	}
+	pageSize := os.Getpagesize()
+	for _, tc := range []struct {
+		cfg  RingSizeConfig
+		want int
+	}{
+		{RingSizeConfig{Pages: 5}, 8},
+		{RingSizeConfig{Pages: 16}, 16},
+		// Tiny rates get the minimum size.
+		{RingSizeConfig{SampleRate: 1, RecordSize: 64}, minRingPages},
+		// 1000 pages worth, doubled for slack, rounded up.
+		{RingSizeConfig{SampleRate: 1000, RecordSize: pageSize, DrainInterval: time.Second}, 2048},
+	} {
+		got := ChooseRingSize(tc.cfg)
+		if got.Pages != tc.want {
+			t.Errorf("%+v: got %d pages, want %d (%s)", tc.cfg, got.Pages, tc.want, got.Reason)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ReserveFDs checks that the process can open n more file descriptors. It
+// raises the soft RLIMIT_NOFILE limit to the hard limit if it is lower. If
+// that is still not enough, it returns an error that reports how many file
+// descriptors are needed.
+//
+// Each event on each CPU or thread requires its own file descriptor, so
+// measuring many events on a large machine can easily require thousands of
+// file descriptors. Callers planning to open many counters can call this
+// first to fail early with a clear error. [OpenCounter] calls this itself if
+// it runs out of file descriptors.
+func ReserveFDs(n int) error {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		return err
+	}
+	if lim.Cur < lim.Max {
+		// Raise the soft limit as far as we can. The Go runtime already does
+		// this at startup, so usually this does nothing. We do this before
+		// counting FDs, since that itself requires an FD. Use the syscall
+		// package rather than unix so the os/exec package knows we changed
+		// the limit.
+		newLim := lim
+		newLim.Cur = lim.Max
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &newLim); err == nil {
+			lim = newLim
+		}
+	}
+	ents, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return err
+	}
+	inUse := uint64(len(ents))
+	need := inUse + uint64(n)
+	if need <= lim.Cur {
+		return nil
+	}
+	return fmt.Errorf("%w: need %d file descriptors (%d in use + %d), but RLIMIT_NOFILE is %d (hard limit %d); consider raising the hard limit (ulimit -Hn)", syscall.EMFILE, need, inUse, n, lim.Cur, lim.Max)
+}
+
+// perfEventOpen calls perf_event_open. If the process is out of file
+// descriptors, it tries to reserve enough for this and the remaining
+// n-1 calls and retries.
+func perfEventOpen(attr *unix.PerfEventAttr, pid, cpu, groupFD, n int) (int, error) {
+	fd, err := sys.perfEventOpen(attr, pid, cpu, groupFD, unix.PERF_FLAG_FD_CLOEXEC)
+	if errors.Is(err, syscall.EMFILE) {
+		if err := ReserveFDs(n); err != nil {
+			return -1, err
+		}
+		fd, err = sys.perfEventOpen(attr, pid, cpu, groupFD, unix.PERF_FLAG_FD_CLOEXEC)
+	}
+	return fd, err
+}
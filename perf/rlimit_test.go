@@ -0,0 +1,53 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"errors"
+	"math"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestRaiseFDLimit(t *testing.T) {
+	var orig syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &orig); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Setrlimit(syscall.RLIMIT_NOFILE, &orig)
+
+	// Lower the soft limit so we're just about out of FDs.
+	ents, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lim := orig
+	lim.Cur = uint64(len(ents)) + 1
+	if lim.Cur+3 > lim.Max {
+		t.Skip("hard RLIMIT_NOFILE too low")
+	}
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening a group should raise the limit.
+	c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventTaskClock, events.EventPageFaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestReserveFDsError(t *testing.T) {
+	err := ReserveFDs(math.MaxInt)
+	if !errors.Is(err, syscall.EMFILE) {
+		t.Fatalf("want EMFILE, got %v", err)
+	}
+}
@@ -0,0 +1,418 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// A Sample is a decoded PERF_RECORD_SAMPLE record. Only the fields selected by
+// the sample type the Sampler was opened with are filled in; the others are
+// zero.
+type Sample struct {
+	Identifier uint64 // SampleIdentifier
+	IP         uint64 // SampleIP
+	PID, TID   uint32 // SampleTID
+	Time       uint64 // SampleTime, in nanoseconds
+	Addr       uint64 // SampleAddr
+	ID         uint64 // SampleID
+	StreamID   uint64 // SampleStreamID
+	CPU        uint32 // SampleCPU
+	Period     uint64 // SamplePeriod
+
+	// Counts is the value of each event in the Sampler's group at the time
+	// of the sample, starting with the sampled event (SampleRead). This can
+	// attribute ratios such as instructions per cycle to each sample.
+	Counts []Count
+
+	// Callchain is the stack of return addresses at the sample, starting
+	// with the sampled IP (SampleCallchain). It may include
+	// PERF_CONTEXT_* markers that indicate switches between kernel and
+	// user space.
+	Callchain []uint64
+
+	// Raw is the raw data recorded by the event (SampleRaw), such as the
+	// fields of a tracepoint. It includes any padding added by the kernel.
+	Raw []byte
+
+	// BranchStack is the most recent branches before the sample, from most
+	// to least recent (SampleBranchStack).
+	BranchStack []BranchEntry
+	// BranchHWIndex is the hardware index of the most recent branch in the
+	// branch stack, or ^0 if unknown. This is only set if the branch sample
+	// type includes BranchHWIndex.
+	BranchHWIndex uint64
+
+	// RegsUser is the user-space register state of the sampled thread
+	// (SampleRegsUser). If the sample was taken in the kernel, these are
+	// the registers at the last entry to the kernel. Its ABI is
+	// RegsABINone if the thread has no user-space state, such as a kernel
+	// thread.
+	RegsUser Regs
+
+	// StackUser is a copy of the top of the sampled thread's user-space
+	// stack, starting at its stack pointer in RegsUser (SampleStackUser).
+	// Together with RegsUser, this lets a DWARF-based unwinder compute the
+	// call stack of code without frame pointers, such as C code called
+	// through cgo. It is shorter than requested if the stack is smaller.
+	StackUser []byte
+
+	// Weight is a measure of the cost of the sampled event, such as the
+	// latency of a memory access in cycles (SampleWeight or
+	// SampleWeightStruct). With SampleWeightStruct, this is only the first
+	// 32 bits of the weight, and Weight2 and Weight3 are the remaining
+	// fields. Their meaning depends on the CPU. On Intel CPUs, Weight2 is
+	// the instruction latency. On Power CPUs, Weight2 and Weight3 are
+	// pipeline stage cycles.
+	Weight           uint64
+	Weight2, Weight3 uint16
+
+	DataSrc DataSrc // SampleDataSrc
+
+	// PhysAddr is the physical address corresponding to Addr
+	// (SamplePhysAddr), or 0 if it is not known. Use [NUMAMap] to find the
+	// NUMA node of the address. Like counting kernel events, recording this
+	// requires CAP_PERFMON or a perf_event_paranoid setting of 1 or less.
+	PhysAddr uint64
+}
+
+// A BranchEntry is one branch in a sample's branch stack.
+type BranchEntry struct {
+	From, To uint64
+
+	Mispredicted bool // The branch target was mispredicted
+	Predicted    bool // The branch target was predicted
+	InTx         bool // The branch was in a transaction
+	Abort        bool // The branch was a transaction abort
+
+	// Cycles is the number of cycles since the previous branch, or 0 if
+	// unknown.
+	Cycles uint16
+
+	// Type is the type of the branch. This is only set if the branch sample
+	// type includes BranchTypeSave.
+	Type BranchType
+}
+
+// BranchType is the type of a branch in a [BranchEntry]. These correspond to
+// the PERF_BR_* constants.
+type BranchType uint8
+
+const (
+	BranchTypeUnknown  BranchType = unix.PERF_BR_UNKNOWN
+	BranchTypeCond     BranchType = unix.PERF_BR_COND
+	BranchTypeUncond   BranchType = unix.PERF_BR_UNCOND
+	BranchTypeInd      BranchType = unix.PERF_BR_IND
+	BranchTypeCall     BranchType = unix.PERF_BR_CALL
+	BranchTypeIndCall  BranchType = unix.PERF_BR_IND_CALL
+	BranchTypeRet      BranchType = unix.PERF_BR_RET
+	BranchTypeSyscall  BranchType = unix.PERF_BR_SYSCALL
+	BranchTypeSysret   BranchType = unix.PERF_BR_SYSRET
+	BranchTypeCondCall BranchType = unix.PERF_BR_COND_CALL
+	BranchTypeCondRet  BranchType = unix.PERF_BR_COND_RET
+	BranchTypeEret     BranchType = unix.PERF_BR_ERET
+	BranchTypeIRQ      BranchType = unix.PERF_BR_IRQ
+	BranchTypeSError   BranchType = unix.PERF_BR_SERROR
+	BranchTypeNoTx     BranchType = unix.PERF_BR_NO_TX
+)
+
+var branchTypeNames = []string{
+	"UNKNOWN", "COND", "UNCOND", "IND", "CALL", "IND_CALL", "RET", "SYSCALL",
+	"SYSRET", "COND_CALL", "COND_RET", "ERET", "IRQ", "SERROR", "NO_TX",
+}
+
+// String returns the name of t without the "PERF_BR_" prefix, such as "CALL".
+func (t BranchType) String() string {
+	if int(t) < len(branchTypeNames) {
+		return branchTypeNames[t]
+	}
+	return fmt.Sprintf("BranchType(%d)", uint8(t))
+}
+
+// SampleFormat describes the layout of sample records, which depends on how
+// the sampled event was configured.
+type SampleFormat struct {
+	SampleType       SampleTypeFlags
+	BranchSampleType BranchSampleFlags
+	ReadFormat       ReadFormatFlags // Format of SampleRead values
+	RegsUser         RegMask         // Registers recorded by SampleRegsUser
+
+	// SampleIDAll indicates that non-sample records end with a
+	// [RecordID]. Samplers always set this.
+	SampleIDAll bool
+
+	// scales are the scales of the events in the group, for [Sample.Counts].
+	// If nil, all events are unscaled.
+	scales []scale
+}
+
+// supportedSampleType is the set of sample type flags DecodeSample can
+// decode.
+const supportedSampleType = SampleIdentifier | SampleIP | SampleTID |
+	SampleTime | SampleAddr | SampleID | SampleStreamID | SampleCPU |
+	SamplePeriod | SampleRead | SampleCallchain | SampleRaw | SampleBranchStack |
+	SampleRegsUser | SampleStackUser | SampleWeight | SampleWeightStruct | SampleDataSrc | SamplePhysAddr
+
+var errShortSample = errors.New("sample record too short")
+
+// DecodeSample decodes rec, which must be a [RecordSample] record, into s,
+// according to format, which must be the format the record was produced with
+// (see [Sampler.SampleFormat]).
+//
+// To reduce allocation, DecodeSample reuses the storage of s.Counts,
+// s.Callchain, s.BranchStack, and s.RegsUser.Values. s.Raw and s.StackUser
+// point into rec.Data, so they're only valid as long as rec.Data is.
+func DecodeSample(rec RawRecord, format SampleFormat, s *Sample) error {
+	sampleType := format.SampleType
+	if rec.Type != RecordSample {
+		return fmt.Errorf("cannot decode %s record as a sample", rec.Type)
+	}
+	if unsupported := sampleType &^ supportedSampleType; unsupported != 0 {
+		return fmt.Errorf("decoding sample type %s is not supported", unsupported)
+	}
+
+	d := sampleDecoder{data: rec.Data}
+	counts, callchain, branches := s.Counts[:0], s.Callchain[:0], s.BranchStack[:0]
+	regsUser := s.RegsUser.Values[:0]
+	*s = Sample{}
+	if sampleType&SampleIdentifier != 0 {
+		s.Identifier = d.u64()
+	}
+	if sampleType&SampleIP != 0 {
+		s.IP = d.u64()
+	}
+	if sampleType&SampleTID != 0 {
+		s.PID, s.TID = d.u32(), d.u32()
+	}
+	if sampleType&SampleTime != 0 {
+		s.Time = d.u64()
+	}
+	if sampleType&SampleAddr != 0 {
+		s.Addr = d.u64()
+	}
+	if sampleType&SampleID != 0 {
+		s.ID = d.u64()
+	}
+	if sampleType&SampleStreamID != 0 {
+		s.StreamID = d.u64()
+	}
+	if sampleType&SampleCPU != 0 {
+		s.CPU = d.u32()
+		d.u32() // Reserved
+	}
+	if sampleType&SamplePeriod != 0 {
+		s.Period = d.u64()
+	}
+	if sampleType&SampleRead != 0 {
+		var err error
+		s.Counts, err = d.counts(format, counts)
+		if err != nil {
+			return err
+		}
+	}
+	if sampleType&SampleCallchain != 0 {
+		n := d.u64()
+		if n > uint64(len(d.data))/8 {
+			return errShortSample
+		}
+		for i := uint64(0); i < n; i++ {
+			callchain = append(callchain, d.u64())
+		}
+		s.Callchain = callchain
+	}
+	if sampleType&SampleRaw != 0 {
+		// Check n before converting it to int, which may be 32 bits.
+		n := d.u32()
+		if uint64(n) > uint64(len(d.data)) {
+			return errShortSample
+		}
+		s.Raw = d.bytes(int(n))
+	}
+	if sampleType&SampleBranchStack != 0 {
+		n := d.u64()
+		if format.BranchSampleType&BranchHWIndex != 0 {
+			s.BranchHWIndex = d.u64()
+		}
+		if n > uint64(len(d.data))/24 {
+			return errShortSample
+		}
+		for i := uint64(0); i < n; i++ {
+			from, to, flags := d.u64(), d.u64(), d.u64()
+			// flags is a bit field. See struct perf_branch_entry.
+			branches = append(branches, BranchEntry{
+				From:         from,
+				To:           to,
+				Mispredicted: flags&(1<<0) != 0,
+				Predicted:    flags&(1<<1) != 0,
+				InTx:         flags&(1<<2) != 0,
+				Abort:        flags&(1<<3) != 0,
+				Cycles:       uint16(flags >> 4),
+				Type:         BranchType(flags >> 20 & 0xf),
+			})
+		}
+		s.BranchStack = branches
+	}
+	if sampleType&SampleRegsUser != 0 {
+		s.RegsUser = d.regs(format.RegsUser, regsUser)
+	}
+	if sampleType&SampleStackUser != 0 {
+		// The kernel records the requested size, that many bytes, and
+		// then how many of them are valid, unless the size is 0.
+		if n := d.u64(); n != 0 {
+			if n > uint64(len(d.data)) {
+				return errShortSample
+			}
+			stack := d.bytes(int(n))
+			if dyn := d.u64(); dyn <= n {
+				s.StackUser = stack[:dyn]
+			}
+		}
+	}
+	if sampleType&SampleWeight != 0 {
+		s.Weight = d.u64()
+	} else if sampleType&SampleWeightStruct != 0 {
+		// This is a union of a u64 and a struct of a u32 and two u16s,
+		// arranged so the fields are in the same bits of the u64 on any
+		// endianness.
+		w := d.u64()
+		s.Weight, s.Weight2, s.Weight3 = w&(1<<32-1), uint16(w>>32), uint16(w>>48)
+	}
+	if sampleType&SampleDataSrc != 0 {
+		s.DataSrc = DataSrc(d.u64())
+	}
+	if sampleType&SamplePhysAddr != 0 {
+		s.PhysAddr = d.u64()
+	}
+	if d.short {
+		return errShortSample
+	}
+	return nil
+}
+
+// sampleDecoder reads fields from the body of a sample record. If it runs out
+// of data, it sets short and returns zero values.
+type sampleDecoder struct {
+	data  []byte
+	short bool
+}
+
+func (d *sampleDecoder) u64() uint64 {
+	if len(d.data) < 8 {
+		d.short = true
+		return 0
+	}
+	v := binary.NativeEndian.Uint64(d.data)
+	d.data = d.data[8:]
+	return v
+}
+
+func (d *sampleDecoder) u32() uint32 {
+	if len(d.data) < 4 {
+		d.short = true
+		return 0
+	}
+	v := binary.NativeEndian.Uint32(d.data)
+	d.data = d.data[4:]
+	return v
+}
+
+// counts decodes a read_format structure in the given format and appends the
+// Counts to cs.
+func (d *sampleDecoder) counts(format SampleFormat, cs []Count) ([]Count, error) {
+	rf := format.ReadFormat
+	group := rf&ReadFormatGroup != 0
+	n := uint64(1)
+	if group {
+		n = d.u64()
+		if n > uint64(len(d.data))/8 {
+			return nil, errShortSample
+		}
+	}
+	var enabled, running uint64
+	times := func() {
+		if rf&ReadFormatTotalTimeEnabled != 0 {
+			enabled = d.u64()
+		}
+		if rf&ReadFormatTotalTimeRunning != 0 {
+			running = d.u64()
+		}
+	}
+	if group {
+		// All events share the times, which precede the values.
+		times()
+	}
+	for i := uint64(0); i < n; i++ {
+		c := Count{RawValue: d.u64(), scale: scale{1, ""}}
+		if !group {
+			times()
+		}
+		c.TimeEnabled, c.TimeRunning = enabled, running
+		if rf&ReadFormatID != 0 {
+			d.u64()
+		}
+		if rf&ReadFormatLost != 0 {
+			c.Lost = d.u64()
+		}
+		if i < uint64(len(format.scales)) {
+			c.scale = format.scales[i]
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+func (d *sampleDecoder) bytes(n int) []byte {
+	if len(d.data) < n {
+		d.short = true
+		return nil
+	}
+	v := d.data[:n:n]
+	d.data = d.data[n:]
+	return v
+}
+
+// ReadSamples reads records from the ring buffer until it is empty, decoding
+// each sample record and calling f with it. If f returns false, ReadSamples
+// stops early. Records other than samples are discarded.
+//
+// f must not retain s or any of its slices after returning; they are reused
+// for the next sample.
+//
+// ReadSamples counts as the process's own work (see [DoOwnWork]), including
+// the calls to f.
+func (s *Sampler) ReadSamples(f func(s *Sample) bool) (err error) {
+	DoOwnWork(func() {
+		err = s.readSamples(f)
+	})
+	return err
+}
+
+func (s *Sampler) readSamples(f func(s *Sample) bool) error {
+	var sample Sample
+	for {
+		rec, ok := s.ReadRecord()
+		if !ok {
+			return nil
+		}
+		if rec.Type != RecordSample {
+			continue
+		}
+		if err := DecodeSample(rec, s.format, &sample); err != nil {
+			return err
+		}
+		if s.excludeOwn && IsOwnWork(&sample) {
+			continue
+		}
+		if !f(&sample) {
+			return nil
+		}
+	}
+}
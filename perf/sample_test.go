@@ -0,0 +1,335 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestDecodeSample(t *testing.T) {
+	var data []byte
+	u64 := func(v uint64) { data = binary.NativeEndian.AppendUint64(data, v) }
+	u32 := func(v uint32) { data = binary.NativeEndian.AppendUint32(data, v) }
+	u64(1)        // identifier
+	u64(0x401000) // ip
+	u32(10)       // pid
+	u32(11)       // tid
+	u64(12345)    // time
+	u64(0xdead)   // addr
+	u64(2)        // id
+	u64(3)        // stream_id
+	u32(4)        // cpu
+	u32(0)        // res
+	u64(1000)     // period
+	u64(2)        // callchain nr
+	u64(0x401000) // ips[0]
+	u64(0x402000) // ips[1]
+	u32(4)        // raw size
+	data = append(data, 1, 2, 3, 4)
+	u64(300)    // weight
+	u64(0x1234) // data_src
+	u64(0x5000) // phys_addr
+
+	const sampleType = SampleIdentifier | SampleIP | SampleTID | SampleTime |
+		SampleAddr | SampleID | SampleStreamID | SampleCPU | SamplePeriod |
+		SampleCallchain | SampleRaw | SampleWeight | SampleDataSrc | SamplePhysAddr
+	want := Sample{
+		Identifier: 1,
+		IP:         0x401000,
+		PID:        10,
+		TID:        11,
+		Time:       12345,
+		Addr:       0xdead,
+		ID:         2,
+		StreamID:   3,
+		CPU:        4,
+		Period:     1000,
+		Callchain:  []uint64{0x401000, 0x402000},
+		Raw:        []byte{1, 2, 3, 4},
+		Weight:     300,
+		DataSrc:    0x1234,
+		PhysAddr:   0x5000,
+	}
+	var got Sample
+	if err := DecodeSample(RawRecord{Type: RecordSample, Data: data}, SampleFormat{SampleType: sampleType}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Truncated records are an error.
+	for _, n := range []int{0, 7, 8 * 5, len(data) - 1} {
+		if err := DecodeSample(RawRecord{Type: RecordSample, Data: data[:n]}, SampleFormat{SampleType: sampleType}, &got); err != errShortSample {
+			t.Errorf("truncated to %d bytes: got %v, want %v", n, err, errShortSample)
+		}
+	}
+
+	// So are raw sizes larger than the record, including ones that don't fit
+	// in a 32-bit int.
+	for _, n := range []uint32{5, 1 << 31, 1<<32 - 1} {
+		data := binary.NativeEndian.AppendUint32(nil, n)
+		data = append(data, 1, 2, 3, 4)
+		if err := DecodeSample(RawRecord{Type: RecordSample, Data: data}, SampleFormat{SampleType: SampleRaw}, &got); err != errShortSample {
+			t.Errorf("raw size %#x: got %v, want %v", n, err, errShortSample)
+		}
+	}
+
+	// Unsupported fields are an error.
+	if err := DecodeSample(RawRecord{Type: RecordSample}, SampleFormat{SampleType: SampleRegsIntr}, &got); err == nil {
+		t.Errorf("decoding REGS_INTR: want error")
+	}
+	// As are other record types.
+	if err := DecodeSample(RawRecord{Type: RecordMmap}, SampleFormat{SampleType: SampleIP}, &got); err == nil {
+		t.Errorf("decoding MMAP record: want error")
+	}
+}
+
+func TestDecodeWeightStruct(t *testing.T) {
+	data := binary.NativeEndian.AppendUint64(nil, 3<<48|2<<32|1)
+	want := Sample{Weight: 1, Weight2: 2, Weight3: 3}
+	var got Sample
+	if err := DecodeSample(RawRecord{Type: RecordSample, Data: data}, SampleFormat{SampleType: SampleWeightStruct}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeStackUser(t *testing.T) {
+	format := SampleFormat{SampleType: SampleStackUser | SampleWeight}
+	stack := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	var data []byte
+	data = binary.NativeEndian.AppendUint64(data, uint64(len(stack)))
+	data = append(data, stack...)
+	data = binary.NativeEndian.AppendUint64(data, 12) // dyn_size
+	data = binary.NativeEndian.AppendUint64(data, 99) // weight
+	var got Sample
+	if err := DecodeSample(RawRecord{Type: RecordSample, Data: data}, format, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Sample{StackUser: stack[:12], Weight: 99}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// If there's no user stack, the kernel records only a 0 size.
+	data = binary.NativeEndian.AppendUint64(nil, 0)
+	data = binary.NativeEndian.AppendUint64(data, 99)
+	if err := DecodeSample(RawRecord{Type: RecordSample, Data: data}, format, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Sample{Weight: 99}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// A size larger than the record is an error.
+	data = binary.NativeEndian.AppendUint64(nil, 1<<20)
+	if err := DecodeSample(RawRecord{Type: RecordSample, Data: data}, format, &got); err != errShortSample {
+		t.Errorf("got %v, want %v", err, errShortSample)
+	}
+}
+
+func TestDecodeRead(t *testing.T) {
+	u64s := func(vs ...uint64) []byte {
+		var data []byte
+		for _, v := range vs {
+			data = binary.NativeEndian.AppendUint64(data, v)
+		}
+		return data
+	}
+	unscaled := scale{1, ""}
+	for _, tc := range []struct {
+		name string
+		rf   ReadFormatFlags
+		data []byte
+		want []Count
+	}{
+		{"single", ReadFormatTotalTimeEnabled | ReadFormatTotalTimeRunning | ReadFormatLost,
+			u64s(100, 20, 10, 3),
+			[]Count{{RawValue: 100, TimeEnabled: 20, TimeRunning: 10, Lost: 3, scale: unscaled}}},
+		{"group", ReadFormatTotalTimeEnabled | ReadFormatTotalTimeRunning | ReadFormatGroup,
+			u64s(2, 20, 10, 100, 200),
+			[]Count{{RawValue: 100, TimeEnabled: 20, TimeRunning: 10, scale: unscaled}, {RawValue: 200, TimeEnabled: 20, TimeRunning: 10, scale: unscaled}}},
+		{"group-id", ReadFormatGroup | ReadFormatID,
+			u64s(2, 100, 1, 200, 2),
+			[]Count{{RawValue: 100, scale: unscaled}, {RawValue: 200, scale: unscaled}}},
+	} {
+		format := SampleFormat{SampleType: SampleRead, ReadFormat: tc.rf}
+		var got Sample
+		if err := DecodeSample(RawRecord{Type: RecordSample, Data: tc.data}, format, &got); err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Counts, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got.Counts, tc.want)
+		}
+	}
+}
+
+func TestSampleGroup(t *testing.T) {
+	opts := SamplerOptions{Period: 1000000} // 1ms of task-clock
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock, events.EventCPUClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.SampleType()&SampleRead == 0 {
+		t.Fatalf("sample type %s does not include READ", s.SampleType())
+	}
+
+	s.Start()
+	start := time.Now()
+	for time.Since(start) < 20*time.Millisecond {
+	}
+	s.Stop()
+
+	n := 0
+	var prev uint64
+	err = s.ReadSamples(func(s *Sample) bool {
+		n++
+		if len(s.Counts) != 2 {
+			t.Fatalf("got %d counts, want 2", len(s.Counts))
+		}
+		// The sibling's count should keep increasing.
+		if v := s.Counts[1].RawValue; v <= prev {
+			t.Errorf("cpu-clock went from %d to %d", prev, v)
+		}
+		prev = s.Counts[1].RawValue
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Errorf("no samples")
+	}
+}
+
+func TestDecodeBranchStack(t *testing.T) {
+	var data []byte
+	u64 := func(v uint64) { data = binary.NativeEndian.AppendUint64(data, v) }
+	u64(2)  // nr
+	u64(17) // hw_idx
+	u64(0x1000)
+	u64(0x2000)
+	u64(1<<0 | 100<<4 | uint64(BranchTypeCall)<<20) // mispred, cycles, type
+	u64(0x3000)
+	u64(0x4000)
+	u64(1<<1 | 1<<2) // predicted, in_tx
+
+	format := SampleFormat{SampleType: SampleBranchStack, BranchSampleType: BranchAny | BranchHWIndex | BranchTypeSave}
+	want := Sample{
+		BranchHWIndex: 17,
+		BranchStack: []BranchEntry{
+			{From: 0x1000, To: 0x2000, Mispredicted: true, Cycles: 100, Type: BranchTypeCall},
+			{From: 0x3000, To: 0x4000, Predicted: true, InTx: true},
+		},
+	}
+	var got Sample
+	if err := DecodeSample(RawRecord{Type: RecordSample, Data: data}, format, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Without HW_INDEX, there's no hw_idx field.
+	format.BranchSampleType &^= BranchHWIndex
+	data = append(data[:8], data[16:]...)
+	want.BranchHWIndex = 0
+	if err := DecodeSample(RawRecord{Type: RecordSample, Data: data}, format, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("without HW_INDEX: got %+v, want %+v", got, want)
+	}
+}
+
+func TestSampleBranchStack(t *testing.T) {
+	opts := SamplerOptions{
+		SampleType:       SampleIP | SampleBranchStack,
+		BranchSampleType: BranchUser | BranchAny,
+	}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		// Most virtual machines and many CPUs don't support branch
+		// sampling.
+		t.Skipf("branch stack sampling not supported: %s", err)
+	}
+	defer s.Close()
+
+	s.Start()
+	start := time.Now()
+	for time.Since(start) < 20*time.Millisecond {
+	}
+	s.Stop()
+
+	n := 0
+	err = s.ReadSamples(func(s *Sample) bool {
+		n += len(s.BranchStack)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Errorf("no branches recorded")
+	}
+}
+
+func TestReadSamples(t *testing.T) {
+	opts := SamplerOptions{
+		SampleType: SampleIP | SampleTID | SampleTime | SamplePeriod,
+		Period:     100000,
+	}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.Start()
+	start := time.Now()
+	for time.Since(start) < 20*time.Millisecond {
+	}
+	s.Stop()
+
+	pid, tid := uint32(unix.Getpid()), uint32(unix.Gettid())
+	n := 0
+	var lastTime uint64
+	err = s.ReadSamples(func(s *Sample) bool {
+		n++
+		if s.PID != pid || s.TID != tid {
+			t.Errorf("got pid/tid %d/%d, want %d/%d", s.PID, s.TID, pid, tid)
+		}
+		if s.IP == 0 {
+			t.Errorf("sample has zero IP")
+		}
+		if s.Time < lastTime {
+			t.Errorf("sample time went backwards")
+		}
+		lastTime = s.Time
+		if s.Period == 0 {
+			t.Errorf("sample has zero period")
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n < 10 {
+		t.Errorf("got %d samples, want at least 10", n)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// SampleLabels attributes [Sample]s of the current process to the
+// [runtime/pprof] labels of the goroutine that was running when each sample
+// was taken, so hardware profiles can be sliced by request or tenant like
+// CPU profiles.
+//
+// The kernel only knows which thread a sample came from, not which
+// goroutine, so SampleLabels records which labels each thread was running
+// with over time. [SampleLabels.Do] locks the calling goroutine to its thread
+// while running with a label set, so samples of that thread during that time
+// can only be from that goroutine. Samples must include SampleTID and
+// SampleTime, and come from a Sampler opened with
+// [SamplerOptions.MonotonicClock], so their times can be compared with the
+// times SampleLabels records.
+//
+// It is safe to call methods on SampleLabels from multiple goroutines.
+type SampleLabels struct {
+	mu    sync.RWMutex
+	spans map[uint32][]*labelSpan // By TID, sorted by start
+
+	// maxSpans, if non-zero, bounds the number of spans recorded for each
+	// thread. When a thread exceeds it, the oldest half of its spans that
+	// have ended are discarded.
+	maxSpans int
+}
+
+// A labelSpan is a period of time in which a thread ran with a label set.
+type labelSpan struct {
+	start, end uint64 // CLOCK_MONOTONIC nanoseconds; end is MaxUint64 until f returns
+	labels     map[string]string
+}
+
+// NewSampleLabels returns a new SampleLabels with no recorded label sets.
+func NewSampleLabels() *SampleLabels {
+	return &SampleLabels{spans: make(map[uint32][]*labelSpan)}
+}
+
+// Do calls [pprof.Do] with ctx, labels, and f, and records that the calling
+// thread is running with f's complete label set until f returns.
+//
+// Do locks the calling goroutine to its OS thread while running f, so if f
+// blocks, its thread can't run other goroutines. This is fine for
+// coarse-grained regions, such as handling a request, but Do shouldn't wrap
+// long-lived goroutines that mostly block.
+//
+// If f calls Do again with more labels, samples during the inner call are
+// attributed to the inner label set, which includes the outer labels.
+func (sl *SampleLabels) Do(ctx context.Context, labels pprof.LabelSet, f func(context.Context)) {
+	pprof.Do(ctx, labels, func(ctx context.Context) {
+		m := make(map[string]string)
+		pprof.ForLabels(ctx, func(k, v string) bool {
+			m[k] = v
+			return true
+		})
+		sl.run(m, func() { f(ctx) })
+	})
+}
+
+// run calls f, recording that the calling thread is running with labels until
+// f returns. It locks the calling goroutine to its thread while running f.
+func (sl *SampleLabels) run(labels map[string]string, f func()) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	span := &labelSpan{end: math.MaxUint64, labels: labels}
+	tid := uint32(unix.Gettid())
+	sl.mu.Lock()
+	span.start = monotonicNow()
+	sl.spans[tid] = append(sl.spans[tid], span)
+	if sl.maxSpans > 0 && len(sl.spans[tid]) > sl.maxSpans {
+		sl.trim(tid)
+	}
+	sl.mu.Unlock()
+
+	defer func() {
+		sl.mu.Lock()
+		span.end = monotonicNow()
+		sl.mu.Unlock()
+	}()
+	f()
+}
+
+// Labels returns the labels of the goroutine that was running on s's thread
+// at the time of s, or nil if s wasn't taken in a call to [SampleLabels.Do].
+// The caller must not modify the returned map.
+func (sl *SampleLabels) Labels(s *Sample) map[string]string {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	spans := sl.spans[s.TID]
+	// Find the last span that started at or before s. Spans of one thread
+	// are nested, so the innermost span containing s is the last one that
+	// started before it and hasn't ended.
+	i := sort.Search(len(spans), func(i int) bool { return spans[i].start > s.Time })
+	for i--; i >= 0; i-- {
+		if s.Time < spans[i].end {
+			return spans[i].labels
+		}
+	}
+	return nil
+}
+
+// trim discards the oldest spans of thread tid that have ended until it has at
+// most half of sl.maxSpans. Spans that haven't ended are kept, since a sample
+// may still fall in them. sl.mu must be held.
+func (sl *SampleLabels) trim(tid uint32) {
+	spans := sl.spans[tid]
+	drop := len(spans) - sl.maxSpans/2
+	keep := spans[:0]
+	for _, span := range spans {
+		if drop > 0 && span.end != math.MaxUint64 {
+			drop--
+			continue
+		}
+		keep = append(keep, span)
+	}
+	clear(spans[len(keep):])
+	sl.spans[tid] = keep
+}
+
+// Discard forgets label sets that stopped running before time t, in
+// CLOCK_MONOTONIC nanoseconds. Callers should periodically discard label
+// sets older than any sample they may still process, such as the Time of
+// the last sample they read, to bound the memory used by SampleLabels.
+func (sl *SampleLabels) Discard(t uint64) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	for tid, spans := range sl.spans {
+		keep := spans[:0]
+		for _, span := range spans {
+			if span.end >= t {
+				keep = append(keep, span)
+			}
+		}
+		clear(spans[len(keep):])
+		if len(keep) == 0 {
+			delete(sl.spans, tid)
+		} else {
+			sl.spans[tid] = keep
+		}
+	}
+}
+
+// monotonicNow returns the current time of CLOCK_MONOTONIC in nanoseconds,
+// which is the clock of Samplers opened with [SamplerOptions.MonotonicClock].
+func monotonicNow() uint64 {
+	var ts unix.Timespec
+	unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
+	return uint64(ts.Nano())
+}
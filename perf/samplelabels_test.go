@@ -0,0 +1,95 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"context"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestSampleLabels(t *testing.T) {
+	sl := NewSampleLabels()
+	var tid uint32
+	var outer, inner, after uint64
+	sl.Do(context.Background(), pprof.Labels("req", "a"), func(ctx context.Context) {
+		tid = uint32(unix.Gettid())
+		outer = monotonicNow()
+		sl.Do(ctx, pprof.Labels("step", "1"), func(context.Context) {
+			inner = monotonicNow()
+		})
+		after = monotonicNow()
+	})
+	end := monotonicNow()
+
+	for _, test := range []struct {
+		name string
+		s    Sample
+		want map[string]string
+	}{
+		{"outer", Sample{TID: tid, Time: outer}, map[string]string{"req": "a"}},
+		{"inner", Sample{TID: tid, Time: inner}, map[string]string{"req": "a", "step": "1"}},
+		{"after inner", Sample{TID: tid, Time: after}, map[string]string{"req": "a"}},
+		{"after Do", Sample{TID: tid, Time: end}, nil},
+		{"other thread", Sample{TID: tid + 1, Time: inner}, nil},
+	} {
+		got := sl.Labels(&test.s)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for k, v := range test.want {
+			if got[k] != v {
+				t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+			}
+		}
+	}
+
+	sl.Discard(end)
+	if got := sl.Labels(&Sample{TID: tid, Time: inner}); got != nil {
+		t.Errorf("after Discard: got %v, want nil", got)
+	}
+	if len(sl.spans) != 0 {
+		t.Errorf("after Discard: %d threads still have spans", len(sl.spans))
+	}
+}
+
+func TestSampleLabelsSampler(t *testing.T) {
+	opts := SamplerOptions{Period: 100000, MonotonicClock: true} // 100µs of task-clock
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer s.Close()
+
+	sl := NewSampleLabels()
+	s.Start()
+	sl.Do(context.Background(), pprof.Labels("phase", "spin"), func(context.Context) {
+		for start := time.Now(); time.Since(start) < 10*time.Millisecond; {
+		}
+	})
+	s.Stop()
+
+	n := 0
+	err = s.ReadSamples(func(sample *Sample) bool {
+		if sl.Labels(sample)["phase"] == "spin" {
+			n++
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Errorf("no samples attributed to phase=spin")
+	}
+}
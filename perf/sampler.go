@@ -0,0 +1,670 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// A Sampler records samples of an [events.Event] into a ring buffer shared
+// with the kernel. Each time the event occurs a certain number of times (the
+// sample period), the kernel writes a sample record, along with other records
+// describing the target, such as lost records.
+//
+// A Sampler is not safe for concurrent use by multiple goroutines.
+type Sampler struct {
+	target Target
+	tid    int // Thread ID of the target, or 0 if it's a CPU
+
+	// f is the sampled event. We use an os.File so we can wait for
+	// wakeups using the runtime poller. fd is its file descriptor, which
+	// we keep separately because [os.File.Fd] disables the poller.
+	f     *os.File
+	fd    int
+	group []int // Other events in f's group
+	mmap  []byte
+	ring  ring
+
+	aux    []byte // AUX area, if any (see SamplerOptions.AuxPages)
+	auxBuf []byte // Holds AUX data that wraps around the end of aux
+
+	attr       unix.PerfEventAttr // Attributes the event was opened with
+	format     SampleFormat
+	running    bool
+	period     uint64   // Current sample period, or 0 if sampling at a frequency
+	ringSize   RingSize // Zero if output is redirected
+	excludeOwn bool     // SamplerOptions.ExcludeOwnWork
+
+	throttle    ThrottleStats
+	throttledAt uint64 // Time of the last unmatched throttle record, or 0
+	lost        LostStats
+
+	// backfill holds synthetic side-band records describing the target as
+	// it was when the Sampler started. ReadRecord returns these before any
+	// records from the ring buffer.
+	backfill   []RawRecord
+	backfilled bool
+}
+
+// SamplerOptions configures how a [Sampler] is opened. The zero value is the
+// default configuration used by [OpenSampler].
+type SamplerOptions struct {
+	// SampleType selects the fields recorded in each sample. If 0, this uses
+	// SampleIP|SampleTID|SampleTime.
+	SampleType SampleTypeFlags
+
+	// Period or Freq, if non-zero, set the sample rate, overriding any rate
+	// specified by the event (see [events.EventSampleRate]). Period samples
+	// every Period events. Freq samples Freq times per second, with the
+	// kernel adjusting the period dynamically. At most one may be set. If
+	// neither the options nor the event specify a rate, the Sampler uses a
+	// frequency of 4000, which is perf's default.
+	Period, Freq uint64
+
+	// BranchSampleType selects the branches recorded if SampleType includes
+	// SampleBranchStack. If 0, this uses BranchAny, which records all
+	// branches at the privilege levels the event counts.
+	BranchSampleType BranchSampleFlags
+
+	// Precise requests that the kernel attribute samples to the exact
+	// instruction that caused them, using hardware support such as Intel's
+	// PEBS or AMD's IBS, like perf's "p" modifiers. 0 allows arbitrary skid,
+	// 1 requests constant skid, 2 requests zero skid, and 3 requires zero
+	// skid. Memory access events, such as for [SampleDataSrc], typically
+	// require Precise to be at least 1.
+	Precise uint8
+
+	// RegsUser selects the user-space registers recorded in each sample if
+	// SampleType includes SampleRegsUser. If 0, this uses
+	// [DefaultRegMask], which is all of the general-purpose registers.
+	RegsUser RegMask
+
+	// StackUser is how many bytes of the user stack to record in each
+	// sample if SampleType includes SampleStackUser, which also implies
+	// SampleRegsUser. It must be a multiple of 8 less than 65536. If 0,
+	// this uses 8192, which is perf's default. Larger dumps let an
+	// unwinder recover deeper stacks, but make samples much larger, so
+	// consider a larger [SamplerOptions.RingSize].
+	StackUser uint32
+
+	// MaxStack, if non-zero, limits the number of frames recorded in each
+	// sample's callchain if SampleType includes SampleCallchain. Otherwise,
+	// the limit is /proc/sys/kernel/perf_event_max_stack.
+	MaxStack uint16
+
+	// SideBand requests side-band records that describe changes to the
+	// target: RecordMmap2 records for new executable mappings, RecordComm
+	// records for thread name changes, and RecordFork and RecordExit
+	// records for new and exited threads and processes. Use
+	// [Sampler.ReadSamplesAndSideBand] to read these along with samples.
+	// Each side-band record includes the [RecordID] fields selected by
+	// SampleType, so including SampleTime allows ordering them with
+	// samples.
+	//
+	// The kernel only reports changes after the Sampler is started, so
+	// when a Sampler monitoring a thread first starts, it reads the
+	// thread's name and its process's executable mappings from /proc and
+	// synthesizes RecordComm and RecordMmap2 records for them, like "perf
+	// record" does. These come before any other records, with a zero
+	// [RecordID.Time]. A Sampler monitoring a CPU doesn't synthesize
+	// records, since that would mean reading every process on the system;
+	// use [ReadProcessInfo] to find the mappings and threads of the
+	// processes of interest.
+	SideBand bool
+
+	// ContextSwitch requests RecordSwitch or RecordSwitchCPUWide records
+	// each time a target thread is scheduled onto or off of a CPU. These
+	// show when and where threads ran, including time spent blocked off
+	// CPU, without the permissions required for scheduler tracepoints.
+	// Use [Sampler.ReadSamplesAndSideBand] to read these as
+	// [SwitchRecord]s. Include SampleTime in SampleType to know when each
+	// switch happened.
+	ContextSwitch bool
+
+	// MonotonicClock makes the kernel record [Sample.Time] and the times
+	// of other records using CLOCK_MONOTONIC, like "perf record -k
+	// monotonic", instead of the kernel's internal perf clock. This allows
+	// comparing them with times read from user space, such as by
+	// [SampleLabels].
+	MonotonicClock bool
+
+	// ExcludeOwnWork makes [Sampler.ReadSamples] and
+	// [Sampler.ReadSamplesAndSideBand] drop samples of this process's own
+	// monitoring and profiling work, as recorded by [DoOwnWork]. This
+	// implies MonotonicClock, and adds SampleTID and SampleTime to
+	// SampleType.
+	ExcludeOwnWork bool
+
+	// WakeupEvents and WakeupWatermark control how often the kernel wakes
+	// up [Sampler.Wait] and [Sampler.Wakeups]. If WakeupEvents is non-zero,
+	// the kernel wakes them up after every WakeupEvents samples. If
+	// WakeupWatermark is non-zero, it wakes them up whenever at least
+	// WakeupWatermark bytes of records are in the ring buffer. At most one
+	// may be set. By default, the kernel wakes them up when the ring buffer
+	// is half full. Waking up more often lets a consumer process records
+	// sooner, at the cost of more overhead.
+	WakeupEvents    uint32
+	WakeupWatermark uint32
+
+	// Output, if non-nil, makes the new Sampler write its records to
+	// Output's ring buffer instead of mapping its own, like "perf record"
+	// does with the events on each CPU. This saves memory when sampling
+	// several events, and lets the caller read all of their records, in
+	// order, from one place. Both Samplers must monitor the same thread
+	// and have the same SampleFormat, except for the event scales. Include
+	// SampleIdentifier in SampleType to tell which event each record came
+	// from (see [Sampler.ID]).
+	//
+	// Records of the new Sampler, including its lost and throttle records,
+	// must be read from Output. Output should be closed last.
+	Output *Sampler
+
+	// RingSize configures the size of the ring buffer. Set its Pages field
+	// to choose the size directly. Otherwise, if its SampleRate or
+	// SampleType fields are zero, they are filled in from the Sampler's
+	// configuration. High sampling rates need much larger ring buffers
+	// than the default. Use [Sampler.RingSize] to get the chosen size.
+	RingSize RingSizeConfig
+
+	// AuxPages, if non-zero, maps an AUX area of this many pages, rounded
+	// up to a power of two, alongside the ring buffer. PMUs that produce
+	// hardware traces, such as Intel PT and ARM SPE, write their trace data
+	// to the AUX area and write a [RecordAux] record to the ring buffer
+	// describing each chunk. Use [DecodeAux] and [Sampler.AuxData] to read
+	// these chunks. This package doesn't decode the trace data itself.
+	// Other PMUs don't support an AUX area, so opening the Sampler fails.
+	AuxPages int
+
+	// AuxWatermark, if non-zero, is how many bytes of AUX data the kernel
+	// accumulates before writing a RecordAux record. By default, this is
+	// half the size of the AUX area.
+	AuxWatermark uint32
+}
+
+const defaultSampleType = SampleIP | SampleTID | SampleTime
+
+// defaultStackUser is the default size of user stack dumps, which is perf's
+// default.
+const defaultStackUser = 8192
+
+// OpenSampler returns a new [Sampler] that samples ev on the given [Target]
+// using the default options. Callers are expected to call [Sampler.Close] when
+// done with this Sampler.
+//
+// If other events are given, they are opened in a group with ev and counted,
+// but not sampled. Each sample records the values of all of the events in the
+// group (see [Sample.Counts]), so the sample type always includes SampleRead.
+// This is sometimes called "leader sampling".
+//
+// The sampler is initially not running. Call [Sampler.Start] to start it.
+//
+// To open a sampler with non-default options, use [SamplerOptions.OpenSampler].
+func OpenSampler(target Target, ev events.Event, others ...events.Event) (*Sampler, error) {
+	var opts SamplerOptions
+	return opts.OpenSampler(target, ev, others...)
+}
+
+// OpenSampler is like the top-level [OpenSampler] function, but uses the
+// options in o.
+func (o *SamplerOptions) OpenSampler(target Target, ev events.Event, others ...events.Event) (*Sampler, error) {
+	sampleType := o.SampleType
+	if sampleType == 0 {
+		sampleType = defaultSampleType
+	}
+	if len(others) > 0 {
+		sampleType |= SampleRead
+	}
+	if sampleType&SampleStackUser != 0 {
+		// Unwinding the stack requires the registers.
+		sampleType |= SampleRegsUser
+	}
+	if o.ExcludeOwnWork {
+		// Needed to match samples with DoOwnWork.
+		sampleType |= SampleTID | SampleTime
+	}
+	if err := sampleType.Validate(); err != nil {
+		return nil, err
+	}
+	if o.Precise > 3 {
+		return nil, fmt.Errorf("Precise must be between 0 and 3, got %d", o.Precise)
+	}
+	if o.WakeupEvents != 0 && o.WakeupWatermark != 0 {
+		return nil, fmt.Errorf("cannot specify both WakeupEvents and WakeupWatermark")
+	}
+	if o.AuxPages != 0 && o.Output != nil {
+		return nil, fmt.Errorf("cannot specify both AuxPages and Output")
+	}
+	var branchSampleType BranchSampleFlags
+	if sampleType&SampleBranchStack != 0 {
+		branchSampleType = o.BranchSampleType
+		if branchSampleType == 0 {
+			branchSampleType = BranchAny
+		}
+		if err := branchSampleType.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	var regsUser RegMask
+	if sampleType&SampleRegsUser != 0 {
+		regsUser = o.RegsUser
+		if regsUser == 0 {
+			regsUser = DefaultRegMask
+		}
+		if err := validateRegMask(regsUser); err != nil {
+			return nil, err
+		}
+	}
+	var stackUser uint32
+	if sampleType&SampleStackUser != 0 {
+		stackUser = o.StackUser
+		if stackUser == 0 {
+			stackUser = defaultStackUser
+		}
+		if stackUser%8 != 0 || stackUser >= 1<<16 {
+			return nil, fmt.Errorf("StackUser must be a multiple of 8 less than 65536, got %d", stackUser)
+		}
+	}
+
+	pid, cpu := target.pidCPU()
+	if cpu == -1 {
+		// As in openCounter, the kernel rejects events of uncore PMUs for a
+		// thread with just EINVAL.
+		for _, event := range append([]events.Event{ev}, others...) {
+			if cpus := events.CPUsOf(event); cpus != nil {
+				return nil, fmt.Errorf("event %s can only be sampled on CPUs %v, not for a thread; use TargetCPU", event, cpus)
+			}
+		}
+	}
+
+	attr := unix.PerfEventAttr{}
+	attr.Size = uint32(unsafe.Sizeof(attr))
+	if err := ev.SetAttrs(&attr); err != nil {
+		return nil, err
+	}
+	attr.Sample_type = uint64(sampleType)
+	attr.Sample_regs_user = uint64(regsUser)
+	attr.Sample_stack_user = stackUser
+	var readFormat ReadFormatFlags
+	var scales []scale
+	if sampleType&SampleRead != 0 {
+		readFormat = ReadFormatTotalTimeEnabled | ReadFormatTotalTimeRunning | ReadFormatGroup
+		for _, event := range append([]events.Event{ev}, others...) {
+			sc, unit := events.ScaleUnitOf(event)
+			scales = append(scales, scale{sc, unit})
+		}
+	}
+	attr.Read_format = uint64(readFormat)
+	attr.Branch_sample_type = uint64(branchSampleType)
+	attr.Sample_max_stack = o.MaxStack
+	attr.Bits |= unix.PerfBitDisabled
+	// Only the group leader can be pinned, so if any event in the group is
+	// pinned, pin the leader.
+	for _, event := range others {
+		if events.PriorityOf(event) == events.PriorityPinned {
+			attr.Bits |= unix.PerfBitPinned
+		}
+	}
+	if o.Precise&1 != 0 {
+		attr.Bits |= unix.PerfBitPreciseIPBit1
+	}
+	if o.Precise&2 != 0 {
+		attr.Bits |= unix.PerfBitPreciseIPBit2
+	}
+	// Record the sample ID fields in all records, which tells us which
+	// CPU lost records came from, and orders side-band records.
+	attr.Bits |= unix.PerfBitSampleIDAll
+	if o.SideBand {
+		attr.Bits |= unix.PerfBitMmap | unix.PerfBitMmap2 | unix.PerfBitComm |
+			unix.PerfBitCommExec | unix.PerfBitTask
+	}
+	if o.ContextSwitch {
+		attr.Bits |= unix.PerfBitContextSwitch
+	}
+	if o.MonotonicClock || o.ExcludeOwnWork {
+		attr.Bits |= unix.PerfBitUseClockID
+		attr.Clockid = unix.CLOCK_MONOTONIC
+	}
+	// attr.Wakeup is a union of wakeup_events and wakeup_watermark.
+	if o.WakeupWatermark != 0 {
+		attr.Wakeup = o.WakeupWatermark
+		attr.Bits |= unix.PerfBitWatermark
+	} else {
+		attr.Wakeup = o.WakeupEvents
+	}
+	attr.Aux_watermark = o.AuxWatermark
+
+	// Set the sample rate.
+	switch {
+	case o.Period != 0 && o.Freq != 0:
+		return nil, fmt.Errorf("cannot specify both Period and Freq")
+	case o.Period != 0:
+		attr.Sample = o.Period
+		attr.Bits &^= unix.PerfBitFreq
+	case o.Freq != 0:
+		attr.Sample = o.Freq
+		attr.Bits |= unix.PerfBitFreq
+	case attr.Sample == 0:
+		attr.Sample = defaultSampleRate
+		attr.Bits |= unix.PerfBitFreq
+	}
+
+	// Choose the ring buffer size.
+	ringCfg := o.RingSize
+	if ringCfg.SampleType == 0 {
+		ringCfg.SampleType = sampleType
+	}
+	if ringCfg.RecordSize == 0 && ringCfg.SampleType&SampleStackUser != 0 && stackUser != 0 {
+		// estimateRecordSize assumes the default stack dump size.
+		ringCfg.RecordSize = ringCfg.SampleType.estimateRecordSize() - defaultStackUser + int(stackUser)
+	}
+	if ringCfg.SampleRate == 0 && attr.Bits&unix.PerfBitFreq != 0 {
+		ringCfg.SampleRate = float64(attr.Sample)
+	}
+	ringSize := ChooseRingSize(ringCfg)
+	if o.Output == nil && o.WakeupWatermark != 0 && int(o.WakeupWatermark) >= ringSize.Bytes() {
+		// The kernel would silently lower the watermark.
+		return nil, fmt.Errorf("WakeupWatermark %d must be less than the ring buffer size %d (ring size: %s)", o.WakeupWatermark, ringSize.Bytes(), ringSize.Reason)
+	}
+
+	s := &Sampler{target: target, attr: attr, excludeOwn: o.ExcludeOwnWork, format: SampleFormat{
+		SampleType:       sampleType,
+		BranchSampleType: branchSampleType,
+		ReadFormat:       readFormat,
+		RegsUser:         regsUser,
+		SampleIDAll:      true,
+		scales:           scales,
+	}}
+
+	success := false
+	target.open()
+	if s.excludeOwn {
+		ownWorkUsers.Add(1)
+	}
+	defer func() {
+		if !success {
+			target.close()
+			if s.excludeOwn {
+				ownWorkUsers.Add(-1)
+			}
+		}
+	}()
+
+	if attr.Bits&unix.PerfBitFreq == 0 {
+		s.period = attr.Sample
+	}
+
+	switch {
+	case pid == 0:
+		// The target is this thread, which is now locked.
+		s.tid = unix.Gettid()
+	case pid > 0:
+		s.tid = pid
+	}
+	fd, err := perfEventOpen(&attr, pid, cpu, -1, 1+len(others))
+	if err != nil {
+		return nil, err
+	}
+	// Make the FD non-blocking so os.File registers it with the runtime
+	// poller, which we use to wait for wakeups.
+	if err := unix.SetNonblock(fd, true); err != nil {
+		sys.close(fd)
+		return nil, err
+	}
+	s.f, s.fd = os.NewFile(uintptr(fd), "<perf-event>"), fd
+	defer func() {
+		if !success {
+			s.f.Close()
+			for _, fd := range s.group {
+				sys.close(fd)
+			}
+		}
+	}()
+	if err := setFilter(fd, ev); err != nil {
+		return nil, err
+	}
+
+	// Open the other events in the group. These only count, and are
+	// controlled by the leader.
+	for i, event := range others {
+		attr := unix.PerfEventAttr{}
+		attr.Size = uint32(unsafe.Sizeof(attr))
+		if err := event.SetAttrs(&attr); err != nil {
+			return nil, err
+		}
+		attr.Sample = 0
+		attr.Bits &^= unix.PerfBitFreq | unix.PerfBitPinned
+		attr.Read_format = uint64(readFormat)
+		fd2, err := perfEventOpen(&attr, pid, cpu, fd, len(others)-i)
+		if err != nil {
+			return nil, err
+		}
+		s.group = append(s.group, fd2)
+		if err := setFilter(fd2, event); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Output != nil {
+		if err := s.redirectOutput(o.Output); err != nil {
+			return nil, err
+		}
+		success = true
+		return s, nil
+	}
+
+	// Map the ring buffer: one control page followed by the data pages.
+	pageSize := os.Getpagesize()
+	s.mmap, err = sys.mmap(fd, 0, (1+ringSize.Pages)*pageSize)
+	if err != nil {
+		if errors.Is(err, syscall.EPERM) {
+			err = fmt.Errorf("mapping %d page ring buffer: %w (ring size: %s)", ringSize.Pages, err, ringSize.Reason)
+		}
+		return nil, err
+	}
+	s.ring.meta = (*unix.PerfEventMmapPage)(unsafe.Pointer(&s.mmap[0]))
+	dataOff, dataSize := s.ring.meta.Data_offset, s.ring.meta.Data_size
+	if dataSize == 0 {
+		// Linux before 4.1 doesn't report the data area.
+		dataOff, dataSize = uint64(pageSize), uint64(ringSize.Pages*pageSize)
+	}
+	s.ring.data = s.mmap[dataOff : dataOff+dataSize]
+	s.ringSize = ringSize
+
+	if o.AuxPages > 0 {
+		if err := s.mapAux(o.AuxPages); err != nil {
+			sys.munmap(s.mmap)
+			return nil, err
+		}
+	}
+
+	success = true
+	return s, nil
+}
+
+// Close closes this sampler, unmaps its ring buffer, and unlocks the goroutine
+// from the OS thread if the target is [TargetThisGoroutine].
+func (s *Sampler) Close() {
+	if s == nil || s.f == nil {
+		return
+	}
+	if s.aux != nil {
+		sys.munmap(s.aux)
+		s.aux, s.auxBuf = nil, nil
+	}
+	if s.mmap != nil {
+		sys.munmap(s.mmap)
+		s.mmap = nil
+	}
+	s.ring = ring{}
+	s.f.Close()
+	s.f, s.fd = nil, -1
+	for _, fd := range s.group {
+		sys.close(fd)
+	}
+	s.group = nil
+	s.target.close()
+	s.target = nil
+	if s.excludeOwn {
+		ownWorkUsers.Add(-1)
+	}
+}
+
+// Start the sampler.
+func (s *Sampler) Start() {
+	if s == nil || s.running {
+		return
+	}
+	s.running = true
+	sys.ioctl(s.fd, unix.PERF_EVENT_IOC_ENABLE, 0)
+	if !s.backfilled {
+		// Do this after enabling so changes can't fall in between. At
+		// worst, the kernel also reports a mapping we synthesize.
+		s.backfilled = true
+		s.backfillSideBand()
+	}
+}
+
+// backfillSideBand queues synthetic side-band records describing the target
+// thread as it is now. This is best-effort: if the thread has exited, it
+// queues nothing.
+func (s *Sampler) backfillSideBand() {
+	if s.tid == 0 || s.mmap == nil || s.attr.Bits&unix.PerfBitComm == 0 {
+		// A redirected Sampler monitors the same thread as its output
+		// Sampler, which backfills for both.
+		return
+	}
+	pid, err := readTgid(s.tid)
+	if err != nil {
+		return
+	}
+	info, err := ReadProcessInfo(pid)
+	if err != nil {
+		return
+	}
+	// Only the target thread is sampled.
+	info.Threads = slices.DeleteFunc(info.Threads, func(t ThreadInfo) bool {
+		return t.TID != s.tid
+	})
+	var id uint64
+	if s.format.SampleType&(SampleID|SampleIdentifier) != 0 {
+		id, _ = sys.eventID(s.fd)
+	}
+	s.backfill = processInfoRecords(info, id, s.format)
+}
+
+// Stop the sampler. Records already in the ring buffer can still be read.
+func (s *Sampler) Stop() {
+	if s == nil || !s.running {
+		return
+	}
+	sys.ioctl(s.fd, unix.PERF_EVENT_IOC_DISABLE, 0)
+	s.running = false
+}
+
+// RingSize returns the size of s's ring buffer and how it was chosen. If s's
+// output is redirected to another Sampler (see [SamplerOptions.Output]), it
+// returns the zero RingSize.
+func (s *Sampler) RingSize() RingSize {
+	return s.ringSize
+}
+
+// SampleType returns the fields recorded in each sample record.
+func (s *Sampler) SampleType() SampleTypeFlags {
+	return s.format.SampleType
+}
+
+// SampleFormat returns the format of s's sample records, for use with
+// [DecodeSample].
+func (s *Sampler) SampleFormat() SampleFormat {
+	return s.format
+}
+
+// ReadRecord returns the next record from the ring buffer. If the ring buffer
+// is empty, it returns false immediately. Use [Sampler.Wait] to wait for
+// records.
+//
+// The returned record's Data is only valid until the next call to ReadRecord
+// or Close, at which point its space in the ring buffer is returned to the
+// kernel.
+func (s *Sampler) ReadRecord() (RawRecord, bool) {
+	if s == nil || s.f == nil || s.mmap == nil {
+		return RawRecord{}, false
+	}
+	if len(s.backfill) > 0 {
+		rec := s.backfill[0]
+		s.backfill = s.backfill[1:]
+		return rec, true
+	}
+	rec, ok := s.ring.next()
+	switch rec.Type {
+	case RecordThrottle, RecordUnthrottle:
+		s.noteThrottle(rec)
+	case RecordLost, RecordLostSamples:
+		s.noteLost(rec)
+	}
+	return rec, ok
+}
+
+// Wait blocks until there are records to read from the ring buffer or ctx is
+// done. It returns ctx.Err() if ctx is done before any records are available.
+//
+// To reduce overhead, the kernel by default only wakes up Wait once the ring
+// buffer is half full, so callers that want to process records promptly should
+// either set [SamplerOptions.WakeupEvents] or pass a ctx with a deadline and
+// read any available records when it expires.
+func (s *Sampler) Wait(ctx context.Context) error {
+	if s == nil || s.f == nil {
+		return fmt.Errorf("Sampler is closed")
+	}
+	if s.mmap == nil {
+		return errOutputRedirected
+	}
+	if len(s.backfill) > 0 || s.ring.available() {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	rc, err := s.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	// Interrupt the wait when ctx is done by expiring the read deadline.
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		s.f.SetReadDeadline(time.Now())
+		close(interrupted)
+	})
+	err = rc.Read(func(fd uintptr) bool {
+		return s.ring.available()
+	})
+	if !stop() {
+		<-interrupted
+	}
+	s.f.SetReadDeadline(time.Time{})
+
+	if s.ring.available() {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return err
+}
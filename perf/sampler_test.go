@@ -0,0 +1,196 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"context"
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestSampler(t *testing.T) {
+	opts := SamplerOptions{
+		SampleType: SampleIP | SampleTID,
+		Period:     100000, // 100µs of task-clock
+	}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if s.SampleType() != SampleIP|SampleTID {
+		t.Errorf("got sample type %s, want IP|TID", s.SampleType())
+	}
+
+	s.Start()
+	start := time.Now()
+	for time.Since(start) < 50*time.Millisecond {
+	}
+	s.Stop()
+
+	// The ring probably isn't half full, so this should time out and we
+	// read what's available.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Wait(ctx); err != nil && err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+
+	tid := uint32(unix.Gettid())
+	samples := 0
+	for {
+		rec, ok := s.ReadRecord()
+		if !ok {
+			break
+		}
+		if rec.Type != RecordSample {
+			continue
+		}
+		samples++
+		// IP, then PID and TID.
+		if len(rec.Data) != 16 {
+			t.Fatalf("sample has %d bytes, want 16", len(rec.Data))
+		}
+		if got := binary.NativeEndian.Uint32(rec.Data[12:]); got != tid {
+			t.Errorf("sample TID %d, want %d", got, tid)
+		}
+	}
+	t.Logf("%d samples", samples)
+	if samples < 10 {
+		t.Errorf("got %d samples, want at least 10", samples)
+	}
+}
+
+func TestSamplerWait(t *testing.T) {
+	// Use a tiny ring so it quickly fills past the wakeup watermark.
+	opts := SamplerOptions{
+		Period:   10000,
+		RingSize: RingSizeConfig{Pages: 1},
+	}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, ok := s.ReadRecord(); ok {
+		t.Fatal("got record before starting")
+	}
+
+	// Wait should time out with no records.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	err = s.Wait(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Wait with no records: got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Generate samples from another goroutine while this one waits. Since
+	// we're sampling this goroutine's thread, spin here instead and have the
+	// waiter run elsewhere.
+	s.Start()
+	done := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- s.Wait(ctx)
+	}()
+	for {
+		select {
+		case err := <-done:
+			s.Stop()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := s.ReadRecord(); !ok {
+				t.Fatal("Wait returned, but no records")
+			}
+			return
+		default:
+		}
+	}
+}
+
+func TestSamplerOptionsInvalid(t *testing.T) {
+	for _, opts := range []SamplerOptions{
+		{Period: 1, Freq: 1},
+		{Precise: 4},
+		{SampleType: SampleWeight | SampleWeightStruct},
+	} {
+		s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+		if err == nil {
+			s.Close()
+			t.Errorf("%+v: want error", opts)
+		}
+	}
+}
+
+// scaledEvent is an event with a scale and unit, like a RAPL energy event.
+type scaledEvent struct {
+	events.Event
+}
+
+func (scaledEvent) ScaleUnit() (float64, string) { return 0.5, "Joules" }
+
+func TestSamplerGroupEvents(t *testing.T) {
+	k := useFakeKernel(t)
+	pinned := events.WithPriority(events.EventInstructions, events.PriorityPinned)
+	s, err := OpenSampler(TargetThisGoroutine, events.EventCPUCycles, pinned, scaledEvent{events.EventTaskClock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Pinning any event in the group pins the leader, which pins the group.
+	leader := k.events[s.fd]
+	if leader.attr.Bits&unix.PerfBitPinned == 0 {
+		t.Errorf("leader of group with pinned event is not pinned")
+	}
+	for _, ev := range leader.members[1:] {
+		if ev.attr.Bits&unix.PerfBitPinned != 0 {
+			t.Errorf("group member is pinned")
+		}
+	}
+
+	want := []scale{{1, ""}, {1, ""}, {0.5, "Joules"}}
+	if got := s.SampleFormat().scales; !slices.Equal(got, want) {
+		t.Errorf("got scales %v, want %v", got, want)
+	}
+}
+
+func TestSamplerEventCPUs(t *testing.T) {
+	useFakeKernel(t)
+	// Sampling an event that can only be counted on certain CPUs for a
+	// thread fails without asking the kernel.
+	uncore := cpusEvent{events.EventCPUClock, []int{0}}
+	for _, evs := range [][]events.Event{
+		{uncore},
+		{events.EventCPUClock, uncore},
+	} {
+		s, err := OpenSampler(TargetThisGoroutine, evs[0], evs[1:]...)
+		if err == nil {
+			s.Close()
+			t.Errorf("OpenSampler(TargetThisGoroutine, %v): want error", evs)
+		} else if !strings.Contains(err.Error(), "TargetCPU") {
+			t.Errorf("OpenSampler(TargetThisGoroutine, %v): got %v, want error suggesting TargetCPU", evs, err)
+		}
+	}
+
+	s, err := OpenSampler(TargetCPU(0), uncore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+}
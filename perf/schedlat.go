@@ -0,0 +1,287 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+	"time"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// SchedLatency measures scheduler latency, also known as run-queue latency:
+// the time from when a thread becomes runnable, either by being woken up or
+// by being preempted, until it starts running on a CPU. This is the analysis
+// done by "perf sched latency".
+//
+// SchedLatency computes this from samples of the sched:sched_wakeup,
+// sched:sched_wakeup_new, and sched:sched_switch tracepoints (see
+// [SchedLatency.Events]). These must be sampled on every CPU the threads of
+// interest run on, recording every occurrence (a Period of 1) with a sample
+// type that includes SampleRaw, SampleTime, and SampleCPU, and each sample
+// passed to [SchedLatency.Add]. For example, to open the Samplers:
+//
+//	opts := perf.SamplerOptions{
+//		SampleType: perf.SampleRaw | perf.SampleTime | perf.SampleCPU,
+//		Period:     1,
+//	}
+//	for _, cpu := range cpus {
+//		for _, ev := range l.Events() {
+//			s, err := opts.OpenSampler(perf.TargetCPU(cpu), ev)
+//			...
+//		}
+//	}
+type SchedLatency struct {
+	evs      []events.Event
+	formats  map[uint64]*events.TracepointFormat // By tracepoint ID
+	switchID uint64
+
+	pending  []schedEvent
+	runnable map[int64]uint64 // Time each runnable thread became runnable, by TID
+	threads  map[int]*LatencyDist
+	cpus     map[int]*LatencyDist
+}
+
+// A schedEvent is a decoded scheduler tracepoint sample. For a wakeup, next
+// is the TID of the woken thread.
+type schedEvent struct {
+	time      uint64
+	cpu       int
+	isSwitch  bool
+	prev      int64
+	prevState int64
+	next      int64
+}
+
+// A LatencyDist is a distribution of latencies.
+type LatencyDist struct {
+	Count uint64
+	Total time.Duration
+	Max   time.Duration
+
+	// Buckets is a histogram of the latencies. Bucket 0 counts latencies
+	// of 0ns, and bucket i > 0 counts latencies in [2^(i-1), 2^i) ns.
+	Buckets [64]uint64
+}
+
+// NewSchedLatency returns a new SchedLatency with no samples. It reads the
+// formats of the scheduler tracepoints from tracefs.
+func NewSchedLatency() (*SchedLatency, error) {
+	evs, formats, err := openTracepoints("sched:sched_switch", "sched:sched_wakeup", "sched:sched_wakeup_new")
+	if err != nil {
+		return nil, err
+	}
+	l, err := newSchedLatency(formats[0], formats[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	l.evs = evs
+	return l, nil
+}
+
+func newSchedLatency(sw *events.TracepointFormat, wakeups ...*events.TracepointFormat) (*SchedLatency, error) {
+	l := &SchedLatency{
+		formats:  map[uint64]*events.TracepointFormat{sw.ID: sw},
+		switchID: sw.ID,
+		runnable: make(map[int64]uint64),
+		threads:  make(map[int]*LatencyDist),
+		cpus:     make(map[int]*LatencyDist),
+	}
+	if !hasFields(sw, "prev_pid", "prev_state", "next_pid") {
+		return nil, fmt.Errorf("tracepoint %s is missing prev_pid, prev_state, or next_pid field", sw.Name)
+	}
+	for _, f := range wakeups {
+		if !hasFields(f, "pid") {
+			return nil, fmt.Errorf("tracepoint %s is missing pid field", f.Name)
+		}
+		l.formats[f.ID] = f
+	}
+	return l, nil
+}
+
+// openTracepoints parses the named tracepoint events and reads their formats.
+func openTracepoints(names ...string) ([]events.Event, []*events.TracepointFormat, error) {
+	var evs []events.Event
+	var formats []*events.TracepointFormat
+	for _, name := range names {
+		ev, err := events.ParseEvent(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		f, err := events.ReadTracepointFormat(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		evs = append(evs, ev)
+		formats = append(formats, f)
+	}
+	return evs, formats, nil
+}
+
+func hasFields(f *events.TracepointFormat, names ...string) bool {
+	for _, name := range names {
+		found := false
+		for _, field := range f.Fields {
+			if field.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Events returns the tracepoint events whose samples l expects.
+func (l *SchedLatency) Events() []events.Event {
+	return l.evs
+}
+
+// Add adds a sample of one of the scheduler tracepoints. It ignores samples
+// of other events.
+//
+// Samples from different CPUs may be added in any order, as long as all of
+// the samples up to some time have been added before calling
+// [SchedLatency.Threads] or [SchedLatency.CPUs].
+func (l *SchedLatency) Add(s *Sample) error {
+	// Every tracepoint's raw data starts with its ID in common_type.
+	if len(s.Raw) < 2 {
+		return fmt.Errorf("sample has no raw tracepoint data")
+	}
+	id := uint64(binary.NativeEndian.Uint16(s.Raw))
+	f, ok := l.formats[id]
+	if !ok {
+		return nil
+	}
+	fields, err := f.Decode(s.Raw)
+	if err != nil {
+		return err
+	}
+	ev := schedEvent{time: s.Time, cpu: int(s.CPU)}
+	if id == l.switchID {
+		ev.isSwitch = true
+		ev.prev = intField(fields, "prev_pid")
+		ev.prevState = intField(fields, "prev_state")
+		ev.next = intField(fields, "next_pid")
+	} else {
+		ev.next = intField(fields, "pid")
+	}
+	l.pending = append(l.pending, ev)
+	return nil
+}
+
+// process processes the pending events in time order.
+func (l *SchedLatency) process() {
+	slices.SortStableFunc(l.pending, func(a, b schedEvent) int {
+		return cmp.Compare(a.time, b.time)
+	})
+	for _, ev := range l.pending {
+		if !ev.isSwitch {
+			// A wakeup. If the thread was preempted and is
+			// already runnable, it's waiting from the earlier
+			// time.
+			if _, ok := l.runnable[ev.next]; !ok {
+				l.runnable[ev.next] = ev.time
+			}
+			continue
+		}
+
+		delete(l.runnable, ev.prev)
+		// The low byte of prev_state holds the task state, which is 0
+		// (TASK_RUNNING) if the thread is still runnable. Newer kernels
+		// set a higher bit if the thread was preempted. TID 0 is the
+		// idle task.
+		if ev.prevState&0xff == 0 && ev.prev != 0 {
+			l.runnable[ev.prev] = ev.time
+		}
+		if start, ok := l.runnable[ev.next]; ok {
+			delete(l.runnable, ev.next)
+			lat := time.Duration(ev.time - start)
+			l.dist(l.threads, int(ev.next)).add(lat)
+			l.dist(l.cpus, ev.cpu).add(lat)
+		}
+	}
+	l.pending = l.pending[:0]
+}
+
+// intField returns the value of integer field name, regardless of its
+// signedness.
+func intField(fields map[string]any, name string) int64 {
+	switch v := fields[name].(type) {
+	case int64:
+		return v
+	case uint64:
+		return int64(v)
+	}
+	return 0
+}
+
+func (l *SchedLatency) dist(m map[int]*LatencyDist, key int) *LatencyDist {
+	d, ok := m[key]
+	if !ok {
+		d = new(LatencyDist)
+		m[key] = d
+	}
+	return d
+}
+
+// Threads returns the latency distribution of each thread, by TID.
+func (l *SchedLatency) Threads() map[int]*LatencyDist {
+	l.process()
+	return l.threads
+}
+
+// CPUs returns the latency distribution of threads starting to run on each
+// CPU.
+func (l *SchedLatency) CPUs() map[int]*LatencyDist {
+	l.process()
+	return l.cpus
+}
+
+func (d *LatencyDist) add(lat time.Duration) {
+	d.Count++
+	d.Total += lat
+	d.Max = max(d.Max, lat)
+	d.Buckets[min(bits.Len64(uint64(lat)), len(d.Buckets)-1)]++
+}
+
+// Mean returns the mean latency, or 0 if there are no latencies.
+func (d *LatencyDist) Mean() time.Duration {
+	if d.Count == 0 {
+		return 0
+	}
+	return d.Total / time.Duration(d.Count)
+}
+
+// Quantile returns an upper bound on the q'th quantile latency, where q is
+// between 0 and 1. The bound is within a factor of 2 of the true quantile.
+func (d *LatencyDist) Quantile(q float64) time.Duration {
+	if d.Count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(d.Count)))
+	var n uint64
+	for i, c := range d.Buckets {
+		n += c
+		if n >= rank && c > 0 {
+			if i == 0 {
+				return 0
+			}
+			// The upper bound of bucket i is 2^i-1, but no more
+			// than the maximum.
+			return min(time.Duration(1)<<i-1, d.Max)
+		}
+	}
+	return d.Max
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+var (
+	testSwitchFormat = &events.TracepointFormat{Name: "sched_switch", ID: 300, Fields: []events.TracepointField{
+		{Name: "common_type", Type: "unsigned short", Offset: 0, Size: 2},
+		{Name: "prev_pid", Type: "pid_t", Offset: 8, Size: 4, Signed: true},
+		{Name: "prev_state", Type: "long", Offset: 16, Size: 8, Signed: true},
+		{Name: "next_pid", Type: "pid_t", Offset: 24, Size: 4, Signed: true},
+	}}
+	testWakeupFormat = &events.TracepointFormat{Name: "sched_wakeup", ID: 301, Fields: []events.TracepointField{
+		{Name: "common_type", Type: "unsigned short", Offset: 0, Size: 2},
+		{Name: "pid", Type: "pid_t", Offset: 8, Size: 4, Signed: true},
+	}}
+)
+
+func testSchedSwitch(time uint64, cpu uint32, prev, state, next int) *Sample {
+	raw := make([]byte, 32)
+	binary.NativeEndian.PutUint16(raw, 300)
+	binary.NativeEndian.PutUint32(raw[8:], uint32(prev))
+	binary.NativeEndian.PutUint64(raw[16:], uint64(state))
+	binary.NativeEndian.PutUint32(raw[24:], uint32(next))
+	return &Sample{Time: time, CPU: cpu, Raw: raw}
+}
+
+func testSchedWakeup(time uint64, cpu uint32, pid int) *Sample {
+	raw := make([]byte, 16)
+	binary.NativeEndian.PutUint16(raw, 301)
+	binary.NativeEndian.PutUint32(raw[8:], uint32(pid))
+	return &Sample{Time: time, CPU: cpu, Raw: raw}
+}
+
+func TestSchedLatency(t *testing.T) {
+	l, err := newSchedLatency(testSwitchFormat, testWakeupFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		sleeping  = 1
+		preempted = 0x100
+	)
+	// Added out of order, as if read from separate per-CPU Samplers.
+	samples := []*Sample{
+		// CPU 1: thread 10 runs 100ns after it's woken on CPU 0,
+		// then is preempted by thread 11.
+		testSchedSwitch(200, 1, 0, 0, 10),
+		testSchedSwitch(300, 1, 10, preempted, 11),
+		// Thread 10 resumes 1000ns after being preempted.
+		testSchedSwitch(1300, 1, 11, sleeping, 10),
+		// CPU 0.
+		testSchedWakeup(100, 0, 10),
+		testSchedWakeup(250, 0, 11),
+		// A thread that sleeps isn't runnable, so this has no latency.
+		testSchedSwitch(400, 0, 12, sleeping, 0),
+		testSchedSwitch(500, 0, 0, 0, 12),
+		// An unrelated tracepoint is ignored.
+		{Raw: []byte{1, 2, 3, 4}},
+	}
+	for _, s := range samples {
+		if err := l.Add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	threads := l.Threads()
+	if len(threads) != 2 {
+		t.Errorf("got latencies for %d threads, want 2", len(threads))
+	}
+	if d := threads[10]; d == nil || d.Count != 2 || d.Total != 1100 || d.Max != 1000 || d.Mean() != 550 {
+		t.Errorf("thread 10: got %+v, want 2 latencies of 100ns and 1000ns", d)
+	}
+	if d := threads[11]; d == nil || d.Count != 1 || d.Total != 50 {
+		t.Errorf("thread 11: got %+v, want 1 latency of 50ns", d)
+	}
+	cpus := l.CPUs()
+	if d := cpus[1]; len(cpus) != 1 || d == nil || d.Count != 3 || d.Max != 1000 {
+		t.Errorf("got CPU latencies %v, want 3 on CPU 1", cpus)
+	}
+}
+
+func TestLatencyDist(t *testing.T) {
+	var d LatencyDist
+	if d.Mean() != 0 || d.Quantile(0.5) != 0 {
+		t.Errorf("empty distribution: got mean %v, median %v, want 0", d.Mean(), d.Quantile(0.5))
+	}
+	for i := 0; i < 99; i++ {
+		d.add(100 * time.Nanosecond)
+	}
+	d.add(time.Millisecond)
+	if d.Buckets[7] != 99 || d.Buckets[20] != 1 {
+		t.Errorf("got buckets %v, want 99 in bucket 7 and 1 in bucket 20", d.Buckets)
+	}
+	for q, want := range map[float64]time.Duration{
+		0:    127,
+		0.5:  127,
+		0.99: 127,
+		1:    time.Millisecond,
+	} {
+		if got := d.Quantile(q); got != want {
+			t.Errorf("Quantile(%v) = %v, want %v", q, got, want)
+		}
+	}
+}
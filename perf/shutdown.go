@@ -0,0 +1,64 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+// ShutdownStats summarizes a Sampler's records at the end of a session. See
+// [Sampler.Shutdown].
+type ShutdownStats struct {
+	// Lost and Throttled are the Sampler's final [Sampler.Lost] and
+	// [Sampler.Throttled] statistics, including any records counted
+	// while draining the ring buffer.
+	Lost      LostStats
+	Throttled ThrottleStats
+
+	// Drained is the number of records passed to the callback.
+	Drained int
+
+	// Unread is the number of records left in the ring buffer when the
+	// callback stopped the drain or the limit was reached.
+	Unread int
+}
+
+// Shutdown stops s, drains its ring buffer, and closes it. The records around
+// the end of a session are often the most interesting, and closing a running
+// Sampler without draining it silently loses them.
+//
+// Shutdown first disables the event, so the kernel doesn't write any more
+// records. It then calls f with each record remaining in the ring buffer, in
+// order, until f returns false or it has passed limit records to f, if limit
+// is positive. It still tallies the lost and throttle records among any
+// remaining records, so the returned statistics are final. Finally, it unmaps
+// the ring buffer and closes the events, like [Sampler.Close].
+//
+// Like [Sampler.ReadRecord], each record's Data is only valid until f returns.
+func (s *Sampler) Shutdown(limit int, f func(rec RawRecord) bool) ShutdownStats {
+	var st ShutdownStats
+	if s == nil || s.f == nil {
+		return st
+	}
+	s.Stop()
+
+	draining := true
+	for {
+		rec, ok := s.ReadRecord()
+		if !ok {
+			break
+		}
+		if draining && (limit <= 0 || st.Drained < limit) {
+			st.Drained++
+			if !f(rec) {
+				draining = false
+			}
+			continue
+		}
+		draining = false
+		st.Unread++
+	}
+	st.Lost, st.Throttled = s.Lost(), s.Throttled()
+	s.Close()
+	return st
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestSamplerShutdown(t *testing.T) {
+	for _, test := range []struct {
+		limit   int
+		stop    bool // Callback returns false
+		drained int
+		unread  int
+	}{
+		{0, false, 4, 0},
+		{2, false, 2, 2},
+		{10, true, 1, 3},
+	} {
+		k := useFakeKernel(t)
+		opts := SamplerOptions{SampleType: SampleIP}
+		s, err := opts.OpenSampler(TargetThisGoroutine, events.EventCPUCycles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ev := k.events[s.fd]
+		s.Start()
+
+		ip := binary.NativeEndian.AppendUint64(nil, 0x1234)
+		ev.writeRecord(RecordSample, 0, ip)
+		ev.writeRecord(RecordSample, 0, ip)
+		ev.writeRecord(RecordSample, 0, ip)
+		// A lost record at the end is still counted.
+		var lost []byte
+		lost = binary.NativeEndian.AppendUint64(lost, 0)
+		lost = binary.NativeEndian.AppendUint64(lost, 7)
+		ev.writeRecord(RecordLost, 0, lost)
+
+		var got []RecordType
+		st := s.Shutdown(test.limit, func(rec RawRecord) bool {
+			got = append(got, rec.Type)
+			return !test.stop
+		})
+		if ev.enabled {
+			t.Errorf("limit %d: event still enabled", test.limit)
+		}
+		if st.Drained != test.drained || st.Unread != test.unread || len(got) != test.drained {
+			t.Errorf("limit %d: drained %d (%d callbacks), %d unread; want %d drained, %d unread", test.limit, st.Drained, len(got), st.Unread, test.drained, test.unread)
+		}
+		if st.Lost.Records != 7 {
+			t.Errorf("limit %d: got %d lost records, want 7", test.limit, st.Lost.Records)
+		}
+		if _, ok := s.ReadRecord(); ok {
+			t.Errorf("limit %d: read record after Shutdown", test.limit)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// A SideBandRecord is a decoded side-band record, which describes a change to
+// the sampled tasks rather than a sample, such as a new memory mapping. These
+// are needed to attribute the IPs of samples to binaries, and to track the
+// lifetimes of processes and threads. It is one of *[MmapRecord],
+// *[CommRecord], *[TaskRecord], or *[SwitchRecord].
+//
+// The kernel only writes side-band records if the Sampler was opened with
+// [SamplerOptions.SideBand] or [SamplerOptions.ContextSwitch].
+type SideBandRecord interface {
+	// Type returns the type of the underlying raw record.
+	Type() RecordType
+	// ID returns the record's ID, which can be used to order
+	// side-band records with samples.
+	ID() RecordID
+}
+
+// A RecordID identifies the task, time, and source of a non-sample record.
+// This is the kernel's struct sample_id. Its fields are a subset of the fields
+// of a [Sample], and like a Sample, only the fields selected by the sample type
+// are filled in. If the sample type doesn't include SampleTime, side-band
+// records can only be ordered with samples by their order in the ring buffer.
+type RecordID struct {
+	PID, TID uint32 // SampleTID
+	Time     uint64 // SampleTime, in nanoseconds
+	ID       uint64 // SampleID or SampleIdentifier
+	StreamID uint64 // SampleStreamID
+	CPU      uint32 // SampleCPU
+}
+
+// An MmapRecord is a decoded [RecordMmap2] or [RecordMmap] record, which
+// reports a new memory mapping.
+type MmapRecord struct {
+	PID, TID uint32
+	Addr     uint64 // Start address of the mapping
+	Len      uint64 // Length of the mapping in bytes
+	PgOff    uint64 // Offset of the mapping in the file, in bytes
+
+	// Maj, Min, Ino, and InoGeneration identify the mapped file. These are
+	// only set for RecordMmap2 records without a BuildID.
+	Maj, Min           uint32
+	Ino, InoGeneration uint64
+
+	// BuildID is the build ID of the mapped file, if the kernel reported
+	// it in place of the inode (PERF_RECORD_MISC_MMAP_BUILD_ID).
+	BuildID []byte
+
+	// Prot and Flags are the PROT_* and MAP_* flags of the mapping. These
+	// are only set for RecordMmap2 records.
+	Prot, Flags uint32
+
+	// Filename is the path of the mapped file or a pseudo-path like
+	// "//anon".
+	Filename string
+
+	// Data indicates a non-executable mapping (PERF_RECORD_MISC_MMAP_DATA).
+	Data bool
+
+	RecordID RecordID
+
+	typ RecordType
+}
+
+// A CommRecord is a decoded [RecordComm] record, which reports a change in
+// the name of a thread.
+type CommRecord struct {
+	PID, TID uint32
+	Comm     string
+
+	// Exec indicates the name changed because the process called exec
+	// (PERF_RECORD_MISC_COMM_EXEC).
+	Exec bool
+
+	RecordID RecordID
+}
+
+// A TaskRecord is a decoded [RecordFork] or [RecordExit] record, which
+// reports a new or exited thread or process. For a new thread, PID equals
+// PPID; for a new process, it does not.
+type TaskRecord struct {
+	Exit      bool // This is a RecordExit record
+	PID, PPID uint32
+	TID, PTID uint32
+	Time      uint64 // In nanoseconds
+	RecordID  RecordID
+}
+
+// A SwitchRecord is a decoded [RecordSwitch] or [RecordSwitchCPUWide] record,
+// which reports that a thread was scheduled onto or off of a CPU. The
+// RecordID identifies the thread and CPU, and when this happened.
+//
+// A Sampler monitoring a thread or process gets RecordSwitch records for the
+// target's threads. A Sampler monitoring a whole CPU gets RecordSwitchCPUWide
+// records, which also identify the other thread involved in the switch.
+type SwitchRecord struct {
+	// Out indicates a switch out of the thread. Otherwise, this is a
+	// switch into the thread.
+	Out bool
+
+	// Preempt indicates the thread was preempted while it was still
+	// runnable, rather than blocking. This is only set for switches out.
+	Preempt bool
+
+	// NextPrevPID and NextPrevTID identify the thread being switched to,
+	// for a switch out, or the thread being switched from, for a switch
+	// in. These are only set for RecordSwitchCPUWide records.
+	NextPrevPID, NextPrevTID uint32
+
+	RecordID RecordID
+
+	typ RecordType
+}
+
+func (r *MmapRecord) Type() RecordType { return r.typ }
+func (r *CommRecord) Type() RecordType { return RecordComm }
+
+func (r *TaskRecord) Type() RecordType {
+	if r.Exit {
+		return RecordExit
+	}
+	return RecordFork
+}
+
+func (r *MmapRecord) ID() RecordID { return r.RecordID }
+func (r *CommRecord) ID() RecordID { return r.RecordID }
+func (r *TaskRecord) ID() RecordID { return r.RecordID }
+
+func (r *SwitchRecord) Type() RecordType { return r.typ }
+func (r *SwitchRecord) ID() RecordID     { return r.RecordID }
+
+var errShortRecord = errors.New("record too short")
+
+// DecodeSideBand decodes rec, which must be a [RecordMmap2], [RecordMmap],
+// [RecordComm], [RecordFork], [RecordExit], [RecordSwitch], or
+// [RecordSwitchCPUWide] record, according to format,
+// which must be the format the record was produced with (see
+// [Sampler.SampleFormat]).
+//
+// Unlike [DecodeSample], the returned record doesn't refer to rec.Data, so it
+// remains valid after rec.Data is released.
+func DecodeSideBand(rec RawRecord, format SampleFormat) (SideBandRecord, error) {
+	body, id, err := decodeRecordID(rec.Data, format)
+	if err != nil {
+		return nil, err
+	}
+	d := sampleDecoder{data: body}
+	var out SideBandRecord
+	switch rec.Type {
+	case RecordMmap, RecordMmap2:
+		r := &MmapRecord{RecordID: id, typ: rec.Type}
+		r.PID, r.TID = d.u32(), d.u32()
+		r.Addr, r.Len, r.PgOff = d.u64(), d.u64(), d.u64()
+		if rec.Type == RecordMmap2 {
+			if rec.Misc&unix.PERF_RECORD_MISC_MMAP_BUILD_ID != 0 {
+				// u8 build_id_size, u8 and u16 reserved, u8 build_id[20].
+				ident := d.bytes(24)
+				if ident != nil && int(ident[0]) <= 20 {
+					r.BuildID = bytes.Clone(ident[4 : 4+ident[0]])
+				}
+			} else {
+				r.Maj, r.Min = d.u32(), d.u32()
+				r.Ino, r.InoGeneration = d.u64(), d.u64()
+			}
+			r.Prot, r.Flags = d.u32(), d.u32()
+		}
+		r.Filename = d.cstring()
+		r.Data = rec.Misc&unix.PERF_RECORD_MISC_MMAP_DATA != 0
+		out = r
+	case RecordComm:
+		r := &CommRecord{RecordID: id}
+		r.PID, r.TID = d.u32(), d.u32()
+		r.Comm = d.cstring()
+		r.Exec = rec.Misc&unix.PERF_RECORD_MISC_COMM_EXEC != 0
+		out = r
+	case RecordFork, RecordExit:
+		r := &TaskRecord{Exit: rec.Type == RecordExit, RecordID: id}
+		r.PID, r.PPID = d.u32(), d.u32()
+		r.TID, r.PTID = d.u32(), d.u32()
+		r.Time = d.u64()
+		out = r
+	case RecordSwitch, RecordSwitchCPUWide:
+		r := &SwitchRecord{RecordID: id, typ: rec.Type}
+		r.Out = rec.Misc&unix.PERF_RECORD_MISC_SWITCH_OUT != 0
+		r.Preempt = rec.Misc&unix.PERF_RECORD_MISC_SWITCH_OUT_PREEMPT != 0
+		if rec.Type == RecordSwitchCPUWide {
+			r.NextPrevPID, r.NextPrevTID = d.u32(), d.u32()
+		}
+		out = r
+	default:
+		return nil, fmt.Errorf("cannot decode %s record as a side-band record", rec.Type)
+	}
+	if d.short {
+		return nil, errShortRecord
+	}
+	return out, nil
+}
+
+// decodeRecordID splits the sample_id structure from the end of the body of a
+// non-sample record, if format includes one.
+func decodeRecordID(data []byte, format SampleFormat) (body []byte, id RecordID, err error) {
+	if !format.SampleIDAll {
+		return data, id, nil
+	}
+	st := format.SampleType
+	n := 0
+	for _, flag := range []SampleTypeFlags{SampleTID, SampleTime, SampleID, SampleStreamID, SampleCPU, SampleIdentifier} {
+		if st&flag != 0 {
+			n += 8
+		}
+	}
+	if len(data) < n {
+		return nil, id, errShortRecord
+	}
+	d := sampleDecoder{data: data[len(data)-n:]}
+	if st&SampleTID != 0 {
+		id.PID, id.TID = d.u32(), d.u32()
+	}
+	if st&SampleTime != 0 {
+		id.Time = d.u64()
+	}
+	if st&SampleID != 0 {
+		id.ID = d.u64()
+	}
+	if st&SampleStreamID != 0 {
+		id.StreamID = d.u64()
+	}
+	if st&SampleCPU != 0 {
+		id.CPU = d.u32()
+		d.u32() // Reserved
+	}
+	if st&SampleIdentifier != 0 {
+		id.ID = d.u64()
+	}
+	return data[:len(data)-n], id, nil
+}
+
+// appendRecordID appends the sample_id structure for id to the body of a
+// non-sample record, if format includes one. This is the inverse of
+// decodeRecordID.
+func appendRecordID(data []byte, id RecordID, format SampleFormat) []byte {
+	if !format.SampleIDAll {
+		return data
+	}
+	st := format.SampleType
+	u32, u64 := binary.NativeEndian.AppendUint32, binary.NativeEndian.AppendUint64
+	if st&SampleTID != 0 {
+		data = u32(u32(data, id.PID), id.TID)
+	}
+	if st&SampleTime != 0 {
+		data = u64(data, id.Time)
+	}
+	if st&SampleID != 0 {
+		data = u64(data, id.ID)
+	}
+	if st&SampleStreamID != 0 {
+		data = u64(data, id.StreamID)
+	}
+	if st&SampleCPU != 0 {
+		data = u32(u32(data, id.CPU), 0)
+	}
+	if st&SampleIdentifier != 0 {
+		data = u64(data, id.ID)
+	}
+	return data
+}
+
+// cstring consumes the rest of the data and returns the NUL-terminated string
+// at its start.
+func (d *sampleDecoder) cstring() string {
+	s := d.data
+	if i := bytes.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	d.data = nil
+	return string(s)
+}
+
+// ReadSamplesAndSideBand is like [Sampler.ReadSamples], but also decodes
+// side-band records and calls sideBand with each of them, in the order they
+// appear in the ring buffer. If either callback returns false,
+// ReadSamplesAndSideBand stops early.
+//
+// sideBand may retain its argument.
+//
+// Like [Sampler.ReadSamples], this counts as the process's own work.
+func (s *Sampler) ReadSamplesAndSideBand(sample func(s *Sample) bool, sideBand func(r SideBandRecord) bool) (err error) {
+	DoOwnWork(func() {
+		err = s.readSamplesAndSideBand(sample, sideBand)
+	})
+	return err
+}
+
+func (s *Sampler) readSamplesAndSideBand(sample func(s *Sample) bool, sideBand func(r SideBandRecord) bool) error {
+	var smpl Sample
+	for {
+		rec, ok := s.ReadRecord()
+		if !ok {
+			return nil
+		}
+		switch rec.Type {
+		case RecordSample:
+			if err := DecodeSample(rec, s.format, &smpl); err != nil {
+				return err
+			}
+			if s.excludeOwn && IsOwnWork(&smpl) {
+				continue
+			}
+			if !sample(&smpl) {
+				return nil
+			}
+		case RecordMmap, RecordMmap2, RecordComm, RecordFork, RecordExit, RecordSwitch, RecordSwitchCPUWide:
+			r, err := DecodeSideBand(rec, s.format)
+			if err != nil {
+				return err
+			}
+			if !sideBand(r) {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"encoding/binary"
+	"os"
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestDecodeSideBand(t *testing.T) {
+	format := SampleFormat{SampleType: SampleIP | SampleTID | SampleTime | SampleCPU, SampleIDAll: true}
+	id := RecordID{PID: 10, TID: 11, Time: 12345, CPU: 3}
+	withID := func(body []byte) []byte {
+		body = binary.NativeEndian.AppendUint32(body, id.PID)
+		body = binary.NativeEndian.AppendUint32(body, id.TID)
+		body = binary.NativeEndian.AppendUint64(body, id.Time)
+		body = binary.NativeEndian.AppendUint32(body, id.CPU)
+		return binary.NativeEndian.AppendUint32(body, 0)
+	}
+	u32 := binary.NativeEndian.AppendUint32
+	u64 := binary.NativeEndian.AppendUint64
+
+	var mmap2 []byte
+	mmap2 = u32(u32(mmap2, 10), 11)
+	mmap2 = u64(u64(u64(mmap2, 0x400000), 0x1000), 0x2000)
+	mmap2 = u32(u32(mmap2, 8), 1)    // maj, min
+	mmap2 = u64(u64(mmap2, 1234), 5) // ino, ino_generation
+	mmap2 = u32(u32(mmap2, 5), 2)    // prot, flags
+	mmap2 = append(mmap2, "/bin/true\x00\x00\x00\x00\x00\x00\x00"...)
+
+	var buildID []byte
+	buildID = u32(u32(buildID, 10), 11)
+	buildID = u64(u64(u64(buildID, 0x400000), 0x1000), 0)
+	buildID = append(buildID, 3, 0, 0, 0, 0xab, 0xcd, 0xef)
+	buildID = append(buildID, make([]byte, 17)...)
+	buildID = u32(u32(buildID, 5), 2)
+	buildID = append(buildID, "/x\x00\x00\x00\x00\x00\x00"...)
+
+	var comm []byte
+	comm = u32(u32(comm, 10), 11)
+	comm = append(comm, "worker\x00\x00"...)
+
+	var exit []byte
+	exit = u32(u32(u32(u32(exit, 10), 1), 12), 11)
+	exit = u64(exit, 999)
+
+	var switchCPUWide []byte
+	switchCPUWide = u32(u32(switchCPUWide, 20), 21)
+
+	for _, test := range []struct {
+		rec  RawRecord
+		want SideBandRecord
+	}{
+		{
+			RawRecord{RecordMmap2, 0, withID(mmap2)},
+			&MmapRecord{PID: 10, TID: 11, Addr: 0x400000, Len: 0x1000, PgOff: 0x2000, Maj: 8, Min: 1, Ino: 1234, InoGeneration: 5, Prot: 5, Flags: 2, Filename: "/bin/true", RecordID: id, typ: RecordMmap2},
+		},
+		{
+			RawRecord{RecordMmap2, unix.PERF_RECORD_MISC_MMAP_BUILD_ID, withID(buildID)},
+			&MmapRecord{PID: 10, TID: 11, Addr: 0x400000, Len: 0x1000, BuildID: []byte{0xab, 0xcd, 0xef}, Prot: 5, Flags: 2, Filename: "/x", RecordID: id, typ: RecordMmap2},
+		},
+		{
+			RawRecord{RecordComm, unix.PERF_RECORD_MISC_COMM_EXEC, withID(comm)},
+			&CommRecord{PID: 10, TID: 11, Comm: "worker", Exec: true, RecordID: id},
+		},
+		{
+			RawRecord{RecordExit, 0, withID(exit)},
+			&TaskRecord{Exit: true, PID: 10, PPID: 1, TID: 12, PTID: 11, Time: 999, RecordID: id},
+		},
+		{
+			RawRecord{RecordSwitchCPUWide, unix.PERF_RECORD_MISC_SWITCH_OUT | unix.PERF_RECORD_MISC_SWITCH_OUT_PREEMPT, withID(switchCPUWide)},
+			&SwitchRecord{Out: true, Preempt: true, NextPrevPID: 20, NextPrevTID: 21, RecordID: id, typ: RecordSwitchCPUWide},
+		},
+	} {
+		got, err := DecodeSideBand(test.rec, format)
+		if err != nil {
+			t.Errorf("%s: %v", test.rec.Type, err)
+			continue
+		}
+		if got.Type() != test.rec.Type {
+			t.Errorf("%s: got type %s", test.rec.Type, got.Type())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.rec.Type, got, test.want)
+		}
+
+		// Truncated records are an error.
+		short := test.rec
+		short.Data = short.Data[:len(short.Data)-len(withID(nil))-1]
+		if _, err := DecodeSideBand(short, format); err != errShortRecord {
+			t.Errorf("%s: truncated record: got %v, want %v", test.rec.Type, err, errShortRecord)
+		}
+	}
+
+	// A RecordSwitch record has only a RecordID.
+	got, err := DecodeSideBand(RawRecord{RecordSwitch, 0, withID(nil)}, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&SwitchRecord{RecordID: id, typ: RecordSwitch}); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: got %+v, want %+v", RecordSwitch, got, want)
+	}
+
+	// Without SampleIDAll, there's no RecordID.
+	got, err = DecodeSideBand(RawRecord{RecordExit, 0, exit}, SampleFormat{SampleType: format.SampleType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID() != (RecordID{}) {
+		t.Errorf("got ID %+v without SampleIDAll, want zero", got.ID())
+	}
+
+	if _, err := DecodeSideBand(RawRecord{RecordSample, 0, nil}, format); err == nil {
+		t.Errorf("decoding a sample as side-band: want error")
+	}
+}
+
+func TestSamplerSideBand(t *testing.T) {
+	opts := SamplerOptions{
+		SampleType: SampleIP | SampleTID | SampleTime,
+		SideBand:   true,
+	}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if !s.SampleFormat().SampleIDAll {
+		t.Errorf("SampleIDAll not set")
+	}
+
+	// Create an executable mapping and rename the thread, which should
+	// produce MMAP2 and COMM records.
+	exe, err := os.Open("/proc/self/exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exe.Close()
+	s.Start()
+	m, err := unix.Mmap(int(exe.Fd()), 0, 4096, unix.PROT_READ|unix.PROT_EXEC, unix.MAP_PRIVATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Munmap(m)
+	var oldName [16]byte
+	if err := unix.Prctl(unix.PR_GET_NAME, uintptr(unsafe.Pointer(&oldName[0])), 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	name, _ := unix.BytePtrFromString("sideband-test")
+	if err := unix.Prctl(unix.PR_SET_NAME, uintptr(unsafe.Pointer(name)), 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	unix.Prctl(unix.PR_SET_NAME, uintptr(unsafe.Pointer(&oldName[0])), 0, 0, 0)
+	s.Stop()
+
+	exePath, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tid := uint32(unix.Gettid())
+	pid := uint32(os.Getpid())
+	var gotMmap, gotComm bool
+	var gotBackfillExe, gotBackfillComm bool
+	backfill := true
+	err = s.ReadSamplesAndSideBand(func(*Sample) bool { return true }, func(r SideBandRecord) bool {
+		if backfill && r.ID().Time == 0 {
+			// Synthesized when the Sampler started.
+			if r.ID().PID != pid {
+				t.Errorf("synthesized %s record has ID %+v, want PID %d", r.Type(), r.ID(), pid)
+			}
+			switch r := r.(type) {
+			case *MmapRecord:
+				if r.Filename == exePath {
+					gotBackfillExe = true
+				}
+			case *CommRecord:
+				if r.TID != tid {
+					t.Errorf("synthesized COMM record for thread %d, want only %d", r.TID, tid)
+				}
+				gotBackfillComm = true
+			}
+			return true
+		}
+		backfill = false
+		if r.ID().TID != tid || r.ID().Time == 0 {
+			t.Errorf("%s record has ID %+v, want TID %d and non-zero time", r.Type(), r.ID(), tid)
+		}
+		switch r := r.(type) {
+		case *MmapRecord:
+			if r.Addr == uint64(uintptr(unsafe.Pointer(&m[0]))) {
+				gotMmap = true
+				if r.Filename != exePath || r.Prot != unix.PROT_READ|unix.PROT_EXEC {
+					t.Errorf("got %+v, want file %s and prot r-x", r, exePath)
+				}
+			}
+		case *CommRecord:
+			if r.Comm == "sideband-test" {
+				gotComm = true
+			}
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotMmap {
+		t.Errorf("no MMAP2 record for executable mapping")
+	}
+	if !gotComm {
+		t.Errorf("no COMM record for thread rename")
+	}
+	if !gotBackfillExe {
+		t.Errorf("no synthesized MMAP2 record for %s", exePath)
+	}
+	if !gotBackfillComm {
+		t.Errorf("no synthesized COMM record")
+	}
+}
+
+func TestSamplerBackfill(t *testing.T) {
+	tid := unix.Gettid()
+	for _, test := range []struct {
+		target   Target
+		sideBand bool
+		want     bool
+	}{
+		{TargetThread(tid), true, true},
+		{TargetThread(tid), false, false},
+		{TargetCPU(0), true, false},
+	} {
+		useFakeKernel(t)
+		opts := SamplerOptions{SampleType: SampleTID | SampleIdentifier, SideBand: test.sideBand}
+		s, err := opts.OpenSampler(test.target, events.EventCPUCycles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := s.ID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Start()
+		var comms, mmaps int
+		for {
+			rec, ok := s.ReadRecord()
+			if !ok {
+				break
+			}
+			r, err := DecodeSideBand(rec, s.SampleFormat())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.ID().ID != id {
+				t.Errorf("%s record has ID %d, want %d", r.Type(), r.ID().ID, id)
+			}
+			switch r := r.(type) {
+			case *CommRecord:
+				comms++
+				if r.TID != uint32(tid) || r.PID != uint32(os.Getpid()) {
+					t.Errorf("got COMM record for %d/%d, want %d/%d", r.PID, r.TID, os.Getpid(), tid)
+				}
+			case *MmapRecord:
+				mmaps++
+			}
+		}
+		if got := comms == 1 && mmaps > 0; got != test.want {
+			t.Errorf("%v, SideBand %v: got %d COMM and %d MMAP2 records, want backfill %v", test.target, test.sideBand, comms, mmaps, test.want)
+		}
+
+		// Backfill only happens when first started.
+		s.Stop()
+		s.Start()
+		if rec, ok := s.ReadRecord(); ok {
+			t.Errorf("got %s record after restarting", rec.Type)
+		}
+		s.Close()
+	}
+}
+
+func TestSamplerContextSwitch(t *testing.T) {
+	opts := SamplerOptions{
+		SampleType:    SampleTID | SampleTime | SampleCPU,
+		ContextSwitch: true,
+	}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Block the thread, which should switch it out and back in.
+	s.Start()
+	unix.Nanosleep(&unix.Timespec{Nsec: 1e6}, nil)
+	s.Stop()
+
+	tid := uint32(unix.Gettid())
+	var in, out int
+	var lastTime uint64
+	err = s.ReadSamplesAndSideBand(func(*Sample) bool { return true }, func(r SideBandRecord) bool {
+		sw, ok := r.(*SwitchRecord)
+		if !ok {
+			t.Errorf("unexpected %s record", r.Type())
+			return true
+		}
+		if sw.Type() != RecordSwitch {
+			t.Errorf("got %s record, want %s", sw.Type(), RecordSwitch)
+		}
+		if sw.RecordID.TID != tid {
+			t.Errorf("got switch of TID %d, want %d", sw.RecordID.TID, tid)
+		}
+		if sw.RecordID.Time < lastTime {
+			t.Errorf("switch time went backwards from %d to %d", lastTime, sw.RecordID.Time)
+		}
+		lastTime = sw.RecordID.Time
+		if sw.Out {
+			out++
+		} else {
+			in++
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in == 0 || out == 0 {
+		t.Errorf("got %d switches in and %d out, want some of each", in, out)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Snapshot reads a mutually consistent set of values from several Counters.
+// It stops every running counter, reads them all, and then restarts the ones
+// it stopped. Reading Counters one at a time while they run smears the reads
+// over time, so ratios between counts from different Counters, such as
+// different CPUs or threads, may not reflect any one instant. Snapshot
+// instead captures all of them as of when the last one stopped.
+//
+// Events that occur between stopping the first and last Counter are only
+// counted by the Counters that haven't stopped yet, and nothing is counted
+// while the Counters are stopped for reading. Hence, Snapshot is best for
+// occasional reads.
+//
+// Snapshot returns one slice of Counts for each Counter, in the order given.
+// If reading any Counter returns an error, Snapshot still restarts the
+// Counters and returns the first error. An error wrapping [ErrMultiplexed]
+// doesn't prevent reading the rest of the Counters.
+func Snapshot(cs ...*Counter) ([][]Count, error) {
+	// Stop all of the counters first, as close together as possible.
+	stopped := make([]*Counter, 0, len(cs))
+	for _, c := range cs {
+		if c != nil && c.running {
+			c.Stop()
+			stopped = append(stopped, c)
+		}
+	}
+	defer func() {
+		for _, c := range stopped {
+			c.Start()
+		}
+	}()
+
+	out := make([][]Count, len(cs))
+	var firstErr error
+	for i, c := range cs {
+		if c == nil {
+			continue
+		}
+		out[i] = make([]Count, c.nEvents)
+		err := c.ReadGroup(out[i])
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("counter %d: %w", i, err)
+		}
+		if !errors.Is(err, ErrMultiplexed) {
+			return nil, firstErr
+		}
+	}
+	return out, firstErr
+}
+
+// Snapshot returns a mutually consistent set of counts from all threads,
+// sorted by thread ID. See the top-level [Snapshot] function.
+func (t *ThreadCounters) Snapshot() ([]ThreadCount, error) {
+	cs := make([]*Counter, len(t.threads))
+	for i, th := range t.threads {
+		cs[i] = th.c
+	}
+	counts, err := Snapshot(cs...)
+	if counts == nil {
+		return nil, err
+	}
+	out := make([]ThreadCount, len(t.threads))
+	for i, th := range t.threads {
+		out[i] = ThreadCount{th.ThreadInfo, counts[i]}
+	}
+	return out, err
+}
+
+// Snapshot returns a mutually consistent set of counts from all CPUs, sorted
+// by CPU number. See the top-level [Snapshot] function.
+func (c *CPUCounters) Snapshot() ([]CPUCount, error) {
+	counts, err := Snapshot(c.cs...)
+	if counts == nil {
+		return nil, err
+	}
+	out := make([]CPUCount, len(c.cs))
+	for i := range c.cs {
+		out[i] = CPUCount{c.cpus[i], counts[i]}
+	}
+	return out, err
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"testing"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestSnapshot(t *testing.T) {
+	k := useFakeKernel(t)
+	var cs []*Counter
+	for i := 0; i < 3; i++ {
+		c, err := OpenCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventInstructions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		cs = append(cs, c)
+	}
+
+	// The first two are running, and the last one is stopped.
+	cs[0].Start()
+	cs[1].Start()
+	k.advance(100)
+	counts, err := Snapshot(cs[0], nil, cs[1], cs[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 4 || counts[1] != nil {
+		t.Fatalf("got %d results with %v for nil Counter, want 4 with nil", len(counts), counts[1])
+	}
+	for i, want := range []uint64{100, 0, 100, 0} {
+		for j, count := range counts[i] {
+			if count.RawValue != want {
+				t.Errorf("counter %d event %d: got %d, want %d", i, j, count.RawValue, want)
+			}
+		}
+	}
+
+	// Snapshot restarts only the counters that were running.
+	for i, want := range []bool{true, true, false} {
+		if cs[i].running != want || k.fakeEventFor(t, cs[i], 0).enabled != want {
+			t.Errorf("counter %d: running is %v, want %v", i, cs[i].running, want)
+		}
+	}
+}
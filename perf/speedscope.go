@@ -0,0 +1,148 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// A Speedscope accumulates [Sample]s and writes them in the JSON format of
+// speedscope (https://www.speedscope.app), an interactive profile viewer that
+// runs in a browser.
+//
+// It writes one profile for each event. Each sample is weighted by its
+// period, if the sample type includes [SamplePeriod], or otherwise by 1.
+// Samples are kept in the order they're added, so speedscope's time-ordered
+// view shows how the stacks changed over time.
+type Speedscope struct {
+	sym *SelfSymbolizer
+
+	frames   []speedscopeFrame
+	frameIDs map[speedscopeFrame]int
+
+	profiles []*speedscopeProfile
+	byEvent  map[string]*speedscopeProfile
+
+	stack []int
+}
+
+// NewSpeedscope returns a new, empty Speedscope. If sym is non-nil, it is used
+// to symbolize PCs. Otherwise, frames are named by their addresses.
+func NewSpeedscope(sym *SelfSymbolizer) *Speedscope {
+	return &Speedscope{
+		sym:      sym,
+		frameIDs: make(map[speedscopeFrame]int),
+		byEvent:  make(map[string]*speedscopeProfile),
+	}
+}
+
+type speedscopeFile struct {
+	Schema   string               `json:"$schema"`
+	Shared   speedscopeShared     `json:"shared"`
+	Profiles []*speedscopeProfile `json:"profiles"`
+	Exporter string               `json:"exporter"`
+}
+
+type speedscopeShared struct {
+	Frames []speedscopeFrame `json:"frames"`
+}
+
+type speedscopeFrame struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+}
+
+type speedscopeProfile struct {
+	Type       string   `json:"type"`
+	Name       string   `json:"name"`
+	Unit       string   `json:"unit"`
+	StartValue uint64   `json:"startValue"`
+	EndValue   uint64   `json:"endValue"`
+	Samples    [][]int  `json:"samples"`
+	Weights    []uint64 `json:"weights"`
+
+	// unit is the unit of weights that are sample periods.
+	unit string
+	// counted indicates some samples had no period, so the weights
+	// aren't in unit.
+	counted bool
+}
+
+// Add adds s, which is a sample of ev, to the profile for ev.
+func (sc *Speedscope) Add(ev events.Event, s *Sample) {
+	name := ev.String()
+	p := sc.byEvent[name]
+	if p == nil {
+		p = &speedscopeProfile{Type: "sampled", Name: name, unit: profileUnit(ev)}
+		sc.byEvent[name] = p
+		sc.profiles = append(sc.profiles, p)
+	}
+
+	weight := s.Period
+	if weight == 0 {
+		weight = 1
+		p.counted = true
+	}
+	p.EndValue += weight
+
+	// Speedscope stacks are outermost first.
+	sc.stack = sc.stack[:0]
+	frames := sampleFrames(sc.sym, s)
+	for i := len(frames) - 1; i >= 0; i-- {
+		sc.stack = append(sc.stack, sc.frame(frames[i]))
+	}
+	// Merge consecutive samples of the same stack.
+	if n := len(p.Samples); n > 0 && slices.Equal(p.Samples[n-1], sc.stack) {
+		p.Weights[n-1] += weight
+		return
+	}
+	p.Samples = append(p.Samples, slices.Clone(sc.stack))
+	p.Weights = append(p.Weights, weight)
+}
+
+func (sc *Speedscope) frame(fr Frame) int {
+	f := speedscopeFrame{Name: frameName(fr), File: fr.File, Line: fr.Line}
+	if fr.Kernel {
+		f.Name += " [kernel]"
+	}
+	if id, ok := sc.frameIDs[f]; ok {
+		return id
+	}
+	id := len(sc.frames)
+	sc.frames = append(sc.frames, f)
+	sc.frameIDs[f] = id
+	return id
+}
+
+// Write writes the profiles to w as speedscope JSON.
+func (sc *Speedscope) Write(w io.Writer) error {
+	for _, p := range sc.profiles {
+		p.Unit = "none"
+		// Speedscope only supports units of time and bytes.
+		if !p.counted && (p.unit == "nanoseconds" || p.unit == "bytes") {
+			p.Unit = p.unit
+		}
+	}
+	f := speedscopeFile{
+		Schema:   "https://www.speedscope.app/file-format-schema.json",
+		Shared:   speedscopeShared{Frames: sc.frames},
+		Profiles: sc.profiles,
+		Exporter: "go-perfevent",
+	}
+	if f.Shared.Frames == nil {
+		f.Shared.Frames = []speedscopeFrame{}
+	}
+	if f.Profiles == nil {
+		f.Profiles = []*speedscopeProfile{}
+	}
+	return json.NewEncoder(w).Encode(f)
+}
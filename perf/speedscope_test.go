@@ -0,0 +1,91 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestSpeedscope(t *testing.T) {
+	sym, err := NewSelfSymbolizer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcs := pprofTestLeaf()
+	leaf := uint64(reflect.ValueOf(pprofTestLeaf).Pointer())
+	user := []uint64{1<<64 + unix.PERF_CONTEXT_USER, leaf, uint64(pcs[1])}
+	kernel := append([]uint64{1<<64 + unix.PERF_CONTEXT_KERNEL, 0xffffffff81000000}, user...)
+
+	sc := NewSpeedscope(sym)
+	sc.Add(events.EventTaskClock, &Sample{Callchain: user, Period: 100})
+	sc.Add(events.EventTaskClock, &Sample{Callchain: user, Period: 200})
+	sc.Add(events.EventTaskClock, &Sample{Callchain: kernel, Period: 300})
+	sc.Add(events.EventCPUCycles, &Sample{IP: 0x1234})
+	var buf bytes.Buffer
+	if err := sc.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var f struct {
+		Schema string `json:"$schema"`
+		Shared struct {
+			Frames []struct{ Name, File string }
+		}
+		Profiles []struct {
+			Type, Name, Unit     string
+			StartValue, EndValue uint64
+			Samples              [][]int
+			Weights              []uint64
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &f); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(f.Schema, "speedscope") {
+		t.Errorf("got schema %q", f.Schema)
+	}
+	stack := func(ids []int) string {
+		var names []string
+		for _, id := range ids {
+			names = append(names, strings.TrimPrefix(f.Shared.Frames[id].Name, "github.com/aclements/go-perfevent/perf."))
+		}
+		return strings.Join(names, ";")
+	}
+	if len(f.Profiles) != 2 {
+		t.Fatalf("got %d profiles, want 2", len(f.Profiles))
+	}
+
+	p := f.Profiles[0]
+	if p.Type != "sampled" || p.Name != "task-clock" || p.Unit != "nanoseconds" || p.EndValue != 600 {
+		t.Errorf("got task-clock profile %s %s in %s ending at %d", p.Type, p.Name, p.Unit, p.EndValue)
+	}
+	var got []string
+	for _, s := range p.Samples {
+		got = append(got, stack(s))
+	}
+	want := []string{
+		"TestSpeedscope;pprofTestLeaf",
+		"TestSpeedscope;pprofTestLeaf;0xffffffff81000000 [kernel]",
+	}
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(p.Weights, []uint64{300, 300}) {
+		t.Errorf("got samples %q with weights %v, want %q with weights [300 300]", got, p.Weights, want)
+	}
+
+	// Samples without periods are counted.
+	p = f.Profiles[1]
+	if p.Name != "cpu-cycles" || p.Unit != "none" || len(p.Samples) != 1 || stack(p.Samples[0]) != "0x1234" || p.Weights[0] != 1 {
+		t.Errorf("got cpu-cycles profile %+v", p)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// An Interval is the counts of a [Counter]'s events over one interval of
+// [Counter.Stream].
+type Interval struct {
+	// Start and End are the wall-clock times of the reads at the beginning
+	// and end of this interval.
+	Start, End time.Time
+
+	// Counts are the counts of each event during this interval, in the order
+	// they were passed to [OpenCounter].
+	Counts []Count
+
+	// Err is non-nil if reading the counter failed. In this case, Counts is
+	// nil, and this is the last Interval sent on the channel.
+	Err error
+}
+
+// Stream reads c every interval and sends the change in counts since the
+// previous read on the returned channel. This is useful for tools that report
+// counts periodically, like "perf stat -I".
+//
+// Stream reads c from a new goroutine, so the caller must not otherwise use c
+// until the returned channel is closed. The channel is closed when ctx is done
+// or after sending an Interval with a non-nil Err. If the receiver falls
+// behind, Stream skips ticks, so the following Interval covers a longer period.
+//
+// Stream does not start or stop c. Reads that return [ErrMultiplexed] because
+// of [CounterOptions.StrictMultiplexing] are not treated as errors; check
+// [Count.Multiplexed] on each Interval's Counts instead.
+func (c *Counter) Stream(ctx context.Context, interval time.Duration) <-chan Interval {
+	ch := make(chan Interval)
+	go c.stream(ctx, interval, ch)
+	return ch
+}
+
+func (c *Counter) stream(ctx context.Context, interval time.Duration, ch chan<- Interval) {
+	defer close(ch)
+
+	send := func(iv Interval) bool {
+		select {
+		case ch <- iv:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	n := 0
+	if c != nil {
+		n = c.nEvents
+	}
+	read := func(cs []Count) (err error) {
+		DoOwnWork(func() {
+			err = c.ReadGroup(cs)
+		})
+		if errors.Is(err, ErrMultiplexed) {
+			err = nil
+		}
+		return err
+	}
+
+	prev := make([]Count, n)
+	if err := read(prev); err != nil {
+		send(Interval{Err: err})
+		return
+	}
+	prevTime := time.Now()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cur := make([]Count, n)
+		if err := read(cur); err != nil {
+			send(Interval{Err: err})
+			return
+		}
+		now := time.Now()
+
+		delta := make([]Count, len(cur))
+		for i := range cur {
+			delta[i] = cur[i].Sub(prev[i])
+		}
+		if !send(Interval{Start: prevTime, End: now, Counts: delta}) {
+			return
+		}
+		prev, prevTime = cur, now
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// A Frame is a symbolized PC from a sample's callchain.
+type Frame struct {
+	PC uint64
+
+	// Function, File, and Line identify the source location of PC, if
+	// known. For Go code, a single PC may expand to several Frames if
+	// functions were inlined.
+	Function string
+	File     string
+	Line     int
+
+	// Kernel indicates PC is in the kernel. Kernel frames are not
+	// symbolized.
+	Kernel bool
+
+	// Mapping is the memory mapping containing PC, or nil if unknown. This
+	// is set for frames outside Go code, such as cgo or shared libraries,
+	// which can't be symbolized by the Go runtime.
+	Mapping *Mapping
+}
+
+// A SelfSymbolizer symbolizes PCs in the calling process. It symbolizes Go
+// code using the Go runtime's symbol tables. Other PCs, such as in cgo code or
+// shared libraries, are resolved to a memory mapping from /proc/self/maps and
+// symbolized using the symbol table and DWARF line table of the mapped ELF
+// file, if it has them. It caches the tables of each file and the frame of
+// each non-Go PC.
+//
+// It is safe to call methods on SelfSymbolizer from multiple goroutines.
+type SelfSymbolizer struct {
+	mu     sync.Mutex
+	maps   []Mapping           // Sorted by Start
+	files  map[string]*elfFile // By path; nil if the file can't be read
+	frames map[symbolKey]Frame // Non-Go frames, without PC
+}
+
+type symbolKey struct {
+	pc    uint64
+	exact bool
+}
+
+// NewSelfSymbolizer returns a new [SelfSymbolizer] for the calling process.
+func NewSelfSymbolizer() (*SelfSymbolizer, error) {
+	s := new(SelfSymbolizer)
+	if err := s.Refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Refresh re-reads the memory mappings of the process and discards cached
+// frames. This is necessary to resolve PCs in shared libraries loaded after
+// the SelfSymbolizer was created.
+func (s *SelfSymbolizer) Refresh() error {
+	data, err := os.ReadFile("/proc/self/maps")
+	if err != nil {
+		return err
+	}
+	maps, err := parseMaps(data)
+	if err != nil {
+		return err
+	}
+	sort.Slice(maps, func(i, j int) bool { return maps[i].Start < maps[j].Start })
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maps = maps
+	s.frames = make(map[symbolKey]Frame)
+	if s.files == nil {
+		s.files = make(map[string]*elfFile)
+	}
+	return nil
+}
+
+// mapping returns the mapping containing pc, or nil. s.mu must be held.
+func (s *SelfSymbolizer) mapping(pc uint64) *Mapping {
+	i := sort.Search(len(s.maps), func(i int) bool { return s.maps[i].End > pc })
+	if i < len(s.maps) && s.maps[i].Start <= pc {
+		m := s.maps[i]
+		return &m
+	}
+	return nil
+}
+
+// Callchain symbolizes a sample callchain, such as [Sample.Callchain], and
+// returns its frames from innermost to outermost. It drops the
+// PERF_CONTEXT_* markers in the callchain, but uses them to mark kernel
+// frames.
+func (s *SelfSymbolizer) Callchain(pcs []uint64) []Frame {
+	var frames []Frame
+	kernel := false
+	// The first PC in each context is the exact PC where the sample was
+	// taken (or where the context was entered). The rest are return
+	// addresses.
+	exact := true
+	for _, pc := range pcs {
+		if pc >= perfContextMax {
+			kernel = isKernelContext(pc)
+			exact = true
+			continue
+		}
+		if kernel {
+			frames = append(frames, Frame{PC: pc, Kernel: true})
+		} else {
+			frames = s.appendFrames(frames, pc, exact)
+		}
+		exact = false
+	}
+	return frames
+}
+
+// sampleFrames returns the frames of s's stack, from innermost to outermost.
+// The stack is s.Callchain if the sample has one, or otherwise just s.IP. If
+// sym is nil, the frames aren't symbolized.
+func sampleFrames(sym *SelfSymbolizer, s *Sample) []Frame {
+	pcs := s.Callchain
+	if pcs == nil {
+		pcs = []uint64{s.IP}
+	}
+	if sym != nil {
+		return sym.Callchain(pcs)
+	}
+	var frames []Frame
+	kernel := false
+	for _, pc := range pcs {
+		if pc >= perfContextMax {
+			kernel = isKernelContext(pc)
+			continue
+		}
+		frames = append(frames, Frame{PC: pc, Kernel: kernel})
+	}
+	return frames
+}
+
+// perfContextMax is PERF_CONTEXT_MAX as an unsigned value. Callchain entries
+// at or above this are context markers rather than PCs.
+const perfContextMax = 1<<64 + unix.PERF_CONTEXT_MAX
+
+// isKernelContext reports whether the callchain context marker pc begins
+// kernel frames.
+func isKernelContext(pc uint64) bool {
+	switch int64(pc) {
+	case unix.PERF_CONTEXT_KERNEL, unix.PERF_CONTEXT_GUEST_KERNEL, unix.PERF_CONTEXT_HV:
+		return true
+	}
+	return false
+}
+
+// appendFrames appends the frames for user-space PC pc to frames. If exact is
+// false, pc is a return address.
+func (s *SelfSymbolizer) appendFrames(frames []Frame, pc uint64, exact bool) []Frame {
+	// runtime.CallersFrames expects return addresses, and backs up to the
+	// call instruction. If pc is exact, adjust it so CallersFrames gets the
+	// right instruction.
+	rpc := uintptr(pc)
+	if exact {
+		rpc++
+	}
+	if fn := runtime.FuncForPC(rpc - 1); fn != nil {
+		n := len(frames)
+		iter := runtime.CallersFrames([]uintptr{rpc})
+		for {
+			f, more := iter.Next()
+			if f.Function != "" {
+				frames = append(frames, Frame{PC: pc, Function: f.Function, File: f.File, Line: f.Line})
+			}
+			if !more {
+				break
+			}
+		}
+		if len(frames) > n {
+			return frames
+		}
+	}
+	// Not Go code.
+	return append(frames, s.nonGoFrame(pc, exact))
+}
+
+// nonGoFrame symbolizes pc using the ELF file mapped at pc. If exact is
+// false, pc is a return address.
+func (s *SelfSymbolizer) nonGoFrame(pc uint64, exact bool) Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := symbolKey{pc, exact}
+	if f, ok := s.frames[key]; ok {
+		f.PC = pc
+		return f
+	}
+
+	var f Frame
+	if m := s.mapping(pc); m != nil {
+		f.Mapping = m
+		if ef := s.elfFile(m.Path); ef != nil {
+			// Look up the call instruction, not the instruction
+			// after it.
+			lookup := pc
+			if !exact {
+				lookup--
+			}
+			if addr, ok := ef.fileAddr(m, lookup); ok {
+				f.Function, f.File, f.Line = ef.symbolize(addr)
+			}
+		}
+	}
+	s.frames[key] = f
+	f.PC = pc
+	return f
+}
+
+// elfFile returns the tables of the ELF file at path, or nil if it can't be
+// read. s.mu must be held.
+func (s *SelfSymbolizer) elfFile(path string) *elfFile {
+	if ef, ok := s.files[path]; ok {
+		return ef
+	}
+	var ef *elfFile
+	// Pseudo-paths like "[vdso]" and anonymous mappings aren't files.
+	if strings.HasPrefix(path, "/") {
+		ef, _ = openELFFile(path)
+	}
+	s.files[path] = ef
+	return ef
+}
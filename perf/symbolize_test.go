@@ -0,0 +1,164 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+//go:noinline
+func spinForSymbolizeTest(d time.Duration) {
+	// Check the time only occasionally so most samples land in this
+	// function itself. Without frame pointers, samples in time.Now's
+	// callees don't unwind back to here.
+	start := time.Now()
+	for i := 0; i%1000 != 0 || time.Since(start) < d; i++ {
+	}
+}
+
+func TestSelfSymbolizer(t *testing.T) {
+	opts := SamplerOptions{
+		SampleType: SampleIP | SampleCallchain,
+		Period:     100000,
+	}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	sym, err := NewSelfSymbolizer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Start()
+	spinForSymbolizeTest(20 * time.Millisecond)
+	s.Stop()
+
+	samples, found := 0, 0
+	err = s.ReadSamples(func(sample *Sample) bool {
+		samples++
+		for _, f := range sym.Callchain(sample.Callchain) {
+			if strings.HasSuffix(f.Function, ".spinForSymbolizeTest") {
+				found++
+				if !strings.HasSuffix(f.File, "symbolize_test.go") {
+					t.Errorf("spinForSymbolizeTest frame has file %q", f.File)
+				}
+				break
+			}
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("found spinForSymbolizeTest in %d of %d samples", found, samples)
+	if found < samples/2 {
+		t.Errorf("spinForSymbolizeTest in only %d of %d samples", found, samples)
+	}
+}
+
+func TestSymbolizerCallchain(t *testing.T) {
+	sym, err := NewSelfSymbolizer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kernelPC := uint64(0xffffffff81000000)
+	const ctxKernel = 1<<64 + unix.PERF_CONTEXT_KERNEL
+	const ctxUser = 1<<64 + unix.PERF_CONTEXT_USER
+	frames := sym.Callchain([]uint64{ctxKernel, kernelPC, ctxUser, 1})
+	if len(frames) != 2 {
+		t.Fatalf("got %d frames, want 2: %+v", len(frames), frames)
+	}
+	if !frames[0].Kernel || frames[0].PC != kernelPC {
+		t.Errorf("frame 0: got %+v, want kernel frame at %#x", frames[0], kernelPC)
+	}
+	if frames[1].Kernel || frames[1].Function != "" || frames[1].Mapping != nil {
+		t.Errorf("frame 1: got %+v, want unknown user frame", frames[1])
+	}
+}
+
+func TestSymbolizerELF(t *testing.T) {
+	// Build a shared library with a symbol and line table. The Go test
+	// binary itself is stripped.
+	cc, err := exec.LookPath("gcc")
+	if err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "lib.c")
+	if err := os.WriteFile(src, []byte("int perfTestFunc(int x) {\n\treturn x * 2;\n}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	lib := filepath.Join(dir, "lib.so")
+	if out, err := exec.Command(cc, "-g", "-O0", "-shared", "-fPIC", "-o", lib, src).CombinedOutput(); err != nil {
+		t.Skipf("building shared library: %v\n%s", err, out)
+	}
+
+	f, err := elf.Open(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	syms, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fn elf.Symbol
+	for _, sym := range syms {
+		if sym.Name == "perfTestFunc" {
+			fn = sym
+		}
+	}
+	var text *elf.Prog
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD && p.Vaddr <= fn.Value && fn.Value < p.Vaddr+p.Memsz {
+			text = p
+		}
+	}
+	if fn.Value == 0 || text == nil {
+		t.Fatalf("perfTestFunc or its segment not found")
+	}
+
+	// Pretend the library is mapped at base.
+	const base = 0x7f0000000000
+	m := Mapping{Start: base + text.Vaddr&^0xfff, End: base + text.Vaddr + text.Memsz, Offset: text.Off &^ 0xfff, Perm: "r-xp", Path: lib}
+	sym := &SelfSymbolizer{maps: []Mapping{m}, files: make(map[string]*elfFile), frames: make(map[symbolKey]Frame)}
+	pc := base + fn.Value + 4
+	for i := 0; i < 2; i++ { // The second time is cached.
+		frames := sym.appendFrames(nil, pc, true)
+		if len(frames) != 1 {
+			t.Fatalf("got %d frames, want 1", len(frames))
+		}
+		fr := frames[0]
+		if fr.PC != pc || fr.Mapping == nil || fr.Mapping.Path != lib {
+			t.Errorf("got %+v, want frame at %#x in %s", fr, pc, lib)
+		}
+		if fr.Function != "perfTestFunc" {
+			t.Errorf("got function %q, want perfTestFunc", fr.Function)
+		}
+		if fr.File != src || fr.Line != 1 {
+			t.Errorf("got %s:%d, want %s:1", fr.File, fr.Line, src)
+		}
+	}
+
+	// PCs past the end of the function aren't in it.
+	if fr := sym.appendFrames(nil, base+fn.Value+fn.Size, true)[0]; fr.Function == "perfTestFunc" {
+		t.Errorf("PC past end of perfTestFunc symbolized as %+v", fr)
+	}
+}
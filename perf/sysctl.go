@@ -0,0 +1,114 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Sysctls is a snapshot of the kernel settings that control perf events.
+// Changing these can make opening events fail, or change how often a
+// [Sampler] may sample.
+type Sysctls struct {
+	// Paranoid is perf_event_paranoid, which restricts unprivileged use
+	// of perf events. Higher values are more restrictive.
+	Paranoid int
+
+	// MaxSampleRate is perf_event_max_sample_rate, the maximum rate in
+	// samples per second. The kernel lowers this itself if sampling uses
+	// too much CPU time.
+	MaxSampleRate int
+
+	// MaxStack is perf_event_max_stack, the maximum number of frames in a
+	// callchain, or 0 if the kernel doesn't have this setting.
+	MaxStack int
+
+	// MlockKB is perf_event_mlock_kb, the amount of memory each user may
+	// lock for ring buffers beyond RLIMIT_MEMLOCK, or 0 if the kernel
+	// doesn't have this setting.
+	MlockKB int
+}
+
+// sysctlDir is the directory containing the perf event sysctls.
+var sysctlDir = "/proc/sys/kernel"
+
+// ReadSysctls returns the current perf event sysctls. It returns an error if
+// the kernel doesn't support perf events.
+func ReadSysctls() (Sysctls, error) {
+	return readSysctls(sysctlDir)
+}
+
+func readSysctls(dir string) (Sysctls, error) {
+	var s Sysctls
+	for _, f := range []struct {
+		name     string
+		val      *int
+		optional bool
+	}{
+		{"perf_event_paranoid", &s.Paranoid, false},
+		{"perf_event_max_sample_rate", &s.MaxSampleRate, false},
+		{"perf_event_max_stack", &s.MaxStack, true},
+		{"perf_event_mlock_kb", &s.MlockKB, true},
+	} {
+		path := filepath.Join(dir, f.name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if f.optional && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return Sysctls{}, err
+		}
+		*f.val, err = strconv.Atoi(string(bytes.TrimSpace(data)))
+		if err != nil {
+			return Sysctls{}, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+// WatchSysctls checks the perf event sysctls every interval and calls changed
+// with the old and new settings whenever they differ. For example, a
+// long-running monitor can use this to re-check which events it can open
+// after an administrator raises perf_event_paranoid, rather than failing when
+// it next opens an event.
+//
+// WatchSysctls reads the sysctls once before returning, and returns an error
+// if that fails. It then checks them from a new goroutine, which calls changed,
+// until ctx is done. If reading the sysctls later fails, it keeps the last
+// settings it read and tries again at the next interval.
+func WatchSysctls(ctx context.Context, interval time.Duration, changed func(old, new Sysctls)) error {
+	dir := sysctlDir
+	prev, err := readSysctls(dir)
+	if err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			cur, err := readSysctls(dir)
+			if err != nil || cur == prev {
+				continue
+			}
+			changed(prev, cur)
+			prev = cur
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadSysctls(t *testing.T) {
+	s, err := ReadSysctls()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.MaxSampleRate <= 0 {
+		t.Errorf("got max sample rate %d, want > 0", s.MaxSampleRate)
+	}
+	t.Logf("%+v", s)
+}
+
+func TestWatchSysctls(t *testing.T) {
+	dir := t.TempDir()
+	oldDir := sysctlDir
+	sysctlDir = dir
+	defer func() { sysctlDir = oldDir }()
+
+	write := func(name, val string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(val+"\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("perf_event_paranoid", "2")
+	write("perf_event_max_sample_rate", "100000")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type change struct{ old, new Sysctls }
+	changes := make(chan change, 10)
+	err := WatchSysctls(ctx, time.Millisecond, func(old, new Sysctls) {
+		changes <- change{old, new}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("perf_event_paranoid", "-1")
+	got := <-changes
+	want := change{Sysctls{Paranoid: 2, MaxSampleRate: 100000}, Sysctls{Paranoid: -1, MaxSampleRate: 100000}}
+	if got != want {
+		t.Errorf("got change %+v, want %+v", got, want)
+	}
+
+	// Unreadable settings are skipped, not reported.
+	os.Remove(filepath.Join(dir, "perf_event_max_sample_rate"))
+	time.Sleep(10 * time.Millisecond)
+	write("perf_event_max_sample_rate", "5000")
+	got = <-changes
+	want = change{Sysctls{Paranoid: -1, MaxSampleRate: 100000}, Sysctls{Paranoid: -1, MaxSampleRate: 5000}}
+	if got != want {
+		t.Errorf("got change %+v, want %+v", got, want)
+	}
+
+	// Missing required settings are an error.
+	os.Remove(filepath.Join(dir, "perf_event_paranoid"))
+	if err := WatchSysctls(ctx, time.Millisecond, func(old, new Sysctls) {}); err == nil {
+		t.Errorf("watching without perf_event_paranoid: want error")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"fmt"
+	"time"
+)
+
+// A ThrottleRecord is a decoded [RecordThrottle] or [RecordUnthrottle] record.
+//
+// The kernel throttles a sampled event, which stops it from taking samples,
+// if it samples faster than /proc/sys/kernel/perf_event_max_sample_rate, and
+// unthrottles it on a later timer tick. The kernel also lowers
+// perf_event_max_sample_rate if sampling takes too much CPU time. No samples
+// are recorded while an event is throttled, which can silently skew a
+// profile, so it's important to check for throttling. [Sampler.Throttled]
+// summarizes these records.
+type ThrottleRecord struct {
+	Unthrottle bool   // This is a RecordUnthrottle record
+	Time       uint64 // In nanoseconds
+	ID         uint64 // The event's ID
+	StreamID   uint64 // The event's stream ID
+	RecordID   RecordID
+}
+
+// DecodeThrottle decodes rec, which must be a [RecordThrottle] or
+// [RecordUnthrottle] record, according to format, which must be the format
+// the record was produced with (see [Sampler.SampleFormat]).
+func DecodeThrottle(rec RawRecord, format SampleFormat) (ThrottleRecord, error) {
+	if rec.Type != RecordThrottle && rec.Type != RecordUnthrottle {
+		return ThrottleRecord{}, fmt.Errorf("cannot decode %s record as a throttle record", rec.Type)
+	}
+	body, id, err := decodeRecordID(rec.Data, format)
+	if err != nil {
+		return ThrottleRecord{}, err
+	}
+	d := sampleDecoder{data: body}
+	r := ThrottleRecord{Unthrottle: rec.Type == RecordUnthrottle, RecordID: id}
+	r.Time, r.ID, r.StreamID = d.u64(), d.u64(), d.u64()
+	if d.short {
+		return ThrottleRecord{}, errShortRecord
+	}
+	return r, nil
+}
+
+// ThrottleStats summarizes how much the kernel throttled a [Sampler]. See
+// [ThrottleRecord].
+type ThrottleStats struct {
+	// Throttles is the number of times the kernel throttled the event.
+	Throttles int
+
+	// Duration is the total time the event was throttled. This only
+	// includes throttles that have been unthrottled.
+	Duration time.Duration
+}
+
+// Throttled returns a summary of the throttle and unthrottle records the
+// Sampler has read. This includes records read by any method, such as
+// [Sampler.ReadSamples] and [Sampler.ReadRecord]. If Throttles is non-zero,
+// samples are missing from the periods the event was throttled, so consider
+// sampling less often.
+func (s *Sampler) Throttled() ThrottleStats {
+	return s.throttle
+}
+
+// noteThrottle updates s's throttle statistics from rec, which must be a
+// RecordThrottle or RecordUnthrottle record.
+func (s *Sampler) noteThrottle(rec RawRecord) {
+	r, err := DecodeThrottle(rec, s.format)
+	if err != nil {
+		return
+	}
+	if !r.Unthrottle {
+		s.throttle.Throttles++
+		s.throttledAt = r.Time
+	} else if s.throttledAt != 0 && r.Time >= s.throttledAt {
+		s.throttle.Duration += time.Duration(r.Time - s.throttledAt)
+		s.throttledAt = 0
+	}
+}
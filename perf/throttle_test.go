@@ -0,0 +1,83 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func throttleData(t, id, streamID uint64) []byte {
+	var data []byte
+	data = binary.NativeEndian.AppendUint64(data, t)
+	data = binary.NativeEndian.AppendUint64(data, id)
+	return binary.NativeEndian.AppendUint64(data, streamID)
+}
+
+func TestDecodeThrottle(t *testing.T) {
+	format := SampleFormat{SampleType: SampleIP | SampleTID, SampleIDAll: true}
+	data := throttleData(1000, 2, 3)
+	data = binary.NativeEndian.AppendUint32(data, 10)
+	data = binary.NativeEndian.AppendUint32(data, 11)
+	got, err := DecodeThrottle(RawRecord{Type: RecordUnthrottle, Data: data}, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ThrottleRecord{Unthrottle: true, Time: 1000, ID: 2, StreamID: 3, RecordID: RecordID{PID: 10, TID: 11}}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := DecodeThrottle(RawRecord{Type: RecordThrottle, Data: data[:20]}, format); err != errShortRecord {
+		t.Errorf("truncated record: got %v, want %v", err, errShortRecord)
+	}
+	if _, err := DecodeThrottle(RawRecord{Type: RecordSample, Data: data}, format); err == nil {
+		t.Errorf("decoding a sample as a throttle record: want error")
+	}
+}
+
+func TestSamplerThrottled(t *testing.T) {
+	k := useFakeKernel(t)
+	opts := SamplerOptions{SampleType: SampleIP}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ev := k.events[s.fd]
+
+	sample := binary.NativeEndian.AppendUint64(nil, 0x1000)
+	ev.writeRecord(RecordSample, 0, sample)
+	ev.writeRecord(RecordThrottle, 0, throttleData(1000, 0, 0))
+	ev.writeRecord(RecordUnthrottle, 0, throttleData(5000, 0, 0))
+	ev.writeRecord(RecordSample, 0, sample)
+	ev.writeRecord(RecordThrottle, 0, throttleData(8000, 0, 0))
+
+	n := 0
+	if err := s.ReadSamples(func(*Sample) bool { n++; return true }); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d samples, want 2", n)
+	}
+	// The second throttle hasn't ended, so it doesn't count toward the
+	// duration yet.
+	want := ThrottleStats{Throttles: 2, Duration: 4000 * time.Nanosecond}
+	if got := s.Throttled(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	ev.writeRecord(RecordUnthrottle, 0, throttleData(9000, 0, 0))
+	s.ReadSamples(func(*Sample) bool { return true })
+	want.Duration += 1000
+	if got := s.Throttled(); got != want {
+		t.Errorf("after unthrottle, got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"context"
+	"runtime/trace"
+)
+
+// TraceRegion runs f in a [runtime/trace] region of type regionType, and
+// records the counts of c's events while running f in the execution trace.
+// Each event is logged with [trace.Log] at the end of the region, with the
+// event's name as the category and the [Count] as the message, so they show
+// up alongside the region in "go tool trace".
+//
+// c must be running and counting the calling goroutine, such as a Counter
+// opened on [TargetThisGoroutine]. TraceRegion reads c before and after
+// calling f and logs the difference, so it doesn't disturb c's running total.
+//
+// If execution tracing isn't enabled, TraceRegion just calls f in the region,
+// without reading c. If c can't be read, TraceRegion still runs f, but
+// returns the error.
+func (c *Counter) TraceRegion(ctx context.Context, regionType string, f func()) error {
+	if !trace.IsEnabled() {
+		trace.WithRegion(ctx, regionType, f)
+		return nil
+	}
+	evs := c.Events()
+	before := make([]Count, len(evs))
+	after := make([]Count, len(evs))
+	var err error
+	trace.WithRegion(ctx, regionType, func() {
+		if err = c.ReadGroup(before); err != nil {
+			f()
+			return
+		}
+		f()
+		if err = c.ReadGroup(after); err != nil {
+			return
+		}
+		for i, ev := range evs {
+			trace.Log(ctx, ev.String(), after[i].Sub(before[i]).String())
+		}
+	})
+	return err
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"bytes"
+	"context"
+	"runtime/trace"
+	"testing"
+	"time"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestTraceRegion(t *testing.T) {
+	c, err := OpenCounter(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Start()
+	defer c.Stop()
+
+	spin := func() {
+		for start := time.Now(); time.Since(start) < time.Millisecond; {
+		}
+	}
+
+	// Without tracing, it just runs f.
+	ran := false
+	if err := c.TraceRegion(context.Background(), "untraced", func() { ran = true }); err != nil {
+		t.Fatal(err)
+	}
+	if !ran {
+		t.Errorf("TraceRegion didn't run f")
+	}
+
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		t.Skipf("can't start execution trace: %v", err)
+	}
+	err = c.TraceRegion(context.Background(), "perf-test-region", spin)
+	trace.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parsing the trace requires golang.org/x/exp/trace, so just check
+	// that the region and log strings made it into the trace.
+	for _, want := range []string{"perf-test-region", "task-clock"} {
+		if !bytes.Contains(buf.Bytes(), []byte(want)) {
+			t.Errorf("trace doesn't contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// Bits of perf_event_attr that x/sys/unix doesn't define yet.
+const (
+	perfBitRemoveOnExec = 1 << 36
+	perfBitSigtrap      = 1 << 37
+)
+
+// A TrapSampler samples an event by having the kernel deliver SIGTRAP to the
+// calling thread each time the event overflows, and recording the instruction
+// pointer the signal interrupted. Unlike a [Sampler], this doesn't need a ring
+// buffer, so it's a lightweight way to sample small programs and test
+// harnesses. It requires Linux 5.13 or later and is currently only supported
+// on amd64.
+//
+// The Go runtime crashes on SIGTRAPs it doesn't expect, so the first
+// TrapSampler installs a process-wide SIGTRAP handler that handles signals
+// from TrapSamplers and forwards all others to the Go runtime. Calling
+// [os/signal.Notify], [os/signal.Ignore], or [os/signal.Reset] with
+// [syscall.SIGTRAP] replaces this handler, after which overflows crash the
+// program.
+//
+// A TrapSampler is not safe for concurrent use by multiple goroutines.
+type TrapSampler struct {
+	fd   int
+	slot int
+	buf  *trapBuf
+}
+
+// trapBufSize is the number of samples a TrapSampler can record between
+// resets.
+const trapBufSize = 4096
+
+// trapBuf is the buffer the SIGTRAP handler records samples in. Its layout is
+// known to the handler.
+type trapBuf struct {
+	n   uint64 // Number of overflows, including dropped samples
+	ips [trapBufSize]uint64
+}
+
+// trapSigData tags the sig_data of TrapSampler events, so the SIGTRAP handler
+// can tell them apart from other users of sigtrap. The low 32 bits are the
+// index of the event's trapBuf in trapBufs.
+const trapSigData = 0x70657266 << 32
+
+// trapBufs are the buffers of all open TrapSamplers, indexed by slot. The
+// SIGTRAP handler reads this without locks.
+var trapBufs [64]*trapBuf
+
+var (
+	trapMu      sync.Mutex
+	trapInstall sync.Once
+	trapErr     error
+)
+
+// sigtrapPrev is the SIGTRAP handler that was installed before ours. Our
+// handler jumps to this for SIGTRAPs that aren't from a TrapSampler.
+var sigtrapPrev uintptr
+
+// OpenTrapSampler returns a new [TrapSampler] that samples ev on the calling
+// goroutine every period events. Like [TargetThisGoroutine], it locks the
+// calling goroutine to its OS thread until the TrapSampler is closed.
+//
+// The sampler is initially not running. Call [TrapSampler.Start] to start it.
+func OpenTrapSampler(ev events.Event, period uint64) (*TrapSampler, error) {
+	if !haveTrap {
+		return nil, fmt.Errorf("SIGTRAP sampling is not supported on this architecture")
+	}
+	if period == 0 {
+		return nil, fmt.Errorf("period must be non-zero")
+	}
+	trapInstall.Do(func() { trapErr = installTrapHandler() })
+	if trapErr != nil {
+		return nil, fmt.Errorf("installing SIGTRAP handler: %w", trapErr)
+	}
+
+	s := &TrapSampler{fd: -1, slot: -1, buf: new(trapBuf)}
+	trapMu.Lock()
+	for i, b := range trapBufs {
+		if b == nil {
+			s.slot = i
+			trapBufs[i] = s.buf
+			break
+		}
+	}
+	trapMu.Unlock()
+	if s.slot < 0 {
+		return nil, fmt.Errorf("too many open TrapSamplers")
+	}
+
+	attr := unix.PerfEventAttr{}
+	attr.Size = uint32(unsafe.Sizeof(attr))
+	if err := ev.SetAttrs(&attr); err != nil {
+		s.freeSlot()
+		return nil, err
+	}
+	attr.Sample = period
+	attr.Bits &^= unix.PerfBitFreq | unix.PerfBitInherit
+	// The kernel requires sigtrap events to be removed on exec, since the
+	// new program wouldn't expect the signals.
+	attr.Bits |= unix.PerfBitDisabled | perfBitSigtrap | perfBitRemoveOnExec
+	attr.Sig_data = trapSigData | uint64(s.slot)
+
+	TargetThisGoroutine.open()
+	pid, cpu := TargetThisGoroutine.pidCPU()
+	fd, err := perfEventOpen(&attr, pid, cpu, -1, 1)
+	if err != nil {
+		TargetThisGoroutine.close()
+		s.freeSlot()
+		if errors.Is(err, unix.EINVAL) {
+			err = fmt.Errorf("%w (SIGTRAP sampling requires Linux 5.13 or later)", err)
+		}
+		return nil, err
+	}
+	s.fd = fd
+	return s, nil
+}
+
+// installTrapHandler installs sigtrapHandler as the SIGTRAP handler, keeping
+// the flags, mask, and restorer of the Go runtime's handler.
+func installTrapHandler() error {
+	// This is the kernel's struct sigaction.
+	type sigaction struct {
+		handler  uintptr
+		flags    uint64
+		restorer uintptr
+		mask     uint64
+	}
+	var old sigaction
+	if _, _, errno := unix.RawSyscall6(unix.SYS_RT_SIGACTION, uintptr(unix.SIGTRAP), 0, uintptr(unsafe.Pointer(&old)), unsafe.Sizeof(old.mask), 0, 0); errno != 0 {
+		return errno
+	}
+	if old.handler == 0 || old.handler == 1 {
+		// SIG_DFL or SIG_IGN. This shouldn't happen, since the Go
+		// runtime always handles SIGTRAP.
+		return fmt.Errorf("unexpected SIGTRAP disposition %d", old.handler)
+	}
+	sigtrapPrev = old.handler
+	act := old
+	act.handler = sigtrapHandlerPC()
+	if _, _, errno := unix.RawSyscall6(unix.SYS_RT_SIGACTION, uintptr(unix.SIGTRAP), uintptr(unsafe.Pointer(&act)), 0, unsafe.Sizeof(act.mask), 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (s *TrapSampler) freeSlot() {
+	trapMu.Lock()
+	trapBufs[s.slot] = nil
+	trapMu.Unlock()
+	s.slot = -1
+}
+
+// Close closes this sampler and unlocks the goroutine from the OS thread.
+func (s *TrapSampler) Close() {
+	if s == nil || s.fd < 0 {
+		return
+	}
+	// Overflow signals are delivered synchronously to this thread, so
+	// once the event is closed, the handler won't use the buffer.
+	sys.close(s.fd)
+	s.fd = -1
+	s.freeSlot()
+	TargetThisGoroutine.close()
+}
+
+// Start the sampler.
+func (s *TrapSampler) Start() {
+	if s == nil || s.fd < 0 {
+		return
+	}
+	sys.ioctl(s.fd, unix.PERF_EVENT_IOC_ENABLE, 0)
+}
+
+// Stop the sampler.
+func (s *TrapSampler) Stop() {
+	if s == nil || s.fd < 0 {
+		return
+	}
+	sys.ioctl(s.fd, unix.PERF_EVENT_IOC_DISABLE, 0)
+}
+
+// Samples returns the instruction pointers sampled since s was opened or
+// last reset, in order. The TrapSampler records at most 4096 samples between
+// resets and counts the rest as dropped. Samples should only be called while
+// s is stopped.
+func (s *TrapSampler) Samples() (ips []uint64, dropped uint64) {
+	if s == nil || s.buf == nil {
+		return nil, 0
+	}
+	n := atomic.LoadUint64(&s.buf.n)
+	if n > trapBufSize {
+		dropped = n - trapBufSize
+		n = trapBufSize
+	}
+	return append([]uint64(nil), s.buf.ips[:n]...), dropped
+}
+
+// Reset discards all recorded samples. Reset should only be called while s is
+// stopped.
+func (s *TrapSampler) Reset() {
+	if s == nil || s.buf == nil {
+		return
+	}
+	atomic.StoreUint64(&s.buf.n, 0)
+}
@@ -0,0 +1,17 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+// haveTrap indicates that this architecture implements sigtrapHandler.
+const haveTrap = true
+
+// sigtrapHandler is the SIGTRAP signal handler. It is called by the kernel,
+// not from Go.
+func sigtrapHandler()
+
+// sigtrapHandlerPC returns the address of sigtrapHandler.
+func sigtrapHandlerPC() uintptr
@@ -0,0 +1,60 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+#include "textflag.h"
+
+// Offsets in the kernel's siginfo_t and ucontext_t.
+#define SI_CODE 8
+#define SI_PERF_DATA 24
+#define UC_RIP 168
+
+#define TRAP_PERF 6
+
+// func sigtrapHandler()
+//
+// This is called by the kernel as an SA_SIGINFO signal handler, using the C
+// calling convention: DI is the signal number, SI points to the siginfo_t,
+// and DX points to the ucontext_t. It may only clobber caller-saved
+// registers and must not use the stack, since it may run on any goroutine's
+// stack or the signal stack.
+TEXT ·sigtrapHandler(SB),NOSPLIT,$0-0
+	// Is this an overflow of a TrapSampler event?
+	CMPL	SI_CODE(SI), $TRAP_PERF
+	JNE	chain
+	MOVQ	SI_PERF_DATA(SI), AX
+	MOVQ	AX, CX
+	SHRQ	$32, CX
+	CMPQ	CX, $0x70657266
+	JNE	chain
+	MOVL	AX, AX
+	CMPQ	AX, $64
+	JAE	chain
+	LEAQ	·trapBufs(SB), CX
+	MOVQ	(CX)(AX*8), CX
+	TESTQ	CX, CX
+	JZ	chain
+
+	// Record the interrupted IP in the trapBuf, if there's room.
+	MOVQ	$1, AX
+	LOCK
+	XADDQ	AX, 0(CX)
+	CMPQ	AX, $4096
+	JAE	done
+	MOVQ	UC_RIP(DX), R8
+	MOVQ	R8, 8(CX)(AX*8)
+done:
+	RET
+
+chain:
+	// Forward the signal to the previous handler.
+	MOVQ	·sigtrapPrev(SB), AX
+	JMP	AX
+
+// func sigtrapHandlerPC() uintptr
+TEXT ·sigtrapHandlerPC(SB),NOSPLIT,$0-8
+	MOVQ	$·sigtrapHandler(SB), AX
+	MOVQ	AX, ret+0(FP)
+	RET
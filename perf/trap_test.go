@@ -0,0 +1,79 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestTrapSampler(t *testing.T) {
+	if !haveTrap {
+		t.Skip("SIGTRAP sampling not supported")
+	}
+	s, err := OpenTrapSampler(events.EventTaskClock, 100000) // 100µs
+	if err != nil {
+		t.Skip(err)
+	}
+	defer s.Close()
+
+	s.Start()
+	trapSpin(20 * time.Millisecond)
+	s.Stop()
+
+	ips, dropped := s.Samples()
+	t.Logf("%d samples, %d dropped", len(ips), dropped)
+	if len(ips) < 10 {
+		t.Fatalf("got %d samples, want at least 10", len(ips))
+	}
+	if dropped != 0 {
+		t.Errorf("dropped %d samples", dropped)
+	}
+	inSpin := 0
+	for _, ip := range ips {
+		if f := runtime.FuncForPC(uintptr(ip)); f != nil && strings.HasSuffix(f.Name(), ".trapSpin") {
+			inSpin++
+		}
+	}
+	if inSpin < len(ips)/2 {
+		t.Errorf("only %d of %d samples in trapSpin", inSpin, len(ips))
+	}
+
+	s.Reset()
+	if ips, _ := s.Samples(); len(ips) != 0 {
+		t.Errorf("got %d samples after Reset, want 0", len(ips))
+	}
+
+	// A second sampler gets its own samples.
+	s2, err := OpenTrapSampler(events.EventTaskClock, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	s2.Start()
+	trapSpin(5 * time.Millisecond)
+	s2.Stop()
+	if ips, _ := s.Samples(); len(ips) != 0 {
+		t.Errorf("stopped sampler got %d samples", len(ips))
+	}
+	if ips, _ := s2.Samples(); len(ips) == 0 {
+		t.Errorf("second sampler got no samples")
+	}
+}
+
+//go:noinline
+func trapSpin(d time.Duration) {
+	start := time.Now()
+	for time.Since(start) < d {
+		for i := 0; i < 1000; i++ {
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+//go:noinline
+func uprobeTarget(x int) int {
+	return x + 1
+}
+
+func TestUprobeCounter(t *testing.T) {
+	sym, err := NewSelfSymbolizer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := uint64(reflect.ValueOf(uprobeTarget).Pointer())
+	sym.mu.Lock()
+	m := sym.mapping(pc)
+	sym.mu.Unlock()
+	if m == nil {
+		t.Fatalf("no mapping for %#x", pc)
+	}
+
+	ev, err := events.Uprobe(m.Path, pc-m.Start+m.Offset)
+	if err != nil {
+		t.Skip(err)
+	}
+	c, err := OpenCounter(TargetThisGoroutine, ev)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer c.Close()
+
+	c.Start()
+	for i := 0; i < 10; i++ {
+		uprobeTarget(i)
+	}
+	c.Stop()
+	count, err := c.ReadOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.RawValue != 10 {
+		t.Errorf("got %d calls, want 10", count.RawValue)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"errors"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+// A UserKernelCounter counts events separately in user space and in the
+// kernel. This shows how much of a goroutine's cost is spent in the kernel on
+// its behalf, for example in system calls and page faults.
+//
+// This is equivalent to counting each event with perf's ":u" and ":k"
+// modifiers.
+type UserKernelCounter struct {
+	c       *Counter
+	nEvents int
+	buf     []Count
+}
+
+// UserKernelCount is the count of an event split into user and kernel space.
+type UserKernelCount struct {
+	User, Kernel Count
+}
+
+// Total returns the sum of the user and kernel counts.
+//
+// The user and kernel events are counted in one group, so they're enabled
+// and running for the same time, and the total has the same times as each of
+// them. (Unlike [Count.Add], which adds up counts of separate regions.)
+func (c UserKernelCount) Total() Count {
+	t := c.User
+	t.RawValue += c.Kernel.RawValue
+	t.Lost += c.Kernel.Lost
+	return t
+}
+
+// OpenUserKernelCounter returns a new [UserKernelCounter] that counts the given
+// events on the given [Target]. Like [OpenCounter], the user and kernel
+// variants of all events are opened as a single group, so they are all
+// scheduled onto the hardware at the same time. This requires twice as many
+// hardware counters as [OpenCounter].
+//
+// Counting kernel events may require privileges. See
+// /proc/sys/kernel/perf_event_paranoid.
+func OpenUserKernelCounter(target Target, evs ...events.Event) (*UserKernelCounter, error) {
+	if len(evs) == 0 {
+		return nil, nil
+	}
+	pevs := make([]events.Event, 0, 2*len(evs))
+	for _, ev := range evs {
+		pevs = append(pevs, events.WithPrivilege(ev, events.PrivUser))
+	}
+	for _, ev := range evs {
+		pevs = append(pevs, events.WithPrivilege(ev, events.PrivKernel))
+	}
+	c, err := OpenCounter(target, pevs...)
+	if err != nil {
+		return nil, err
+	}
+	return &UserKernelCounter{c, len(evs), make([]Count, len(pevs))}, nil
+}
+
+// Close closes this counter. See [Counter.Close].
+func (c *UserKernelCounter) Close() {
+	if c == nil {
+		return
+	}
+	c.c.Close()
+}
+
+// Start the counter. See [Counter.Start].
+func (c *UserKernelCounter) Start() {
+	if c == nil {
+		return
+	}
+	c.c.Start()
+}
+
+// Stop the counter. See [Counter.Stop].
+func (c *UserKernelCounter) Stop() {
+	if c == nil {
+		return
+	}
+	c.c.Stop()
+}
+
+// Reset resets all counts to zero. See [Counter.Reset].
+func (c *UserKernelCounter) Reset() error {
+	if c == nil {
+		return nil
+	}
+	return c.c.Reset()
+}
+
+// ReadGroup returns the current user and kernel counts of all events in c.
+func (c *UserKernelCounter) ReadGroup(cs []UserKernelCount) error {
+	if c == nil {
+		return nil
+	}
+	err := c.c.ReadGroup(c.buf)
+	if err != nil && !errors.Is(err, ErrMultiplexed) {
+		return err
+	}
+	for i := 0; i < len(cs) && i < c.nEvents; i++ {
+		cs[i] = UserKernelCount{c.buf[i], c.buf[c.nEvents+i]}
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestUserKernelCounter(t *testing.T) {
+	c, err := OpenUserKernelCounter(TargetThisGoroutine, events.EventCPUCycles)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer c.Close()
+
+	c.Start()
+	// Do some work in user space and some in the kernel.
+	for i := 0; i < 100000; i++ {
+	}
+	for i := 0; i < 100; i++ {
+		os.Getpid()
+		os.Stat("/")
+	}
+	c.Stop()
+
+	var cs [1]UserKernelCount
+	if err := c.ReadGroup(cs[:]); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("user %s, kernel %s", cs[0].User, cs[0].Kernel)
+	if cs[0].User.RawValue == 0 {
+		t.Errorf("no user cycles counted")
+	}
+	if cs[0].Kernel.RawValue == 0 {
+		t.Errorf("no kernel cycles counted")
+	}
+	if got, want := cs[0].Total().RawValue, cs[0].User.RawValue+cs[0].Kernel.RawValue; got != want {
+		t.Errorf("total is %d, want %d", got, want)
+	}
+}
+
+func TestUserKernelCount(t *testing.T) {
+	one := scale{1, ""}
+	c := UserKernelCount{
+		User:   Count{RawValue: 100, TimeEnabled: 1000, TimeRunning: 500, Lost: 1, scale: one},
+		Kernel: Count{RawValue: 20, TimeEnabled: 1000, TimeRunning: 500, Lost: 2, scale: one},
+	}
+	total := c.Total()
+	want := Count{RawValue: 120, TimeEnabled: 1000, TimeRunning: 500, Lost: 3, scale: one}
+	if total != want {
+		t.Errorf("got total %+v, want %+v", total, want)
+	}
+	user, _ := c.User.Value()
+	kernel, _ := c.Kernel.Value()
+	if got, _ := total.Value(); got != user+kernel {
+		t.Errorf("got total value %v, want %v", got, user+kernel)
+	}
+	// The rate of the total is the sum of the rates.
+	start := TimedCount{Count{scale: one}, time.Unix(0, 0)}
+	end := TimedCount{total, start.Time.Add(1000)}
+	if got, want := end.RateSince(start).PerRunningSecond, 120/500e-9; got != want {
+		t.Errorf("got %v per running second, want %v", got, want)
+	}
+}
+
+func TestUserKernelCounterAttrs(t *testing.T) {
+	// Regression test: the leader's exclude bits must not be overwritten
+	// when opening the group.
+	k := useFakeKernel(t)
+	c, err := OpenUserKernelCounter(TargetThisGoroutine, events.EventCPUCycles, events.EventInstructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	const excl = unix.PerfBitExcludeUser | unix.PerfBitExcludeKernel | unix.PerfBitExcludeHv
+	var user, kernel int
+	for _, ev := range k.events {
+		if ev.closed {
+			continue
+		}
+		switch ev.attr.Bits & excl {
+		case unix.PerfBitExcludeKernel | unix.PerfBitExcludeHv:
+			user++
+		case unix.PerfBitExcludeUser | unix.PerfBitExcludeHv:
+			kernel++
+		default:
+			t.Errorf("event %d has exclude bits %#x", ev.fd, ev.attr.Bits&excl)
+		}
+		if ev.leader == ev && ev.attr.Bits&unix.PerfBitDisabled == 0 {
+			t.Errorf("leader is not disabled")
+		}
+	}
+	if user != 2 || kernel != 2 {
+		t.Errorf("got %d user and %d kernel events, want 2 and 2", user, kernel)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"context"
+	"time"
+)
+
+// Wakeups returns a channel that receives a value each time the kernel wakes
+// up s because there are new records in the ring buffer. This lets a consumer
+// sleep until records arrive, and select on other channels while it waits.
+// [SamplerOptions.WakeupEvents] and [SamplerOptions.WakeupWatermark] control
+// how often this happens.
+//
+// After receiving from the channel, the caller should read all available
+// records with [Sampler.ReadRecord] or [Sampler.ReadSamples]. The channel
+// holds at most one pending wakeup, so wakeups that arrive before the caller
+// receives are merged. The channel receives a value immediately, so the
+// caller also reads any records that arrived before calling Wakeups, and may
+// occasionally receive a value when there are no new records.
+//
+// The channel is closed when ctx is done or s is closed. The caller must not
+// call [Sampler.Wait] until then.
+func (s *Sampler) Wakeups(ctx context.Context) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	ch <- struct{}{}
+	if s == nil || s.f == nil {
+		close(ch)
+		return ch
+	}
+	f := s.f
+	rc, err := f.SyscallConn()
+	if err != nil {
+		close(ch)
+		return ch
+	}
+
+	go func() {
+		defer close(ch)
+		// Interrupt the wait when ctx is done by expiring the read
+		// deadline.
+		interrupted := make(chan struct{})
+		stop := context.AfterFunc(ctx, func() {
+			f.SetReadDeadline(time.Now())
+			close(interrupted)
+		})
+		defer func() {
+			if !stop() {
+				<-interrupted
+			}
+			f.SetReadDeadline(time.Time{})
+		}()
+
+		for {
+			// The runtime poller only calls the callback again after
+			// the kernel signals that the file is readable, which it
+			// does on each wakeup. We can't check the ring buffer
+			// here, since the caller may be reading it concurrently.
+			first := true
+			err := rc.Read(func(fd uintptr) bool {
+				if first {
+					first = false
+					return false
+				}
+				return true
+			})
+			if err != nil || ctx.Err() != nil {
+				// ctx is done or f was closed.
+				return
+			}
+			select {
+			case ch <- struct{}{}:
+			default:
+				// There's already a pending wakeup.
+			}
+		}
+	}()
+	return ch
+}
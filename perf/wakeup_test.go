@@ -0,0 +1,110 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aclements/go-perfevent/events"
+)
+
+func TestSamplerWakeups(t *testing.T) {
+	for _, opts := range []SamplerOptions{
+		{Period: 100000, WakeupEvents: 1},
+		{Period: 100000, WakeupWatermark: 1},
+	} {
+		s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		ch := s.Wakeups(ctx)
+
+		// The first wakeup is immediate.
+		if _, ok := <-ch; !ok {
+			t.Fatal("Wakeups channel closed")
+		}
+
+		// Spin on this thread to generate samples. The wakeups are
+		// delivered on another thread, so they should arrive while we
+		// spin.
+		s.Start()
+		samples := 0
+		start := time.Now()
+		for samples == 0 && time.Since(start) < 5*time.Second {
+			select {
+			case <-ch:
+				for {
+					rec, ok := s.ReadRecord()
+					if !ok {
+						break
+					}
+					if rec.Type == RecordSample {
+						samples++
+					}
+				}
+			default:
+			}
+		}
+		s.Stop()
+		if samples == 0 {
+			t.Errorf("%+v: got no samples after wakeups", opts)
+		}
+
+		// Canceling ctx closes the channel.
+		cancel()
+		for range ch {
+		}
+		s.Close()
+	}
+
+	// Closing the Sampler also closes the channel.
+	s, err := OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := s.Wakeups(context.Background())
+	<-ch
+	s.Close()
+	for range ch {
+	}
+}
+
+func TestSamplerWakeupInvalid(t *testing.T) {
+	for _, opts := range []SamplerOptions{
+		{WakeupEvents: 1, WakeupWatermark: 1},
+		// The watermark must be less than the ring size.
+		{WakeupWatermark: uint32(2 * os.Getpagesize()), RingSize: RingSizeConfig{Pages: 2}},
+	} {
+		s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+		if err == nil {
+			s.Close()
+			t.Errorf("%+v: want error", opts)
+		}
+	}
+}
+
+func TestSamplerRingSize(t *testing.T) {
+	opts := SamplerOptions{
+		WakeupWatermark: uint32(os.Getpagesize()),
+		RingSize:        RingSizeConfig{Pages: 3},
+	}
+	s, err := opts.OpenSampler(TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := s.RingSize().Pages; got != 4 {
+		t.Errorf("got %d pages, want 4", got)
+	}
+	if got, want := len(s.ring.data), s.RingSize().Bytes(); got != want {
+		t.Errorf("ring buffer has %d bytes, want %d", got, want)
+	}
+}
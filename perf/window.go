@@ -0,0 +1,97 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import "time"
+
+// A SampleAggregator accumulates [Sample]s. [ProfileBuilder] and
+// [FoldedStacks] are SampleAggregators.
+type SampleAggregator interface {
+	Add(s *Sample)
+}
+
+// A Window is a completed time window of samples.
+type Window[A SampleAggregator] struct {
+	// Start and End are the time span of the window, in nanoseconds of the
+	// sample clock. The window includes samples with Start <= Time < End.
+	Start, End uint64
+
+	// Samples is the number of samples added to the window.
+	Samples int
+
+	// Aggregate is the aggregator of the window's samples.
+	Aggregate A
+}
+
+// Windows aggregates [Sample]s into consecutive time windows of a fixed
+// length, such as 10 second buckets, and calls a function with each window
+// when it's complete. This keeps a history of profiles, for comparing before
+// and after some event such as a deploy, without keeping every sample.
+//
+// Windows are aligned to multiples of their length on the sample clock, so
+// windows of different Windows with the same length line up. Samples must
+// include SampleTime. Windows with no samples are skipped.
+//
+// Windows isn't safe to use from multiple goroutines.
+type Windows[A SampleAggregator] struct {
+	length uint64
+	newAgg func() A
+	done   func(w *Window[A])
+
+	cur  Window[A]
+	open bool // cur has at least one sample
+}
+
+// NewWindows returns a Windows that aggregates samples into windows of the
+// given length. It calls newAgg to create the aggregator of each window, and
+// done with each window when it's complete. The Window passed to done isn't
+// used again by Windows, so done can keep it.
+func NewWindows[A SampleAggregator](length time.Duration, newAgg func() A, done func(w *Window[A])) *Windows[A] {
+	if length <= 0 {
+		panic("window length must be positive")
+	}
+	return &Windows[A]{length: uint64(length), newAgg: newAgg, done: done}
+}
+
+// Add adds s to the window containing s.Time, first completing the current
+// window if s is after it.
+//
+// Samples from different CPUs may be slightly out of order, so Add adds
+// samples from before the current window to the current window rather than
+// reopening a completed one.
+func (w *Windows[A]) Add(s *Sample) {
+	w.Advance(s.Time)
+	if !w.open {
+		start := s.Time - s.Time%w.length
+		w.cur = Window[A]{Start: start, End: start + w.length, Aggregate: w.newAgg()}
+		w.open = true
+	}
+	w.cur.Samples++
+	w.cur.Aggregate.Add(s)
+}
+
+// Advance completes the current window if it ends at or before now, in
+// nanoseconds of the sample clock. Call Advance periodically, such as with
+// the current time of the sample clock, so windows are completed even when no
+// more samples arrive.
+func (w *Windows[A]) Advance(now uint64) {
+	if w.open && now >= w.cur.End {
+		w.Flush()
+	}
+}
+
+// Flush completes the current window, if it has any samples, even though its
+// time span hasn't ended yet. The next sample starts a new window.
+func (w *Windows[A]) Flush() {
+	if !w.open {
+		return
+	}
+	win := w.cur
+	w.cur = Window[A]{}
+	w.open = false
+	w.done(&win)
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perf
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWindows(t *testing.T) {
+	type window struct {
+		start, end uint64
+		samples    int
+		folded     string
+	}
+	var got []window
+	w := NewWindows(10*time.Nanosecond, func() *FoldedStacks { return NewFoldedStacks(nil) },
+		func(win *Window[*FoldedStacks]) {
+			var buf strings.Builder
+			if err := win.Aggregate.Write(&buf); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, window{win.Start, win.End, win.Samples, buf.String()})
+		})
+
+	w.Add(&Sample{Time: 12, IP: 0x1000})
+	w.Add(&Sample{Time: 19, IP: 0x1000})
+	// Slightly out of order samples go in the current window.
+	w.Add(&Sample{Time: 9, IP: 0x2000})
+	if len(got) != 0 {
+		t.Fatalf("window completed early: %+v", got)
+	}
+	// This completes [10, 20) and skips the empty [20, 30).
+	w.Add(&Sample{Time: 35, IP: 0x3000})
+	w.Advance(39)
+	if len(got) != 1 {
+		t.Fatalf("want 1 completed window, got %+v", got)
+	}
+	w.Advance(40)
+	w.Add(&Sample{Time: 41, IP: 0x4000})
+	w.Flush()
+	w.Flush() // No-op with no samples
+
+	want := []window{
+		{10, 20, 3, "0x1000 2\n0x2000 1\n"},
+		{30, 40, 1, "0x3000 1\n"},
+		{40, 50, 1, "0x4000 1\n"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got windows %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("window %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -28,6 +28,35 @@ type Counters struct {
 // The final value of the counters is captured in a b.Cleanup function. If the
 // benchmark does substantial other work in cleanup functions, it may want to
 // explicitly call [Counters.Stop] before returning.
+//
+// Counters are normalized by the value of b.N at the time they are reported,
+// not the value when Open is called. This makes Counters work with both
+// b.N-style loops and [testing.B.Loop], which only sets b.N once the loop is
+// done. However, b.Loop resets the benchmark timer when the loop starts and
+// stops it when the loop ends, and it cannot do the same for Counters. Hence,
+// benchmarks using b.Loop should call Open immediately before the loop (or call
+// [Counters.Reset] there) and call [Counters.Stop] immediately after the loop.
+//
+// If the CPU running the benchmark shares a core with other hardware threads
+// (SMT or hyperthreading), Counters also reports the fraction of time the
+// busiest of these siblings was busy as "smt-sibling-busy". Work on sibling
+// threads competes with the benchmark for the core and is a common source of
+// noise in the counters, so a high value suggests the results may be
+// unreliable.
+//
+// Open also logs a warning, once per process, if the CPU running the benchmark
+// uses a frequency scaling governor other than "performance" or has turbo
+// boost enabled, since these make measurements less reproducible.
+//
+// If the PERFBENCH_TOTALS environment variable is set to 1, Counters also logs
+// the total count of each counter, along with the final b.N, as a single line
+// in a stable format that [ParseTotals] parses. With "go test -json", this line
+// appears in an output event of the benchmark, so tools consuming the JSON
+// stream can extract the counts without parsing benchmark result lines.
+//
+// The testing package may run the benchmark function several times with
+// increasing b.N to determine the iteration count. Each run should call Open
+// separately, and only the counters from the final run are reported.
 func Open(b *testing.B) *Counters {
 	return openOS(b)
 }
@@ -44,6 +73,25 @@ func (cs *Counters) Reset() {
 	cs.resetOS()
 }
 
+// SetBytes records the number of bytes processed in a single operation, like
+// [testing.B.SetBytes]. If n > 0, each counter will additionally be reported
+// normalized by the total number of bytes processed, as "<name>/B" (for
+// example, "cpu-cycles/B"). This makes counters comparable across
+// sub-benchmarks that process different input sizes.
+//
+// This does not call b.SetBytes. Benchmarks that want the standard MB/s
+// metric should call both.
+func (cs *Counters) SetBytes(n int64) {
+	cs.setBytesOS(n)
+}
+
+// N returns the number of iterations that Counters will divide by to report
+// per-operation metrics. This is the current value of b.N, which may not be
+// final until the benchmark loop is done.
+func (cs *Counters) N() int {
+	return cs.nOS()
+}
+
 // Total returns the total count of the named counter, which is a reported
 // metric name without the "/op". If the named counter is unknown or could not
 // be opened, this returns 0, false.
@@ -38,17 +38,22 @@ func getEvent(name string) events.Event {
 }
 
 type countersOS struct {
-	b  testingB
-	bN int
+	b     testingB
+	bN    func() int // Returns the current b.N
+	bytes int64      // Bytes per op, or 0 if not set.
 
-	c []counter
+	// printBytesUnits, if non-nil, prints the unit metadata of the per-byte
+	// metrics. It's nil when Counters isn't reporting to a real benchmark.
+	printBytesUnits func()
+
+	c   []counter
+	smt *smtMonitor // nil if the CPU has no SMT siblings
 }
 
 type counter struct {
-	event    events.Event
-	counter  *perf.Counter
-	name     string
-	baseline perf.Count
+	event   events.Event
+	counter *perf.Counter
+	name    string
 }
 
 var printUnits = sync.OnceFunc(func() {
@@ -57,11 +62,23 @@ var printUnits = sync.OnceFunc(func() {
 		// Currently all events are better=lower.
 		fmt.Printf("Unit %s/op better=lower\n", event.String())
 	}
+	fmt.Printf("Unit %s better=lower\n", smtMetric)
+	fmt.Printf("\n")
+})
+
+// printBytesUnits prints the unit metadata of the per-byte metrics. These are
+// only reported by benchmarks that call SetBytes, so we don't print them until
+// one does.
+var printBytesUnits = sync.OnceFunc(func() {
+	for _, event := range defaultEvents {
+		fmt.Printf("Unit %s/B better=lower\n", event.String())
+	}
 	fmt.Printf("\n")
 })
 
 // testingB is the *testing.B interface needed by Counters. Used for testing.
 type testingB interface {
+	Name() string
 	ReportMetric(n float64, unit string)
 	Logf(format string, args ...any)
 	Cleanup(func())
@@ -71,10 +88,15 @@ var openErrors sync.Map
 
 func openOS(b *testing.B) *Counters {
 	printUnits()
-	return open(b, b.N)
+	// b.N isn't necessarily final when the counters are opened. In particular,
+	// b.Loop sets b.N only once the loop is done. Hence, we read it when we
+	// report the counters.
+	cs := open(b, func() int { return b.N })
+	cs.printBytesUnits = printBytesUnits
+	return cs
 }
 
-func open(b testingB, bN int) *Counters {
+func open(b testingB, bN func() int) *Counters {
 	cs := &Counters{countersOS{
 		b:  b,
 		bN: bN,
@@ -99,11 +121,25 @@ func open(b testingB, bN int) *Counters {
 			}
 		}
 
-		cs.c[i] = counter{event, c, name, perf.Count{}}
+		cs.c[i] = counter{event, c, name}
 	}
 
 	b.Cleanup(cs.close)
 
+	// Monitor for interference from SMT siblings. Counters locks the
+	// goroutine to its OS thread, so we can tell which CPU it's on.
+	cs.smt = startSMTMonitor()
+
+	// Warn if the CPU's frequency may vary during the benchmark. Like
+	// open errors, only report each warning once.
+	if cpu, err := getcpu(); err == nil {
+		for _, msg := range checkCPUFreq(sysFS, cpu) {
+			if _, prev := openErrors.Swap(msg, true); !prev {
+				b.Logf("%s", msg)
+			}
+		}
+	}
+
 	// Start all of the counters.
 	cs.Start()
 
@@ -123,20 +159,21 @@ func (cs *Counters) stopOS() {
 }
 
 func (cs *Counters) resetOS() {
-	// perf has a concept of resetting a counter, but it doesn't reset the
-	// counter's timers, so instead we track our own baseline.
-	for i := range cs.c {
-		c := &cs.c[i]
-		c.baseline, _ = c.counter.ReadOne()
+	for _, c := range cs.c {
+		c.counter.Reset()
+	}
+	cs.smt.reset()
+}
+
+func (cs *Counters) setBytesOS(n int64) {
+	cs.bytes = n
+	if n > 0 && cs.printBytesUnits != nil {
+		cs.printBytesUnits()
 	}
 }
 
 func (c *counter) read() (float64, error) {
 	val, err := c.counter.ReadOne()
-	base := c.baseline
-	val.RawValue -= base.RawValue
-	val.TimeEnabled -= base.TimeEnabled
-	val.TimeRunning -= base.TimeRunning
 	if err != nil {
 		return 0, fmt.Errorf("error reading %s: %w", c.event, err)
 	} else if val.TimeRunning == 0 {
@@ -165,14 +202,46 @@ func (cs *Counters) close() {
 	}
 
 	cs.Stop()
+	bN := cs.bN()
+	if bN <= 0 {
+		// This can happen if the benchmark is using b.Loop, but the loop
+		// didn't finish. We have nothing to normalize by.
+		cs.b.Logf("perfbench: b.N is %d; not reporting counters", bN)
+	}
+	var totals *Totals
+	if bN > 0 && totalsEnabled() {
+		totals = &Totals{Benchmark: cs.b.Name(), N: bN, Values: make(map[string]float64)}
+	}
 	for i := range cs.c {
 		c := &cs.c[i]
-		if val, err := c.read(); err != nil {
+		if bN <= 0 {
+			// Nothing to report.
+		} else if val, err := c.read(); err != nil {
 			cs.b.Logf("%s", err)
 		} else if !math.IsInf(val, 0) {
-			cs.b.ReportMetric(val/float64(cs.bN), c.name+"/op")
+			cs.b.ReportMetric(val/float64(bN), c.name+"/op")
+			if cs.bytes > 0 {
+				cs.b.ReportMetric(val/(float64(bN)*float64(cs.bytes)), c.name+"/B")
+			}
+			if totals != nil {
+				totals.Values[c.name] = val
+			}
 		}
 		c.counter.Close()
 	}
+	if bN > 0 {
+		// Report how busy the benchmark CPU's SMT siblings were, since they
+		// compete for the core's execution resources.
+		if frac, ok := cs.smt.busy(); ok {
+			cs.b.ReportMetric(frac, smtMetric)
+		}
+	}
+	if totals != nil {
+		cs.b.Logf("%s", totals)
+	}
 	cs.b = nil
 }
+
+func (cs *Counters) nOS() int {
+	return cs.bN()
+}
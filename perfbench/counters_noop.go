@@ -20,4 +20,8 @@ func (cs *Counters) stopOS() {}
 
 func (cs *Counters) resetOS() {}
 
+func (cs *Counters) setBytesOS(_ int64) {}
+
+func (cs *Counters) nOS() int { return 0 }
+
 func (cs *Counters) totalOS(_ string) (float64, bool) { return 0, false }
@@ -8,6 +8,7 @@ package perfbench
 
 import (
 	"fmt"
+	"math"
 	"slices"
 	"strings"
 	"testing"
@@ -16,9 +17,14 @@ import (
 type testB struct {
 	t       *testing.T
 	metrics map[string]float64
+	totals  []string
 	cleanup func()
 }
 
+func (tb *testB) Name() string {
+	return tb.t.Name()
+}
+
 func (tb *testB) ReportMetric(n float64, unit string) {
 	if tb.metrics == nil {
 		tb.metrics = map[string]float64{}
@@ -28,16 +34,30 @@ func (tb *testB) ReportMetric(n float64, unit string) {
 
 func (tb *testB) Logf(format string, args ...any) {
 	tb.t.Helper()
-	tb.t.Fatalf("unexpected b.Logf: %s", fmt.Sprintf(format, args...))
+	msg := fmt.Sprintf(format, args...)
+	if strings.HasPrefix(msg, freqWarningPrefix) {
+		// This depends on how the machine is configured.
+		tb.t.Log(msg)
+		return
+	}
+	if strings.HasPrefix(msg, totalsPrefix) {
+		tb.totals = append(tb.totals, msg)
+		return
+	}
+	tb.t.Fatalf("unexpected b.Logf: %s", msg)
 }
 
 func (tb *testB) Cleanup(fn func()) {
 	tb.cleanup = fn
 }
 
+func constN(n int) func() int {
+	return func() int { return n }
+}
+
 func TestBasic(t *testing.T) {
 	tb := &testB{t: t}
-	open(tb, 1)
+	open(tb, constN(1))
 	tb.cleanup()
 
 	// Check that metrics were reported.
@@ -54,6 +74,8 @@ func TestBasic(t *testing.T) {
 			t.Errorf("metric %s reported, but value is 0", name)
 		}
 	}
+	// The SMT metric is only reported on some machines.
+	delete(tb.metrics, smtMetric)
 	if len(tb.metrics) != len(defaultEvents) {
 		t.Errorf("got %d metrics, expected %d", len(tb.metrics), len(defaultEvents))
 	}
@@ -61,7 +83,7 @@ func TestBasic(t *testing.T) {
 
 func TestTotal(t *testing.T) {
 	tb := &testB{t: t}
-	cs := open(tb, 2)
+	cs := open(tb, constN(2))
 	cs.Stop()
 	if _, ok := cs.Total("does-not-exist"); ok {
 		t.Errorf("got ok for does-not-exist")
@@ -76,6 +98,49 @@ func TestTotal(t *testing.T) {
 	}
 }
 
+func TestLateN(t *testing.T) {
+	// Test that counters are normalized by b.N when they're reported, not when
+	// they're opened. This happens with b.Loop.
+	tb := &testB{t: t}
+	bN := 1
+	cs := open(tb, func() int { return bN })
+	bN = 4
+	if got := cs.N(); got != 4 {
+		t.Errorf("N() = %d, want 4", got)
+	}
+	cs.Stop()
+	total, ok := cs.Total("cpu-cycles")
+	tb.cleanup()
+
+	got, gOK := tb.metrics["cpu-cycles/op"]
+	if !ok || !gOK {
+		t.Fatalf("cpu-cycles not reported")
+	}
+	if want := total / 4; got != want {
+		t.Errorf("cpu-cycles/op = %v, want %v", got, want)
+	}
+}
+
+func TestSetBytes(t *testing.T) {
+	tb := &testB{t: t}
+	cs := open(tb, constN(2))
+	cs.SetBytes(10)
+	tb.cleanup()
+
+	for _, ev := range defaultEvents {
+		perOp, ok := tb.metrics[ev.String()+"/op"]
+		if !ok {
+			continue
+		}
+		perByte, ok := tb.metrics[ev.String()+"/B"]
+		if !ok {
+			t.Errorf("metric %s/B not reported", ev)
+		} else if want := perOp / 10; math.Abs(perByte-want) > 1e-9*math.Abs(want) {
+			t.Errorf("%s/B = %v, want %v", ev, perByte, want)
+		}
+	}
+}
+
 var loopIters = 1000
 
 // measureLoop returns the instructions/op of a range loop to 1000. This is used
@@ -83,7 +148,7 @@ var loopIters = 1000
 func measureLoop(t *testing.T) float64 {
 	p95 := p95Of(100, func() float64 {
 		tb := &testB{t: t}
-		open(tb, 1)
+		open(tb, constN(1))
 		for i := 0; i < loopIters; i++ {
 		}
 		tb.cleanup()
@@ -115,7 +180,7 @@ func TestStop(t *testing.T) {
 	// tests and ignore the outliers.
 	p95 := p95Of(100, func() float64 {
 		tb := &testB{t: t}
-		cs := open(tb, 1)
+		cs := open(tb, constN(1))
 		for i := 0; i < loopIters; i++ {
 		}
 		cs.Stop()
@@ -131,7 +196,7 @@ func TestStop(t *testing.T) {
 
 func TestResetStopped(t *testing.T) {
 	tb := &testB{t: t}
-	cs := open(tb, 1)
+	cs := open(tb, constN(1))
 	cs.Stop()
 	cs.Reset()
 	for i := 0; i < loopIters; i++ {
@@ -148,7 +213,7 @@ func TestResetRunning(t *testing.T) {
 
 	p95 := p95Of(100, func() float64 {
 		tb := &testB{t: t}
-		cs := open(tb, 1)
+		cs := open(tb, constN(1))
 		for i := 0; i < 100*loopIters; i++ {
 		}
 		cs.Reset()
@@ -163,3 +228,26 @@ func TestResetRunning(t *testing.T) {
 		t.Errorf("reset didn't reset counter, got %f > %f instructions", p95, limit)
 	}
 }
+
+func TestTotals(t *testing.T) {
+	t.Setenv(totalsEnv, "1")
+	tb := &testB{t: t}
+	cs := open(tb, constN(2))
+	cs.Stop()
+	cycles, ok := cs.Total("cpu-cycles")
+	tb.cleanup()
+
+	if len(tb.totals) != 1 {
+		t.Fatalf("got %d totals lines, want 1", len(tb.totals))
+	}
+	totals, ok2 := ParseTotals(tb.totals[0])
+	if !ok2 {
+		t.Fatalf("failed to parse %q", tb.totals[0])
+	}
+	if totals.Benchmark != t.Name() || totals.N != 2 {
+		t.Errorf("got benchmark %q N=%d, want %q N=2", totals.Benchmark, totals.N, t.Name())
+	}
+	if got, gotOK := totals.Values["cpu-cycles"]; ok != gotOK || got != cycles {
+		t.Errorf("got cpu-cycles %v, %v; want %v, %v", got, gotOK, cycles, ok)
+	}
+}
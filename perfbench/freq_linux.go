@@ -0,0 +1,48 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package perfbench
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// freqWarningPrefix starts each warning about the CPU frequency
+// configuration.
+const freqWarningPrefix = "perfbench: warning: "
+
+// sysFS is the root of sysfs.
+var sysFS fs.FS = os.DirFS("/sys")
+
+// checkCPUFreq returns warnings about the frequency scaling configuration of
+// cpu that makes measurements less stable. Counts like instructions and cache
+// misses per op don't depend on the clock frequency, but cycles and time per
+// op do: time per op scales with the frequency, and for memory-bound code,
+// cycles per op change too, because memory latency is fixed in time rather
+// than in cycles, so a faster clock waits more cycles for each miss.
+func checkCPUFreq(fsys fs.FS, cpu int) []string {
+	read := func(path string) (string, bool) {
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return "", false
+		}
+		return strings.TrimSpace(string(data)), true
+	}
+
+	var warnings []string
+	if gov, ok := read(fmt.Sprintf("devices/system/cpu/cpu%d/cpufreq/scaling_governor", cpu)); ok && gov != "performance" {
+		warnings = append(warnings, fmt.Sprintf("%sCPU %d uses the %q frequency governor, which makes measurements less stable; consider the \"performance\" governor (sudo cpupower frequency-set -g performance)", freqWarningPrefix, cpu, gov))
+	}
+	// intel_pstate reports turbo as no_turbo. Other drivers, including
+	// acpi-cpufreq and amd-pstate, report it as boost.
+	if noTurbo, ok := read("devices/system/cpu/intel_pstate/no_turbo"); ok && noTurbo == "0" {
+		warnings = append(warnings, freqWarningPrefix+"turbo boost is enabled, which makes measurements less stable; consider disabling it (echo 1 | sudo tee /sys/devices/system/cpu/intel_pstate/no_turbo)")
+	} else if boost, ok := read("devices/system/cpu/cpufreq/boost"); ok && boost == "1" {
+		warnings = append(warnings, freqWarningPrefix+"CPU boost is enabled, which makes measurements less stable; consider disabling it (echo 0 | sudo tee /sys/devices/system/cpu/cpufreq/boost)")
+	}
+	return warnings
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perfbench
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCheckCPUFreq(t *testing.T) {
+	file := func(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s + "\n")} }
+	for _, tc := range []struct {
+		name string
+		fs   fstest.MapFS
+		want []string // Substrings of each warning
+	}{
+		{"no cpufreq", fstest.MapFS{}, nil},
+		{
+			"stable",
+			fstest.MapFS{
+				"devices/system/cpu/cpu1/cpufreq/scaling_governor": file("performance"),
+				"devices/system/cpu/intel_pstate/no_turbo":         file("1"),
+			},
+			nil,
+		},
+		{
+			"powersave and turbo",
+			fstest.MapFS{
+				"devices/system/cpu/cpu1/cpufreq/scaling_governor": file("powersave"),
+				"devices/system/cpu/intel_pstate/no_turbo":         file("0"),
+			},
+			[]string{`CPU 1 uses the "powersave" frequency governor`, "turbo boost is enabled"},
+		},
+		{
+			"other CPU",
+			fstest.MapFS{
+				"devices/system/cpu/cpu0/cpufreq/scaling_governor": file("powersave"),
+			},
+			nil,
+		},
+		{
+			"boost",
+			fstest.MapFS{
+				"devices/system/cpu/cpu1/cpufreq/scaling_governor": file("performance"),
+				"devices/system/cpu/cpufreq/boost":                 file("1"),
+			},
+			[]string{"CPU boost is enabled"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := checkCPUFreq(tc.fs, 1)
+			if len(got) != len(tc.want) {
+				t.Fatalf("got warnings %q, want %d warnings", got, len(tc.want))
+			}
+			for i := range got {
+				if !strings.HasPrefix(got[i], freqWarningPrefix) || !strings.Contains(got[i], tc.want[i]) {
+					t.Errorf("warning %d is %q, want one containing %q", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package perfbench
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// smtMetric is the metric reporting how busy the SMT siblings of the
+// benchmark's CPU were.
+const smtMetric = "smt-sibling-busy"
+
+// smtMonitor tracks how busy the SMT siblings (hyperthreads) of the CPU
+// running a benchmark are. Siblings share execution resources with the
+// benchmark's CPU, so work running on them is a common source of unexplained
+// variance in counters like cycles or cache misses.
+type smtMonitor struct {
+	cpu      int   // CPU the benchmark was running on
+	siblings []int // SMT siblings of cpu, not including cpu
+	start    []cpuTime
+}
+
+// cpuTime is the busy and total time of a CPU, in clock ticks.
+type cpuTime struct {
+	busy, total uint64
+}
+
+// startSMTMonitor starts monitoring the SMT siblings of the calling thread's
+// CPU. The calling goroutine should be locked to its OS thread. It returns nil
+// if the CPU has no siblings or the topology can't be determined.
+func startSMTMonitor() *smtMonitor {
+	cpu, err := getcpu()
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(fmt.Sprintf("/sys/devices/system/cpu/cpu%d/topology/thread_siblings_list", cpu))
+	if err != nil {
+		return nil
+	}
+	all, err := parseCPUList(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil
+	}
+	m := &smtMonitor{cpu: cpu}
+	for _, sib := range all {
+		if sib != cpu {
+			m.siblings = append(m.siblings, sib)
+		}
+	}
+	if len(m.siblings) == 0 {
+		return nil
+	}
+	m.reset()
+	return m
+}
+
+// reset restarts measuring sibling utilization from now.
+func (m *smtMonitor) reset() {
+	if m == nil {
+		return
+	}
+	m.start = m.read()
+}
+
+// read returns the current times of m's siblings, or nil on failure.
+func (m *smtMonitor) read() []cpuTime {
+	times, err := readCPUTimes()
+	if err != nil {
+		return nil
+	}
+	out := make([]cpuTime, len(m.siblings))
+	for i, sib := range m.siblings {
+		t, ok := times[sib]
+		if !ok {
+			return nil
+		}
+		out[i] = t
+	}
+	return out
+}
+
+// busy returns the fraction of time since the last reset that the busiest SMT
+// sibling was busy. If the calling thread migrated to a different core or the
+// utilization can't be determined, it returns 0, false.
+func (m *smtMonitor) busy() (float64, bool) {
+	if m == nil || m.start == nil {
+		return 0, false
+	}
+	if cpu, err := getcpu(); err != nil || (cpu != m.cpu && !slices.Contains(m.siblings, cpu)) {
+		return 0, false
+	}
+	end := m.read()
+	if end == nil {
+		return 0, false
+	}
+	var frac float64
+	measured := false
+	for i := range end {
+		total := end[i].total - m.start[i].total
+		if total == 0 {
+			continue
+		}
+		measured = true
+		frac = max(frac, float64(end[i].busy-m.start[i].busy)/float64(total))
+	}
+	return frac, measured
+}
+
+// getcpu returns the CPU the calling thread is running on.
+func getcpu() (int, error) {
+	var cpu uint32
+	_, _, errno := unix.RawSyscall(unix.SYS_GETCPU, uintptr(unsafe.Pointer(&cpu)), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(cpu), nil
+}
+
+// parseCPUList parses a kernel CPU list, such as "0-3,8,10-11".
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("bad CPU list %q", s)
+		}
+		last := first
+		if isRange {
+			last, err = strconv.Atoi(hi)
+			if err != nil || last < first {
+				return nil, fmt.Errorf("bad CPU list %q", s)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// readCPUTimes returns the busy and total time of each CPU from /proc/stat.
+func readCPUTimes() (map[int]cpuTime, error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return nil, err
+	}
+	return parseCPUTimes(data)
+}
+
+func parseCPUTimes(data []byte) (map[int]cpuTime, error) {
+	times := make(map[int]cpuTime)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		// Format: cpuN user nice system idle iowait irq softirq steal ...
+		f := strings.Fields(sc.Text())
+		if len(f) < 5 || !strings.HasPrefix(f[0], "cpu") || f[0] == "cpu" {
+			continue
+		}
+		cpu, err := strconv.Atoi(f[0][len("cpu"):])
+		if err != nil {
+			return nil, fmt.Errorf("malformed /proc/stat line %q", sc.Text())
+		}
+		var t cpuTime
+		for i, field := range f[1:] {
+			if i >= 8 {
+				// Guest time is already included in user time.
+				break
+			}
+			v, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("malformed /proc/stat line %q", sc.Text())
+			}
+			t.total += v
+			if i != 3 && i != 4 {
+				// Not idle or iowait.
+				t.busy += v
+			}
+		}
+		times[cpu] = t
+	}
+	return times, sc.Err()
+}
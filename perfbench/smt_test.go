@@ -0,0 +1,59 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perfbench
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseCPUList(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []int
+	}{
+		{"0", []int{0}},
+		{"0,4", []int{0, 4}},
+		{"0-3,8,10-11", []int{0, 1, 2, 3, 8, 10, 11}},
+	} {
+		got, err := parseCPUList(tc.in)
+		if err != nil {
+			t.Errorf("%q: %s", tc.in, err)
+		} else if !slices.Equal(got, tc.want) {
+			t.Errorf("%q: got %v, want %v", tc.in, got, tc.want)
+		}
+	}
+	for _, bad := range []string{"", "a", "3-1", "1-"} {
+		if _, err := parseCPUList(bad); err == nil {
+			t.Errorf("%q: want error", bad)
+		}
+	}
+}
+
+func TestParseCPUTimes(t *testing.T) {
+	const stat = `cpu  200 0 100 700 0 0 0 0 0 0
+cpu0 100 0 50 300 50 0 0 0 0 0
+cpu1 100 0 50 350 0 0 0 0 7 0
+intr 1 2 3
+`
+	times, err := parseCPUTimes([]byte(stat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]cpuTime{
+		0: {150, 500},
+		1: {150, 500},
+	}
+	if len(times) != len(want) {
+		t.Fatalf("got %v, want %v", times, want)
+	}
+	for cpu, w := range want {
+		if times[cpu] != w {
+			t.Errorf("cpu%d: got %+v, want %+v", cpu, times[cpu], w)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package perfbench
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// totalsEnv is the environment variable that enables logging counter totals.
+const totalsEnv = "PERFBENCH_TOTALS"
+
+// totalsPrefix starts each totals line.
+const totalsPrefix = "perfbench-totals "
+
+// Totals is the total counts of a benchmark's counters, as logged when the
+// PERFBENCH_TOTALS environment variable is set to 1. See [Open].
+type Totals struct {
+	// Benchmark is the full name of the benchmark, such as
+	// "BenchmarkFoo/size=10".
+	Benchmark string
+
+	// N is the number of iterations of the final run of the benchmark.
+	N int
+
+	// Values maps from counter names, such as "cpu-cycles", to their total
+	// counts over all N iterations.
+	Values map[string]float64
+}
+
+// totalsEnabled reports whether Counters should log their totals.
+func totalsEnabled() bool {
+	return os.Getenv(totalsEnv) == "1"
+}
+
+// String formats t as a totals line, such as
+//
+//	perfbench-totals BenchmarkFoo N=1000 cpu-cycles=123456 instructions=234567
+//
+// with the values sorted by name. [ParseTotals] parses this format.
+func (t Totals) String() string {
+	names := make([]string, 0, len(t.Values))
+	for name := range t.Values {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s%s N=%d", totalsPrefix, t.Benchmark, t.N)
+	for _, name := range names {
+		fmt.Fprintf(&sb, " %s=%s", name, strconv.FormatFloat(t.Values[name], 'g', -1, 64))
+	}
+	return sb.String()
+}
+
+// ParseTotals parses a line of test output produced when PERFBENCH_TOTALS is
+// set. The line may include other text before the totals, such as the file and
+// line prefix added by [testing.B.Logf]. This lets tools that consume
+// "go test -json" output extract counter totals from the Output field of
+// output events without parsing benchmark result lines. It returns false if
+// line doesn't contain totals.
+func ParseTotals(line string) (Totals, bool) {
+	i := strings.Index(line, totalsPrefix)
+	if i < 0 {
+		return Totals{}, false
+	}
+	fields := strings.Fields(line[i+len(totalsPrefix):])
+	if len(fields) < 2 || !strings.HasPrefix(fields[1], "N=") {
+		return Totals{}, false
+	}
+	n, err := strconv.Atoi(fields[1][len("N="):])
+	if err != nil {
+		return Totals{}, false
+	}
+	t := Totals{Benchmark: fields[0], N: n, Values: make(map[string]float64)}
+	for _, f := range fields[2:] {
+		name, val, ok := strings.Cut(f, "=")
+		if !ok {
+			return Totals{}, false
+		}
+		v, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return Totals{}, false
+		}
+		t.Values[name] = v
+	}
+	return t, true
+}
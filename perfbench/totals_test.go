@@ -0,0 +1,40 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package perfbench
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTotals(t *testing.T) {
+	want := Totals{
+		Benchmark: "BenchmarkFoo/size=10-8",
+		N:         1000,
+		Values:    map[string]float64{"cpu-cycles": 123456, "instructions": 2.5e9, "power/energy-pkg/-Joules": 0.125},
+	}
+	line := want.String()
+	if line != "perfbench-totals BenchmarkFoo/size=10-8 N=1000 cpu-cycles=123456 instructions=2.5e+09 power/energy-pkg/-Joules=0.125" {
+		t.Errorf("got line %q", line)
+	}
+	for _, line := range []string{line, "    foo_test.go:12: " + line} {
+		got, ok := ParseTotals(line)
+		if !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseTotals(%q) = %+v, %v; want %+v, true", line, got, ok, want)
+		}
+	}
+
+	for _, line := range []string{
+		"BenchmarkFoo 1000 12 ns/op",
+		"perfbench-totals BenchmarkFoo",
+		"perfbench-totals BenchmarkFoo N=x",
+		"perfbench-totals BenchmarkFoo N=1 cpu-cycles",
+		"perfbench-totals BenchmarkFoo N=1 cpu-cycles=x",
+	} {
+		if got, ok := ParseTotals(line); ok {
+			t.Errorf("ParseTotals(%q) = %+v, want false", line, got)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+// Package perfexpvar publishes performance counters as expvar variables, so
+// they appear in a service's /debug/vars.
+package perfexpvar
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/aclements/go-perfevent/perf"
+)
+
+// A Var is an [expvar.Var] that reports the counts and rates of the events of
+// a [perf.Counter] over the most recent refresh interval.
+//
+// Its value is a JSON object with the interval's end time and length in
+// seconds, and for each event, its count, its rate per second, and its unit.
+// The count and rate are scaled to account for multiplexing and the event's
+// scale factor (see [perf.Count.Value]). If reading the Counter fails, the
+// Var stops refreshing and reports the error.
+type Var struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu   sync.Mutex
+	snap snapshot
+}
+
+type snapshot struct {
+	Time     time.Time             `json:"time"`
+	Interval float64               `json:"interval"`
+	Events   map[string]eventValue `json:"events"`
+	Err      string                `json:"error,omitempty"`
+}
+
+type eventValue struct {
+	Count       float64 `json:"count"`
+	Rate        float64 `json:"rate"`
+	Unit        string  `json:"unit,omitempty"`
+	Multiplexed bool    `json:"multiplexed,omitempty"`
+}
+
+// NewVar returns a Var that reads c every interval. It reads c from a new
+// goroutine, so the caller must not otherwise use c until calling
+// [Var.Stop]. NewVar doesn't start or stop c.
+func NewVar(c *perf.Counter, interval time.Duration) *Var {
+	ctx, cancel := context.WithCancel(context.Background())
+	v := &Var{cancel: cancel, done: make(chan struct{})}
+	evs := c.Events()
+	ch := c.Stream(ctx, interval)
+	go func() {
+		defer close(v.done)
+		for iv := range ch {
+			snap := snapshot{Events: make(map[string]eventValue, len(evs))}
+			if iv.Err != nil {
+				snap.Err = iv.Err.Error()
+			} else {
+				snap.Time = iv.End
+				secs := iv.End.Sub(iv.Start).Seconds()
+				snap.Interval = secs
+				for i, ev := range evs {
+					val, unit := iv.Counts[i].Value()
+					e := eventValue{Count: val, Unit: unit, Multiplexed: iv.Counts[i].Multiplexed()}
+					if secs > 0 {
+						e.Rate = val / secs
+					}
+					snap.Events[ev.String()] = e
+				}
+			}
+			v.mu.Lock()
+			v.snap = snap
+			v.mu.Unlock()
+		}
+	}()
+	return v
+}
+
+// Publish creates a Var that reads c every interval, and publishes it under
+// name with [expvar.Publish]. Like expvar.Publish, it panics if name is
+// already registered.
+func Publish(name string, c *perf.Counter, interval time.Duration) *Var {
+	v := NewVar(c, interval)
+	expvar.Publish(name, v)
+	return v
+}
+
+// Stop stops refreshing v and waits until it's no longer reading its
+// Counter. v keeps reporting the last values it read, since expvar variables
+// can't be unpublished.
+func (v *Var) Stop() {
+	v.cancel()
+	<-v.done
+}
+
+// String returns the JSON value of v. It implements [expvar.Var].
+func (v *Var) String() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	snap := v.snap
+	if snap.Events == nil {
+		snap.Events = map[string]eventValue{}
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return "null"
+	}
+	return string(data)
+}
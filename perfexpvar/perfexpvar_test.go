@@ -0,0 +1,66 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perfexpvar
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/aclements/go-perfevent/events"
+	"github.com/aclements/go-perfevent/perf"
+)
+
+func TestPublish(t *testing.T) {
+	c, err := perf.OpenCounter(perf.TargetThisGoroutine, events.EventTaskClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	v := Publish("perf-test", c, 10*time.Millisecond)
+	if expvar.Get("perf-test") != v {
+		t.Fatalf("Var not published")
+	}
+	var snap snapshot
+	if err := json.Unmarshal([]byte(v.String()), &snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap.Interval != 0 || len(snap.Events) != 0 {
+		t.Errorf("before first refresh: got %s", v)
+	}
+
+	c.Start()
+	for start := time.Now(); snap.Interval == 0; {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Var not refreshed after 5s")
+		}
+		time.Sleep(time.Millisecond)
+		if err := json.Unmarshal([]byte(v.String()), &snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v.Stop()
+	c.Stop()
+
+	if snap.Err != "" {
+		t.Fatalf("got error %s", snap.Err)
+	}
+	if snap.Time.IsZero() {
+		t.Errorf("got zero time")
+	}
+	ev, ok := snap.Events["task-clock"]
+	if !ok {
+		t.Fatalf("got events %v, want task-clock", snap.Events)
+	}
+	// task-clock counts nanoseconds this goroutine's thread ran, which is
+	// at most 1 per nanosecond.
+	if ev.Count <= 0 || ev.Rate <= 0 || ev.Rate > 1.1e9 {
+		t.Errorf("got task-clock count %v, rate %v/s", ev.Count, ev.Rate)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+// Package perfhttp serves the current values of performance counters over
+// HTTP, for quick inspection of a running program.
+//
+// Unlike net/http/pprof, importing this package doesn't register any
+// handlers. To serve a set of counters, create a [Handler], add counters to
+// it, and register it:
+//
+//	h := perfhttp.NewHandler()
+//	h.Add("main", c)
+//	http.Handle("/debug/perf", h)
+package perfhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/aclements/go-perfevent/events"
+	"github.com/aclements/go-perfevent/perf"
+)
+
+// A Handler is an [http.Handler] that reads a set of named counters each time
+// it serves a request, and reports their values.
+//
+// By default, it responds with plain text in a format similar to "perf stat".
+// It accepts the following query parameters:
+//
+//   - format=json responds with JSON instead. The response is a list of
+//     objects with the counter's Name, the names of its Events, and its
+//     Counts (see [perf.Count.MarshalJSON]).
+//   - percpu=1 reports [perf.CPUCounters] separately for each CPU, instead of
+//     summing them across CPUs. In JSON, these are reported in a CPUs list
+//     of [perf.CPUCount]s.
+//
+// The values are cumulative from when each counter was opened or last reset.
+// Handler serializes its reads of each counter, but the caller must not use a
+// counter concurrently with the Handler serving requests.
+type Handler struct {
+	mu       sync.Mutex
+	counters []*handlerCounter
+}
+
+type handlerCounter struct {
+	name string
+	c    *perf.Counter
+	cpus *perf.CPUCounters
+}
+
+// NewHandler returns a new Handler with no counters.
+func NewHandler() *Handler {
+	return new(Handler)
+}
+
+// Add adds c to h under name, replacing any counter already added under
+// name. c must stay open until it's removed with [Handler.Remove].
+func (h *Handler) Add(name string, c *perf.Counter) {
+	h.add(&handlerCounter{name: name, c: c})
+}
+
+// AddCPUCounters is like [Handler.Add], but adds a set of per-CPU counters.
+func (h *Handler) AddCPUCounters(name string, c *perf.CPUCounters) {
+	h.add(&handlerCounter{name: name, cpus: c})
+}
+
+func (h *Handler) add(hc *handlerCounter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := slices.IndexFunc(h.counters, func(old *handlerCounter) bool { return old.name == hc.name })
+	if i >= 0 {
+		h.counters[i] = hc
+	} else {
+		h.counters = append(h.counters, hc)
+	}
+}
+
+// Remove removes the counter named name from h.
+func (h *Handler) Remove(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counters = slices.DeleteFunc(h.counters, func(hc *handlerCounter) bool { return hc.name == name })
+}
+
+// A counterValue is the JSON form of one counter.
+type counterValue struct {
+	Name   string
+	Events []string
+	Counts []perf.Count    `json:",omitempty"`
+	CPUs   []perf.CPUCount `json:",omitempty"`
+	Error  string          `json:",omitempty"`
+}
+
+// ServeHTTP implements [http.Handler].
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	perCPU, _ := strconv.ParseBool(r.FormValue("percpu"))
+	format := r.FormValue("format")
+	if format != "" && format != "text" && format != "json" {
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	h.mu.Lock()
+	vals := make([]counterValue, 0, len(h.counters))
+	for _, hc := range h.counters {
+		// Don't let reads show up in profiles of the process.
+		perf.DoOwnWork(func() {
+			vals = append(vals, hc.read(perCPU))
+		})
+	}
+	h.mu.Unlock()
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		enc.Encode(vals)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writeText(w, vals)
+}
+
+func (hc *handlerCounter) read(perCPU bool) counterValue {
+	var evs []events.Event
+	if hc.c != nil {
+		evs = hc.c.Events()
+	} else {
+		evs = hc.cpus.Events()
+	}
+	v := counterValue{Name: hc.name, Events: make([]string, len(evs))}
+	for i, ev := range evs {
+		v.Events[i] = ev.String()
+	}
+
+	if hc.c != nil {
+		v.Counts = make([]perf.Count, len(evs))
+		if err := hc.c.ReadGroup(v.Counts); err != nil {
+			v.Counts, v.Error = nil, err.Error()
+		}
+		return v
+	}
+
+	cpus, err := hc.cpus.Read()
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	if perCPU {
+		v.CPUs = cpus
+		return v
+	}
+	v.Counts = make([]perf.Count, len(evs))
+	for i, cc := range cpus {
+		for j, c := range cc.Counts {
+			if i == 0 {
+				v.Counts[j] = c
+			} else {
+				v.Counts[j] = v.Counts[j].Add(c)
+			}
+		}
+	}
+	return v
+}
+
+func writeText(w io.Writer, vals []counterValue) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	for i, v := range vals {
+		if i > 0 {
+			fmt.Fprintf(tw, "\n")
+		}
+		fmt.Fprintf(tw, "# %s\n", v.Name)
+		if v.Error != "" {
+			fmt.Fprintf(tw, "# error: %s\n", v.Error)
+			continue
+		}
+		for _, cc := range v.CPUs {
+			for j, c := range cc.Counts {
+				fmt.Fprintf(tw, "CPU%d\t%s\t  %s\n", cc.CPU, c, v.Events[j])
+			}
+		}
+		for j, c := range v.Counts {
+			fmt.Fprintf(tw, "%s\t  %s\n", c, v.Events[j])
+		}
+	}
+	tw.Flush()
+}
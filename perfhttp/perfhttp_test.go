@@ -0,0 +1,123 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perfhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aclements/go-perfevent/events"
+	"github.com/aclements/go-perfevent/perf"
+)
+
+func get(t *testing.T, h http.Handler, url string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d: %s", url, rec.Code, rec.Body)
+	}
+	return rec.Body.String()
+}
+
+func TestHandler(t *testing.T) {
+	c, err := perf.OpenCounter(perf.TargetThisGoroutine, events.EventTaskClock, events.EventContextSwitches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Start()
+	for start := time.Now(); time.Since(start) < 10*time.Millisecond; {
+	}
+	c.Stop()
+
+	h := NewHandler()
+	h.Add("main", c)
+
+	text := get(t, h, "/debug/perf")
+	t.Logf("text:\n%s", text)
+	for _, want := range []string{"# main\n", "  task-clock\n", "  context-switches\n"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text response missing %q", want)
+		}
+	}
+
+	var vals []counterValue
+	if err := json.Unmarshal([]byte(get(t, h, "/debug/perf?format=json")), &vals); err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 1 || vals[0].Name != "main" || len(vals[0].Events) != 2 || len(vals[0].Counts) != 2 {
+		t.Fatalf("got %+v, want main counter with 2 events", vals)
+	}
+	if vals[0].Events[0] != "task-clock" || vals[0].Counts[0].RawValue < uint64(5*time.Millisecond) {
+		t.Errorf("got %s %d, want task-clock of at least 5ms", vals[0].Events[0], vals[0].Counts[0].RawValue)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/perf?format=xml", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("format=xml: got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	h.Remove("main")
+	if text := get(t, h, "/debug/perf"); text != "" {
+		t.Errorf("after Remove, got %q, want empty response", text)
+	}
+}
+
+func TestHandlerPerCPU(t *testing.T) {
+	c, err := perf.OpenCPUCounters(nil, events.EventCPUClock)
+	if errors.Is(err, syscall.EACCES) {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Start()
+	for start := time.Now(); time.Since(start) < 10*time.Millisecond; {
+	}
+	c.Stop()
+
+	h := NewHandler()
+	h.AddCPUCounters("all", c)
+
+	var vals []counterValue
+	if err := json.Unmarshal([]byte(get(t, h, "/?format=json")), &vals); err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 1 || len(vals[0].Counts) != 1 || vals[0].CPUs != nil {
+		t.Fatalf("got %+v, want 1 summed count", vals)
+	}
+	total := vals[0].Counts[0].RawValue
+
+	vals = nil
+	if err := json.Unmarshal([]byte(get(t, h, "/?format=json&percpu=1")), &vals); err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 1 || vals[0].Counts != nil || len(vals[0].CPUs) == 0 {
+		t.Fatalf("got %+v, want per-CPU counts", vals)
+	}
+	var sum uint64
+	for _, cc := range vals[0].CPUs {
+		sum += cc.Counts[0].RawValue
+	}
+	if sum != total {
+		t.Errorf("per-CPU counts sum to %d, want total %d", sum, total)
+	}
+
+	text := get(t, h, "/?percpu=1")
+	t.Logf("text:\n%s", text)
+	if !strings.Contains(text, "CPU0 ") {
+		t.Errorf("per-CPU text response missing CPU0")
+	}
+}
@@ -0,0 +1,13 @@
+module github.com/aclements/go-perfevent/perfotel
+
+go 1.21
+
+require (
+	github.com/aclements/go-perfevent v0.0.0-00010101000000-000000000000
+	go.opentelemetry.io/otel v1.28.0
+	go.opentelemetry.io/otel/metric v1.28.0
+)
+
+require golang.org/x/sys v0.17.0 // indirect
+
+replace github.com/aclements/go-perfevent => ../
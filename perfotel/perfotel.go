@@ -0,0 +1,103 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+// Package perfotel exports performance counters as OpenTelemetry metrics.
+package perfotel
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/aclements/go-perfevent/events"
+	"github.com/aclements/go-perfevent/perf"
+)
+
+// Options configures how [Register] exports a Counter.
+type Options struct {
+	// Prefix is prepended to the name of each event to form its metric
+	// name. If empty, this uses "perf.".
+	Prefix string
+
+	// Attributes are attached to each observation, such as to identify
+	// the CPU or thread the Counter monitors.
+	Attributes []attribute.KeyValue
+}
+
+// Register registers an asynchronous counter instrument with meter for each
+// event of c. Each time meter collects metrics, it reads c and observes the
+// value of each event, scaled to account for multiplexing and the event's
+// scale factor (see [perf.Count.Value]). Each instrument's unit is the event's
+// unit, if it has one (see [events.EventScale]).
+//
+// The values are cumulative from when c was opened or last reset, so c should
+// be running and the caller shouldn't call [perf.Counter.ReadAndReset] or
+// [perf.Counter.Reset]. Register serializes reads of c, but the caller must
+// not use c concurrently with meter collecting metrics. Unregister the
+// returned Registration before closing c.
+func Register(meter metric.Meter, c *perf.Counter, opts *Options) (metric.Registration, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	prefix := opts.Prefix
+	if prefix == "" {
+		prefix = "perf."
+	}
+
+	evs := c.Events()
+	insts := make([]metric.Float64ObservableCounter, len(evs))
+	observables := make([]metric.Observable, len(evs))
+	for i, ev := range evs {
+		iopts := []metric.Float64ObservableCounterOption{
+			metric.WithDescription(fmt.Sprintf("Count of perf event %s", ev)),
+		}
+		if _, unit := events.ScaleUnitOf(ev); unit != "" {
+			iopts = append(iopts, metric.WithUnit(unit))
+		}
+		inst, err := meter.Float64ObservableCounter(prefix+metricName(ev.String()), iopts...)
+		if err != nil {
+			return nil, err
+		}
+		insts[i], observables[i] = inst, inst
+	}
+
+	observeOpt := metric.WithAttributes(opts.Attributes...)
+	var mu sync.Mutex
+	counts := make([]perf.Count, len(evs))
+	return meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		mu.Lock()
+		defer mu.Unlock()
+		var err error
+		// Don't let reads show up in profiles of the process.
+		perf.DoOwnWork(func() {
+			err = c.ReadGroup(counts)
+		})
+		if err != nil {
+			return err
+		}
+		for i, inst := range insts {
+			val, _ := counts[i].Value()
+			o.ObserveFloat64(inst, val, observeOpt)
+		}
+		return nil
+	}, observables...)
+}
+
+// metricName converts an event name into a valid OpenTelemetry instrument
+// name, which can only contain letters, digits, and "_./-".
+func metricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', strings.ContainsRune("_./-", r):
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perfotel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/embedded"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/aclements/go-perfevent/events"
+	"github.com/aclements/go-perfevent/perf"
+)
+
+// testMeter records the instruments and callback registered with it.
+type testMeter struct {
+	noop.Meter
+	insts    []*testInstrument
+	callback metric.Callback
+}
+
+type testInstrument struct {
+	noop.Float64ObservableCounter
+	name string
+	cfg  metric.Float64ObservableCounterConfig
+}
+
+func (m *testMeter) Float64ObservableCounter(name string, opts ...metric.Float64ObservableCounterOption) (metric.Float64ObservableCounter, error) {
+	inst := &testInstrument{name: name, cfg: metric.NewFloat64ObservableCounterConfig(opts...)}
+	m.insts = append(m.insts, inst)
+	return inst, nil
+}
+
+func (m *testMeter) RegisterCallback(f metric.Callback, insts ...metric.Observable) (metric.Registration, error) {
+	m.callback = f
+	return noop.Registration{}, nil
+}
+
+type testObserver struct {
+	embedded.Observer
+	values map[string]float64
+	attrs  attribute.Set
+}
+
+func (o *testObserver) ObserveFloat64(inst metric.Float64Observable, val float64, opts ...metric.ObserveOption) {
+	o.values[inst.(*testInstrument).name] = val
+	o.attrs = metric.NewObserveConfig(opts).Attributes()
+}
+
+func (o *testObserver) ObserveInt64(metric.Int64Observable, int64, ...metric.ObserveOption) {}
+
+// msecEvent is task-clock in milliseconds.
+type msecEvent struct{ events.Event }
+
+func (msecEvent) ScaleUnit() (float64, string) { return 1e-6, "ms" }
+
+func TestRegister(t *testing.T) {
+	c, err := perf.OpenCounter(perf.TargetThisGoroutine, events.EventTaskClock, msecEvent{events.EventTaskClock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var m testMeter
+	opts := &Options{Attributes: []attribute.KeyValue{attribute.Int("cpu", 3)}}
+	reg, err := Register(&m, c, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Unregister()
+	if len(m.insts) != 2 {
+		t.Fatalf("registered %d instruments, want 2", len(m.insts))
+	}
+	for i, want := range []string{"", "ms"} {
+		inst := m.insts[i]
+		if inst.name != "perf.task-clock" || inst.cfg.Unit() != want {
+			t.Errorf("instrument %d: got %s in %q, want perf.task-clock in %q", i, inst.name, inst.cfg.Unit(), want)
+		}
+	}
+
+	c.Start()
+	for start := time.Now(); time.Since(start) < 10*time.Millisecond; {
+	}
+	c.Stop()
+
+	o := &testObserver{values: make(map[string]float64)}
+	if err := m.callback(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+	// Both instruments have the same name, so the second wins.
+	if ms := o.values["perf.task-clock"]; ms < 5 || ms > 1000 {
+		t.Errorf("got task-clock %v ms, want about 10 ms", ms)
+	}
+	if v, ok := o.attrs.Value("cpu"); !ok || v.AsInt64() != 3 {
+		t.Errorf("got attributes %v, want cpu=3", o.attrs)
+	}
+}
+
+func TestMetricName(t *testing.T) {
+	for name, want := range map[string]string{
+		"cpu-cycles":         "cpu-cycles",
+		"cpu/event=0x3c/":    "cpu/event_0x3c/",
+		"l1d.replacement:u":  "l1d.replacement_u",
+		"power/energy-pkg/ ": "power/energy-pkg/_",
+	} {
+		if got := metricName(name); got != want {
+			t.Errorf("metricName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+// Package perfprom exports performance counters as Prometheus metrics.
+package perfprom
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aclements/go-perfevent/perf"
+)
+
+// A Collector is a [prometheus.Collector] that reads a set of
+// [perf.Counter]s each time it is scraped.
+//
+// For each event of each Counter, it exports three counter metrics, labeled
+// with the event name, the event's unit (see
+// [github.com/aclements/go-perfevent/events.EventScale]), and the
+// labels the Counter was added with:
+//
+//   - perf_event_total is the event's value, scaled to account for
+//     multiplexing and the event's scale factor (see [perf.Count.Value]).
+//   - perf_event_time_enabled_seconds_total is the time the Counter was
+//     started.
+//   - perf_event_time_running_seconds_total is the time the event was actually
+//     counting. If this is less than the time enabled, the event was
+//     multiplexed with other events and its value is an estimate.
+//
+// The values are cumulative from when each Counter was opened or last reset,
+// so the Counters should be running and the caller shouldn't call
+// [perf.Counter.ReadAndReset] or [perf.Counter.Reset].
+type Collector struct {
+	labelNames []string
+	value      *prometheus.Desc
+	enabled    *prometheus.Desc
+	running    *prometheus.Desc
+
+	mu       sync.Mutex
+	counters []collectorCounter
+}
+
+type collectorCounter struct {
+	c           *perf.Counter
+	labelValues []string
+	counts      []perf.Count
+}
+
+// NewCollector returns a new Collector with no Counters. labelNames are the
+// names of the labels that each Counter added to the Collector must have, in
+// addition to the "event" and "unit" labels.
+func NewCollector(labelNames ...string) *Collector {
+	labels := append([]string{"event", "unit"}, labelNames...)
+	return &Collector{
+		labelNames: slices.Clone(labelNames),
+		value: prometheus.NewDesc("perf_event_total",
+			"Value of a perf event, scaled for multiplexing.", labels, nil),
+		enabled: prometheus.NewDesc("perf_event_time_enabled_seconds_total",
+			"Time a perf event was enabled.", labels, nil),
+		running: prometheus.NewDesc("perf_event_time_running_seconds_total",
+			"Time a perf event was counting.", labels, nil),
+	}
+}
+
+// Add adds c to the Collector, with the given values of the Collector's
+// labels. c must stay open until it's removed with [Collector.Remove].
+func (col *Collector) Add(c *perf.Counter, labels prometheus.Labels) error {
+	if len(labels) != len(col.labelNames) {
+		return fmt.Errorf("got %d labels, want %d", len(labels), len(col.labelNames))
+	}
+	values := make([]string, len(col.labelNames))
+	for i, name := range col.labelNames {
+		val, ok := labels[name]
+		if !ok {
+			return fmt.Errorf("missing label %q", name)
+		}
+		values[i] = val
+	}
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	col.counters = append(col.counters, collectorCounter{c, values, make([]perf.Count, len(c.Events()))})
+	return nil
+}
+
+// Remove removes c from the Collector.
+func (col *Collector) Remove(c *perf.Counter) {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	col.counters = slices.DeleteFunc(col.counters, func(cc collectorCounter) bool { return cc.c == c })
+}
+
+// Describe implements [prometheus.Collector].
+func (col *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- col.value
+	ch <- col.enabled
+	ch <- col.running
+}
+
+// Collect implements [prometheus.Collector]. It reads each Counter and sends
+// its metrics to ch.
+func (col *Collector) Collect(ch chan<- prometheus.Metric) {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	for _, cc := range col.counters {
+		var err error
+		// Don't let reads show up in profiles of the process.
+		perf.DoOwnWork(func() {
+			err = cc.c.ReadGroup(cc.counts)
+		})
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(col.value, err)
+			continue
+		}
+		for i, ev := range cc.c.Events() {
+			val, unit := cc.counts[i].Value()
+			labels := append([]string{ev.String(), unit}, cc.labelValues...)
+			ch <- prometheus.MustNewConstMetric(col.value, prometheus.CounterValue, val, labels...)
+			ch <- prometheus.MustNewConstMetric(col.enabled, prometheus.CounterValue, cc.counts[i].Enabled().Seconds(), labels...)
+			ch <- prometheus.MustNewConstMetric(col.running, prometheus.CounterValue, cc.counts[i].Running().Seconds(), labels...)
+		}
+	}
+}
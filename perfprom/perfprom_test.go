@@ -0,0 +1,78 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package perfprom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aclements/go-perfevent/events"
+	"github.com/aclements/go-perfevent/perf"
+)
+
+func TestCollector(t *testing.T) {
+	c, err := perf.OpenCounter(perf.TargetThisGoroutine, events.EventTaskClock, events.EventContextSwitches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	col := NewCollector("thread")
+	if err := col.Add(c, prometheus.Labels{"other": "x"}); err == nil {
+		t.Errorf("adding Counter with wrong labels: want error")
+	}
+	if err := col.Add(c, prometheus.Labels{"thread": "main"}); err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(col); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Start()
+	for start := time.Now(); time.Since(start) < 10*time.Millisecond; {
+	}
+	c.Stop()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			if labels["thread"] != "main" {
+				t.Errorf("%s: got labels %v, want thread=main", mf.GetName(), labels)
+			}
+			got[mf.GetName()+"/"+labels["event"]] = m.GetCounter().GetValue()
+		}
+	}
+	if len(got) != 6 {
+		t.Errorf("got metrics %v, want 3 for each of 2 events", got)
+	}
+	if v := got["perf_event_total/task-clock"]; v < 5e6 || v > 1e9 {
+		t.Errorf("got task-clock %v ns, want about 10ms", v)
+	}
+	if en, run := got["perf_event_time_enabled_seconds_total/task-clock"], got["perf_event_time_running_seconds_total/task-clock"]; en < 0.005 || run > en {
+		t.Errorf("got enabled %vs, running %vs, want at least 10ms enabled", en, run)
+	}
+
+	col.Remove(c)
+	families, err = reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 0 {
+		t.Errorf("got %d metric families after Remove, want 0", len(families))
+	}
+}